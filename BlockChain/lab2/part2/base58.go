@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"math/big"
 )
 
+var ErrInvalidBase58 = errors.New("invalid base58 character")
+
 // excluding: 0 (zero), O (capital o), I (capital i), l (lowercase L),
 var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
 
@@ -23,9 +27,9 @@ func Base58Encode(input []byte) []byte {
 		result = append(result, b58Alphabet[mod.Int64()])
 	}
 
-	// Append bitcoin pubkey hash leading symbol
+	// Append bitcoin pubkey hash leading symbol, one per leading zero byte
 	// https://en.bitcoin.it/wiki/Base58Check_encoding#Version_bytes
-	if input[0] == 0x00 {
+	for i := 0; i < len(input) && input[i] == 0x00; i++ {
 		result = append(result, b58Alphabet[0])
 	}
 
@@ -35,20 +39,21 @@ func Base58Encode(input []byte) []byte {
 }
 
 // Base58Decode decodes Base58-encoded data
-func Base58Decode(input []byte) []byte {
+func Base58Decode(input []byte) ([]byte, error) {
 	result := big.NewInt(0)
 
-	for _, b := range input {
+	for i, b := range input {
 		charIndex := bytes.IndexByte(b58Alphabet, b)
+		if charIndex < 0 {
+			return nil, fmt.Errorf("%w: %q at %d", ErrInvalidBase58, b, i)
+		}
 		result.Mul(result, big.NewInt(58))
 		result.Add(result, big.NewInt(int64(charIndex)))
 	}
 
-	decoded := result.Bytes()
-
-	if input[0] == b58Alphabet[0] {
-		decoded = append([]byte{0x00}, decoded...)
+	zeros := 0
+	for zeros < len(input) && input[zeros] == b58Alphabet[0] {
+		zeros++
 	}
-
-	return decoded
+	return append(make([]byte, zeros), result.Bytes()...), nil
 }
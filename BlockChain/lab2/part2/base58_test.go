@@ -19,6 +19,24 @@ func TestBase58(t *testing.T) {
 	encoded := Base58Encode(hash)
 	assert.Equal(t, "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM", string(encoded))
 
-	decoded := Base58Decode([]byte("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"))
+	decoded, err := Base58Decode([]byte("16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"))
+	assert.Nil(t, err)
 	assert.Equal(t, strings.ToLower("00010966776006953D5567439E5E39F86A0D273BEED61967F6"), hex.EncodeToString(decoded))
 }
+
+func TestBase58LeadingZeros(t *testing.T) {
+	for _, data := range [][]byte{{}, {0}, {0, 0, 0}, {0, 0, 1, 2}, {0, 0, 0xff}} {
+		encoded := Base58Encode(data)
+		decoded, err := Base58Decode(encoded)
+		assert.Nil(t, err)
+		assert.Equal(t, data, decoded, "%q", encoded)
+	}
+	assert.Equal(t, "111", string(Base58Encode([]byte{0, 0, 0})))
+}
+
+func TestBase58InvalidCharacters(t *testing.T) {
+	for _, s := range []string{"0", "O", "I", "l", "16UwLL9Risc3QfPqBUvK0fHmBQ7wMtjvM", "abc def", "é"} {
+		_, err := Base58Decode([]byte(s))
+		assert.ErrorIs(t, err, ErrInvalidBase58, s)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32 strings, as in BIP173, and their Bech32m variant of BIP350
+// https://github.com/bitcoin/bips/blob/master/bip-0173.mediawiki
+// https://github.com/bitcoin/bips/blob/master/bip-0350.mediawiki
+//
+// A string is a human-readable part, the separator "1" and data of 5 bits
+// per character followed by a 6 characters checksum. The checksum detects
+// any error of up to 4 characters, and the characters avoid the ones that
+// are easily mistaken for each other.
+//
+// Segwit addresses are Bech32 strings whose data is a witness version,
+// 0 to 16, and a program of 2 to 40 bytes. Version 0 uses Bech32 and the
+// later versions Bech32m.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32MaxLength is the length limit of BIP173
+const bech32MaxLength = 90
+
+// Bech32Variant is the constant the checksum of a string is built with
+type Bech32Variant uint32
+
+const (
+	Bech32  Bech32Variant = 1
+	Bech32m Bech32Variant = 0x2bc830a3
+)
+
+var (
+	ErrInvalidBech32 = errors.New("invalid bech32 string")
+	ErrBech32Variant = errors.New("wrong bech32 variant")
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+// bech32HRPExpand returns the values of the human-readable part
+// the checksum is computed on
+func bech32HRPExpand(hrp string) []byte {
+	values := make([]byte, 0, 2*len(hrp)+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return values
+}
+
+func bech32Checksum(hrp string, data []byte, variant Bech32Variant) []byte {
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ uint32(variant)
+	checksum := make([]byte, 6)
+	for i := range checksum {
+		checksum[i] = byte(polymod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+// Bech32Encode returns the string of the human-readable part and the
+// data, 5 bits values, in lower case
+func Bech32Encode(hrp string, data []byte, variant Bech32Variant) (string, error) {
+	hrp = strings.ToLower(hrp)
+	if len(hrp) == 0 || len(hrp)+1+len(data)+6 > bech32MaxLength {
+		return "", fmt.Errorf("%w: length", ErrInvalidBech32)
+	}
+	var b strings.Builder
+	b.WriteString(hrp)
+	b.WriteByte('1')
+	values := append(append([]byte{}, data...), bech32Checksum(hrp, data, variant)...)
+	for _, v := range values {
+		if v > 31 {
+			return "", fmt.Errorf("%w: %d is not a 5 bits value", ErrInvalidBech32, v)
+		}
+		b.WriteByte(bech32Charset[v])
+	}
+	return b.String(), nil
+}
+
+// Bech32Decode returns the human-readable part, in lower case, the data
+// and the variant of a string
+func Bech32Decode(s string) (string, []byte, Bech32Variant, error) {
+	if len(s) > bech32MaxLength {
+		return "", nil, 0, fmt.Errorf("%w: %d characters", ErrInvalidBech32, len(s))
+	}
+	lower, upper := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 33 || c > 126 {
+			return "", nil, 0, fmt.Errorf("%w: character %d", ErrInvalidBech32, i)
+		}
+		lower = lower || (c >= 'a' && c <= 'z')
+		upper = upper || (c >= 'A' && c <= 'Z')
+	}
+	if lower && upper {
+		return "", nil, 0, fmt.Errorf("%w: mixed case", ErrInvalidBech32)
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, 0, fmt.Errorf("%w: no separator", ErrInvalidBech32)
+	}
+	hrp := s[:pos]
+	data := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, 0, fmt.Errorf("%w: character %q", ErrInvalidBech32, s[i])
+		}
+		data = append(data, byte(v))
+	}
+	variant := Bech32Variant(bech32Polymod(append(bech32HRPExpand(hrp), data...)))
+	if variant != Bech32 && variant != Bech32m {
+		return "", nil, 0, fmt.Errorf("%w: wrong checksum", ErrInvalidBech32)
+	}
+	return hrp, data[:len(data)-6], variant, nil
+}
+
+// convertBits regroups the bits of data from groups of from bits to groups
+// of to bits. With pad, the last group is padded with zeros; without, the
+// left over bits must be zeros and fewer than from.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<to - 1
+	var out []byte
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, fmt.Errorf("%w: %d is not a %d bits value", ErrInvalidBech32, v, from)
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, fmt.Errorf("%w: invalid padding", ErrInvalidBech32)
+	}
+	return out, nil
+}
+
+// EncodeSegwitAddress returns the segwit address of the witness version
+// and program
+func EncodeSegwitAddress(hrp string, witnessVersion byte, program []byte) (string, error) {
+	if err := checkWitnessProgram(witnessVersion, program); err != nil {
+		return "", err
+	}
+	data, _ := convertBits(program, 8, 5, true)
+	variant := Bech32
+	if witnessVersion > 0 {
+		variant = Bech32m
+	}
+	return Bech32Encode(hrp, append([]byte{witnessVersion}, data...), variant)
+}
+
+// DecodeSegwitAddress returns the witness version and program of a segwit
+// address with the human-readable part
+func DecodeSegwitAddress(hrp, address string) (byte, []byte, error) {
+	gotHRP, data, variant, err := Bech32Decode(address)
+	if err != nil {
+		return 0, nil, err
+	}
+	if gotHRP != strings.ToLower(hrp) {
+		return 0, nil, fmt.Errorf("%w: human-readable part %q", ErrInvalidBech32, gotHRP)
+	}
+	if len(data) == 0 {
+		return 0, nil, fmt.Errorf("%w: no witness version", ErrInvalidBech32)
+	}
+	witnessVersion := data[0]
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := checkWitnessProgram(witnessVersion, program); err != nil {
+		return 0, nil, err
+	}
+	if (witnessVersion == 0) != (variant == Bech32) {
+		return 0, nil, fmt.Errorf("%w: witness version %d", ErrBech32Variant, witnessVersion)
+	}
+	return witnessVersion, program, nil
+}
+
+func checkWitnessProgram(witnessVersion byte, program []byte) error {
+	if witnessVersion > 16 {
+		return fmt.Errorf("%w: witness version %d", ErrInvalidBech32, witnessVersion)
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return fmt.Errorf("%w: %d bytes program", ErrInvalidBech32, len(program))
+	}
+	if witnessVersion == 0 && len(program) != 20 && len(program) != 32 {
+		return fmt.Errorf("%w: %d bytes version 0 program", ErrInvalidBech32, len(program))
+	}
+	return nil
+}
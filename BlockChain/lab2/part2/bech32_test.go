@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the valid strings of BIP173 and BIP350
+var bech32Table = []struct {
+	s       string
+	variant Bech32Variant
+}{
+	{"A12UEL5L", Bech32},
+	{"a12uel5l", Bech32},
+	{"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs", Bech32},
+	{"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw", Bech32},
+	{"11" + strings.Repeat("q", 82) + "c8247j", Bech32},
+	{"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w", Bech32},
+	{"?1ezyfcl", Bech32},
+	{"A1LQFN3A", Bech32m},
+	{"a1lqfn3a", Bech32m},
+	{"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", Bech32m},
+	{"split1checkupstagehandshakeupstreamerranterredcaperredlc445v", Bech32m},
+	{"?1v759aa", Bech32m},
+}
+
+var invalidBech32 = []string{
+	"\x201nwldj5",   // character out of range in the human-readable part
+	"\x7f1axkwrx",   // same
+	"pzry9x0s0muk",  // no separator
+	"1pzry9x0s0muk", // empty human-readable part
+	"x1b4n0q5v",     // invalid data character
+	"li1dgmt3",      // checksum too short
+	"A1G7SGD8",      // checksum computed with an upper case human-readable part
+	"a12UEL5L",      // mixed case
+	"an84characterslonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1569pvx", // too long
+	"a12uel5m", // wrong checksum
+}
+
+func TestBech32(t *testing.T) {
+	for _, test := range bech32Table {
+		hrp, data, variant, err := Bech32Decode(test.s)
+		if !assert.Nil(t, err, test.s) {
+			continue
+		}
+		assert.Equal(t, test.variant, variant, test.s)
+		encoded, err := Bech32Encode(hrp, data, variant)
+		assert.Nil(t, err)
+		assert.Equal(t, strings.ToLower(test.s), encoded)
+	}
+	for _, s := range invalidBech32 {
+		_, _, _, err := Bech32Decode(s)
+		assert.ErrorIs(t, err, ErrInvalidBech32, "%q", s)
+	}
+}
+
+func TestSegwitAddress(t *testing.T) {
+	program := Hex2Bytes("751e76e8199196d454941c45d1b3a323f1433bd6")
+	valid := []struct {
+		address        string
+		witnessVersion byte
+		program        []byte
+	}{
+		// BIP173 and BIP350
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", 0, program},
+		{"bc1pw508d6qejxtdg4y5r3zarvary0c5xw7kw508d6qejxtdg4y5r3zarvary0c5xw7kt5nd6y", 1, append(append([]byte{}, program...), program...)},
+		{"BC1SW50QGDZ25J", 16, Hex2Bytes("751e")},
+	}
+	for _, test := range valid {
+		witnessVersion, got, err := DecodeSegwitAddress("bc", test.address)
+		if !assert.Nil(t, err, test.address) {
+			continue
+		}
+		assert.Equal(t, test.witnessVersion, witnessVersion)
+		assert.Equal(t, test.program, got)
+		encoded, err := EncodeSegwitAddress("bc", witnessVersion, got)
+		assert.Nil(t, err)
+		assert.Equal(t, strings.ToLower(test.address), encoded)
+	}
+
+	invalid := map[string]error{
+		"tc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vq5zuyut": ErrInvalidBech32, // other human-readable part
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd": ErrBech32Variant, // version 1 with Bech32
+		"BC1S0XLXVLHEMJA6C4DQV22UAPCTQUPFHLXM9H8Z3K2E72Q4K9HCZ7VQ54WELL": ErrBech32Variant, // version 16 with Bech32
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh":                     ErrBech32Variant, // version 0 with Bech32m
+		"bc1pw5dgrnzv":                          ErrInvalidBech32, // 1 byte program
+		"BC1QR508D6QEJXTDG4Y5R3ZARVARYV98GJ9P":  ErrInvalidBech32, // 16 bytes version 0 program
+		"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du": ErrInvalidBech32, // padding
+	}
+	for address, expected := range invalid {
+		_, _, err := DecodeSegwitAddress("bc", address)
+		assert.ErrorIs(t, err, expected, address)
+	}
+}
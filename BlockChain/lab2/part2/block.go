@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"strings"
 	"time"
@@ -9,16 +10,61 @@ import (
 
 // Block keeps block information
 type Block struct {
-	Timestamp     int64          // the block creation timestamp
-	Transactions  []*Transaction // The block transactions
-	PrevBlockHash []byte         // the hash of the previous block
-	Hash          []byte         // the hash of the block
-	Nonce         int            // the nonce of the block
+	BlockHeader                 // the header of the block, hashed by the proof of work
+	Transactions []*Transaction // The block transactions
+	Hash         []byte         // the hash of the block header
 }
 
-// NewBlock creates and returns a non-mined Block
+// BlockHeader is the part of a block hashed by the proof of work.
+// It commits to the transactions of the block through their merkle root.
+type BlockHeader struct {
+	Version       uint32 // version of the header encoding
+	PrevBlockHash []byte // the hash of the previous block
+	MerkleRoot    []byte // the merkle root of the block transactions
+	Timestamp     int64  // the block creation timestamp
+	Bits          uint32 // the mining difficulty, see difficulty.go
+	Nonce         int    // the nonce of the block
+}
+
+// Serialize returns the binary encoding of the header
+func (h BlockHeader) Serialize() []byte {
+	var e encoder
+	e.putHeader(&h)
+	return e.buf.Bytes()
+}
+
+// DeserializeBlockHeader decodes a header serialized by BlockHeader.Serialize
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	d := decoder{data: data}
+	h := d.header()
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Hash returns the hash of the header, which is the hash of a
+// mined block. It does not depend on the transactions but through
+// the merkle root, so headers can be validated without the block body.
+func (h BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+	return hash[:]
+}
+
+// NewBlock creates and returns a non-mined Block at the initial
+// difficulty, see Blockchain.NextBits for the difficulty of the chain
 func NewBlock(timestamp int64, transactions []*Transaction, prevBlockHash []byte) *Block {
-	return &Block{timestamp, transactions, prevBlockHash, nil, 0}
+	b := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     timestamp,
+			Bits:          InitialBits,
+		},
+		Transactions: transactions,
+	}
+	b.MerkleRoot = b.HashTransactions()
+	return b
 }
 
 // NewGenesisBlock creates and returns genesis Block
@@ -27,13 +73,27 @@ func NewGenesisBlock(timestamp int64, tx *Transaction) *Block {
 }
 
 // Mine calculates and sets the block hash and nonce.
+// The merkle root of the header is set from the block transactions.
 func (b *Block) Mine() {
+	b.prepareHeader()
 	pow := NewProofOfWork(b)
 	nonce, hash := pow.Run()
 	b.Hash = hash
 	b.Nonce = nonce
 }
 
+// prepareHeader completes the header before mining: the
+// defaults of the version and the difficulty, and the merkle root
+func (b *Block) prepareHeader() {
+	if b.Version == 0 {
+		b.Version = blockVersion
+	}
+	if b.Bits == 0 {
+		b.Bits = InitialBits
+	}
+	b.MerkleRoot = b.HashTransactions()
+}
+
 // HashTransactions returns a hash of the transactions in the block
 // This function iterates over all transactions in a block, serialize them
 // and make a merkle tree of it.
@@ -43,6 +103,9 @@ func (b *Block) HashTransactions() []byte {
 	// This function should iterate over all txs in a block,
 	// serialize them and compute the merkle root of it.
 	// It returns the merkle root hash.
+	if len(b.Transactions) == 0 {
+		return nil
+	}
 	var allTrans [][]byte
 	for _, tran := range b.Transactions {
 		allTrans = append(allTrans, tran.Serialize())
@@ -62,10 +125,41 @@ func (b *Block) FindTransaction(ID []byte) (*Transaction, error) {
 	return nil, ErrTxNotFound
 }
 
+// Serialize returns a serialized Block: its header,
+// its hash and its transactions, see serialization.go
+func (b *Block) Serialize() []byte {
+	var e encoder
+	e.putHeader(&b.BlockHeader)
+	e.putBytes(b.Hash)
+	e.putUint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		e.putTransaction(tx)
+	}
+	return e.buf.Bytes()
+}
+
+// DeserializeBlock decodes a Block serialized by Block.Serialize
+func DeserializeBlock(data []byte) (*Block, error) {
+	d := decoder{data: data}
+	block := &Block{BlockHeader: d.header(), Hash: d.bytes()}
+	// a transaction takes at least 16 bytes
+	if n := d.count(16); n > 0 {
+		block.Transactions = make([]*Transaction, n)
+		for i := range block.Transactions {
+			block.Transactions[i] = d.transaction()
+		}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
 func (b *Block) String() string {
 	var lines []string
 	lines = append(lines, fmt.Sprintf("============ Block %x ============", b.Hash))
 	lines = append(lines, fmt.Sprintf("Prev. hash: %x", b.PrevBlockHash))
+	lines = append(lines, fmt.Sprintf("Merkle root: %x", b.MerkleRoot))
 	lines = append(lines, fmt.Sprintf("Timestamp: %v", time.Unix(b.Timestamp, 0)))
 	lines = append(lines, fmt.Sprintf("Nonce: %d", b.Nonce))
 	lines = append(lines, fmt.Sprintf("Transactions:"))
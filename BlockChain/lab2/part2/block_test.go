@@ -25,7 +25,7 @@ func TestGenesisBlock(t *testing.T) {
 
 func TestBlockHashTransactions(t *testing.T) {
 	// Merkle root of block1
-	merkleRootTxsHash := Hex2Bytes("153b097cb029051834d285f69901237812f13d6dc166d684778096422f331677")
+	merkleRootTxsHash := Hex2Bytes("afb159383c33b4590d1fbed0f631d880c87049a75ffc37f6859b8d109ffcb919")
 	b := &Block{
 		Transactions: []*Transaction{testTransactions["tx1"]},
 	}
@@ -38,12 +38,14 @@ func TestMine(t *testing.T) {
 	genesisBlock := testBlockchainData["block0"]
 
 	b := &Block{
-		Timestamp: TestBlockTime,
+		BlockHeader: BlockHeader{
+			Timestamp:     TestBlockTime,
+			PrevBlockHash: genesisBlock.Hash,
+		},
 		Transactions: []*Transaction{
 			minerCoinbaseTx["tx1"],
 			testTransactions["tx1"],
 		},
-		PrevBlockHash: genesisBlock.Hash,
 	}
 	b.Mine()
 
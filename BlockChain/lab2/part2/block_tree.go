@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrBlockExists = errors.New("block already exists")
+	ErrOrphanBlock = errors.New("block's parent is unknown")
+)
+
+// Buckets used by the block tree
+var (
+	// blockIndexBucket maps the hash of every known block,
+	// in the main chain or not, to its blockIndexEntry
+	blockIndexBucket = []byte("blockindex")
+	// undoBucket maps the hash of a connected block to the outputs
+	// spent by it, needed to roll the UTXO index back
+	undoBucket = []byte("undo")
+)
+
+// blockIndexEntry keeps the position of a block in the block tree
+type blockIndexEntry struct {
+	Height int    // height of the block
+	Work   []byte // cumulative work of the chain ending at the block
+}
+
+// spentOutput is an output removed from the UTXO index by a block
+type spentOutput struct {
+	Txid     []byte
+	OutIdx   int
+	Output   TXOutput
+	Height   int  // see utxoEntry
+	Coinbase bool // see utxoEntry
+}
+
+// ReorgEvent describes a switch of the main chain to a branch with more work
+type ReorgEvent struct {
+	OldTip       []byte   // hash of the tip before the reorganization
+	NewTip       []byte   // hash of the tip after the reorganization
+	Fork         []byte   // hash of the last block shared by both branches
+	Disconnected []*Block // blocks removed from the main chain, from the old tip down
+	Connected    []*Block // blocks added to the main chain, from the fork up
+}
+
+// OnReorg registers fn to be called after every chain reorganization
+func (bc *Blockchain) OnReorg(fn func(ReorgEvent)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.reorgListeners = append(bc.reorgListeners, fn)
+}
+
+// OnBlockConnected registers fn to be called for every block added
+// to the main chain, either extending it or during a reorganization.
+// On reorganizations, the OnReorg functions are called first.
+func (bc *Blockchain) OnBlockConnected(fn func(*Block)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.connectListeners = append(bc.connectListeners, fn)
+}
+
+// HasBlock reports whether the block is known, in the main chain or not
+func (bc *Blockchain) HasBlock(hash []byte) bool {
+	var found bool
+	bc.db.View(func(tx StorageTx) error {
+		found = tx.Get(blockIndexBucket, hash) != nil
+		return nil
+	})
+	return found
+}
+
+// blockHeight returns the height of a known block, in the main chain or not
+func (bc *Blockchain) blockHeight(hash []byte) (int, error) {
+	var height int
+	err := bc.db.View(func(tx StorageTx) error {
+		entry, err := getIndexEntry(tx, hash)
+		if err != nil {
+			return err
+		}
+		height = entry.Height
+		return nil
+	})
+	return height, err
+}
+
+// Work returns the cumulative proof-of-work of the main chain
+func (bc *Blockchain) Work() *big.Int {
+	var work *big.Int
+	tip, _ := bc.Tip()
+	bc.db.View(func(tx StorageTx) error {
+		entry, err := getIndexEntry(tx, tip)
+		if err != nil {
+			return err
+		}
+		work = new(big.Int).SetBytes(entry.Work)
+		return nil
+	})
+	return work
+}
+
+// BlockLocator returns hashes of main chain blocks from the tip back to the
+// genesis block. The first ten blocks are listed one by one, then the
+// step doubles at each hash. A peer uses it to find the last block that
+// both chains have in common.
+func (bc *Blockchain) BlockLocator() [][]byte {
+	var locator [][]byte
+	_, height := bc.Tip()
+	bc.db.View(func(tx StorageTx) error {
+		step := 1
+		for h := height; h > 0; h -= step {
+			locator = append(locator, tx.Get(heightsBucket, heightKey(h)))
+			if len(locator) >= 10 {
+				step *= 2
+			}
+		}
+		locator = append(locator, tx.Get(heightsBucket, heightKey(0)))
+		return nil
+	})
+	return locator
+}
+
+// BlocksAfter returns the hashes of up to max main chain blocks following
+// the first block of the locator that is in the main chain.
+// If none of them is, the blocks following the genesis are returned.
+func (bc *Blockchain) BlocksAfter(locator [][]byte, max int) [][]byte {
+	var hashes [][]byte
+	bc.db.View(func(tx StorageTx) error {
+		start := 1
+		for _, hash := range locator {
+			entry, err := getIndexEntry(tx, hash)
+			if err == nil && bytes.Equal(tx.Get(heightsBucket, heightKey(entry.Height)), hash) {
+				start = entry.Height + 1
+				break
+			}
+		}
+		for h := start; len(hashes) < max; h++ {
+			hash := tx.Get(heightsBucket, heightKey(h))
+			if hash == nil {
+				break
+			}
+			hashes = append(hashes, hash)
+		}
+		return nil
+	})
+	return hashes
+}
+
+// blockWork returns the expected number of hashes needed to mine the block
+// i.e., 2^256 / (target+1)
+func blockWork(block *Block) *big.Int {
+	target := CompactToBig(block.Bits)
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// storeBlock adds the block to the block tree without further validation.
+// If the block extends the main chain it becomes the new tip, and if it
+// makes a side branch heavier than the main chain, the chain is
+// reorganized. The UTXO index is updated in the same storage transaction.
+func (bc *Blockchain) storeBlock(block *Block) error {
+	bc.mu.Lock()
+	var event *ReorgEvent
+	var connected []*Block
+	tip, height := bc.tip, bc.height
+
+	err := bc.db.Update(func(tx StorageTx) error {
+		if tx.Get(blockIndexBucket, block.Hash) != nil {
+			return ErrBlockExists
+		}
+
+		entry := blockIndexEntry{Height: 0, Work: blockWork(block).Bytes()}
+		if bc.tip != nil {
+			parent, err := getIndexEntry(tx, block.PrevBlockHash)
+			if err != nil {
+				return ErrOrphanBlock
+			}
+			work := new(big.Int).SetBytes(parent.Work)
+			entry.Height = parent.Height + 1
+			entry.Work = work.Add(work, blockWork(block)).Bytes()
+		}
+
+		if err := tx.Put(blocksBucket, block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := putIndexEntry(tx, block.Hash, entry); err != nil {
+			return err
+		}
+
+		// extends the main chain
+		if bc.tip == nil || bytes.Equal(block.PrevBlockHash, bc.tip) {
+			tip, height = block.Hash, entry.Height
+			connected = []*Block{block}
+			return connectBlock(tx, block, entry.Height)
+		}
+
+		// side branch, becomes the main chain only with more work
+		tipEntry, err := getIndexEntry(tx, bc.tip)
+		if err != nil {
+			return err
+		}
+		if new(big.Int).SetBytes(entry.Work).Cmp(new(big.Int).SetBytes(tipEntry.Work)) <= 0 {
+			return nil
+		}
+		event, err = bc.reorganize(tx, block, entry.Height)
+		if err != nil {
+			return err
+		}
+		tip, height = block.Hash, entry.Height
+		connected = event.Connected
+		return nil
+	})
+	if err != nil {
+		bc.mu.Unlock()
+		return err
+	}
+
+	bc.tip, bc.height = tip, height
+	reorgListeners, connectListeners := bc.reorgListeners, bc.connectListeners
+	bc.mu.Unlock()
+
+	if event != nil {
+		for _, fn := range reorgListeners {
+			fn(*event)
+		}
+	}
+	for _, b := range connected {
+		for _, fn := range connectListeners {
+			fn(b)
+		}
+	}
+	return nil
+}
+
+// reorganize switches the main chain to the branch ending at newTip:
+// the blocks of the current chain above the fork point are disconnected,
+// rolling the UTXO index back, and the blocks of the new branch are
+// connected from the fork point up.
+func (bc *Blockchain) reorganize(tx StorageTx, newTip *Block, newHeight int) (*ReorgEvent, error) {
+	event := &ReorgEvent{OldTip: bc.tip, NewTip: newTip.Hash}
+
+	// walk the new branch back to the main chain
+	var branch []*Block
+	block, height := newTip, newHeight
+	for !bytes.Equal(tx.Get(heightsBucket, heightKey(height)), block.Hash) {
+		branch = append(branch, block)
+		prev, err := getBlock(tx, block.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+		block, height = prev, height-1
+	}
+	event.Fork = block.Hash
+
+	for h := bc.height; h > height; h-- {
+		old, err := getBlock(tx, tx.Get(heightsBucket, heightKey(h)))
+		if err != nil {
+			return nil, err
+		}
+		if err := disconnectBlock(tx, old, h); err != nil {
+			return nil, err
+		}
+		event.Disconnected = append(event.Disconnected, old)
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		height++
+		if err := connectBlock(tx, branch[i], height); err != nil {
+			return nil, err
+		}
+		event.Connected = append(event.Connected, branch[i])
+	}
+	return event, nil
+}
+
+// connectBlock makes the block the tip of the main chain
+func connectBlock(tx StorageTx, block *Block, height int) error {
+	if err := tx.Put(heightsBucket, heightKey(height), block.Hash); err != nil {
+		return err
+	}
+	if err := tx.Put(metaBucket, heightKeyName, heightKey(height)); err != nil {
+		return err
+	}
+	if err := tx.Put(metaBucket, tipKey, block.Hash); err != nil {
+		return err
+	}
+	if err := connectUTXO(tx, block, height); err != nil {
+		return err
+	}
+	if err := connectTxIndex(tx, block, height); err != nil {
+		return err
+	}
+	return pruneBlocks(tx, height)
+}
+
+// disconnectBlock removes the tip of the main chain,
+// making its parent the new tip
+func disconnectBlock(tx StorageTx, block *Block, height int) error {
+	if err := disconnectTxIndex(tx, block, height); err != nil {
+		return err
+	}
+	if err := disconnectUTXO(tx, block); err != nil {
+		return err
+	}
+	if err := tx.Delete(heightsBucket, heightKey(height)); err != nil {
+		return err
+	}
+	if err := tx.Put(metaBucket, heightKeyName, heightKey(height-1)); err != nil {
+		return err
+	}
+	return tx.Put(metaBucket, tipKey, block.PrevBlockHash)
+}
+
+func getIndexEntry(tx StorageTx, hash []byte) (*blockIndexEntry, error) {
+	data := tx.Get(blockIndexBucket, hash)
+	if data == nil {
+		return nil, ErrBlockNotFound
+	}
+	var entry blockIndexEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func putIndexEntry(tx StorageTx, hash []byte, entry blockIndexEntry) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(entry); err != nil {
+		return err
+	}
+	return tx.Put(blockIndexBucket, hash, buffer.Bytes())
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMinerAddress = "14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh"
+
+// mineTestBlock mines a block on top of prev, paying the
+// reward to testMinerAddress. The data makes the coinbase unique.
+// The block has the timestamp of prev, so that it is not before the
+// median time past. It passes ValidateBlock, which rejects the nonces
+// 0 and 1. Its coinbase has the height following the one of prev,
+// which is the genesis block when it has no hash.
+func mineTestBlock(t *testing.T, prev *Block, data string, txs ...*Transaction) *Block {
+	height := 0
+	if prev.Hash != nil {
+		prevHeight, err := prev.Height()
+		if err != nil {
+			t.Fatal(err)
+		}
+		height = prevHeight + 1
+	}
+	coinbase, err := NewCoinbaseTX(testMinerAddress, data, height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mineTestBlockWithCoinbase(prev, coinbase, txs...)
+}
+
+// mineTestBlockWithCoinbase mines a block on top of prev with the given coinbase
+func mineTestBlockWithCoinbase(prev *Block, coinbase *Transaction, txs ...*Transaction) *Block {
+	b := NewBlock(prev.Timestamp, append([]*Transaction{coinbase}, txs...), prev.Hash)
+	b.Mine()
+	return b
+}
+
+func mustStoreBlock(t *testing.T, bc *Blockchain, b *Block) {
+	if err := bc.storeBlock(b); err != nil {
+		t.Fatalf("unexpected error storing block %x: %v", b.Hash, err)
+	}
+}
+
+func TestStoreBlockSideBranch(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+
+	a1 := mineTestBlock(t, genesis, "a1")
+	b1 := mineTestBlock(t, genesis, "b1")
+	mustStoreBlock(t, bc, a1)
+	mustStoreBlock(t, bc, b1)
+
+	// same amount of work: the first seen branch is kept
+	assert.Equal(t, a1.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, 1, bc.Height())
+	assert.True(t, bc.HasBlock(b1.Hash))
+
+	// blocks of side branches can be read but are not part of the chain
+	b, err := bc.GetBlock(b1.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, b1.Hash, b.Hash)
+	_, err = bc.FindTransaction(b1.Transactions[0].ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+	_, ok := bc.UTXOIndex().FindOutput(b1.Transactions[0].ID, 0)
+	assert.False(t, ok)
+
+	expectedWork := new(big.Int).Mul(blockWork(genesis), big.NewInt(2))
+	assert.Equal(t, expectedWork, bc.Work())
+}
+
+func TestStoreBlockRejected(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+
+	a1 := mineTestBlock(t, genesis, "a1")
+	mustStoreBlock(t, bc, a1)
+	assert.ErrorIs(t, bc.storeBlock(a1), ErrBlockExists)
+
+	orphan := mineTestBlock(t, &Block{Hash: Hex2Bytes("0102"), Transactions: genesis.Transactions}, "orphan")
+	assert.ErrorIs(t, bc.storeBlock(orphan), ErrOrphanBlock)
+	assert.False(t, bc.HasBlock(orphan.Hash))
+	assert.False(t, bc.ValidateBlock(orphan))
+}
+
+func TestReorganization(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+	genesisTx := genesis.Transactions[0]
+
+	var events []ReorgEvent
+	bc.OnReorg(func(e ReorgEvent) {
+		events = append(events, e)
+	})
+
+	// branch a spends the genesis output
+	spend := &Transaction{
+		Vin: []TXInput{{
+			Txid:   genesisTx.ID,
+			OutIdx: 0,
+			PubKey: Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
+		}},
+		Vout: []TXOutput{{Value: BlockReward, PubKeyHash: Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")}},
+	}
+	spend.ID = spend.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	assert.Nil(t, bc.SignTransaction(spend, *privKey))
+
+	a1 := mineTestBlock(t, genesis, "a1", spend)
+	a2 := mineTestBlock(t, a1, "a2")
+	mustStoreBlock(t, bc, a1)
+	mustStoreBlock(t, bc, a2)
+
+	b1 := mineTestBlock(t, genesis, "b1")
+	b2 := mineTestBlock(t, b1, "b2")
+	b3 := mineTestBlock(t, b2, "b3")
+	mustStoreBlock(t, bc, b1)
+	mustStoreBlock(t, bc, b2)
+	assert.Equal(t, a2.Hash, bc.CurrentBlock().Hash)
+	assert.Empty(t, events)
+
+	_, ok := bc.UTXOIndex().FindOutput(genesisTx.ID, 0)
+	assert.False(t, ok, "genesis output should be spent in branch a")
+
+	// b3 makes branch b heavier
+	mustStoreBlock(t, bc, b3)
+	assert.Equal(t, b3.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, 3, bc.Height())
+	for height, b := range []*Block{genesis, b1, b2, b3} {
+		got, err := bc.GetBlockByHeight(height)
+		assert.Nil(t, err)
+		assert.Equalf(t, b.Hash, got.Hash, "wrong block at height %d", height)
+	}
+
+	if assert.Len(t, events, 1) {
+		e := events[0]
+		assert.Equal(t, a2.Hash, e.OldTip)
+		assert.Equal(t, b3.Hash, e.NewTip)
+		assert.Equal(t, genesis.Hash, e.Fork)
+		assert.Equal(t, [][]byte{a2.Hash, a1.Hash}, blockHashes(e.Disconnected))
+		assert.Equal(t, [][]byte{b1.Hash, b2.Hash, b3.Hash}, blockHashes(e.Connected))
+	}
+
+	// The UTXO index follows the new branch
+	_, ok = bc.UTXOIndex().FindOutput(genesisTx.ID, 0)
+	assert.True(t, ok, "genesis output should be unspent in branch b")
+	_, ok = bc.UTXOIndex().FindOutput(spend.ID, 0)
+	assert.False(t, ok)
+	for _, b := range []*Block{a1, a2} {
+		_, ok = bc.UTXOIndex().FindOutput(b.Transactions[0].ID, 0)
+		assert.False(t, ok)
+	}
+	amount, _ := bc.UTXOIndex().FindSpendableOutputs(GetPubKeyHashFromAddress(testMinerAddress), 0)
+	assert.Equal(t, 4*BlockReward, amount)
+
+	// a rebuilt index matches the incrementally maintained one
+	utxos := bc.UTXOIndex().Snapshot()
+	assert.Nil(t, bc.UTXOIndex().Reindex())
+	diff(t, utxos, bc.UTXOIndex().Snapshot(), "incorrect UTXO index after reorganization")
+
+	// and back to branch a
+	a3 := mineTestBlock(t, a2, "a3")
+	a4 := mineTestBlock(t, a3, "a4")
+	mustStoreBlock(t, bc, a3)
+	assert.Equal(t, b3.Hash, bc.CurrentBlock().Hash)
+	mustStoreBlock(t, bc, a4)
+	assert.Equal(t, a4.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, 4, bc.Height())
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, [][]byte{b3.Hash, b2.Hash, b1.Hash}, blockHashes(events[1].Disconnected))
+		assert.Equal(t, [][]byte{a1.Hash, a2.Hash, a3.Hash, a4.Hash}, blockHashes(events[1].Connected))
+	}
+	_, ok = bc.UTXOIndex().FindOutput(spend.ID, 0)
+	assert.True(t, ok)
+	_, ok = bc.UTXOIndex().FindOutput(genesisTx.ID, 0)
+	assert.False(t, ok)
+}
+
+func TestReorganizationInvalidScript(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	tip := bc.CurrentBlock()
+	parent, _ := bc.GetBlock(tip.PrevBlockHash)
+	utxos := bc.FindUTXOSet()
+
+	// a side branch spending an output with the signature of another transaction
+	forged := newTestSpend(t, bc, coinbases[0], 9)
+	forged.Vout[0].Value = 8
+	forged.ID = forged.Hash()
+	b1 := mineTestBlock(t, parent, "forged 1", forged)
+	b2 := mineTestBlock(t, b1, "forged 2")
+	mustStoreBlock(t, bc, b1)
+	assert.ErrorIs(t, bc.storeBlock(b2), ErrBadSignature)
+	assert.Equal(t, tip.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, utxos, bc.FindUTXOSet())
+}
+
+func TestReorganizationInBlockSpend(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	tip := bc.CurrentBlock()
+	utxos := bc.FindUTXOSet()
+
+	// a block spending an output created by an earlier transaction of it
+	spend := newTestSpend(t, bc, coinbases[0], 9)
+	child := &Transaction{
+		Vin:  []TXInput{{Txid: spend.ID, PubKey: testTransactions["tx2"].Vin[0].PubKey}},
+		Vout: []TXOutput{{Value: 8, PubKeyHash: spend.Vout[0].PubKeyHash}},
+	}
+	child.ID = child.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser2, testEncPubKeyUser2)
+	assert.Nil(t, child.Sign(*privKey, map[string]*Transaction{hex.EncodeToString(spend.ID): spend}))
+	a1 := mineTestBlock(t, tip, "a1", spend, child)
+	mustStoreBlock(t, bc, a1)
+	_, ok := bc.UTXOIndex().FindOutput(child.ID, 0)
+	assert.True(t, ok)
+
+	// a heavier branch disconnects it
+	b1 := mineTestBlock(t, tip, "b1")
+	b2 := mineTestBlock(t, b1, "b2")
+	mustStoreBlock(t, bc, b1)
+	mustStoreBlock(t, bc, b2)
+	assert.Equal(t, b2.Hash, bc.CurrentBlock().Hash)
+	for _, b := range []*Block{b1, b2} {
+		utxos[hex.EncodeToString(b.Transactions[0].ID)] = map[int]TXOutput{0: b.Transactions[0].Vout[0]}
+	}
+	assert.Equal(t, utxos, bc.FindUTXOSet())
+}
+
+func blockHashes(blocks []*Block) [][]byte {
+	var hashes [][]byte
+	for _, b := range blocks {
+		hashes = append(hashes, b.Hash)
+	}
+	return hashes
+}
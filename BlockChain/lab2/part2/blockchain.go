@@ -1,12 +1,14 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,18 +16,48 @@ var (
 	ErrTxNotFound    = errors.New("transaction not found")
 	ErrNoValidTx     = errors.New("there is no valid transaction")
 	ErrBlockNotFound = errors.New("block not found")
-	ErrInvalidBlock  = errors.New("block is not valid")
+
+	// errStopIteration stops a block iteration early
+	errStopIteration = errors.New("stop iteration")
 )
 
 // Blockchain keeps a sequence of Blocks
+// The blocks are kept in a Storage backend indexed by their hash and
+// by their height in the chain. Blocks of competing branches are kept
+// as well, and the main chain is the branch with the most work.
 type Blockchain struct {
-	blocks []*Block
+	db     Storage
+	mu     sync.RWMutex // protects tip, height and the listeners
+	tip    []byte       // hash of the last block
+	height int          // height of the last block (genesis is 0)
+
+	reorgListeners   []func(ReorgEvent)
+	connectListeners []func(*Block)
 }
 
-// NewBlockchain creates a new blockchain with genesis Block
-func NewBlockchain(address string) (*Blockchain, error) {
+// NewBlockchain opens the blockchain kept in the given storage.
+// If the storage is empty, a new blockchain is created with a
+// genesis Block that pays the reward to the given address.
+func NewBlockchain(db Storage, address string) (*Blockchain, error) {
+	bc := &Blockchain{db: db, height: -1}
+	err := db.View(func(tx StorageTx) error {
+		tip := tx.Get(metaBucket, tipKey)
+		if tip == nil {
+			return nil
+		}
+		bc.tip = tip
+		bc.height = int(binary.BigEndian.Uint64(tx.Get(metaBucket, heightKeyName)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if bc.tip != nil {
+		return bc, nil
+	}
+
 	// TODO(student)
-	conibaseTx, err := NewCoinbaseTX(address, "")
+	conibaseTx, err := NewCoinbaseTX(address, "", 0)
 	if err != nil {
 		return nil, ErrNoValidTx
 	}
@@ -35,166 +67,295 @@ func NewBlockchain(address string) (*Blockchain, error) {
 
 	block.Transactions[0].ID = block.Transactions[0].Hash()
 	block.Mine()
-	bc := &Blockchain{
-		blocks: []*Block{block},
+	if err := bc.storeBlock(block); err != nil {
+		return nil, err
 	}
 	return bc, nil
 }
 
+// HasBlockchain reports whether the storage already keeps a blockchain
+func HasBlockchain(db Storage) bool {
+	var found bool
+	db.View(func(tx StorageTx) error {
+		found = tx.Get(metaBucket, tipKey) != nil
+		return nil
+	})
+	return found
+}
+
+// Close closes the underlying storage
+func (bc *Blockchain) Close() error {
+	return bc.db.Close()
+}
+
 // addBlock saves the block into the blockchain
 func (bc *Blockchain) addBlock(block *Block) error {
 	// TODO(student) -- make sure you only add valid blocks!
-	ok := bc.ValidateBlock(block)
-	if !ok {
-		return ErrInvalidBlock
+	if err := bc.CheckBlock(block); err != nil {
+		return err
 	}
 
-	bc.blocks = append(bc.blocks, block)
-	return nil
+	return bc.storeBlock(block)
 }
 
 // GetGenesisBlock returns the Genesis Block
-func (bc Blockchain) GetGenesisBlock() *Block {
-	return bc.blocks[0]
+func (bc *Blockchain) GetGenesisBlock() *Block {
+	block, _ := bc.GetBlockByHeight(0)
+	return block
+}
+
+// CurrentBlock returns the last block, nil if its body is not kept,
+// see NewBlockchainFromSnapshot
+func (bc *Blockchain) CurrentBlock() *Block {
+	tip, _ := bc.Tip()
+	block, _ := bc.GetBlock(tip)
+	return block
 }
 
-// CurrentBlock returns the last block
-func (bc Blockchain) CurrentBlock() *Block {
-	return bc.blocks[len(bc.blocks)-1]
+// Height returns the height of the last block
+func (bc *Blockchain) Height() int {
+	_, height := bc.Tip()
+	return height
+}
+
+// Tip returns the hash and the height of the last block
+func (bc *Blockchain) Tip() ([]byte, int) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.tip, bc.height
 }
 
 // GetBlock returns the block of a given hash
-func (bc Blockchain) GetBlock(hash []byte) (*Block, error) {
-	for _, block := range bc.blocks {
-		if bytes.Equal(block.Hash, hash) {
-			return block, nil
+func (bc *Blockchain) GetBlock(hash []byte) (*Block, error) {
+	var block *Block
+	err := bc.db.View(func(tx StorageTx) error {
+		var err error
+		block, err = getBlock(tx, hash)
+		return err
+	})
+	return block, err
+}
+
+// GetBlockByHeight returns the block at the given height
+func (bc *Blockchain) GetBlockByHeight(height int) (*Block, error) {
+	var block *Block
+	err := bc.db.View(func(tx StorageTx) error {
+		hash := tx.Get(heightsBucket, heightKey(height))
+		if hash == nil {
+			return ErrBlockNotFound
+		}
+		var err error
+		block, err = getBlock(tx, hash)
+		return err
+	})
+	return block, err
+}
+
+// forEachBlock calls fn for every block of the main chain with its
+// height, starting from the genesis. Blocks are read one at a time from
+// the storage, and the pruned ones are skipped, see EnablePruning.
+func (bc *Blockchain) forEachBlock(fn func(block *Block, height int) error) error {
+	for height := 0; height <= bc.Height(); height++ {
+		block, err := bc.GetBlockByHeight(height)
+		if errors.Is(err, ErrBlockPruned) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(block, height); err != nil {
+			return err
 		}
 	}
-	return nil, ErrBlockNotFound
+	return nil
 }
 
-// ValidateBlock validates the block before adding it to the blockchain
-func (bc *Blockchain) ValidateBlock(block *Block) bool {
-	// TODO(student) -- a valid block cannot be nil and must contain txs.
-	// Also, it should has the result of a valid PoW.
-	if block == nil || len(block.Transactions) == 0 {
-		return false
+// getBlock reads and decodes a block from the storage
+func getBlock(tx StorageTx, hash []byte) (*Block, error) {
+	data := tx.Get(blocksBucket, hash)
+	if data == nil {
+		if tx.Get(headersBucket, hash) != nil {
+			return nil, fmt.Errorf("%w: %x", ErrBlockPruned, hash)
+		}
+		return nil, ErrBlockNotFound
 	}
+	return DeserializeBlock(data)
+}
 
-	// check if it has coinbase transaction
-	tx := block.Transactions[0]
+// heightKey encodes a block height as a storage key.
+// Big endian keeps the keys ordered by height.
+func heightKey(height int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(height))
+	return key
+}
 
-	if !tx.IsCoinbase() {
-		return false
-	}
+// ValidateBlock validates the block before adding it to the blockchain,
+// see CheckBlock for the reason a block is not valid
+func (bc *Blockchain) ValidateBlock(block *Block) bool {
+	// TODO(student) -- a valid block cannot be nil and must contain txs.
+	// Also, it should has the result of a valid PoW.
+	return bc.CheckBlock(block) == nil
+}
 
-	pow := NewProofOfWork(block)
-	if !pow.Validate() || pow.block.Nonce <= 1 {
-		return false
+// checkBlockValue checks, against the UTXO set of the main chain, that the
+// transactions of a block extending its tip spend unspent outputs, or the
+// outputs of earlier transactions of the block, with scripts unlocking
+// them and without creating value, and that the coinbase claims at most
+// the block reward plus the fees of the block
+func (bc *Blockchain) checkBlockValue(block *Block, height int) error {
+	utxos := bc.UTXOIndex()
+	created := make(map[string]TXOutput) // outputs of earlier transactions of the block
+	spent := make(map[string]bool)
+	fees := 0
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			var inputs []TXOutput
+			for _, vin := range tx.Vin {
+				key := outpointString(vin.Txid, vin.OutIdx)
+				out, ok := created[key]
+				if !ok {
+					var entry *utxoEntry
+					if entry, ok = utxos.findEntry(vin.Txid, vin.OutIdx); ok {
+						if !entry.spendableAt(height) {
+							return fmt.Errorf("transaction %x: %w: %s", tx.ID, ErrImmatureCoinbase, key)
+						}
+						out = entry.Output
+					}
+				}
+				if !ok || spent[key] {
+					return fmt.Errorf("transaction %x: %w: %s", tx.ID, ErrTxInputNotFound, key)
+				}
+				spent[key] = true
+				inputs = append(inputs, out)
+			}
+			fee, err := tx.Fee(inputs)
+			if err != nil {
+				return fmt.Errorf("transaction %x: %w", tx.ID, err)
+			}
+			if err := checkInputs(tx, inputs); err != nil {
+				return fmt.Errorf("transaction %x: %w", tx.ID, err)
+			}
+			fees += fee
+		}
+		for outIdx, out := range tx.Vout {
+			created[outpointString(tx.ID, outIdx)] = out
+		}
 	}
-	return true
+	return checkCoinbaseValue(block.Transactions[0], height, fees)
 }
 
 // MineBlock mines a new block with the provided transactions
 func (bc *Blockchain) MineBlock(transactions []*Transaction) (*Block, error) {
+	block, _, err := bc.MineBlockContext(context.Background(), transactions)
+	return block, err
+}
+
+// MineBlockContext mines a new block with the provided transactions on
+// every core, see Block.MineContext. It returns the error of the context
+// if it is done before the block is mined, or the consensus rule broken
+// by the transactions, see CheckTransaction and CheckBlock.
+func (bc *Blockchain) MineBlockContext(ctx context.Context, transactions []*Transaction) (*Block, MiningStats, error) {
 	// TODO(student)
 	// 1) Verify the existence of transactions inputs and discard invalid transactions that make reference to unknown inputs
 	// 2) Add a block if there is a list of valid transactions
-	prevBlock := bc.CurrentBlock()
-	newBlock := NewBlock(time.Now().Unix(), transactions, prevBlock.Hash)
+	prevHash, _ := bc.Tip()
+	newBlock := NewBlock(time.Now().Unix(), transactions, prevHash)
 	if newBlock == nil || len(newBlock.Transactions) == 0 {
-		return nil, ErrNoValidTx
+		return nil, MiningStats{}, ErrNoValidTx
 	}
+	bits, err := bc.NextBits(prevHash)
+	if err != nil {
+		return nil, MiningStats{}, err
+	}
+	newBlock.Bits = bits
 
 	// verify each transaction
 	for _, tx := range transactions {
-		if !bc.VerifyTransaction(tx) {
-			return nil, ErrNoValidTx
+		if err := bc.CheckTransaction(tx); err != nil {
+			return nil, MiningStats{}, fmt.Errorf("transaction %x: %w", tx.ID, err)
 		}
 	}
 
-	newBlock.Mine()
-	if bc.ValidateBlock(newBlock) {
-		bc.addBlock(newBlock)
-		return newBlock, nil
+	stats, err := newBlock.MineContext(ctx, MinerWorkers)
+	if err != nil {
+		return nil, stats, err
 	}
-
-	return nil, ErrNoValidTx
+	if err := bc.addBlock(newBlock); err != nil {
+		return nil, stats, err
+	}
+	return newBlock, stats, nil
 }
 
-// VerifyTransaction verifies transaction input signatures
+// VerifyTransaction verifies that every input of the transaction spends an
+// unspent output of the main chain locked with the key of the input,
+// that the outputs are worth at most the spent outputs,
+// and that the input signatures are valid, see CheckTransaction.
+// The inputs spending a MultisigAddress need m valid signatures.
 func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
-	// TODO(student)
-	// Modify the function to get the inputs referred in tx
-	// and return false in case of some error (i.e. not found the input).
-	// Then call Verify for tx passing those inputs as parameter and return the result.
 	// Remember that coinbase transaction doesn't have input or signature. Thus all coinbase tx are valid.
-	result := false
-	for _, vin := range tx.Vin {
-		// Check if coinbase transaction
-		if vin.OutIdx == -1 {
-			result = true
-			break
-		}
+	return bc.CheckTransaction(tx) == nil
+}
 
-		prevTx, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			return false
-		}
-		for _, ip := range prevTx.Vout {
-			if ip.IsLockedWithKey(HashPubKey(vin.PubKey)) {
-				result = true
-				break
-			}
+// TransactionFee returns the fee paid by a transaction spending
+// unspent outputs of the main chain
+func (bc *Blockchain) TransactionFee(tx *Transaction) (int, error) {
+	utxos := bc.UTXOIndex()
+	var inputs []TXOutput
+	for _, vin := range tx.Vin {
+		out, ok := utxos.FindOutput(vin.Txid, vin.OutIdx)
+		if !ok {
+			return 0, ErrTxInputNotFound
 		}
+		inputs = append(inputs, out)
 	}
-	return result
+	return tx.Fee(inputs)
 }
 
-// FindTransaction finds a transaction by its ID in the whole blockchain
-func (bc Blockchain) FindTransaction(ID []byte) (*Transaction, error) {
-	for _, block := range bc.blocks {
-		for _, tran := range block.Transactions {
-			if bytes.Equal(tran.ID, ID) {
-				return tran, nil
-			}
+// TotalFees returns the fees paid by transactions spending
+// unspent outputs of the main chain
+func (bc *Blockchain) TotalFees(txs []*Transaction) (int, error) {
+	total := 0
+	for _, tx := range txs {
+		fee, err := bc.TransactionFee(tx)
+		if err != nil {
+			return 0, err
 		}
+		total += fee
 	}
-	return nil, ErrTxNotFound
+	return total, nil
 }
 
-// FindUTXOSet finds and returns all unspent transaction outputs
-func (bc Blockchain) FindUTXOSet() UTXOSet {
-	// TODO(student) -- YOU DON'T NEED TO CHANGE YOUR PREVIOUS METHOD
-	utxoSet := make(UTXOSet)
-	for _, block := range bc.blocks {
-		for _, tran := range block.Transactions {
-			mp := make(map[int]TXOutput)
-			for idx, out := range tran.Vout {
-				mp[idx] = out
-			}
-			id := hex.EncodeToString(tran.ID)
-			utxoSet[id] = mp
-		}
+// FindTransaction finds a transaction by its ID in the whole blockchain.
+// With the transaction indexes, see EnableTxIndex, the blocks are not
+// scanned.
+func (bc *Blockchain) FindTransaction(ID []byte) (*Transaction, error) {
+	if tran, _, err := bc.findIndexedTransaction(ID); !errors.Is(err, ErrNoTxIndex) {
+		return tran, err
 	}
-
-	for _, block := range bc.blocks {
-		for _, tran := range block.Transactions {
-			for _, in := range tran.Vin {
-				// if the prevHash is found in there, delete the entry
-				// tid := fmt.Sprintf("%x", in.Txid)
-				tid := hex.EncodeToString(in.Txid)
-				if _, ok := utxoSet[tid]; ok {
-					delete(utxoSet[tid], in.OutIdx)
-					if len(utxoSet[tid]) == 0 {
-						delete(utxoSet, tid)
-					}
-				}
-			}
+	var found *Transaction
+	err := bc.forEachBlock(func(block *Block, height int) error {
+		tran, err := block.FindTransaction(ID)
+		if err == nil {
+			found = tran
+			return errStopIteration
 		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrTxNotFound
 	}
+	return found, nil
+}
 
-	return utxoSet
+// FindUTXOSet finds and returns all unspent transaction outputs
+// The outputs are read from the UTXO index, see UTXOIndex.Reindex
+// to rebuild it from the blocks.
+func (bc *Blockchain) FindUTXOSet() UTXOSet {
+	return bc.UTXOIndex().Snapshot()
 }
 
 // GetInputTXsOf returns a map index by the ID,
@@ -213,6 +374,10 @@ func (bc *Blockchain) GetInputTXsOf(tx *Transaction) (map[string]*Transaction, e
 
 	for _, vin := range tx.Vin {
 		tran, err := bc.FindTransaction(vin.Txid)
+		if errors.Is(err, ErrTxNotFound) && bc.PrunedHeight() > 0 {
+			// the block of the transaction may be pruned
+			tran, err = bc.unspentOutputsOf(vin.Txid)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -236,10 +401,31 @@ func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)
 	return err
 }
 
-func (bc Blockchain) String() string {
+// SignMultisigTransaction adds the signature of a co-signer to the inputs
+// of a Transaction spending a MultisigAddress, see Transaction.SignMultisig
+func (bc *Blockchain) SignMultisigTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTxs, err := bc.GetInputTXsOf(tx)
+	if err != nil {
+		return err
+	}
+	return tx.SignMultisig(privKey, prevTxs)
+}
+
+// CombineSignatures merges the multisig signatures of other into tx,
+// see Transaction.CombineSignatures
+func (bc *Blockchain) CombineSignatures(tx, other *Transaction) error {
+	prevTxs, err := bc.GetInputTXsOf(tx)
+	if err != nil {
+		return err
+	}
+	return tx.CombineSignatures(other, prevTxs)
+}
+
+func (bc *Blockchain) String() string {
 	var lines []string
-	for _, block := range bc.blocks {
+	bc.forEachBlock(func(block *Block, height int) error {
 		lines = append(lines, fmt.Sprintf("%v", block))
-	}
+		return nil
+	})
 	return strings.Join(lines, "\n")
 }
@@ -1,26 +1,75 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// TestMain lets the tests spend coinbases right away, as the fixture
+// chain and most test chains are far shorter than CoinbaseMaturity.
+// The tests of maturity set it back, see setCoinbaseMaturity.
+func TestMain(m *testing.M) {
+	CoinbaseMaturity = 0
+	os.Exit(m.Run())
+}
+
 func newMockBlockchain() *Blockchain {
-	return &Blockchain{[]*Block{testBlockchainData["block0"]}}
+	bc := &Blockchain{db: NewMemoryStorage(), height: -1}
+	addMockBlock(bc, testBlockchainData["block0"])
+	return bc
 }
 
 func addMockBlock(bc *Blockchain, newBlock *Block) {
-	bc.blocks = append(bc.blocks, newBlock)
+	if err := bc.storeBlock(newBlock); err != nil {
+		panic(err)
+	}
 }
 
 func TestBlockchain(t *testing.T) {
-	bc, err := NewBlockchain("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh")
+	bc, err := NewBlockchain(NewMemoryStorage(), "14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh")
 	if bc == nil {
 		t.Fatal("Blockchain is nil")
 	}
 	assert.Nil(t, err)
-	assert.Equal(t, 1, len(bc.blocks))
+	assert.Equal(t, 0, bc.Height())
+}
+
+func TestReopenBlockchain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBFile)
+	db, err := OpenBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, HasBlockchain(db))
+
+	bc, err := NewBlockchain(db, "14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh")
+	if bc == nil {
+		t.Fatal("Blockchain is nil")
+	}
+	assert.Nil(t, err)
+	genesis := bc.CurrentBlock()
+	assert.Nil(t, bc.Close())
+
+	db, err = OpenBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assert.True(t, HasBlockchain(db))
+
+	// The address is ignored when the chain already exists
+	bc, err = NewBlockchain(db, "1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, bc.Height())
+	diff(t, genesis, bc.CurrentBlock(), "reopened chain has a different tip")
+	diff(t, genesis, bc.GetGenesisBlock(), "reopened chain has a different genesis")
+
+	tx, err := bc.FindTransaction(genesis.Transactions[0].ID)
+	assert.Nil(t, err)
+	diff(t, genesis.Transactions[0], tx, "incorrect transaction found")
 }
 
 func TestGetGenesisBlock(t *testing.T) {
@@ -39,7 +88,7 @@ func TestGetGenesisBlock(t *testing.T) {
 		assert.Equal(t, 1, len(gb.Transactions))
 		assert.Equal(t, -1, coinbaseTx.Vin[0].OutIdx)
 		assert.Nil(t, coinbaseTx.Vin[0].Txid)
-		assert.Equal(t, []byte(GenesisCoinbaseData), coinbaseTx.Vin[0].PubKey)
+		assert.Equal(t, coinbaseScript(0, []byte(GenesisCoinbaseData)), coinbaseTx.Vin[0].PubKey)
 		assert.Equal(t, BlockReward, coinbaseTx.Vout[0].Value)
 		assert.Equal(t, Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38"), coinbaseTx.Vout[0].PubKeyHash)
 	} else {
@@ -49,20 +98,20 @@ func TestGetGenesisBlock(t *testing.T) {
 
 func TestAddBlock(t *testing.T) {
 	bc := newMockBlockchain()
-	assert.Equal(t, 1, len(bc.blocks))
+	assert.Equal(t, 0, bc.Height())
 
 	b1 := testBlockchainData["block1"]
 	err := bc.addBlock(b1)
 	assert.Nil(t, err, "unexpected error adding block %x", b1.Hash)
-	assert.Equal(t, 2, len(bc.blocks))
+	assert.Equal(t, 1, bc.Height())
 
-	gb := bc.blocks[0]
+	gb := bc.GetGenesisBlock()
 	assert.Equalf(t, gb.Hash, b1.PrevBlockHash, "Genesis block Hash: %x isn't equal to current PrevBlockHash: %x", gb.Hash, b1.PrevBlockHash)
 
 	b2 := testBlockchainData["block2"]
 	err = bc.addBlock(b2)
 	assert.Nil(t, err, "unexpected error adding block %x", b2.Hash)
-	assert.Equal(t, 3, len(bc.blocks))
+	assert.Equal(t, 2, bc.Height())
 	assert.Equalf(t, b1.Hash, b2.PrevBlockHash, "Previous block Hash: %x isn't equal to the expected: %x", b2.PrevBlockHash, b1.Hash)
 }
 
@@ -74,26 +123,31 @@ func TestCurrentBlock(t *testing.T) {
 		t.Fatal("CurrentBlock returned nil")
 	}
 
-	expectedBlock := bc.blocks[0]
+	expectedBlock := testBlockchainData["block0"]
 	assert.Equalf(t, expectedBlock.Hash, b.Hash, "Current block Hash: %x isn't the expected: %x", b.Hash, expectedBlock.Hash)
 
 	addMockBlock(bc, testBlockchainData["block1"])
 
 	b = bc.CurrentBlock()
-	expectedBlock = bc.blocks[1]
+	expectedBlock = testBlockchainData["block1"]
 	assert.Equalf(t, expectedBlock.Hash, b.Hash, "Current block Hash: %x isn't the expected: %x", b.Hash, expectedBlock.Hash)
 }
 
 func TestGetBlock(t *testing.T) {
 	bc := newMockBlockchain()
 
-	b, err := bc.GetBlock(bc.blocks[0].Hash)
+	gb := testBlockchainData["block0"]
+	b, err := bc.GetBlock(gb.Hash)
 	assert.Nil(t, err)
 	if b == nil {
 		t.Fatal("GetBlock returned nil")
 	}
 
-	assert.Equalf(t, bc.blocks[0].Hash, b.Hash, "Block Hash: %x isn't the expected: %x", b.Hash, bc.blocks[0].Hash)
+	assert.Equalf(t, gb.Hash, b.Hash, "Block Hash: %x isn't the expected: %x", b.Hash, gb.Hash)
+
+	b, err = bc.GetBlock(Hex2Bytes("non-existentID"))
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+	assert.Nil(t, b)
 }
 
 func TestMineBlockWithInvalidTxInput(t *testing.T) {
@@ -115,9 +169,10 @@ func TestMineBlockWithInvalidTxInput(t *testing.T) {
 			{Value: 5, PubKeyHash: Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38")},
 		},
 	}
+	invalidTx.ID = invalidTx.Hash()
 
 	b, err := bc.MineBlock([]*Transaction{invalidTx})
-	assert.ErrorIs(t, err, ErrNoValidTx)
+	assert.ErrorIs(t, err, ErrTxInputNotFound)
 	assert.Nil(t, b)
 }
 
@@ -125,12 +180,12 @@ func TestMineBlock(t *testing.T) {
 	bc := newMockBlockchain()
 
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
-				Signature: Hex2Bytes("add1f5693b8606c0273672e8ca515efcaee68c22d1a826280c77cbb43c871e2a32bd7ffce5e5081a9cbb03b31a95e713a9415be63c8d48d40678a92fc28df5f7"),
+				Signature: Hex2Bytes("3045022100ae8cdb5a2884b0cb70cab16f3a5d31533a650271ba551b1aac54c60ff95cc8390220482f43005c48d82d31a7c057ac9a49b1edc2c2fed1c5dcbece551740740cdb57"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
 			},
 		},
@@ -154,9 +209,9 @@ func TestMineBlock(t *testing.T) {
 	if b == nil {
 		t.Fatal("MineBlock returned nil")
 	}
-	assert.Equal(t, 2, len(bc.blocks))
+	assert.Equal(t, 1, bc.Height())
 
-	gb := bc.blocks[0]
+	gb := bc.GetGenesisBlock()
 	assert.Equalf(t, gb.Hash, b.PrevBlockHash, "Genesis block Hash: %x isn't equal to current PrevBlockHash: %x", gb.Hash, b.PrevBlockHash)
 
 	minedBlock, err := bc.GetBlock(b.Hash)
@@ -164,7 +219,7 @@ func TestMineBlock(t *testing.T) {
 	if minedBlock == nil {
 		t.Fatal("GetBlock returned nil")
 	} else {
-		txMinedBlock := bc.blocks[1].Transactions[1] // second tx in block1
+		txMinedBlock := minedBlock.Transactions[1] // second tx in block1
 		assert.NotNil(t, txMinedBlock)
 		assert.Equal(t, tx.ID, txMinedBlock.ID)
 	}
@@ -173,10 +228,10 @@ func TestMineBlock(t *testing.T) {
 func TestSignTransaction(t *testing.T) {
 	bc := newMockBlockchain()
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
 				Signature: nil,
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
@@ -193,7 +248,7 @@ func TestSignTransaction(t *testing.T) {
 			},
 		},
 	}
-	privKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
 	bc.SignTransaction(tx, *privKey)
 
 	assert.NotNil(t, tx.Vin[0].Signature)
@@ -202,7 +257,7 @@ func TestSignTransaction(t *testing.T) {
 func TestSignTransactionWithInvalidTxInput(t *testing.T) {
 	bc := newMockBlockchain()
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
 				Txid:      Hex2Bytes("non-existentID"),
@@ -222,7 +277,7 @@ func TestSignTransactionWithInvalidTxInput(t *testing.T) {
 			},
 		},
 	}
-	privKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
 
 	err := bc.SignTransaction(tx, *privKey)
 	assert.ErrorIs(t, err, ErrTxNotFound)
@@ -234,12 +289,12 @@ func TestVerifyTransaction(t *testing.T) {
 	assert.True(t, bc.VerifyTransaction(testTransactions["tx0"]))
 
 	signedTX := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
-				Signature: Hex2Bytes("add1f5693b8606c0273672e8ca515efcaee68c22d1a826280c77cbb43c871e2a32bd7ffce5e5081a9cbb03b31a95e713a9415be63c8d48d40678a92fc28df5f7"),
+				Signature: Hex2Bytes("3045022100ae8cdb5a2884b0cb70cab16f3a5d31533a650271ba551b1aac54c60ff95cc8390220482f43005c48d82d31a7c057ac9a49b1edc2c2fed1c5dcbece551740740cdb57"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
 			},
 		},
@@ -260,7 +315,7 @@ func TestVerifyTransaction(t *testing.T) {
 func TestVerifyTransactionInvalidTxInput(t *testing.T) {
 	bc := newMockBlockchain()
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
 				Txid:      Hex2Bytes("non-existentID"),
@@ -299,65 +354,101 @@ func TestValidateBlock(t *testing.T) {
 		{
 			name: "valid mined",
 			block: &Block{
-				Timestamp: TestBlockTime,
+				BlockHeader: BlockHeader{
+					Version:       blockVersion,
+					PrevBlockHash: testBlockchainData["block0"].Hash,
+					MerkleRoot:    Hex2Bytes("073c61b6216704c54ea31616c1fe9fe3b0b4e5ad32e373c089247e02fbabe912"),
+					Timestamp:     TestBlockTime,
+					Bits:          InitialBits,
+					Nonce:         240,
+				},
 				Transactions: []*Transaction{
 					minerCoinbaseTx["tx1"],
 					testTransactions["tx1"],
 				},
-				PrevBlockHash: testBlockchainData["block0"].Hash,
-				Hash:          Hex2Bytes("0017379eb3ca189e5deaecc58523533883452ffe7dbba43cd71c3319d392a931"),
-				Nonce:         35,
+				Hash: Hex2Bytes("00b44ac4016b8f887e29d12c1cd6e69146f6fef653d7a1611ae050c023617517"),
 			},
 			valid: true,
 		},
 		{
 			name: "invalid block hash",
 			block: &Block{
-				Timestamp:     TestBlockTime,
-				Transactions:  []*Transaction{testTransactions["tx0"]},
-				PrevBlockHash: nil,
-				Hash:          Hex2Bytes("73d40a0510b6327d0fbcd4a2baf6e7a70f2de174ad2c84538a7b09320e9db3f2"),
-				Nonce:         164,
+				BlockHeader: BlockHeader{
+					Version:       blockVersion,
+					PrevBlockHash: nil,
+					MerkleRoot:    Hex2Bytes("426b361af5b5c788b8b14b7c5ba11d42f385b45e3923a875f516ee55e1d9d830"),
+					Timestamp:     TestBlockTime,
+					Bits:          InitialBits,
+					Nonce:         164,
+				},
+				Transactions: []*Transaction{testTransactions["tx0"]},
+				Hash:         Hex2Bytes("73d40a0510b6327d0fbcd4a2baf6e7a70f2de174ad2c84538a7b09320e9db3f2"),
 			},
 			valid: false,
 		},
 		{
 			name: "invalid block nonce",
 			block: &Block{
-				Timestamp:     TestBlockTime,
-				Transactions:  []*Transaction{testTransactions["tx0"]},
-				PrevBlockHash: nil,
-				Hash:          Hex2Bytes("00b8075f4a34f54c1cf0c7f6ec9605a52161ee21e974abb4fa8a39ab7553049a"),
-				Nonce:         1,
+				BlockHeader: BlockHeader{
+					Version:       blockVersion,
+					PrevBlockHash: nil,
+					MerkleRoot:    Hex2Bytes("426b361af5b5c788b8b14b7c5ba11d42f385b45e3923a875f516ee55e1d9d830"),
+					Timestamp:     TestBlockTime + 799,
+					Bits:          InitialBits,
+					Nonce:         1,
+				},
+				Transactions: []*Transaction{testTransactions["tx0"]},
+				Hash:         Hex2Bytes("0073135867c6c9d0a763f37cbdf773af88f7c3596e064c6b5ed5169d2f5d7364"),
+			},
+			valid: false,
+		},
+		{
+			name: "invalid merkle root",
+			block: &Block{
+				BlockHeader: testBlockchainData["block1"].BlockHeader,
+				Transactions: []*Transaction{
+					minerCoinbaseTx["tx1"],
+				},
+				Hash: testBlockchainData["block1"].Hash,
 			},
 			valid: false,
 		},
 		{
 			name: "missing coinbase",
 			block: &Block{
-				Timestamp: TestBlockTime,
+				BlockHeader: BlockHeader{
+					Version:       blockVersion,
+					PrevBlockHash: testBlockchainData["block1"].Hash,
+					MerkleRoot:    Hex2Bytes("752f9a7a66bc6c90f5b9858ab5dc19f3a7d7b549febe9631f47f2a1ead19cb7c"),
+					Timestamp:     TestBlockTime,
+					Bits:          InitialBits,
+					Nonce:         600,
+				},
 				// missing coinbase transaction
 				Transactions: []*Transaction{
 					testTransactions["tx3"],
 				},
-				PrevBlockHash: testBlockchainData["block1"].Hash,
-				Hash:          Hex2Bytes("001b92bf4f15fccc72d5f3be56c430507f83179014da38d9289dbdc03c790c3f"),
-				Nonce:         153,
+				Hash: Hex2Bytes("005845c64e0fcf2ac8a4a10b001309f3703804b2a120b307b6126de36b396c2d"),
 			},
 			valid: false,
 		},
 		{
 			name: "wrong coinbase order",
 			block: &Block{
-				Timestamp: TestBlockTime,
+				BlockHeader: BlockHeader{
+					Version:       blockVersion,
+					PrevBlockHash: testBlockchainData["block0"].Hash,
+					MerkleRoot:    Hex2Bytes("eb56337d52c667648f3628951a86cf2c62af9cb133e9680e93cd8cc32588b97d"),
+					Timestamp:     TestBlockTime,
+					Bits:          InitialBits,
+					Nonce:         218,
+				},
 				// wrong coinbase order; Coinbase must be the first transaction in a block!
 				Transactions: []*Transaction{
 					testTransactions["tx1"],
 					minerCoinbaseTx["tx1"],
 				},
-				PrevBlockHash: testBlockchainData["block0"].Hash,
-				Hash:          Hex2Bytes("005209ca671422e9295965054ce9940f3ecbf1e15823d7fe5b1ce144ad1cc28f"),
-				Nonce:         410,
+				Hash: Hex2Bytes("00f1e879a431acc2acc16bb1f5dfb2d6c9f7a8a90872bea831575fcf2837fe9a"),
 			},
 			valid: false,
 		},
@@ -378,6 +469,45 @@ func TestValidateBlock(t *testing.T) {
 	}
 }
 
+func TestValidateBlockValue(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 2)
+	tip := bc.CurrentBlock()
+	spend := newTestSpend(t, bc, coinbases[0], 7) // fee of 3
+
+	coinbaseWithFees := func(fees int) *Transaction {
+		tx, err := NewCoinbaseTXWithFees(testMinerAddress, "", bc.Height()+1, fees)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	greedy := mineTestBlockWithCoinbase(tip, coinbaseWithFees(4), spend)
+	assert.False(t, bc.ValidateBlock(greedy), "coinbase claims more than the fees")
+	assert.ErrorIs(t, bc.storeBlock(greedy), ErrCoinbaseValue)
+
+	minting := mineTestBlockWithCoinbase(tip, coinbaseWithFees(0), newTestSpend(t, bc, coinbases[1], 11))
+	assert.False(t, bc.ValidateBlock(minting), "transaction creates value")
+	assert.ErrorIs(t, bc.storeBlock(minting), ErrValueNotConserved)
+
+	doubleSpend := mineTestBlockWithCoinbase(tip, coinbaseWithFees(0), spend, newTestSpend(t, bc, coinbases[0], 6))
+	assert.False(t, bc.ValidateBlock(doubleSpend), "output spent twice")
+
+	valid := mineTestBlockWithCoinbase(tip, coinbaseWithFees(3), spend)
+	assert.True(t, bc.ValidateBlock(valid))
+	assert.Nil(t, bc.addBlock(valid))
+	out, ok := bc.UTXOIndex().FindOutput(valid.Transactions[0].ID, 0)
+	assert.True(t, ok)
+	assert.Equal(t, BlockReward+3, out.Value)
+
+	// a branch with a greedy coinbase cannot become the main chain
+	side := mineTestBlockWithCoinbase(tip, coinbaseWithFees(1))
+	mustStoreBlock(t, bc, side)
+	heavier := mineTestBlock(t, side, "heavier")
+	assert.ErrorIs(t, bc.storeBlock(heavier), ErrCoinbaseValue)
+	assert.Equal(t, valid.Hash, bc.CurrentBlock().Hash)
+}
+
 func TestFindTransactionSuccess(t *testing.T) {
 	bc := newMockBlockchain()
 
@@ -1,169 +1,1414 @@
 package main
 
 import (
-	"crypto/ecdsa"
-	_ "embed"
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/manifoldco/promptui"
 )
 
-const (
-	CREATE_BLCKCHN = "create-blockchain"
-	ADD_TRAN       = "add-transaction"
-	DEMO_TRAN      = "demo-transaction"
-	GET_BALANCE    = "get-balace"
-	MINE_BLCK      = "mine-block"
-	PRINT_CHAIN    = "print-chain"
-	PRINT_BLCK     = "print-block"
-	PRINT_TRAN     = "print-transaction"
-	EXIT           = "exit"
+// The command-line interface runs one command on the blockchain and the
+// wallets kept in a data directory, see DBFile and WalletsFile:
+//
+//	blockchain [-datadir DIR] [-json] COMMAND [FLAGS]
+//
+// With -json, the result of the command is written as a JSON value, and
+// an error as {"error": "..."}, so the commands can be scripted.
+// The passphrase of the wallets is read from PassphraseEnv, or asked for
+// on the terminal.
+
+// PassphraseEnv is the environment variable holding the wallets passphrase
+const PassphraseEnv = "WALLET_PASSPHRASE"
+
+var (
+	ErrUsage        = errors.New("usage")
+	ErrChainExists  = errors.New("blockchain already exists")
+	ErrNoBlockchain = errors.New("no blockchain, see createblockchain")
 )
 
-func getBalance(address string, utxo UTXOSet) int {
+// command is a command of the CLI. run parses the flags of the command
+// and returns the result printed by the CLI.
+type command struct {
+	name string
+	args string
+	help string
+	run  func(c *CLI, fs *flag.FlagSet, args []string) (interface{}, error)
+}
+
+var commands = []command{
+	{"createwallet", "[-label LABEL] [-scheme SCHEME]", "add a new address to the wallets, created if needed", (*CLI).createWallet},
+	{"listaddresses", "", "list the addresses of the wallets with their balance", (*CLI).listAddresses},
+	{"importkey", "[-label LABEL] < KEY.pem", "add the PEM encoded private key read from stdin", (*CLI).importKey},
+	{"exportkey", "-address ADDRESS", "print the PEM encoded private key of an address", (*CLI).exportKey},
+	{"labeladdress", "-address ADDRESS -label LABEL", "change the label of an address", (*CLI).labelAddress},
+	{"newseed", "[-seedpassphrase PASSPHRASE] [-scheme SCHEME]", "give the wallets a new mnemonic seed, the new addresses derive from it", (*CLI).newSeed},
+	{"restoreseed", "[-seedpassphrase PASSPHRASE] [-scheme SCHEME] < MNEMONIC", "restore the funded addresses of the mnemonic read from stdin", (*CLI).restoreSeed},
+	{"watchxpub", "-xpub XPUB", "list the funded addresses of an account public key", (*CLI).watchXpub},
+	{"createblockchain", "-address ADDRESS", "create a blockchain whose genesis block pays ADDRESS", (*CLI).createBlockchain},
+	{"getbalance", "-address ADDRESS", "print the balance of an address", (*CLI).getBalance},
+	{"send", "-from ADDRESS -to ADDRESS -amount N [-fee N] [-feerate N] [-coins STRATEGY] (-mine | -node HOST:PORT)", "send coins from an address of the wallets, mined at once or relayed to a node", (*CLI).send},
+	{"createpsbt", "(-from ADDRESS | -pubkey KEY) -to ADDRESS -amount N [-fee N] [-feerate N] [-coins STRATEGY] -out FILE", "write an unsigned transaction with the transactions it spends, to be signed offline", (*CLI).createPSBT},
+	{"decodepsbt", "-in FILE", "print a partially signed transaction, its fee and its signed inputs", (*CLI).decodePSBT},
+	{"signpsbt", "-in FILE [-out FILE]", "sign a partially signed transaction with the keys of the wallets, without the blockchain", (*CLI).signPSBT},
+	{"combinepsbt", "-in FILE,FILE... -out FILE", "merge the signatures of copies of a partially signed transaction", (*CLI).combinePSBT},
+	{"sendpsbt", "-in FILE (-mine ADDRESS | -node HOST:PORT)", "send a fully signed transaction, mined at once or relayed to a node", (*CLI).sendPSBT},
+	{"mine", "-address ADDRESS [-blocks N]", "mine blocks paying ADDRESS", (*CLI).mine},
+	{"printchain", "", "print the blocks of the main chain, from the genesis", (*CLI).printChain},
+	{"reindexutxo", "", "rebuild the index of the unspent outputs", (*CLI).reindexUTXO},
+	{"txindex", "[-disable]", "build and keep the transaction, address and spent output indexes, or drop them", (*CLI).txIndex},
+	{"gethistory", "-address ADDRESS", "print the transactions of an address, with the transaction indexes", (*CLI).getHistory},
+	{"prune", "(-depth N | -disable)", "discard the bodies of the blocks more than N blocks below the tip, or stop pruning", (*CLI).prune},
+	{"exportsnapshot", "-out FILE", "write the UTXO set at the tip with the headers of the chain, and print its commitment", (*CLI).exportSnapshot},
+	{"importsnapshot", "-in FILE -commitment HASH", "create the blockchain of a snapshot with a trusted commitment, without its blocks", (*CLI).importSnapshot},
+	{"startnode", "-port PORT [-peer HOST:PORT] [-mine ADDRESS] [-rpc HOST:PORT]", "run a node until interrupted, mining for ADDRESS and serving the JSON-RPC API", (*CLI).startNode},
+}
+
+// CLI runs the commands on the data of a directory
+type CLI struct {
+	DataDir string
+	JSON    bool
+	Out     io.Writer
+	In      io.Reader
+	Err     io.Writer // the usage messages, os.Stderr if nil
+	// Passphrase returns the passphrase of the wallets
+	Passphrase func(prompt string) (string, error)
+	// Interrupt is closed to stop mining and startnode
+	Interrupt <-chan struct{}
+
+	db *BoltStorage
+}
+
+// NewCLI returns the CLI of the process, on its standard streams and
+// interrupted by os.Interrupt
+func NewCLI() *CLI {
+	interrupt := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		close(interrupt)
+	}()
+	return &CLI{DataDir: ".", Out: os.Stdout, In: os.Stdin, Err: os.Stderr, Passphrase: terminalPassphrase, Interrupt: interrupt}
+}
+
+// terminalPassphrase returns the passphrase of PassphraseEnv, or asks
+// for it on the terminal
+func terminalPassphrase(prompt string) (string, error) {
+	if passphrase, ok := os.LookupEnv(PassphraseEnv); ok {
+		return passphrase, nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return "", fmt.Errorf("no terminal to ask for the passphrase, set %s", PassphraseEnv)
+	}
+	return (&promptui.Prompt{Label: prompt, Mask: '*'}).Run()
+}
+
+func main() {
+	c := NewCLI()
+	if err := c.Run(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		if c.JSON {
+			json.NewEncoder(c.Out).Encode(map[string]string{"error": err.Error()})
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// Run parses the global flags and runs the command of the arguments
+func (c *CLI) Run(args []string) error {
+	fs := flag.NewFlagSet("blockchain", flag.ContinueOnError)
+	fs.StringVar(&c.DataDir, "datadir", c.DataDir, "the directory of the blockchain and the wallets")
+	fs.BoolVar(&c.JSON, "json", c.JSON, "write the results as JSON")
+	fs.SetOutput(c.Err)
+	fs.Usage = func() { c.usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("%w: no command", ErrUsage)
+	}
+	for _, cmd := range commands {
+		if cmd.name != fs.Arg(0) {
+			continue
+		}
+		cmdFlags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+		cmdFlags.SetOutput(fs.Output())
+		cmdFlags.Usage = func() {
+			fmt.Fprintf(cmdFlags.Output(), "Usage: blockchain %s %s\n  %s\n", cmd.name, cmd.args, cmd.help)
+			cmdFlags.PrintDefaults()
+		}
+		defer c.close()
+		result, err := cmd.run(c, cmdFlags, fs.Args()[1:])
+		if err != nil {
+			return err
+		}
+		return c.print(result)
+	}
+	fs.Usage()
+	return fmt.Errorf("%w: unknown command %q", ErrUsage, fs.Arg(0))
+}
+
+func (c *CLI) usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintln(w, "Usage: blockchain [-datadir DIR] [-json] COMMAND [FLAGS]")
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\n    \t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+}
+
+// print writes the result of a command, as JSON or as text
+func (c *CLI) print(result interface{}) error {
+	if c.JSON {
+		return json.NewEncoder(c.Out).Encode(result)
+	}
+	_, err := fmt.Fprintln(c.Out, result)
+	return err
+}
+
+// parseFlags parses the flags of a command, which takes no other
+// arguments, and checks that the required flags are set
+func parseFlags(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected argument %q", ErrUsage, fs.Arg(0))
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range required {
+		if !set[name] {
+			return fmt.Errorf("%w: %s needs -%s", ErrUsage, fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+// checkAddresses returns the error of the first invalid address
+func checkAddresses(addresses ...string) error {
+	for _, address := range addresses {
+		if _, _, err := DecodeAddress(address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openDB opens the storage of the blockchain, created if needed
+func (c *CLI) openDB() (*BoltStorage, error) {
+	if c.db == nil {
+		if err := os.MkdirAll(c.DataDir, 0700); err != nil {
+			return nil, err
+		}
+		db, err := OpenBoltStorage(filepath.Join(c.DataDir, DBFile))
+		if err != nil {
+			return nil, err
+		}
+		c.db = db
+	}
+	return c.db, nil
+}
+
+// blockchain opens the blockchain, which must exist
+func (c *CLI) blockchain() (*Blockchain, error) {
+	if _, err := os.Stat(filepath.Join(c.DataDir, DBFile)); err != nil {
+		return nil, ErrNoBlockchain
+	}
+	db, err := c.openDB()
+	if err != nil {
+		return nil, err
+	}
+	if !HasBlockchain(db) {
+		return nil, ErrNoBlockchain
+	}
+	return NewBlockchain(db, "")
+}
+
+func (c *CLI) close() {
+	if c.db != nil {
+		c.db.Close()
+		c.db = nil
+	}
+}
+
+// wallets loads the wallets, unlocked when asked to
+func (c *CLI) wallets(unlock bool) (*Wallets, error) {
+	ws, err := LoadWallets(filepath.Join(c.DataDir, WalletsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no wallets, see createwallet: %w", err)
+	}
+	if err != nil || !unlock {
+		return ws, err
+	}
+	passphrase, err := c.Passphrase("Passphrase")
+	if err != nil {
+		return nil, err
+	}
+	return ws, ws.Unlock(passphrase)
+}
+
+// readLine returns the first line of the input
+func (c *CLI) readLine() (string, error) {
+	line, err := bufio.NewReader(c.In).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// walletResult is an address of the wallets
+type walletResult struct {
+	Address string `json:"address"`
+	Bech32  string `json:"bech32"`
+	PubKey  string `json:"pubKey"` // in hex, see createpsbt
+	Label   string `json:"label,omitempty"`
+	Scheme  string `json:"scheme"`
+	Path    string `json:"path,omitempty"`
+	Balance *int   `json:"balance,omitempty"` // when there is a blockchain
+}
+
+func newWalletResult(w *Wallet) walletResult {
+	return walletResult{Address: w.Address(), Bech32: GetBech32Address(w.PublicKey), PubKey: hex.EncodeToString(w.PublicKey), Label: w.Label, Scheme: w.Scheme().Name(), Path: w.Path}
+}
+
+func (r walletResult) String() string {
+	balance := "-"
+	if r.Balance != nil {
+		balance = fmt.Sprint(*r.Balance)
+	}
+	return fmt.Sprintf("%-34s %8s  %-17s %-18s %s", r.Address, balance, r.Scheme, r.Path, r.Label)
+}
+
+func (c *CLI) createWallet(fs *flag.FlagSet, args []string) (interface{}, error) {
+	label := fs.String("label", "", "the label of the address")
+	schemeName := fs.String("scheme", DefaultSignatureScheme.Name(), "the signature scheme, when the wallets have no seed")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	scheme, err := SchemeByName(*schemeName)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := c.wallets(true)
+	if errors.Is(err, os.ErrNotExist) {
+		var passphrase string
+		if passphrase, err = c.Passphrase("New passphrase"); err != nil {
+			return nil, err
+		}
+		if err = os.MkdirAll(c.DataDir, 0700); err != nil {
+			return nil, err
+		}
+		ws, err = CreateWallets(filepath.Join(c.DataDir, WalletsFile), passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w *Wallet
+	if ws.HasSeed() {
+		w, err = ws.NewHDWallet(*label)
+	} else {
+		w, err = ws.NewSchemeWallet(scheme, *label)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newWalletResult(w), nil
+}
+
+// addressList is the result of listaddresses
+type addressList struct {
+	Addresses []walletResult `json:"addresses"`
+}
+
+func (l addressList) String() string {
+	lines := []string{fmt.Sprintf("%d addresses", len(l.Addresses))}
+	for _, a := range l.Addresses {
+		lines = append(lines, a.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *CLI) listAddresses(fs *flag.FlagSet, args []string) (interface{}, error) {
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	ws, err := c.wallets(false)
+	if err != nil {
+		return nil, err
+	}
+	var utxo UTXOFinder
+	if bc, err := c.blockchain(); err == nil {
+		utxo = bc.UTXOIndex()
+	} else if !errors.Is(err, ErrNoBlockchain) {
+		return nil, err
+	}
+
+	list := addressList{Addresses: []walletResult{}}
+	for _, w := range ws.Wallets() {
+		r := newWalletResult(w)
+		if utxo != nil {
+			balance := getBalance(w.Address(), utxo)
+			r.Balance = &balance
+		}
+		list.Addresses = append(list.Addresses, r)
+	}
+	return list, nil
+}
+
+func (c *CLI) importKey(fs *flag.FlagSet, args []string) (interface{}, error) {
+	label := fs.String("label", "", "the label of the address")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	pem, err := io.ReadAll(c.In)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := c.wallets(true)
+	if err != nil {
+		return nil, err
+	}
+	w, err := ws.Import(string(pem), *label)
+	if err != nil {
+		return nil, err
+	}
+	return newWalletResult(w), nil
+}
+
+// keyResult is the result of exportkey
+type keyResult struct {
+	Address    string `json:"address"`
+	PrivateKey string `json:"privateKey"`
+}
+
+func (r keyResult) String() string {
+	return strings.TrimSuffix(r.PrivateKey, "\n")
+}
+
+func (c *CLI) exportKey(fs *flag.FlagSet, args []string) (interface{}, error) {
+	address := fs.String("address", "", "the address of the key")
+	if err := parseFlags(fs, args, "address"); err != nil {
+		return nil, err
+	}
+	ws, err := c.wallets(true)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ws.Export(*address)
+	if err != nil {
+		return nil, err
+	}
+	return keyResult{Address: *address, PrivateKey: key}, nil
+}
+
+func (c *CLI) labelAddress(fs *flag.FlagSet, args []string) (interface{}, error) {
+	address := fs.String("address", "", "the address to label")
+	label := fs.String("label", "", "the new label")
+	if err := parseFlags(fs, args, "address", "label"); err != nil {
+		return nil, err
+	}
+	ws, err := c.wallets(false)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.SetLabel(*address, *label); err != nil {
+		return nil, err
+	}
+	w, err := ws.Get(*address)
+	if err != nil {
+		return nil, err
+	}
+	return newWalletResult(w), nil
+}
+
+// seedResult is the result of newseed and restoreseed
+type seedResult struct {
+	Mnemonic      string         `json:"mnemonic,omitempty"` // of a new seed only
+	AccountPubKey string         `json:"accountPublicKey"`
+	Restored      []walletResult `json:"restored"`
+}
+
+func (r seedResult) String() string {
+	var lines []string
+	if r.Mnemonic != "" {
+		lines = append(lines, "Write down this mnemonic, it restores the new addresses:", r.Mnemonic)
+	}
+	lines = append(lines, "Account public key, for watch-only wallets: "+r.AccountPubKey)
+	if r.Mnemonic == "" {
+		lines = append(lines, fmt.Sprintf("Restored %d addresses", len(r.Restored)))
+		for _, w := range r.Restored {
+			lines = append(lines, w.String())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *CLI) newSeed(fs *flag.FlagSet, args []string) (interface{}, error) {
+	return c.setSeed(fs, args, false)
+}
+
+func (c *CLI) restoreSeed(fs *flag.FlagSet, args []string) (interface{}, error) {
+	return c.setSeed(fs, args, true)
+}
+
+// setSeed gives the wallets the seed of a new mnemonic, or of the
+// mnemonic of the input whose funded addresses are restored
+func (c *CLI) setSeed(fs *flag.FlagSet, args []string, restore bool) (interface{}, error) {
+	seedPassphrase := fs.String("seedpassphrase", "", "the passphrase of the mnemonic, may be empty")
+	schemeName := fs.String("scheme", DefaultSignatureScheme.Name(), "the signature scheme of the keys of the seed")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	scheme, err := SchemeByName(*schemeName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hdSchemeOf(scheme); err != nil {
+		return nil, err
+	}
+	var mnemonic string
+	if restore {
+		mnemonic, err = c.readLine()
+	} else {
+		mnemonic, err = NewMnemonic(DefaultEntropyBits)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := MnemonicToSeed(mnemonic, *seedPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	var bc *Blockchain
+	if restore {
+		if bc, err = c.blockchain(); err != nil {
+			return nil, err
+		}
+	}
+	ws, err := c.wallets(true)
+	if err != nil {
+		return nil, err
+	}
+	if err := ws.SetSchemeSeed(scheme, seed); err != nil {
+		return nil, err
+	}
+	hd, err := ws.HDWallet()
+	if err != nil {
+		return nil, err
+	}
+	result := seedResult{AccountPubKey: hd.AccountPublicKey(), Restored: []walletResult{}}
+	if !restore {
+		result.Mnemonic = mnemonic
+		return result, nil
+	}
+	restored, err := ws.RestoreHD(bc.UTXOIndex())
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range restored {
+		result.Restored = append(result.Restored, newWalletResult(w))
+	}
+	return result, nil
+}
+
+// hdAddressList is the result of watchxpub
+type hdAddressList []HDAddress
+
+func (l hdAddressList) String() string {
+	lines := []string{fmt.Sprintf("%d funded addresses", len(l))}
+	for _, a := range l {
+		lines = append(lines, fmt.Sprintf("%-34s %8d  change=%v index=%d", a.Address, a.Balance, a.Change, a.Index))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *CLI) watchXpub(fs *flag.FlagSet, args []string) (interface{}, error) {
+	xpub := fs.String("xpub", "", "the account public key")
+	if err := parseFlags(fs, args, "xpub"); err != nil {
+		return nil, err
+	}
+	hd, err := NewWatchOnlyHDWallet(*xpub)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	found, err := hd.Scan(bc.UTXOIndex())
+	if err != nil {
+		return nil, err
+	}
+	return append(hdAddressList{}, found...), nil
+}
+
+// blockResult is a block of the chain, the hashes in hex
+type blockResult struct {
+	Hash          string     `json:"hash"`
+	Height        int        `json:"height"`
+	PrevBlockHash string     `json:"prevBlockHash"`
+	MerkleRoot    string     `json:"merkleRoot"`
+	Timestamp     int64      `json:"timestamp"`
+	Bits          uint32     `json:"bits"`
+	Nonce         int        `json:"nonce"`
+	Transactions  []txResult `json:"transactions"`
+}
+
+// txResult is a transaction, the hashes and the scripts in hex
+type txResult struct {
+	ID      string           `json:"id"`
+	Inputs  []txInputResult  `json:"inputs"`
+	Outputs []txOutputResult `json:"outputs"`
+}
+
+type txInputResult struct {
+	Txid   string `json:"txid"`
+	OutIdx int    `json:"outIdx"`
+}
+
+type txOutputResult struct {
+	Value    int    `json:"value"`
+	LockHash string `json:"lockHash"`
+	Script   string `json:"script,omitempty"`
+}
+
+func newBlockResult(block *Block, height int) blockResult {
+	r := blockResult{
+		Hash:          hex.EncodeToString(block.Hash),
+		Height:        height,
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+		Timestamp:     block.Timestamp,
+		Bits:          block.Bits,
+		Nonce:         block.Nonce,
+	}
+	for _, tx := range block.Transactions {
+		r.Transactions = append(r.Transactions, newTxResult(tx))
+	}
+	return r
+}
+
+func newTxResult(tx *Transaction) txResult {
+	r := txResult{ID: hex.EncodeToString(tx.ID), Inputs: []txInputResult{}}
+	for _, vin := range tx.Vin {
+		r.Inputs = append(r.Inputs, txInputResult{Txid: hex.EncodeToString(vin.Txid), OutIdx: vin.OutIdx})
+	}
+	for _, out := range tx.Vout {
+		r.Outputs = append(r.Outputs, txOutputResult{Value: out.Value, LockHash: hex.EncodeToString(out.LockHash()), Script: hex.EncodeToString(out.Script)})
+	}
+	return r
+}
+
+func (r blockResult) String() string {
+	lines := []string{
+		fmt.Sprintf("============ Block %s ============", r.Hash),
+		fmt.Sprintf("Height: %d", r.Height),
+		fmt.Sprintf("Prev. block: %s", r.PrevBlockHash),
+		fmt.Sprintf("Merkle root: %s", r.MerkleRoot),
+		fmt.Sprintf("Timestamp: %d", r.Timestamp),
+		fmt.Sprintf("Bits: %#08x Nonce: %d", r.Bits, r.Nonce),
+	}
+	for _, tx := range r.Transactions {
+		lines = append(lines, "--- Transaction "+tx.ID)
+		for i, in := range tx.Inputs {
+			lines = append(lines, fmt.Sprintf("     Input %d: %s:%d", i, in.Txid, in.OutIdx))
+		}
+		for i, out := range tx.Outputs {
+			lines = append(lines, fmt.Sprintf("     Output %d: %d to %s", i, out.Value, out.LockHash))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// blockList is the result of printchain and mine
+type blockList []blockResult
+
+func (l blockList) String() string {
+	var blocks []string
+	for _, b := range l {
+		blocks = append(blocks, b.String())
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+func (c *CLI) createBlockchain(fs *flag.FlagSet, args []string) (interface{}, error) {
+	address := fs.String("address", "", "the address paid by the genesis block")
+	if err := parseFlags(fs, args, "address"); err != nil {
+		return nil, err
+	}
+	if err := checkAddresses(*address); err != nil {
+		return nil, err
+	}
+	db, err := c.openDB()
+	if err != nil {
+		return nil, err
+	}
+	if HasBlockchain(db) {
+		return nil, fmt.Errorf("%w in %s", ErrChainExists, c.DataDir)
+	}
+	bc, err := NewBlockchain(db, *address)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockResult(bc.GetGenesisBlock(), 0), nil
+}
+
+// balanceResult is the result of getbalance
+type balanceResult struct {
+	Address string `json:"address"`
+	Balance int    `json:"balance"`
+}
+
+func (r balanceResult) String() string {
+	return fmt.Sprintf("Balance of %s: %d", r.Address, r.Balance)
+}
+
+func getBalance(address string, utxo UTXOFinder) int {
 	pubKeyHash := GetPubKeyHashFromAddress(address)
 	amt, _ := utxo.FindSpendableOutputs(pubKeyHash, 0)
 	return amt
 }
 
-func main() {
-	peppers := []string{CREATE_BLCKCHN, DEMO_TRAN, GET_BALANCE, PRINT_CHAIN, EXIT}
+func (c *CLI) getBalance(fs *flag.FlagSet, args []string) (interface{}, error) {
+	address := fs.String("address", "", "the address")
+	if err := parseFlags(fs, args, "address"); err != nil {
+		return nil, err
+	}
+	if err := checkAddresses(*address); err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	return balanceResult{Address: *address, Balance: getBalance(*address, bc.UTXOIndex())}, nil
+}
 
-	templatesSelect := &promptui.SelectTemplates{
-		Label:    "{{ . | green }}",
-		Active:   "\U000027a4 {{ . | cyan }}",
-		Inactive: "  {{ . | cyan }}",
-		Selected: " {{ . | cyan }}",
+// sendResult is the result of send
+type sendResult struct {
+	Transaction txResult     `json:"transaction"`
+	Fee         int          `json:"fee"`
+	Block       *blockResult `json:"block,omitempty"` // with -mine
+	Node        string       `json:"node,omitempty"`  // with -node
+}
+
+func (r sendResult) String() string {
+	if r.Block != nil {
+		return fmt.Sprintf("Sent transaction %s, mined in block %s at height %d", r.Transaction.ID, r.Block.Hash, r.Block.Height)
 	}
+	return fmt.Sprintf("Sent transaction %s to %s", r.Transaction.ID, r.Node)
+}
 
-	searcher := func(input string, index int) bool {
-		pepper := peppers
-		name := strings.Replace(strings.ToLower(pepper[index]), " ", "", -1)
-		input = strings.Replace(strings.ToLower(input), " ", "", -1)
+func (c *CLI) send(fs *flag.FlagSet, args []string) (interface{}, error) {
+	from := fs.String("from", "", "the address of the wallets sending the coins")
+	to := fs.String("to", "", "the address receiving the coins")
+	amount := fs.Int("amount", 0, "the amount sent")
+	fee := fs.Int("fee", 0, "a fixed fee left to the miner")
+	feeRate := fs.Int("feerate", 0, "the fee left to the miner per byte of the transaction")
+	selectorName := fs.String("coins", LargestFirst.Name(), "the coin selection: largest-first, smallest-first, branch-and-bound or random-improve")
+	mine := fs.Bool("mine", false, "mine a block with the transaction at once, paying the reward to the sender")
+	node := fs.String("node", "", "the address of the node to relay the transaction to")
+	if err := parseFlags(fs, args, "from", "to", "amount"); err != nil {
+		return nil, err
+	}
+	if *mine == (*node != "") {
+		return nil, fmt.Errorf("%w: send needs either -mine or -node", ErrUsage)
+	}
+	if err := checkAddresses(*from, *to); err != nil {
+		return nil, err
+	}
+	selector, err := CoinSelectorByName(*selectorName)
+	if err != nil {
+		return nil, err
+	}
 
-		return strings.Contains(name, input)
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	ws, err := c.wallets(true)
+	if err != nil {
+		return nil, err
+	}
+	w, err := ws.Get(*from)
+	if err != nil {
+		return nil, err
 	}
-	var blockchain *Blockchain
-	var block *Block
-	var trans []*Transaction
-	var utxo UTXOSet
-	aPri, aPub := newKeyPair()
-	aAdd := GetStringAddress(GetAddress(aPub))
+	privKey, err := w.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	builder := NewTxBuilder(w.PublicKey, bc.UTXOIndex())
+	builder.Selector = selector
+	builder.Fee = *fee
+	builder.FeeRate = *feeRate
+	if err := builder.AddOutput(*to, *amount); err != nil {
+		return nil, err
+	}
+	tx, coins, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.SignTransaction(tx, privKey); err != nil {
+		return nil, err
+	}
+	paid, err := tx.Fee(spentOutputs(coins))
+	if err != nil {
+		return nil, err
+	}
+	miner := ""
+	if *mine {
+		miner = *from
+	}
+	return c.submit(bc, tx, paid, miner, *node)
+}
 
-	bPri, bPub := newKeyPair()
-	bAdd := GetStringAddress(GetAddress(bPub))
+// submit relays the signed transaction to the node or, without a node,
+// mines a block with it paying the miner
+func (c *CLI) submit(bc *Blockchain, tx *Transaction, fee int, miner, node string) (interface{}, error) {
+	result := sendResult{Transaction: newTxResult(tx), Fee: fee}
+	if node != "" {
+		client, err := DialLightClient(node, bc.GetGenesisBlock().BlockHeader)
+		if err != nil {
+			return nil, err
+		}
+		defer client.Close()
+		if err := client.SendTransaction(tx); err != nil {
+			return nil, err
+		}
+		result.Node = node
+		return result, nil
+	}
 
-	cPri, cPub := newKeyPair()
-	cAdd := GetStringAddress(GetAddress(cPub))
-	for {
+	mempool := NewMempool(bc, DefaultMempoolSize)
+	if err := mempool.Add(tx); err != nil {
+		return nil, err
+	}
+	block, err := c.mineBlock(bc, mempool, miner)
+	if err != nil {
+		return nil, err
+	}
+	mined := newBlockResult(block, bc.Height())
+	result.Block = &mined
+	return result, nil
+}
+
+// psbtResult is a partially signed transaction, the result of the
+// psbt commands
+type psbtResult struct {
+	Transaction txResult          `json:"transaction"`
+	Inputs      []psbtInputResult `json:"inputs"`
+	Fee         int               `json:"fee"`
+	Complete    bool              `json:"complete"`
+	File        string            `json:"file,omitempty"` // the file written
+}
+
+// psbtInputResult is the output spent by an input
+type psbtInputResult struct {
+	Value    int    `json:"value"`
+	LockHash string `json:"lockHash"`
+	Signed   bool   `json:"signed"`
+}
 
-		promptSelect := promptui.Select{
-			Label:     "Welcome to Blockchain. Please select options",
-			Items:     peppers,
-			Templates: templatesSelect,
-			Size:      len(peppers),
-			Searcher:  searcher,
+func newPSBTResult(p *PartialTransaction, file string) (psbtResult, error) {
+	fee, err := p.Fee()
+	if err != nil {
+		return psbtResult{}, err
+	}
+	r := psbtResult{Transaction: newTxResult(p.Tx), Inputs: []psbtInputResult{}, Fee: fee, Complete: true, File: file}
+	for idx, prevOut := range p.PrevOuts {
+		signed := p.IsSigned(idx)
+		r.Inputs = append(r.Inputs, psbtInputResult{Value: prevOut.Value, LockHash: hex.EncodeToString(prevOut.LockHash()), Signed: signed})
+		r.Complete = r.Complete && signed
+	}
+	return r, nil
+}
+
+func (r psbtResult) String() string {
+	signed := 0
+	for _, in := range r.Inputs {
+		if in.Signed {
+			signed++
 		}
+	}
+	lines := []string{fmt.Sprintf("Transaction %s, fee %d, %d of %d inputs signed", r.Transaction.ID, r.Fee, signed, len(r.Inputs))}
+	for i, in := range r.Transaction.Inputs {
+		lines = append(lines, fmt.Sprintf("     Input %d: %s:%d, %d from %s, signed: %v", i, in.Txid, in.OutIdx, r.Inputs[i].Value, r.Inputs[i].LockHash, r.Inputs[i].Signed))
+	}
+	for i, out := range r.Transaction.Outputs {
+		lines = append(lines, fmt.Sprintf("     Output %d: %d to %s", i, out.Value, out.LockHash))
+	}
+	if r.File != "" {
+		lines = append(lines, "Written to "+r.File)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// readPSBT reads a partial transaction from a file, hex encoded
+func readPSBT(file string) (*PartialTransaction, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return DeserializePartialTransaction(raw)
+}
+
+// writePSBT writes a partial transaction to a file, hex encoded
+func writePSBT(file string, p *PartialTransaction) (psbtResult, error) {
+	result, err := newPSBTResult(p, file)
+	if err != nil {
+		return psbtResult{}, err
+	}
+	return result, os.WriteFile(file, []byte(hex.EncodeToString(p.Serialize())+"\n"), 0600)
+}
 
-		_, result, err := promptSelect.Run()
+func (c *CLI) createPSBT(fs *flag.FlagSet, args []string) (interface{}, error) {
+	from := fs.String("from", "", "the address of the wallets sending the coins, its key may be offline")
+	pubKeyHex := fs.String("pubkey", "", "the public key sending the coins, in hex, instead of -from")
+	to := fs.String("to", "", "the address receiving the coins")
+	amount := fs.Int("amount", 0, "the amount sent")
+	fee := fs.Int("fee", 0, "a fixed fee left to the miner")
+	feeRate := fs.Int("feerate", 0, "the fee left to the miner per byte of the signed transaction")
+	selectorName := fs.String("coins", LargestFirst.Name(), "the coin selection: largest-first, smallest-first, branch-and-bound or random-improve")
+	out := fs.String("out", "", "the file to write")
+	if err := parseFlags(fs, args, "to", "amount", "out"); err != nil {
+		return nil, err
+	}
+	if (*from == "") == (*pubKeyHex == "") {
+		return nil, fmt.Errorf("%w: createpsbt needs either -from or -pubkey", ErrUsage)
+	}
+	if err := checkAddresses(*to); err != nil {
+		return nil, err
+	}
+	selector, err := CoinSelectorByName(*selectorName)
+	if err != nil {
+		return nil, err
+	}
 
+	var pubKey []byte
+	if *from != "" {
+		ws, err := c.wallets(false)
 		if err != nil {
-			fmt.Printf("Prompt failed %v\n", err)
-			return
-		}
-
-		switch result {
-		case CREATE_BLCKCHN:
-			// create blockchain with 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX address. which belongs to
-			blockchain, _ = NewBlockchain(aAdd)
-			utxo = blockchain.FindUTXOSet()
-			fmt.Println("Created blockchain")
-
-		case DEMO_TRAN:
-			// Demo tran will first create a coinbase trans, then will create a transaction from coinbase address to a new address
-
-			demoBlockAdd := func(from []byte, fromadd, to string, amount int, priKey ecdsa.PrivateKey) {
-				trans = []*Transaction{}
-				tran, err := NewCoinbaseTX(aAdd, fromadd+to)
-				if err != nil {
-					fmt.Printf("Unable to create coinbase transaction for block: Err : %+v\n", err)
-					return
-				}
-				trans = append(trans, tran)
-
-				tran, err = NewUTXOTransaction(from, to, amount, utxo)
-
-				if err != nil {
-					fmt.Printf("Unable to create transaction from : %s  to %s: Err : %+v\n", fromadd, to, err)
-					return
-				}
-				blockchain.SignTransaction(tran, priKey)
-				trans = append(trans, tran)
-				utxo.Update(trans)
-
-				block, err = blockchain.MineBlock(trans)
-				if err != nil {
-					fmt.Printf("Unable to Mine block : Err : %+v\n", err)
-				}
-
-				fmt.Println(trans)
-			}
-			time.Sleep(100 * time.Millisecond)
-			fmt.Println("")
-			fmt.Printf("Address of a is : %v\n", aAdd)
-			fmt.Printf("Address of b is : %v\n", bAdd)
-			fmt.Printf("Address of c is : %v\n", cAdd)
-
-			// From A->B
-			demoBlockAdd(aPub, aAdd, bAdd, 10, aPri)
-
-			// Should fail,C has insufficient funds so will not be added to blockchain
-			demoBlockAdd(cPub, cAdd, bAdd, 3, cPri)
-
-			// From B->C
-			demoBlockAdd(bPub, bAdd, cAdd, 5, bPri)
-
-			aBlnc := getBalance(aAdd, utxo)
-			bBlnc := getBalance(bAdd, utxo)
-			cBlnc := getBalance(cAdd, utxo)
-			fmt.Printf("Balance of %s is : %v\n", aAdd, aBlnc)
-			fmt.Printf("Balance of %s is : %v\n", bAdd, bBlnc)
-			fmt.Printf("Balance of %s is : %v\n", cAdd, cBlnc)
-
-		case GET_BALANCE:
-			var addr string
-			fmt.Println("Enter address ->")
-			fmt.Scanln(&addr)
-			senderBlnc := getBalance(addr, utxo)
-			fmt.Printf("Balance of address %s is : %v\n", addr, senderBlnc)
-
-		case MINE_BLCK:
-			var err error
-			block, err = blockchain.MineBlock(trans)
-			if err != nil {
-				fmt.Println("Error occurred while mining. Skipping mining.Error : " + err.Error())
+			return nil, err
+		}
+		w, err := ws.Get(*from)
+		if err != nil {
+			return nil, err
+		}
+		pubKey = w.PublicKey
+	} else {
+		if pubKey, err = hex.DecodeString(*pubKeyHex); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPubKey, err)
+		}
+		if _, err := parsePubKey(pubKey); err != nil {
+			return nil, err
+		}
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	builder := NewTxBuilder(pubKey, bc.UTXOIndex())
+	builder.Selector = selector
+	builder.Fee = *fee
+	builder.FeeRate = *feeRate
+	if err := builder.AddOutput(*to, *amount); err != nil {
+		return nil, err
+	}
+	tx, _, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	p, err := bc.PartialTransaction(tx)
+	if err != nil {
+		return nil, err
+	}
+	return writePSBT(*out, p)
+}
 
-			}
-			fmt.Println("Block mined successfully")
-			trans = []*Transaction{}
+func (c *CLI) decodePSBT(fs *flag.FlagSet, args []string) (interface{}, error) {
+	in := fs.String("in", "", "the file to read")
+	if err := parseFlags(fs, args, "in"); err != nil {
+		return nil, err
+	}
+	p, err := readPSBT(*in)
+	if err != nil {
+		return nil, err
+	}
+	return newPSBTResult(p, "")
+}
 
-		case PRINT_CHAIN:
-			fmt.Println("Printing blockchain")
-			fmt.Println(blockchain)
+func (c *CLI) signPSBT(fs *flag.FlagSet, args []string) (interface{}, error) {
+	in := fs.String("in", "", "the file to read")
+	out := fs.String("out", "", "the file to write, -in by default")
+	if err := parseFlags(fs, args, "in"); err != nil {
+		return nil, err
+	}
+	if *out == "" {
+		*out = *in
+	}
+	p, err := readPSBT(*in)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := c.wallets(true)
+	if err != nil {
+		return nil, err
+	}
+	signers := 0
+	for _, w := range ws.Wallets() {
+		privKey, err := w.PrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		err = p.Sign(privKey)
+		if errors.Is(err, ErrNothingToSign) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		signers++
+	}
+	if signers == 0 {
+		return nil, fmt.Errorf("%w: no key of the wallets", ErrNothingToSign)
+	}
+	return writePSBT(*out, p)
+}
+
+func (c *CLI) combinePSBT(fs *flag.FlagSet, args []string) (interface{}, error) {
+	in := fs.String("in", "", "the files to read, separated by commas")
+	out := fs.String("out", "", "the file to write")
+	if err := parseFlags(fs, args, "in", "out"); err != nil {
+		return nil, err
+	}
+	var combined *PartialTransaction
+	for _, file := range strings.Split(*in, ",") {
+		p, err := readPSBT(file)
+		if err != nil {
+			return nil, err
+		}
+		if combined == nil {
+			combined = p
+		} else if err := combined.Combine(p); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return writePSBT(*out, combined)
+}
 
-		case PRINT_BLCK:
-			fmt.Println("Printing block")
-			fmt.Println(block)
+func (c *CLI) sendPSBT(fs *flag.FlagSet, args []string) (interface{}, error) {
+	in := fs.String("in", "", "the file to read")
+	miner := fs.String("mine", "", "mine a block with the transaction at once, paying this address")
+	node := fs.String("node", "", "the address of the node to relay the transaction to")
+	if err := parseFlags(fs, args, "in"); err != nil {
+		return nil, err
+	}
+	if (*miner == "") == (*node == "") {
+		return nil, fmt.Errorf("%w: sendpsbt needs either -mine or -node", ErrUsage)
+	}
+	if *miner != "" {
+		if err := checkAddresses(*miner); err != nil {
+			return nil, err
+		}
+	}
+	p, err := readPSBT(*in)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := p.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	// the fee of the outputs actually spent, not of the file
+	fee, err := bc.TransactionFee(tx)
+	if err != nil {
+		return nil, err
+	}
+	return c.submit(bc, tx, fee, *miner, *node)
+}
 
-		case PRINT_TRAN:
-			fmt.Println("Printing transactions")
-			fmt.Println(trans)
-		case EXIT:
-			fmt.Println("selected : ", EXIT)
-			os.Exit(0)
+// mineBlock mines a block of the transactions of the mempool, paying
+// the reward and the fees to the address
+func (c *CLI) mineBlock(bc *Blockchain, mempool *Mempool, address string) (*Block, error) {
+	txs := mempool.BlockTemplate()
+	fees, err := bc.TotalFees(txs)
+	if err != nil {
+		return nil, err
+	}
+	coinbase, err := NewCoinbaseTXWithFees(address, "", bc.Height()+1, fees)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.Interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	block, _, err := bc.MineBlockContext(ctx, append([]*Transaction{coinbase}, txs...))
+	return block, err
+}
+
+func (c *CLI) mine(fs *flag.FlagSet, args []string) (interface{}, error) {
+	address := fs.String("address", "", "the address paid by the blocks")
+	blocks := fs.Int("blocks", 1, "the number of blocks")
+	if err := parseFlags(fs, args, "address"); err != nil {
+		return nil, err
+	}
+	if err := checkAddresses(*address); err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	mempool := NewMempool(bc, DefaultMempoolSize)
+	mined := blockList{}
+	for i := 0; i < *blocks; i++ {
+		block, err := c.mineBlock(bc, mempool, *address)
+		if err != nil {
+			return nil, err
+		}
+		mined = append(mined, newBlockResult(block, bc.Height()))
+	}
+	return mined, nil
+}
+
+func (c *CLI) printChain(fs *flag.FlagSet, args []string) (interface{}, error) {
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	chain := blockList{}
+	err = bc.forEachBlock(func(block *Block, height int) error {
+		chain = append(chain, newBlockResult(block, height))
+		return nil
+	})
+	return chain, err
+}
+
+// reindexResult is the result of reindexutxo
+type reindexResult struct {
+	UTXOs int `json:"utxos"`
+}
+
+func (r reindexResult) String() string {
+	return fmt.Sprintf("Done! There are %d unspent outputs in the UTXO set", r.UTXOs)
+}
+
+func (c *CLI) reindexUTXO(fs *flag.FlagSet, args []string) (interface{}, error) {
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	utxo := bc.UTXOIndex()
+	if err := utxo.Reindex(); err != nil {
+		return nil, err
+	}
+	return reindexResult{UTXOs: utxo.CountUTXOs()}, nil
+}
+
+// txIndexResult is the result of txindex
+type txIndexResult struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (r txIndexResult) String() string {
+	if r.Enabled {
+		return "Transaction indexes built and kept up to date"
+	}
+	return "Transaction indexes dropped"
+}
+
+func (c *CLI) txIndex(fs *flag.FlagSet, args []string) (interface{}, error) {
+	disable := fs.Bool("disable", false, "drop the indexes")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	if *disable {
+		err = bc.DisableTxIndex()
+	} else {
+		err = bc.EnableTxIndex()
+	}
+	return txIndexResult{Enabled: !*disable}, err
+}
+
+// historyResult is the result of gethistory
+type historyResult struct {
+	Address      string            `json:"address"`
+	Transactions []historyTxResult `json:"transactions"`
+}
+
+type historyTxResult struct {
+	Txid     string `json:"txid"`
+	Height   int    `json:"height"`
+	Received int    `json:"received"`
+	Sent     int    `json:"sent"`
+}
+
+func (r historyResult) String() string {
+	lines := []string{fmt.Sprintf("%d transactions of %s", len(r.Transactions), r.Address)}
+	for _, tx := range r.Transactions {
+		lines = append(lines, fmt.Sprintf("%6d %s %+d", tx.Height, tx.Txid, tx.Received-tx.Sent))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *CLI) getHistory(fs *flag.FlagSet, args []string) (interface{}, error) {
+	address := fs.String("address", "", "the address")
+	if err := parseFlags(fs, args, "address"); err != nil {
+		return nil, err
+	}
+	_, hash, err := DecodeAddress(*address)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	history, err := bc.AddressHistory(hash)
+	if err != nil {
+		return nil, err
+	}
+	result := historyResult{Address: *address, Transactions: []historyTxResult{}}
+	for _, h := range history {
+		result.Transactions = append(result.Transactions, historyTxResult{Txid: hex.EncodeToString(h.Txid), Height: h.Height, Received: h.Received, Sent: h.Sent})
+	}
+	return result, nil
+}
+
+// pruneResult is the result of prune
+type pruneResult struct {
+	Depth        int `json:"depth"` // 0 when the chain is not pruned
+	PrunedHeight int `json:"prunedHeight"`
+}
+
+func (r pruneResult) String() string {
+	if r.Depth == 0 {
+		return fmt.Sprintf("Pruning stopped, the blocks up to height %d are pruned", r.PrunedHeight)
+	}
+	return fmt.Sprintf("Keeping the last %d blocks, the blocks up to height %d are pruned", r.Depth, r.PrunedHeight)
+}
+
+func (c *CLI) prune(fs *flag.FlagSet, args []string) (interface{}, error) {
+	depth := fs.Int("depth", 0, "the number of blocks below the tip to keep")
+	disable := fs.Bool("disable", false, "stop pruning the new blocks")
+	if err := parseFlags(fs, args); err != nil {
+		return nil, err
+	}
+	if (*depth == 0) == !*disable {
+		return nil, fmt.Errorf("%w: prune needs either -depth or -disable", ErrUsage)
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	if *disable {
+		err = bc.DisablePruning()
+	} else {
+		err = bc.EnablePruning(*depth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pruneResult{Depth: bc.PruneDepth(), PrunedHeight: bc.PrunedHeight()}, nil
+}
+
+// snapshotResult is the result of exportsnapshot and importsnapshot
+type snapshotResult struct {
+	BlockHash  string `json:"blockHash"`
+	Height     int    `json:"height"`
+	UTXOs      int    `json:"utxos"`
+	Commitment string `json:"commitment"`
+	File       string `json:"file"`
+}
+
+func newSnapshotResult(s *UTXOSnapshot, file string) snapshotResult {
+	return snapshotResult{
+		BlockHash:  hex.EncodeToString(s.BlockHash()),
+		Height:     s.Height(),
+		UTXOs:      len(s.Outputs),
+		Commitment: hex.EncodeToString(s.Commitment()),
+		File:       file,
+	}
+}
+
+func (r snapshotResult) String() string {
+	return strings.Join([]string{
+		fmt.Sprintf("Snapshot %s of block %s at height %d, %d unspent outputs", r.File, r.BlockHash, r.Height, r.UTXOs),
+		"Commitment: " + r.Commitment,
+	}, "\n")
+}
+
+func (c *CLI) exportSnapshot(fs *flag.FlagSet, args []string) (interface{}, error) {
+	out := fs.String("out", "", "the file to write")
+	if err := parseFlags(fs, args, "out"); err != nil {
+		return nil, err
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	s, err := bc.UTXOSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotResult(s, *out), os.WriteFile(*out, s.Serialize(), 0600)
+}
+
+func (c *CLI) importSnapshot(fs *flag.FlagSet, args []string) (interface{}, error) {
+	in := fs.String("in", "", "the file to read")
+	commitment := fs.String("commitment", "", "the commitment of the snapshot, from a trusted source")
+	if err := parseFlags(fs, args, "in", "commitment"); err != nil {
+		return nil, err
+	}
+	hash, err := hex.DecodeString(*commitment)
+	if err != nil {
+		return nil, fmt.Errorf("%w: commitment: %v", ErrUsage, err)
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return nil, err
+	}
+	s, err := DeserializeUTXOSnapshot(data)
+	if err != nil {
+		return nil, err
+	}
+	db, err := c.openDB()
+	if err != nil {
+		return nil, err
+	}
+	if HasBlockchain(db) {
+		return nil, fmt.Errorf("%w in %s", ErrChainExists, c.DataDir)
+	}
+	if _, err := NewBlockchainFromSnapshot(db, s, hash); err != nil {
+		return nil, err
+	}
+	return newSnapshotResult(s, *in), nil
+}
+
+// nodeResult is the result of startnode, once interrupted
+type nodeResult struct {
+	Address string   `json:"address"`
+	Height  int      `json:"height"`
+	Peers   []string `json:"peers"`
+	Mined   int      `json:"mined"`
+}
+
+func (r nodeResult) String() string {
+	return fmt.Sprintf("Node %s stopped at height %d with %d peers, mined %d blocks", r.Address, r.Height, len(r.Peers), r.Mined)
+}
+
+func (c *CLI) startNode(fs *flag.FlagSet, args []string) (interface{}, error) {
+	port := fs.Int("port", 0, "the port to listen on")
+	peer := fs.String("peer", "", "the address of a node to connect to")
+	miner := fs.String("mine", "", "mine blocks paying this address")
+	rpc := fs.String("rpc", "", "the address to serve the JSON-RPC API on, see RPCServer")
+	if err := parseFlags(fs, args, "port"); err != nil {
+		return nil, err
+	}
+	if *miner != "" {
+		if err := checkAddresses(*miner); err != nil {
+			return nil, err
+		}
+	}
+	bc, err := c.blockchain()
+	if err != nil {
+		return nil, err
+	}
+	mempool := NewMempool(bc, DefaultMempoolSize)
+	node := NewP2PNode(bc, mempool)
+	defer node.Close()
+	if err := node.Listen(fmt.Sprintf(":%d", *port)); err != nil {
+		return nil, err
+	}
+	node.Logger.Printf("listening on %s at height %d", node.Addr(), bc.Height())
+	if *rpc != "" {
+		listener, err := net.Listen("tcp", *rpc)
+		if err != nil {
+			return nil, err
+		}
+		rpcServer := NewRPCServer(bc, mempool)
+		rpcServer.Node = node
+		server := &http.Server{Handler: rpcServer, ErrorLog: node.Logger}
+		go server.Serve(listener)
+		defer server.Close()
+		node.Logger.Printf("serving JSON-RPC on %s", listener.Addr())
+	}
+	if *peer != "" {
+		if err := node.Connect(*peer); err != nil {
+			return nil, err
+		}
+	}
+
+	result := nodeResult{Address: node.Addr()}
+	if *miner == "" {
+		<-c.Interrupt
+	} else {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-c.Interrupt:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		for ctx.Err() == nil {
+			_, _, err := node.MineBlockContext(ctx, *miner)
+			if err == nil {
+				result.Mined++
+			} else if ctx.Err() == nil && !errors.Is(err, ErrStaleTip) {
+				return nil, err
+			}
 		}
 	}
+	result.Height = bc.Height()
+	result.Peers = node.Peers()
+	sort.Strings(result.Peers)
+	return result, nil
 }
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCLI returns a CLI on a temporary directory writing JSON, with a
+// cheap key derivation for the wallets
+func newTestCLI(t *testing.T, passphrase string) *CLI {
+	old := walletScryptN
+	walletScryptN = 1 << 10
+	t.Cleanup(func() { walletScryptN = old })
+
+	return &CLI{
+		DataDir:    t.TempDir(),
+		JSON:       true,
+		Out:        &bytes.Buffer{},
+		In:         strings.NewReader(""),
+		Err:        io.Discard,
+		Passphrase: func(string) (string, error) { return passphrase, nil },
+		Interrupt:  make(chan struct{}),
+	}
+}
+
+// runCLI runs a command and decodes its JSON result into v
+func runCLI(t *testing.T, c *CLI, v interface{}, args ...string) error {
+	t.Helper()
+	out := c.Out.(*bytes.Buffer)
+	out.Reset()
+	if err := c.Run(args); err != nil {
+		return err
+	}
+	if v != nil {
+		if err := json.Unmarshal(out.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v: %s", args[0], err, out)
+		}
+	}
+	return nil
+}
+
+// mustRunCLI is runCLI failing the test on errors
+func mustRunCLI(t *testing.T, c *CLI, v interface{}, args ...string) {
+	t.Helper()
+	if err := runCLI(t, c, v, args...); err != nil {
+		t.Fatalf("%s: %v", args[0], err)
+	}
+}
+
+func TestCLI(t *testing.T) {
+	c := newTestCLI(t, "secret")
+
+	var alice, bob walletResult
+	mustRunCLI(t, c, &alice, "createwallet", "-label", "alice")
+	mustRunCLI(t, c, &bob, "createwallet", "-label", "bob", "-scheme", Secp256k1Schnorr.Name())
+	assert.Equal(t, DefaultSignatureScheme.Name(), alice.Scheme)
+	assert.Equal(t, Secp256k1Schnorr.Name(), bob.Scheme)
+	assert.Nil(t, alice.Balance)
+
+	var genesis blockResult
+	mustRunCLI(t, c, &genesis, "createblockchain", "-address", alice.Address)
+	assert.Equal(t, 0, genesis.Height)
+	assert.ErrorIs(t, runCLI(t, c, nil, "createblockchain", "-address", alice.Address), ErrChainExists)
+
+	var balance balanceResult
+	mustRunCLI(t, c, &balance, "getbalance", "-address", alice.Address)
+	assert.Equal(t, BlockSubsidy(0), balance.Balance)
+
+	var sent sendResult
+	mustRunCLI(t, c, &sent, "send", "-from", alice.Address, "-to", bob.Bech32, "-amount", "3", "-fee", "1", "-mine")
+	if assert.NotNil(t, sent.Block) {
+		assert.Equal(t, 1, sent.Block.Height)
+		assert.Len(t, sent.Block.Transactions, 2)
+		assert.Equal(t, sent.Transaction.ID, sent.Block.Transactions[1].ID)
+	}
+	mustRunCLI(t, c, &balance, "getbalance", "-address", bob.Address)
+	assert.Equal(t, 3, balance.Balance)
+
+	// the sender mined the block, so the fee came back
+	var list addressList
+	mustRunCLI(t, c, &list, "listaddresses")
+	if assert.Len(t, list.Addresses, 2) {
+		assert.Equal(t, "alice", list.Addresses[0].Label)
+		assert.Equal(t, BlockSubsidy(0)+BlockSubsidy(1)-3, *list.Addresses[0].Balance)
+		assert.Equal(t, 3, *list.Addresses[1].Balance)
+	}
+
+	var mined, chain blockList
+	mustRunCLI(t, c, &mined, "mine", "-address", bob.Address, "-blocks", "2")
+	assert.Len(t, mined, 2)
+	mustRunCLI(t, c, &chain, "printchain")
+	if assert.Len(t, chain, 4) {
+		assert.Equal(t, genesis.Hash, chain[0].Hash)
+		assert.Equal(t, mined[1], chain[3])
+		for i := 1; i < len(chain); i++ {
+			assert.Equal(t, i, chain[i].Height)
+			assert.Equal(t, chain[i-1].Hash, chain[i].PrevBlockHash)
+		}
+	}
+
+	var reindexed reindexResult
+	mustRunCLI(t, c, &reindexed, "reindexutxo")
+	assert.Equal(t, 5, reindexed.UTXOs)
+
+	var indexed txIndexResult
+	var history historyResult
+	assert.ErrorIs(t, runCLI(t, c, nil, "gethistory", "-address", bob.Address), ErrNoTxIndex)
+	mustRunCLI(t, c, &indexed, "txindex")
+	assert.True(t, indexed.Enabled)
+	mustRunCLI(t, c, &history, "gethistory", "-address", bob.Bech32)
+	if assert.Len(t, history.Transactions, 3) {
+		assert.Equal(t, historyTxResult{Txid: sent.Transaction.ID, Height: 1, Received: 3}, history.Transactions[0])
+		assert.Equal(t, mined[1].Transactions[0].ID, history.Transactions[2].Txid)
+	}
+	mustRunCLI(t, c, &indexed, "txindex", "-disable")
+	assert.False(t, indexed.Enabled)
+
+	// the keys move between the wallets of two directories
+	var key keyResult
+	mustRunCLI(t, c, &key, "exportkey", "-address", bob.Address)
+	other := newTestCLI(t, "other")
+	other.In = strings.NewReader(key.PrivateKey)
+	mustRunCLI(t, other, nil, "createwallet")
+	var imported walletResult
+	mustRunCLI(t, other, &imported, "importkey", "-label", "bob")
+	assert.Equal(t, bob.Address, imported.Address)
+	assert.Equal(t, bob.Scheme, imported.Scheme)
+
+	var labeled walletResult
+	mustRunCLI(t, c, &labeled, "labeladdress", "-address", bob.Address, "-label", "robert")
+	assert.Equal(t, "robert", labeled.Label)
+}
+
+func TestCLIErrors(t *testing.T) {
+	c := newTestCLI(t, "secret")
+	assert.ErrorIs(t, runCLI(t, c, nil), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, c, nil, "mkwallet"), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, c, nil, "getbalance"), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, c, nil, "getbalance", "-address", "nope"), ErrInvalidAddress)
+	assert.ErrorIs(t, runCLI(t, c, nil, "getbalance", "-address", testMinerAddress), ErrNoBlockchain)
+	assert.ErrorIs(t, runCLI(t, c, nil, "printchain", "extra"), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, c, nil, "createwallet", "-scheme", "rsa"), ErrUnknownScheme)
+	assert.ErrorIs(t, runCLI(t, c, nil, "listaddresses"), os.ErrNotExist)
+
+	var w walletResult
+	mustRunCLI(t, c, &w, "createwallet")
+	mustRunCLI(t, c, nil, "createblockchain", "-address", w.Address)
+	assert.ErrorIs(t, runCLI(t, c, nil, "send", "-from", w.Address, "-to", testMinerAddress, "-amount", "1"), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, c, nil, "send", "-from", testMinerAddress, "-to", w.Address, "-amount", "1", "-mine"), ErrWalletNotFound)
+
+	c.Passphrase = func(string) (string, error) { return "wrong", nil }
+	assert.ErrorIs(t, runCLI(t, c, nil, "send", "-from", w.Address, "-to", testMinerAddress, "-amount", "1", "-mine"), ErrWrongPassphrase)
+}
+
+func TestCLISeed(t *testing.T) {
+	c := newTestCLI(t, "secret")
+	mustRunCLI(t, c, nil, "createwallet")
+	var seed seedResult
+	mustRunCLI(t, c, &seed, "newseed")
+	assert.True(t, ValidateMnemonic(seed.Mnemonic))
+
+	var hd walletResult
+	mustRunCLI(t, c, &hd, "createwallet")
+	assert.Equal(t, DefaultAccountPath+"/0/0", hd.Path)
+	mustRunCLI(t, c, nil, "createblockchain", "-address", hd.Address)
+
+	var found hdAddressList
+	mustRunCLI(t, c, &found, "watchxpub", "-xpub", seed.AccountPubKey)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, hd.Address, found[0].Address)
+	}
+
+	// the mnemonic restores the address in other wallets, on a copy of
+	// the chain
+	restored := newTestCLI(t, "other")
+	restored.In = strings.NewReader(seed.Mnemonic + "\n")
+	mustRunCLI(t, restored, nil, "createwallet")
+	assert.ErrorIs(t, runCLI(t, restored, nil, "restoreseed"), ErrNoBlockchain)
+	db, err := os.ReadFile(filepath.Join(c.DataDir, DBFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(restored.DataDir, DBFile), db, 0600); err != nil {
+		t.Fatal(err)
+	}
+	restored.In = strings.NewReader(seed.Mnemonic + "\n")
+	var restoredSeed seedResult
+	mustRunCLI(t, restored, &restoredSeed, "restoreseed")
+	assert.Empty(t, restoredSeed.Mnemonic)
+	assert.Equal(t, seed.AccountPubKey, restoredSeed.AccountPubKey)
+	if assert.Len(t, restoredSeed.Restored, 1) {
+		assert.Equal(t, hd, restoredSeed.Restored[0])
+	}
+}
+
+func TestCLISendToNode(t *testing.T) {
+	c := newTestCLI(t, "secret")
+	var w walletResult
+	mustRunCLI(t, c, &w, "createwallet")
+	mustRunCLI(t, c, nil, "createblockchain", "-address", w.Address)
+
+	// a node with the same chain in its own storage
+	db, err := OpenBoltStorage(filepath.Join(c.DataDir, DBFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBlockchain(db, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := bc.GetGenesisBlock()
+	db.Close()
+	node, _ := newTestNode(t, genesis)
+
+	var sent sendResult
+	mustRunCLI(t, c, &sent, "send", "-from", w.Address, "-to", testMinerAddress, "-amount", "5", "-node", node.Addr())
+	assert.Nil(t, sent.Block)
+	assert.Equal(t, node.Addr(), sent.Node)
+	waitFor(t, "transaction in the node's mempool", func() bool {
+		return node.mempool.Has(Hex2Bytes(sent.Transaction.ID))
+	})
+
+	// the transaction is not mined by the CLI
+	var balance balanceResult
+	mustRunCLI(t, c, &balance, "getbalance", "-address", testMinerAddress)
+	assert.Equal(t, 0, balance.Balance)
+}
+
+func TestCLIPSBT(t *testing.T) {
+	// the key of the cold wallet never reaches the online directory
+	cold := newTestCLI(t, "cold")
+	var w walletResult
+	mustRunCLI(t, cold, &w, "createwallet", "-label", "cold")
+	online := newTestCLI(t, "online")
+	mustRunCLI(t, online, nil, "createblockchain", "-address", w.Address)
+
+	unsigned := filepath.Join(online.DataDir, "unsigned.psbt")
+	var created psbtResult
+	mustRunCLI(t, online, &created, "createpsbt", "-pubkey", w.PubKey, "-to", testMinerAddress, "-amount", "3", "-fee", "2", "-out", unsigned)
+	assert.Equal(t, unsigned, created.File)
+	assert.False(t, created.Complete)
+	if assert.Len(t, created.Inputs, 1) {
+		assert.Equal(t, BlockSubsidy(0), created.Inputs[0].Value)
+		assert.False(t, created.Inputs[0].Signed)
+	}
+	assert.Equal(t, 2, created.Fee)
+	assert.ErrorIs(t, runCLI(t, online, nil, "sendpsbt", "-in", unsigned, "-mine", w.Address), ErrIncomplete)
+	assert.ErrorIs(t, runCLI(t, online, nil, "signpsbt", "-in", unsigned), os.ErrNotExist)
+
+	signed := filepath.Join(cold.DataDir, "signed.psbt")
+	var signedResult, decoded psbtResult
+	mustRunCLI(t, cold, &signedResult, "signpsbt", "-in", unsigned, "-out", signed)
+	assert.True(t, signedResult.Complete)
+	assert.Equal(t, created.Transaction, signedResult.Transaction)
+	mustRunCLI(t, online, &decoded, "decodepsbt", "-in", signed)
+	assert.Equal(t, "", decoded.File)
+	decoded.File = signed
+	assert.Equal(t, signedResult, decoded)
+
+	// another key of the cold wallets signs nothing
+	other := newTestCLI(t, "other")
+	mustRunCLI(t, other, nil, "createwallet")
+	assert.ErrorIs(t, runCLI(t, other, nil, "signpsbt", "-in", unsigned), ErrNothingToSign)
+
+	combined := filepath.Join(online.DataDir, "combined.psbt")
+	mustRunCLI(t, online, &decoded, "combinepsbt", "-in", unsigned+","+signed, "-out", combined)
+	assert.True(t, decoded.Complete)
+
+	var sent sendResult
+	mustRunCLI(t, online, &sent, "sendpsbt", "-in", combined, "-mine", w.Address)
+	assert.Equal(t, created.Transaction, sent.Transaction)
+	assert.Equal(t, created.Fee, sent.Fee)
+	if assert.NotNil(t, sent.Block) {
+		assert.Equal(t, 1, sent.Block.Height)
+	}
+	var balance balanceResult
+	mustRunCLI(t, online, &balance, "getbalance", "-address", testMinerAddress)
+	assert.Equal(t, 3, balance.Balance)
+
+	assert.ErrorIs(t, runCLI(t, online, nil, "createpsbt", "-to", testMinerAddress, "-amount", "3", "-out", unsigned), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, online, nil, "createpsbt", "-pubkey", "zz", "-to", testMinerAddress, "-amount", "3", "-out", unsigned), ErrInvalidPubKey)
+	assert.ErrorIs(t, runCLI(t, online, nil, "sendpsbt", "-in", combined), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, online, nil, "decodepsbt", "-in", filepath.Join(online.DataDir, DBFile)), ErrMalformed)
+}
+
+func TestCLISnapshot(t *testing.T) {
+	c := newTestCLI(t, "secret")
+	mustRunCLI(t, c, nil, "createblockchain", "-address", testMinerAddress)
+	mustRunCLI(t, c, nil, "mine", "-address", testMinerAddress, "-blocks", "8")
+
+	var pruned pruneResult
+	mustRunCLI(t, c, &pruned, "prune", "-depth", "6")
+	assert.Equal(t, pruneResult{Depth: 6, PrunedHeight: 2}, pruned)
+	assert.ErrorIs(t, runCLI(t, c, nil, "prune"), ErrUsage)
+	assert.ErrorIs(t, runCLI(t, c, nil, "prune", "-depth", "1"), ErrPruneDepth)
+	var chain []blockResult
+	mustRunCLI(t, c, &chain, "printchain")
+	if assert.Len(t, chain, 7) {
+		assert.Equal(t, 0, chain[0].Height)
+		assert.Equal(t, 3, chain[1].Height)
+	}
+
+	file := filepath.Join(c.DataDir, "utxo.snapshot")
+	var exported snapshotResult
+	mustRunCLI(t, c, &exported, "exportsnapshot", "-out", file)
+	assert.Equal(t, 8, exported.Height)
+	assert.Equal(t, 9, exported.UTXOs)
+
+	// a new node trusting the commitment
+	node := newTestCLI(t, "secret")
+	assert.ErrorIs(t, runCLI(t, node, nil, "importsnapshot", "-in", file, "-commitment", strings.Repeat("00", 32)), ErrSnapshotCommitment)
+	var imported snapshotResult
+	mustRunCLI(t, node, &imported, "importsnapshot", "-in", file, "-commitment", exported.Commitment)
+	assert.Equal(t, exported, imported)
+	assert.ErrorIs(t, runCLI(t, node, nil, "importsnapshot", "-in", file, "-commitment", exported.Commitment), ErrChainExists)
+
+	var balance, expected balanceResult
+	mustRunCLI(t, c, &expected, "getbalance", "-address", testMinerAddress)
+	mustRunCLI(t, node, &balance, "getbalance", "-address", testMinerAddress)
+	assert.Equal(t, expected, balance)
+	var mined []blockResult
+	mustRunCLI(t, node, &mined, "mine", "-address", testMinerAddress)
+	if assert.Len(t, mined, 1) {
+		assert.Equal(t, 9, mined[0].Height)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Coin selection chooses the unspent outputs funding a transaction.
+// The strategies work on the effective values of the coins, their value
+// minus the fee of spending them, see TxBuilder, and select coins whose
+// effective values add up to at least the target: the value of the
+// outputs plus the fee of the rest of the transaction. The excess over
+// the target becomes a change output when it is worth more than the
+// cost of the change, the fee of creating and later spending it, and
+// is left to the miner otherwise.
+
+var (
+	ErrNoSelection      = errors.New("no selection of the coins avoids a change output")
+	ErrUnknownSelector  = errors.New("unknown coin selection")
+	ErrInvalidCoinValue = errors.New("coin values must be positive")
+)
+
+// bnbMaxTries bounds the number of selections branch-and-bound explores
+const bnbMaxTries = 100000
+
+// CoinSelector is a coin selection strategy. SelectCoins returns the
+// indexes of the values selected to reach target, or ErrNoFunds.
+// The values are positive.
+type CoinSelector interface {
+	Name() string
+	SelectCoins(values []int, target, costOfChange int) ([]int, error)
+}
+
+var (
+	// LargestFirst spends the largest coins first, so the transactions
+	// are small but the wallet fills up with small coins
+	LargestFirst CoinSelector = largestFirst{}
+	// SmallestFirst spends the smallest coins first, consolidating the
+	// wallet at the cost of larger transactions
+	SmallestFirst CoinSelector = smallestFirst{}
+	// BranchAndBound searches for a selection that needs no change output,
+	// as in Bitcoin Core, and falls back on LargestFirst
+	BranchAndBound CoinSelector = branchAndBound{fallback: LargestFirst}
+	// RandomImprove selects random coins up to the target, then keeps
+	// adding random coins while they bring the selection closer to twice
+	// the target, as in Cardano's CIP-2. The change is then about the
+	// size of the payment, which keeps coins of useful sizes in the wallet.
+	RandomImprove CoinSelector = randomImprove{}
+)
+
+// coinSelectors are the known strategies, LargestFirst first
+var coinSelectors = []CoinSelector{LargestFirst, SmallestFirst, BranchAndBound, RandomImprove}
+
+// CoinSelectorByName returns the strategy with the given name
+func CoinSelectorByName(name string) (CoinSelector, error) {
+	for _, s := range coinSelectors {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownSelector, name)
+}
+
+// checkSelectionValues checks that the values are positive and that
+// their total reaches the target
+func checkSelectionValues(values []int, target int) error {
+	total := 0
+	for _, v := range values {
+		if v <= 0 {
+			return fmt.Errorf("%w: %d", ErrInvalidCoinValue, v)
+		}
+		total += v
+	}
+	if total < target {
+		return fmt.Errorf("%w: %d < %d", ErrNoFunds, total, target)
+	}
+	return nil
+}
+
+// sortedIndexes returns the indexes of the values sorted by value,
+// decreasing or increasing
+func sortedIndexes(values []int, decreasing bool) []int {
+	indexes := make([]int, len(values))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		if decreasing {
+			return values[indexes[i]] > values[indexes[j]]
+		}
+		return values[indexes[i]] < values[indexes[j]]
+	})
+	return indexes
+}
+
+// selectInOrder selects the values in the given order until the target
+func selectInOrder(values, order []int, target int) []int {
+	var selected []int
+	sum := 0
+	for _, i := range order {
+		if sum >= target {
+			break
+		}
+		selected = append(selected, i)
+		sum += values[i]
+	}
+	return selected
+}
+
+type largestFirst struct{}
+
+func (largestFirst) Name() string { return "largest-first" }
+
+func (largestFirst) SelectCoins(values []int, target, costOfChange int) ([]int, error) {
+	if err := checkSelectionValues(values, target); err != nil {
+		return nil, err
+	}
+	return selectInOrder(values, sortedIndexes(values, true), target), nil
+}
+
+type smallestFirst struct{}
+
+func (smallestFirst) Name() string { return "smallest-first" }
+
+func (smallestFirst) SelectCoins(values []int, target, costOfChange int) ([]int, error) {
+	if err := checkSelectionValues(values, target); err != nil {
+		return nil, err
+	}
+	return selectInOrder(values, sortedIndexes(values, false), target), nil
+}
+
+// branchAndBound explores the selections depth first, from the largest
+// coins, for the one whose excess is the smallest without exceeding the
+// cost of the change. Without a match, it uses the fallback or returns
+// ErrNoSelection.
+type branchAndBound struct {
+	fallback CoinSelector
+}
+
+func (branchAndBound) Name() string { return "branch-and-bound" }
+
+func (s branchAndBound) SelectCoins(values []int, target, costOfChange int) ([]int, error) {
+	if err := checkSelectionValues(values, target); err != nil {
+		return nil, err
+	}
+	order := sortedIndexes(values, true)
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+
+	var best, current []int
+	bestExcess, tries := -1, 0
+	// search decides the coins from order[i], with rest the total of
+	// these coins
+	var search func(i, sum, rest int)
+	search = func(i, sum, rest int) {
+		if tries >= bnbMaxTries || bestExcess == 0 {
+			return
+		}
+		tries++
+		if sum > target+costOfChange || sum+rest < target {
+			return
+		}
+		if sum >= target {
+			// more coins only add to the excess
+			if excess := sum - target; best == nil || excess < bestExcess {
+				best, bestExcess = append([]int{}, current...), excess
+			}
+			return
+		}
+		if i == len(order) {
+			return
+		}
+		v := values[order[i]]
+		current = append(current, order[i])
+		search(i+1, sum+v, rest-v)
+		current = current[:len(current)-1]
+
+		// without the coin, the next ones of the same value are left out
+		// as well, their selections were explored with it
+		j, skipped := i+1, v
+		for j < len(order) && values[order[j]] == v {
+			skipped += v
+			j++
+		}
+		search(j, sum, rest-skipped)
+	}
+	search(0, 0, total)
+
+	if best != nil {
+		return best, nil
+	}
+	if s.fallback != nil {
+		return s.fallback.SelectCoins(values, target, costOfChange)
+	}
+	return nil, ErrNoSelection
+}
+
+type randomImprove struct{}
+
+func (randomImprove) Name() string { return "random-improve" }
+
+func (randomImprove) SelectCoins(values []int, target, costOfChange int) ([]int, error) {
+	if err := checkSelectionValues(values, target); err != nil {
+		return nil, err
+	}
+	order := rand.Perm(len(values))
+	selected := selectInOrder(values, order, target)
+	sum := 0
+	for _, i := range selected {
+		sum += values[i]
+	}
+
+	ideal, limit := 2*target, 3*target
+	distance := func(sum int) int {
+		if sum > ideal {
+			return sum - ideal
+		}
+		return ideal - sum
+	}
+	for _, i := range order[len(selected):] {
+		if next := sum + values[i]; next <= limit && distance(next) < distance(sum) {
+			selected = append(selected, i)
+			sum = next
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// selectedSum checks that the indexes are distinct indexes of values
+// and returns the sum of their values
+func selectedSum(t *testing.T, values, selected []int) int {
+	t.Helper()
+	seen := make(map[int]bool)
+	sum := 0
+	for _, i := range selected {
+		if i < 0 || i >= len(values) || seen[i] {
+			t.Fatalf("invalid selection %v of %d values", selected, len(values))
+		}
+		seen[i] = true
+		sum += values[i]
+	}
+	return sum
+}
+
+func TestCoinSelectors(t *testing.T) {
+	values := []int{5, 1, 20, 2, 10}
+	for _, selector := range coinSelectors {
+		found, err := CoinSelectorByName(selector.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, selector, found)
+
+		for _, target := range []int{1, 7, 17, 38} {
+			selected, err := selector.SelectCoins(values, target, 0)
+			if assert.Nil(t, err, selector.Name()) {
+				assert.GreaterOrEqual(t, selectedSum(t, values, selected), target, selector.Name())
+			}
+		}
+		_, err = selector.SelectCoins(values, 39, 0)
+		assert.ErrorIs(t, err, ErrNoFunds, selector.Name())
+		_, err = selector.SelectCoins(nil, 1, 0)
+		assert.ErrorIs(t, err, ErrNoFunds, selector.Name())
+		_, err = selector.SelectCoins([]int{3, 0}, 1, 0)
+		assert.ErrorIs(t, err, ErrInvalidCoinValue, selector.Name())
+	}
+	_, err := CoinSelectorByName("first-fit")
+	assert.ErrorIs(t, err, ErrUnknownSelector)
+
+	selected, _ := LargestFirst.SelectCoins(values, 7, 0)
+	assert.Equal(t, []int{2}, selected)
+	selected, _ = LargestFirst.SelectCoins(values, 25, 0)
+	assert.Equal(t, []int{2, 4}, selected)
+	selected, _ = SmallestFirst.SelectCoins(values, 7, 0)
+	assert.Equal(t, []int{1, 3, 0}, selected)
+}
+
+func TestBranchAndBound(t *testing.T) {
+	values := []int{5, 1, 20, 2, 10}
+	for _, target := range []int{3, 8, 16, 17, 33, 38} {
+		selected, err := BranchAndBound.SelectCoins(values, target, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, target, selectedSum(t, values, selected), "target %d", target)
+	}
+
+	// the smallest excess within the cost of the change
+	selected, err := BranchAndBound.SelectCoins([]int{10, 7, 4}, 9, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, selectedSum(t, []int{10, 7, 4}, selected))
+
+	// without a match, the fallback pays a change
+	_, err = branchAndBound{}.SelectCoins([]int{10, 20}, 15, 2)
+	assert.ErrorIs(t, err, ErrNoSelection)
+	selected, err = BranchAndBound.SelectCoins([]int{10, 20}, 15, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1}, selected)
+
+	// coins of the same value are not tried in every order
+	values = make([]int, 1000)
+	for i := range values {
+		values[i] = 3
+	}
+	values[500] = 1
+	selected, err = BranchAndBound.SelectCoins(values, 301, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 301, selectedSum(t, values, selected))
+	assert.Len(t, selected, 101)
+}
+
+func TestRandomImprove(t *testing.T) {
+	values := make([]int, 300)
+	for i := range values {
+		values[i] = 1
+	}
+	selected, err := RandomImprove.SelectCoins(values, 50, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, selectedSum(t, values, selected), "twice the target")
+
+	// the improvement never goes over three times the target
+	values = []int{10, 40}
+	for i := 0; i < 20; i++ {
+		selected, err = RandomImprove.SelectCoins(values, 10, 0)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, selectedSum(t, values, selected), 40)
+	}
+}
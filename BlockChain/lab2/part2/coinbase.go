@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+)
+
+// The input of a coinbase starts with the height of its block, as in
+// BIP34: the push of the height as a script number, see scriptNum, which
+// is a length byte followed by the height in little-endian order, on as
+// few bytes as possible, with a sign bit. The coinbases of two blocks
+// are thus different, and so are their IDs, even when they pay the same
+// reward to the same address. The data of the coinbase follows.
+//
+// The reward of a block is halved every HalvingInterval blocks, see
+// BlockSubsidy. The outputs of a coinbase can be spent after
+// CoinbaseMaturity blocks, when a reorganization is unlikely to remove them.
+
+var (
+	ErrBadCoinbaseHeight = errors.New("coinbase does not start with the block height")
+	ErrImmatureCoinbase  = errors.New("coinbase output is spent before its maturity")
+)
+
+// maxHeightSize is the maximum size of an encoded height
+const maxHeightSize = 4
+
+// BlockSubsidy returns the reward of the block at the given height,
+// without the fees: BlockReward halved every HalvingInterval blocks
+func BlockSubsidy(height int) int {
+	halvings := height / HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return BlockReward >> uint(halvings)
+}
+
+// coinbaseScript returns the input of the coinbase of the
+// block at the given height, with the given data
+func coinbaseScript(height int, data []byte) []byte {
+	return append(pushData(scriptNum(int64(height))), data...)
+}
+
+// CoinbaseHeight returns the block height encoded in the coinbase input
+func CoinbaseHeight(tx *Transaction) (int, error) {
+	if len(tx.Vin) != 1 || !tx.IsCoinbase() {
+		return 0, ErrBadCoinbase
+	}
+	script := tx.Vin[0].PubKey
+	if len(script) == 0 || int(script[0]) > maxHeightSize || len(script) <= int(script[0]) {
+		return 0, ErrBadCoinbaseHeight
+	}
+	n := script[1 : 1+script[0]]
+	height := 0
+	for i := len(n) - 1; i >= 0; i-- {
+		height = height<<8 | int(n[i])
+	}
+	// only the minimal encoding of a positive height is valid
+	if !bytes.Equal(coinbaseScript(height, nil), script[:1+len(n)]) {
+		return 0, ErrBadCoinbaseHeight
+	}
+	return height, nil
+}
+
+// Height returns the height of the block, encoded in its coinbase
+func (b *Block) Height() (int, error) {
+	if len(b.Transactions) == 0 {
+		return 0, ErrNoTransactions
+	}
+	return CoinbaseHeight(b.Transactions[0])
+}
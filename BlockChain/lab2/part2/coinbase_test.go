@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setCoinbaseMaturity changes CoinbaseMaturity for the duration of the test
+func setCoinbaseMaturity(t *testing.T, maturity int) {
+	old := CoinbaseMaturity
+	CoinbaseMaturity = maturity
+	t.Cleanup(func() { CoinbaseMaturity = old })
+}
+
+func TestCoinbaseHeight(t *testing.T) {
+	for _, test := range []struct {
+		height  int
+		encoded string
+	}{
+		{0, "00"},
+		{1, "0101"},
+		{127, "017f"},
+		{128, "028000"},
+		{255, "02ff00"},
+		{256, "020001"},
+		{65535, "03ffff00"},
+		{1 << 23, "0400008000"},
+	} {
+		script := coinbaseScript(test.height, []byte("data"))
+		assert.Equal(t, Hex2Bytes(test.encoded+"64617461"), script)
+
+		tx, err := NewCoinbaseTX(testMinerAddress, "data", test.height)
+		assert.Nil(t, err)
+		height, err := CoinbaseHeight(tx)
+		assert.Nil(t, err)
+		assert.Equal(t, test.height, height)
+	}
+
+	tx, _ := NewCoinbaseTX(testMinerAddress, "", 1)
+	for _, script := range []string{
+		"",             // no height
+		"0100",         // zero on one byte
+		"020100",       // not minimal
+		"0181",         // negative
+		"02ff",         // truncated
+		"050000000001", // too long
+	} {
+		tx.Vin[0].PubKey = Hex2Bytes(script)
+		_, err := CoinbaseHeight(tx)
+		assert.ErrorIs(t, err, ErrBadCoinbaseHeight, script)
+	}
+
+	_, err := CoinbaseHeight(testTransactions["tx1"])
+	assert.ErrorIs(t, err, ErrBadCoinbase)
+}
+
+func TestCoinbaseIDsAreUnique(t *testing.T) {
+	tx1, _ := NewCoinbaseTX(testMinerAddress, "", 1)
+	tx2, _ := NewCoinbaseTX(testMinerAddress, "", 2)
+	assert.NotEqual(t, tx1.ID, tx2.ID)
+}
+
+func TestBlockSubsidy(t *testing.T) {
+	for _, test := range []struct {
+		height  int
+		subsidy int
+	}{
+		{0, BlockReward},
+		{HalvingInterval - 1, BlockReward},
+		{HalvingInterval, BlockReward / 2},
+		{2 * HalvingInterval, BlockReward / 4},
+		{4*HalvingInterval - 1, BlockReward / 8},
+		{4 * HalvingInterval, 0},
+		{64 * HalvingInterval, 0},
+	} {
+		assert.Equal(t, test.subsidy, BlockSubsidy(test.height), "height %d", test.height)
+	}
+
+	coinbase, _ := NewCoinbaseTXWithFees(testMinerAddress, "", HalvingInterval, 1)
+	assert.Equal(t, BlockReward/2+1, coinbase.Vout[0].Value)
+	assert.Nil(t, checkCoinbaseValue(coinbase, HalvingInterval, 1))
+	assert.ErrorIs(t, checkCoinbaseValue(coinbase, HalvingInterval+1, 0), ErrCoinbaseValue)
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	setCoinbaseMaturity(t, 2)
+	bc, coinbases := newMempoolTestChain(t, 1)
+	genesis := bc.CurrentBlock()
+	pubKeyHash := Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38")
+
+	// the genesis coinbase cannot be spent in block 1
+	spend := newTestSpend(t, bc, coinbases[0], 9)
+	assert.ErrorIs(t, bc.CheckTransaction(spend), ErrImmatureCoinbase)
+	assert.False(t, bc.VerifyTransaction(spend))
+	assert.ErrorIs(t, NewMempool(bc, DefaultMempoolSize).Add(spend), ErrInvalidTx)
+	balance, outputs := bc.UTXOIndex().FindSpendableOutputs(pubKeyHash, 1)
+	assert.Equal(t, 0, balance)
+	assert.Empty(t, outputs)
+
+	early := mineTestBlock(t, genesis, "early", spend)
+	assert.ErrorIs(t, bc.CheckBlock(early), ErrImmatureCoinbase)
+	assert.ErrorIs(t, bc.storeBlock(early), ErrImmatureCoinbase)
+
+	// but it can in block 2
+	mustStoreBlock(t, bc, mineTestBlock(t, genesis, "b1"))
+	assert.Nil(t, bc.CheckTransaction(spend))
+	balance, outputs = bc.UTXOIndex().FindSpendableOutputs(pubKeyHash, 1)
+	assert.Equal(t, BlockReward, balance)
+	assert.Len(t, outputs, 1)
+	mustStoreBlock(t, bc, mineTestBlock(t, bc.CurrentBlock(), "b2", spend))
+	assert.Equal(t, 2, bc.Height())
+}
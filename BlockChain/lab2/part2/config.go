@@ -1,8 +1,54 @@
 package main
 
-// BlockReward represents the reward given by mining a new block
+import "runtime"
+
+// BlockReward represents the reward given by mining a new block,
+// before its first halving, see BlockSubsidy
 const BlockReward = 10
 
+// MaxMoney is the maximum value of an output and of the outputs of a
+// transaction, more than all the coins the block rewards create, so the
+// sums of values cannot overflow, see CheckTransactionSanity
+const MaxMoney = 21000000
+
+// HalvingInterval is the number of blocks between two halvings of the
+// block reward
+var HalvingInterval = 210
+
+// CoinbaseMaturity is the number of blocks following a coinbase
+// before its outputs can be spent
+var CoinbaseMaturity = 100
+
 // GenesisCoinbaseData contains the message of the genesis transaction.
 // Historically: https://en.bitcoin.it/wiki/File:Jonny1000thetimes.png
 const GenesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+
+// MaxBlockTxsSize is the maximum size of the transactions
+// a miner puts in a block, see Mempool.BlockTemplate
+const MaxBlockTxsSize = 512 << 10
+
+// MaxBlockSize is the maximum size of an encoded block, see CheckBlockSanity
+const MaxBlockSize = 1 << 20
+
+// TargetBlockInterval is the time between two blocks,
+// in seconds, the difficulty retargeting aims for
+var TargetBlockInterval int64 = 10
+
+// RetargetInterval is the number of blocks between two difficulty
+// adjustments. It must be at least 2.
+var RetargetInterval = 20
+
+// MinerWorkers is the number of goroutines mining a block
+var MinerWorkers = runtime.NumCPU()
+
+// DBFile is the file where the blockchain is persisted
+const DBFile = "blockchain.db"
+
+// WalletsFile is the file where the encrypted wallets are persisted,
+// see Wallets
+const WalletsFile = "wallets.json"
+
+// MinPruneDepth is the minimum number of blocks below the tip whose
+// bodies a pruned chain keeps, so it can still be reorganized, see
+// Blockchain.EnablePruning
+const MinPruneDepth = 6
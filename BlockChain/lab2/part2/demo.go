@@ -10,7 +10,7 @@ package main
 
 // func main() {
 // 	fmt.Printf("%+v\n", testEncPubKeyUser1)
-// 	dpk := decodePublicKey(testEncPubKeyUser1)
+// 	dpk, _ := decodePublicKey(testEncPubKeyUser1)
 // 	byt := pubKeyToByte(*dpk)
 // 	fmt.Printf("%x", byt)
 // }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math/big"
+)
+
+// The difficulty of a block is the target its hash must not exceed,
+// stored in the Bits of its header in the compact format of Bitcoin:
+// the highest byte is the size of the target in bytes, and the three
+// lower bytes are its most significant bytes.
+//
+// Every RetargetInterval blocks, the target is scaled by the time it
+// took to mine the previous blocks over the expected time, given by
+// TargetBlockInterval. As in Bitcoin, the scale is clamped between 1/4
+// and 4, so the difficulty changes at most by a factor of 4 at a time,
+// and the target never exceeds the target of the genesis block.
+
+// powLimit is the easiest target allowed, 2^(256-TARGETBITS),
+// which is the target of the genesis block
+var powLimit = new(big.Int).Lsh(big.NewInt(1), 256-TARGETBITS)
+
+// InitialBits is the difficulty of the genesis block
+var InitialBits = BigToCompact(powLimit)
+
+// retargetClamp bounds the change of the difficulty at each retarget
+const retargetClamp = 4
+
+// CompactToBig returns the target encoded by bits
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		target = big.NewInt(mantissa >> (8 * (3 - exponent)))
+	} else {
+		target = new(big.Int).Lsh(big.NewInt(mantissa), 8*(exponent-3))
+	}
+	// the sign bit of the mantissa
+	if bits&0x00800000 != 0 {
+		target.Neg(target)
+	}
+	return target
+}
+
+// BigToCompact returns the compact encoding of a positive target.
+// The target is rounded down to its three most significant bytes.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	exponent := uint(len(target.Bytes()))
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64()) << (8 * (3 - exponent))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+	// the highest bit of the mantissa is the sign bit
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent)<<24 | mantissa
+}
+
+// nextBits returns the difficulty of the block following parent, at
+// height parent + 1. ancestor returns the header of the parent's branch
+// at the given height, it is only called at the retarget heights.
+func nextBits(parent *BlockHeader, parentHeight int, ancestor func(height int) (*BlockHeader, error)) (uint32, error) {
+	height := parentHeight + 1
+	if height%RetargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	// the interval is measured from the first block of the period
+	// to its last block, the parent
+	first, err := ancestor(height - RetargetInterval)
+	if err != nil {
+		return 0, err
+	}
+	expected := int64(RetargetInterval-1) * TargetBlockInterval
+	actual := parent.Timestamp - first.Timestamp
+
+	// the clamp is applied to the target rather than to the duration,
+	// so it is exact whatever the expected duration
+	old := CompactToBig(parent.Bits)
+	target := new(big.Int).Mul(old, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if min := new(big.Int).Div(old, big.NewInt(retargetClamp)); target.Cmp(min) < 0 {
+		target = min
+	}
+	if max := new(big.Int).Mul(old, big.NewInt(retargetClamp)); target.Cmp(max) > 0 {
+		target = max
+	}
+	if target.Cmp(powLimit) > 0 {
+		target.Set(powLimit)
+	}
+	return BigToCompact(target), nil
+}
+
+// NextBits returns the difficulty required for a block
+// extending the block with the given hash
+func (bc *Blockchain) NextBits(prevHash []byte) (uint32, error) {
+	parent, err := bc.GetBlockHeader(prevHash)
+	if err != nil {
+		return 0, err
+	}
+	parentHeight, err := bc.blockHeight(prevHash)
+	if err != nil {
+		return 0, err
+	}
+
+	// walks back the branch of the parent, which may be a side branch
+	ancestor := func(height int) (*BlockHeader, error) {
+		header := parent
+		for h := parentHeight; h > height; h-- {
+			if header, err = bc.GetBlockHeader(header.PrevBlockHash); err != nil {
+				return nil, err
+			}
+		}
+		return header, nil
+	}
+	return nextBits(parent, parentHeight, ancestor)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setRetarget changes the retarget parameters for the duration of the test
+func setRetarget(t *testing.T, interval int, blockInterval int64) {
+	oldInterval, oldBlockInterval := RetargetInterval, TargetBlockInterval
+	RetargetInterval, TargetBlockInterval = interval, blockInterval
+	t.Cleanup(func() {
+		RetargetInterval, TargetBlockInterval = oldInterval, oldBlockInterval
+	})
+}
+
+// mineTestBlockWithBits mines a block on top of prev at the given difficulty
+func mineTestBlockWithBits(t *testing.T, prev *Block, data string, bits uint32) *Block {
+	b := mineTestBlock(t, prev, data)
+	b.Bits = bits
+	b.Mine()
+	return b
+}
+
+func TestCompactBits(t *testing.T) {
+	tests := []struct {
+		bits   uint32
+		target string
+	}{
+		{0x20010000, "100000000000000000000000000000000000000000000000000000000000000"},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x1b0404cb, "404cb000000000000000000000000000000000000000000000000"},
+		{0x03123456, "123456"},
+		{0x02123400, "1234"},
+		{0x01120000, "12"},
+	}
+	for _, test := range tests {
+		target, _ := new(big.Int).SetString(test.target, 16)
+		assert.Equal(t, target, CompactToBig(test.bits), "%08x", test.bits)
+		assert.Equal(t, test.bits, BigToCompact(target), "%08x", test.bits)
+	}
+
+	assert.Equal(t, powLimit, CompactToBig(InitialBits))
+	assert.Equal(t, uint32(0), BigToCompact(big.NewInt(0)))
+	// the mantissa is rounded down and never has its sign bit set
+	assert.Equal(t, uint32(0x02008000), BigToCompact(big.NewInt(0x80)))
+	assert.Equal(t, uint32(0x04123456), BigToCompact(big.NewInt(0x12345678)))
+	assert.Equal(t, big.NewInt(-0x12345600), CompactToBig(0x04923456))
+}
+
+func TestNextBits(t *testing.T) {
+	setRetarget(t, 4, 10)
+	// the expected time of a period is 3 intervals: 30 seconds
+	start := new(big.Int).Rsh(powLimit, 4)
+	startBits := BigToCompact(start)
+	tests := []struct {
+		name     string
+		bits     uint32
+		duration int64
+		height   int
+		expected *big.Int
+	}{
+		{"not a retarget height", startBits, 0, 5, start},
+		{"on schedule", startBits, 30, 3, start},
+		{"twice too fast", startBits, 15, 3, new(big.Int).Rsh(start, 1)},
+		{"three times too slow", startBits, 90, 7, new(big.Int).Mul(start, big.NewInt(3))},
+		{"clamped harder", startBits, 0, 3, new(big.Int).Rsh(start, 2)},
+		{"clamped easier", startBits, 1000, 3, new(big.Int).Lsh(start, 2)},
+		{"capped to the initial difficulty", InitialBits, 60, 3, powLimit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := make([]BlockHeader, test.height+1)
+			for h := range headers {
+				headers[h].Bits = test.bits
+				headers[h].Timestamp = TestBlockTime
+			}
+			// the period ends with the parent of the new block
+			headers[test.height].Timestamp += test.duration
+			ancestor := func(height int) (*BlockHeader, error) {
+				return &headers[height], nil
+			}
+
+			bits, err := nextBits(&headers[test.height], test.height, ancestor)
+			assert.Nil(t, err)
+			assert.Equal(t, BigToCompact(test.expected), bits)
+		})
+	}
+}
+
+func TestValidateBlockDifficulty(t *testing.T) {
+	setRetarget(t, 3, 10)
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+	hc := NewHeaderChain(genesis.BlockHeader)
+	prev := genesis
+	for _, data := range []string{"a1", "a2"} {
+		b := mineTestBlock(t, prev, data)
+		assert.True(t, bc.ValidateBlock(b))
+		assert.Nil(t, hc.AddHeader(b.BlockHeader))
+		mustStoreBlock(t, bc, b)
+		prev = b
+	}
+
+	// the blocks took no time: the difficulty is increased at most 4 times
+	bits, err := bc.NextBits(prev.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, BigToCompact(new(big.Int).Rsh(powLimit, 2)), bits)
+
+	easy := mineTestBlockWithBits(t, prev, "easy", InitialBits)
+	assert.False(t, bc.ValidateBlock(easy))
+	assert.ErrorIs(t, hc.AddHeader(easy.BlockHeader), ErrBadDifficulty)
+
+	retargeted := mineTestBlockWithBits(t, prev, "retargeted", bits)
+	assert.True(t, bc.ValidateBlock(retargeted))
+	assert.Nil(t, hc.AddHeader(retargeted.BlockHeader))
+	mustStoreBlock(t, bc, retargeted)
+
+	// the difficulty is kept until the next retarget
+	next, err := bc.NextBits(retargeted.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, bits, next)
+	assert.False(t, bc.ValidateBlock(mineTestBlock(t, retargeted, "a4")))
+	assert.True(t, bc.ValidateBlock(mineTestBlockWithBits(t, retargeted, "a4", bits)))
+}
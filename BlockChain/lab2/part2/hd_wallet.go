@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Hierarchical deterministic keys, as in BIP32, of the Secp256k1ECDSA
+// and P256ECDSA signature schemes, following SLIP-0010 for P-256
+// https://github.com/bitcoin/bips/blob/master/bip-0032.mediawiki
+// https://github.com/satoshilabs/slips/blob/master/slip-0010.md
+//
+// Every key of a tree is derived from the seed of a mnemonic, so backing
+// up the mnemonic backs up all of them. The child i of a private key is
+// hardened for i >= HardenedKeyStart: it can only be derived from the
+// private key. The other children can also be derived from the public
+// key, which lets a watch-only HDWallet find the addresses of an account
+// without its private keys.
+
+// HardenedKeyStart is the index of the first hardened child key
+const HardenedKeyStart uint32 = 0x80000000
+
+// DefaultAccountPath is the derivation path of the account of HDWallet,
+// as in BIP44: m/purpose'/coin_type'/account'. The coin type is the
+// one of the test networks.
+const DefaultAccountPath = "m/44'/1'/0'"
+
+// DefaultGapLimit is the number of consecutive unused addresses after
+// which HDWallet.Scan stops, as in BIP44
+const DefaultGapLimit = 20
+
+// hdKeyLen is the size of a serialized extended key, without checksum
+const hdKeyLen = 78
+
+// hdScheme is a signature scheme of the HD keys, with the HMAC key of
+// its master key and the versions of its serialized extended keys
+type hdScheme struct {
+	scheme         SignatureScheme
+	masterKey      []byte
+	privateVersion uint32
+	publicVersion  uint32
+}
+
+var hdSchemes = []hdScheme{
+	// Bitcoin's tprv and tpub of the test networks, see DefaultAccountPath
+	{Secp256k1ECDSA, []byte("Bitcoin seed"), 0x04358394, 0x043587cf},
+	// the versions of Bitcoin's xprv and xpub, but the keys are P-256 ones
+	{P256ECDSA, []byte("Nist256p1 seed"), 0x0488ade4, 0x0488b21e},
+}
+
+// hdSchemeOf returns the hdScheme of a signature scheme
+func hdSchemeOf(scheme SignatureScheme) (*hdScheme, error) {
+	for i := range hdSchemes {
+		if hdSchemes[i].scheme == scheme {
+			return &hdSchemes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrHDScheme, scheme.Name())
+}
+
+var (
+	ErrInvalidSeed          = errors.New("seed must be 16 to 64 bytes")
+	ErrDeriveHardened       = errors.New("cannot derive a hardened key from a public key")
+	ErrInvalidPath          = errors.New("invalid derivation path")
+	ErrInvalidExtendedKey   = errors.New("invalid extended key")
+	ErrWatchOnly            = errors.New("watch-only wallet has no private keys")
+	ErrExtendedKeyIsPrivate = errors.New("extended key is private")
+	ErrHDScheme             = errors.New("signature scheme has no HD keys")
+)
+
+// ExtendedKey is a private or public key of a tree of HD keys,
+// with the chain code deriving its children
+type ExtendedKey struct {
+	scheme    SignatureScheme
+	key       []byte // the private key, or the compressed public key
+	chainCode []byte
+	depth     byte
+	parentFP  []byte // the fingerprint of the parent key
+	childNum  uint32
+	private   bool
+}
+
+// NewMasterKey returns the root private key of the tree of the seed,
+// see MnemonicToSeed, of the DefaultSignatureScheme
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	return NewSchemeMasterKey(DefaultSignatureScheme, seed)
+}
+
+// NewSchemeMasterKey returns the root private key of the tree of the
+// seed of the scheme, Secp256k1ECDSA or P256ECDSA
+func NewSchemeMasterKey(scheme SignatureScheme, seed []byte) (*ExtendedKey, error) {
+	hd, err := hdSchemeOf(scheme)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, ErrInvalidSeed
+	}
+	n := scheme.Curve().Params().N
+	mac := hmacSHA512(hd.masterKey, seed)
+	// the key must be in [1, n-1], see SLIP-0010
+	for k := new(big.Int).SetBytes(mac[:32]); k.Sign() == 0 || k.Cmp(n) >= 0; k.SetBytes(mac[:32]) {
+		mac = hmacSHA512(hd.masterKey, mac)
+	}
+	return &ExtendedKey{scheme: scheme, key: mac[:32], chainCode: mac[32:], parentFP: make([]byte, 4), private: true}, nil
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Scheme returns the signature scheme of the key
+func (k *ExtendedKey) Scheme() SignatureScheme {
+	return k.scheme
+}
+
+// IsPrivate reports whether the key is a private key
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+// Depth returns the number of derivations from the master key
+func (k *ExtendedKey) Depth() int {
+	return int(k.depth)
+}
+
+// compressedPubKey returns the public key as 0x02 or 0x03, for
+// the parity of Y, followed by X
+func (k *ExtendedKey) compressedPubKey() []byte {
+	if !k.private {
+		return k.key
+	}
+	curve := k.scheme.Curve()
+	x, y := curve.ScalarBaseMult(k.key)
+	return elliptic.MarshalCompressed(curve, x, y)
+}
+
+// unmarshalCompressed decodes a compressed public key of the curve,
+// nil if it is not valid
+func unmarshalCompressed(curve elliptic.Curve, pubKey []byte) (*big.Int, *big.Int) {
+	if c, ok := curve.(*secp256k1Curve); ok {
+		return c.unmarshalCompressed(pubKey)
+	}
+	return elliptic.UnmarshalCompressed(curve, pubKey)
+}
+
+// PublicKey returns the public key, encoded as the public keys of
+// the wallets, see pubKeyToByte
+func (k *ExtendedKey) PublicKey() []byte {
+	curve := k.scheme.Curve()
+	x, y := unmarshalCompressed(curve, k.compressedPubKey())
+	return pubKeyToByte(ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+}
+
+// PrivateKey returns the private key of a private extended key
+func (k *ExtendedKey) PrivateKey() (ecdsa.PrivateKey, error) {
+	if !k.private {
+		return ecdsa.PrivateKey{}, ErrWatchOnly
+	}
+	curve := k.scheme.Curve()
+	d := new(big.Int).SetBytes(k.key)
+	x, y := curve.ScalarBaseMult(k.key)
+	return ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}, nil
+}
+
+// Address returns the address of the key
+func (k *ExtendedKey) Address() string {
+	return GetStringAddress(GetAddress(k.PublicKey()))
+}
+
+// fingerprint identifies the key in its children
+func (k *ExtendedKey) fingerprint() []byte {
+	return HashPubKey(k.compressedPubKey())[:4]
+}
+
+// Neuter returns the public extended key of the key
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+	return &ExtendedKey{
+		scheme:    k.scheme,
+		key:       k.compressedPubKey(),
+		chainCode: k.chainCode,
+		depth:     k.depth,
+		parentFP:  k.parentFP,
+		childNum:  k.childNum,
+	}
+}
+
+// Child returns the child key i, a hardened one from HardenedKeyStart.
+// Public keys only derive the public keys of the children that are not
+// hardened.
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	if k.depth == 0xff {
+		return nil, fmt.Errorf("%w: too deep", ErrInvalidPath)
+	}
+	hardened := i >= HardenedKeyStart
+	if hardened && !k.private {
+		return nil, ErrDeriveHardened
+	}
+
+	data := make([]byte, 0, 37)
+	if hardened {
+		data = append(append(data, 0), k.key...)
+	} else {
+		data = append(data, k.compressedPubKey()...)
+	}
+	data = appendUint32(data, i)
+
+	curve := k.scheme.Curve()
+	n := curve.Params().N
+	for {
+		mac := hmacSHA512(k.chainCode, data)
+		il, ir := mac[:32], mac[32:]
+		child := &ExtendedKey{
+			scheme:    k.scheme,
+			chainCode: ir,
+			depth:     k.depth + 1,
+			parentFP:  k.fingerprint(),
+			childNum:  i,
+			private:   k.private,
+		}
+
+		// the children whose key would be invalid are derived again
+		// from 0x01|IR|i instead, see SLIP-0010. BIP32 skips them, but
+		// they occur with a negligible probability on secp256k1.
+		tweak := new(big.Int).SetBytes(il)
+		if tweak.Cmp(n) < 0 {
+			if k.private {
+				key := tweak.Add(tweak, new(big.Int).SetBytes(k.key))
+				key.Mod(key, n)
+				if key.Sign() != 0 {
+					child.key = key.FillBytes(make([]byte, 32))
+					return child, nil
+				}
+			} else {
+				px, py := unmarshalCompressed(curve, k.key)
+				tx, ty := curve.ScalarBaseMult(il)
+				x, y := curve.Add(px, py, tx, ty)
+				if x.Sign() != 0 || y.Sign() != 0 {
+					child.key = elliptic.MarshalCompressed(curve, x, y)
+					return child, nil
+				}
+			}
+		}
+		data = appendUint32(append(append(data[:0], 1), ir...), i)
+	}
+}
+
+func appendUint32(data []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(data, b[:]...)
+}
+
+// ParseDerivationPath parses a path such as m/44'/1'/0'/0/5 into the
+// indexes of the children, the ones followed by ' or h are hardened
+func ParseDerivationPath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("%w: %q does not start with m", ErrInvalidPath, path)
+	}
+	var indexes []uint32
+	for _, part := range parts[1:] {
+		offset := uint32(0)
+		if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+			offset = HardenedKeyStart
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedKeyStart {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		indexes = append(indexes, uint32(index)+offset)
+	}
+	return indexes, nil
+}
+
+// Derive returns the key of the path relative to the key,
+// see ParseDerivationPath
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indexes, err := ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+	key := k
+	for _, i := range indexes {
+		if key, err = key.Child(i); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// String returns the base58 encoding of the key with a checksum,
+// as Bitcoin's extended keys, with the versions of its scheme
+func (k *ExtendedKey) String() string {
+	hd, _ := hdSchemeOf(k.scheme)
+	data := make([]byte, 0, hdKeyLen+addressChecksumLen)
+	if k.private {
+		data = appendUint32(data, hd.privateVersion)
+	} else {
+		data = appendUint32(data, hd.publicVersion)
+	}
+	data = append(data, k.depth)
+	data = append(data, k.parentFP...)
+	data = appendUint32(data, k.childNum)
+	data = append(data, k.chainCode...)
+	if k.private {
+		data = append(data, 0)
+	}
+	data = append(data, k.key...)
+	data = append(data, checksum(data)...)
+	return string(Base58Encode(data))
+}
+
+// ParseExtendedKey decodes a key encoded by ExtendedKey.String
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidExtendedKey)
+	}
+	data, err := Base58Decode([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+	}
+	if len(data) != hdKeyLen+addressChecksumLen {
+		return nil, fmt.Errorf("%w: %d bytes", ErrInvalidExtendedKey, len(data))
+	}
+	payload := data[:hdKeyLen]
+	if !bytes.Equal(checksum(payload), data[hdKeyLen:]) {
+		return nil, fmt.Errorf("%w: wrong checksum", ErrInvalidExtendedKey)
+	}
+
+	k := &ExtendedKey{
+		depth:     payload[4],
+		parentFP:  payload[5:9],
+		childNum:  binary.BigEndian.Uint32(payload[9:13]),
+		chainCode: payload[13:45],
+	}
+	version := binary.BigEndian.Uint32(payload)
+	for _, hd := range hdSchemes {
+		curve := hd.scheme.Curve()
+		k.scheme = hd.scheme
+		switch version {
+		case hd.privateVersion:
+			key := new(big.Int).SetBytes(payload[46:])
+			if payload[45] != 0 || key.Sign() == 0 || key.Cmp(curve.Params().N) >= 0 {
+				return nil, fmt.Errorf("%w: invalid private key", ErrInvalidExtendedKey)
+			}
+			k.key = payload[46:]
+			k.private = true
+			return k, nil
+		case hd.publicVersion:
+			if x, _ := unmarshalCompressed(curve, payload[45:]); x == nil {
+				return nil, fmt.Errorf("%w: invalid public key", ErrInvalidExtendedKey)
+			}
+			k.key = payload[45:]
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown version", ErrInvalidExtendedKey)
+}
+
+// HDWallet derives the addresses of an account of a tree of HD keys:
+// the receiving addresses account/0/i and the change addresses
+// account/1/i, as in BIP44. A watch-only wallet has the public key of
+// the account only, so it derives the addresses but cannot sign.
+type HDWallet struct {
+	account  *ExtendedKey
+	GapLimit int
+}
+
+// HDAddress is an address of an HDWallet with its balance
+type HDAddress struct {
+	Change  bool
+	Index   uint32
+	Address string
+	Balance int
+}
+
+// NewHDWallet returns the wallet of the account at DefaultAccountPath
+// of the tree of the seed, of the DefaultSignatureScheme
+func NewHDWallet(seed []byte) (*HDWallet, error) {
+	return NewSchemeHDWallet(DefaultSignatureScheme, seed)
+}
+
+// NewSchemeHDWallet returns the wallet of the account at
+// DefaultAccountPath of the tree of the seed of the scheme,
+// see NewSchemeMasterKey
+func NewSchemeHDWallet(scheme SignatureScheme, seed []byte) (*HDWallet, error) {
+	master, err := NewSchemeMasterKey(scheme, seed)
+	if err != nil {
+		return nil, err
+	}
+	account, err := master.Derive(DefaultAccountPath)
+	if err != nil {
+		return nil, err
+	}
+	return &HDWallet{account: account, GapLimit: DefaultGapLimit}, nil
+}
+
+// NewWatchOnlyHDWallet returns the wallet of the account of the extended
+// public key, see HDWallet.AccountPublicKey
+func NewWatchOnlyHDWallet(xpub string) (*HDWallet, error) {
+	account, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if account.IsPrivate() {
+		return nil, ErrExtendedKeyIsPrivate
+	}
+	return &HDWallet{account: account, GapLimit: DefaultGapLimit}, nil
+}
+
+// IsWatchOnly reports whether the wallet has no private keys
+func (w *HDWallet) IsWatchOnly() bool {
+	return !w.account.IsPrivate()
+}
+
+// AccountPublicKey returns the extended public key of the account,
+// which watch-only wallets are created from
+func (w *HDWallet) AccountPublicKey() string {
+	return w.account.Neuter().String()
+}
+
+// Key returns the key of the receiving or change address index
+func (w *HDWallet) Key(change bool, index uint32) (*ExtendedKey, error) {
+	chain := uint32(0)
+	if change {
+		chain = 1
+	}
+	key, err := w.account.Child(chain)
+	if err != nil {
+		return nil, err
+	}
+	return key.Child(index)
+}
+
+// Address returns the receiving or change address index
+func (w *HDWallet) Address(change bool, index uint32) (string, error) {
+	key, err := w.Key(change, index)
+	if err != nil {
+		return "", err
+	}
+	return key.Address(), nil
+}
+
+// Scan returns the receiving then the change addresses with unspent
+// outputs. Each chain is scanned until GapLimit consecutive addresses
+// without unspent outputs.
+func (w *HDWallet) Scan(utxos UTXOFinder) ([]HDAddress, error) {
+	var found []HDAddress
+	for _, change := range []bool{false, true} {
+		for index, gap := uint32(0), 0; gap < w.GapLimit; index++ {
+			key, err := w.Key(change, index)
+			if err != nil {
+				return nil, err
+			}
+			balance, outputs := utxos.FindSpendableOutputs(HashPubKey(key.PublicKey()), 0)
+			if len(outputs) == 0 {
+				gap++
+				continue
+			}
+			gap = 0
+			found = append(found, HDAddress{Change: change, Index: index, Address: key.Address(), Balance: balance})
+		}
+	}
+	return found, nil
+}
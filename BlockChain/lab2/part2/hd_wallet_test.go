@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vector 1 of BIP32, and of SLIP-0010 for the nist256p1 curve
+var hdKeyTable = []struct {
+	scheme      SignatureScheme
+	path        string
+	fingerprint string
+	chainCode   string
+	privateKey  string
+	publicKey   string
+}{
+	{
+		Secp256k1ECDSA, "m", "00000000",
+		"873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508",
+		"e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35",
+		"0339a36013301597daef41fbe593a02cc513d0b55527ec2df1050e2e8ff49c85c2",
+	},
+	{
+		Secp256k1ECDSA, "m/0'", "3442193e",
+		"47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141",
+		"edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+		"035a784662a4a20a65bf6aab9ae98a6c068a81c52e4b032c0fb5400c706cfccc56",
+	},
+	{
+		Secp256k1ECDSA, "m/0'/1", "5c1bd648",
+		"2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19",
+		"3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+		"03501e454bf00751f24b1b489aa925215d66af2234e3891c3b21a52bedb3cd711c",
+	},
+	{
+		P256ECDSA, "m", "00000000",
+		"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+		"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+		"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8",
+	},
+	{
+		P256ECDSA, "m/0'", "be6105b5",
+		"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+		"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+		"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c",
+	},
+	{
+		P256ECDSA, "m/0'/1", "9b02312f",
+		"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+		"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+		"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844",
+	},
+}
+
+func TestExtendedKeyDerivation(t *testing.T) {
+	seed := Hex2Bytes("000102030405060708090a0b0c0d0e0f")
+	for _, test := range hdKeyTable {
+		master, err := NewSchemeMasterKey(test.scheme, seed)
+		assert.Nil(t, err)
+		key, err := master.Derive(test.path)
+		assert.Nil(t, err, test.path)
+		assert.Equal(t, test.fingerprint, hex.EncodeToString(key.parentFP), test.path)
+		assert.Equal(t, test.chainCode, hex.EncodeToString(key.chainCode), test.path)
+		assert.Equal(t, test.privateKey, hex.EncodeToString(key.key), test.path)
+		assert.Equal(t, test.publicKey, hex.EncodeToString(key.compressedPubKey()), test.path)
+		assert.Equal(t, strings.Count(test.path, "/"), key.Depth())
+		assert.Equal(t, test.scheme, key.Scheme())
+	}
+	master, _ := NewMasterKey(seed)
+	assert.Equal(t, DefaultSignatureScheme, master.Scheme())
+
+	// the public key of a child that is not hardened derives from the
+	// public key of the parent
+	parent, _ := master.Derive("m/0'")
+	child, _ := parent.Child(1)
+	publicChild, err := parent.Neuter().Child(1)
+	assert.Nil(t, err)
+	assert.False(t, publicChild.IsPrivate())
+	assert.Equal(t, child.Neuter(), publicChild)
+	assert.Equal(t, child.PublicKey(), publicChild.PublicKey())
+	assert.Equal(t, child.Address(), publicChild.Address())
+	privateKey, err := child.PrivateKey()
+	assert.Nil(t, err)
+	assert.Equal(t, child.PublicKey(), pubKeyToByte(privateKey.PublicKey))
+
+	_, err = parent.Neuter().Child(HardenedKeyStart)
+	assert.ErrorIs(t, err, ErrDeriveHardened)
+	_, err = publicChild.PrivateKey()
+	assert.ErrorIs(t, err, ErrWatchOnly)
+	_, err = NewMasterKey(make([]byte, 8))
+	assert.ErrorIs(t, err, ErrInvalidSeed)
+	_, err = NewSchemeMasterKey(Secp256k1Schnorr, seed)
+	assert.ErrorIs(t, err, ErrHDScheme)
+}
+
+func TestParseDerivationPath(t *testing.T) {
+	indexes, err := ParseDerivationPath("m/44'/1h/0'/1/5")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{HardenedKeyStart + 44, HardenedKeyStart + 1, HardenedKeyStart, 1, 5}, indexes)
+	indexes, err = ParseDerivationPath("m")
+	assert.Nil(t, err)
+	assert.Empty(t, indexes)
+
+	for _, path := range []string{"", "44'/0", "m/", "m/-1", "m/a", "m/2147483648", "m/0''"} {
+		_, err := ParseDerivationPath(path)
+		assert.ErrorIs(t, err, ErrInvalidPath, path)
+	}
+}
+
+func TestExtendedKeyEncoding(t *testing.T) {
+	seed := Hex2Bytes("000102030405060708090a0b0c0d0e0f")
+	for scheme, prefix := range map[SignatureScheme]string{Secp256k1ECDSA: "t", P256ECDSA: "x"} {
+		master, _ := NewSchemeMasterKey(scheme, seed)
+		key, _ := master.Derive("m/0'/1")
+		for _, k := range []*ExtendedKey{master, key, key.Neuter()} {
+			encoded := k.String()
+			if k.IsPrivate() {
+				assert.True(t, strings.HasPrefix(encoded, prefix+"prv"), encoded)
+			} else {
+				assert.True(t, strings.HasPrefix(encoded, prefix+"pub"), encoded)
+			}
+			decoded, err := ParseExtendedKey(encoded)
+			assert.Nil(t, err)
+			assert.Equal(t, k, decoded)
+		}
+	}
+
+	// the extended keys of test vector 1 of BIP32, but of the test networks
+	master, _ := NewMasterKey(seed)
+	key, _ := master.Derive("m/0'/1")
+	assert.Equal(t, "tpubDApXh6cD2fZ7WjtgpHd8yrWyYaneiFuRZa7fVjMkgxsmC1QzoXW8cgx9zQFJ81Jx4deRGfRE7yXA9A3STsxXj4CKEZJHYgpMYikkas9DBTP", key.Neuter().String())
+	key, _ = master.Derive("m/0'")
+
+	encoded := key.Neuter().String()
+	tampered := encoded[:20] + string(b58Alphabet[(strings.IndexByte(string(b58Alphabet), encoded[20])+1)%58]) + encoded[21:]
+	for _, s := range []string{"", "xpub", tampered, encoded + "0", encoded[:len(encoded)-1]} {
+		_, err := ParseExtendedKey(s)
+		assert.ErrorIs(t, err, ErrInvalidExtendedKey, s)
+	}
+}
+
+func TestHDWallet(t *testing.T) {
+	seed, _ := MnemonicToSeed(mnemonicTable[1].mnemonic, "")
+	hd, err := NewHDWallet(seed)
+	assert.Nil(t, err)
+	assert.False(t, hd.IsWatchOnly())
+	master, _ := NewMasterKey(seed)
+	expected, _ := master.Derive(DefaultAccountPath + "/0/2")
+	key, err := hd.Key(false, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, key)
+
+	// a watch-only wallet derives the same addresses, but no private key
+	watchOnly, err := NewWatchOnlyHDWallet(hd.AccountPublicKey())
+	assert.Nil(t, err)
+	assert.True(t, watchOnly.IsWatchOnly())
+	for _, change := range []bool{false, true} {
+		for index := uint32(0); index < 3; index++ {
+			address, err := hd.Address(change, index)
+			assert.Nil(t, err)
+			watched, err := watchOnly.Address(change, index)
+			assert.Nil(t, err)
+			assert.Equal(t, address, watched)
+		}
+	}
+	key, err = watchOnly.Key(false, 0)
+	assert.Nil(t, err)
+	_, err = key.PrivateKey()
+	assert.ErrorIs(t, err, ErrWatchOnly)
+	_, err = NewWatchOnlyHDWallet(hd.account.String())
+	assert.ErrorIs(t, err, ErrExtendedKeyIsPrivate)
+}
+
+func TestHDWalletScan(t *testing.T) {
+	seed, _ := MnemonicToSeed(mnemonicTable[1].mnemonic, "")
+	hd, _ := NewHDWallet(seed)
+	hd.GapLimit = 3
+
+	utxos := make(UTXOSet)
+	pay := func(change bool, index uint32, value int) {
+		address, _ := hd.Address(change, index)
+		out := TXOutput{Value: value}
+		out.Lock(address)
+		id := hex.EncodeToString([]byte{byte(len(utxos))})
+		utxos[id] = map[int]TXOutput{0: out}
+	}
+	pay(false, 0, 1)
+	pay(false, 3, 2)
+	pay(false, 3, 3)
+	pay(false, 7, 4) // after 3 unused addresses
+	pay(true, 2, 5)
+
+	found, err := hd.Scan(utxos)
+	assert.Nil(t, err)
+	address := func(change bool, index uint32) string {
+		a, _ := hd.Address(change, index)
+		return a
+	}
+	assert.Equal(t, []HDAddress{
+		{Change: false, Index: 0, Address: address(false, 0), Balance: 1},
+		{Change: false, Index: 3, Address: address(false, 3), Balance: 5},
+		{Change: true, Index: 2, Address: address(true, 2), Balance: 5},
+	}, found)
+
+	// the watch-only wallet finds the same addresses
+	watchOnly, _ := NewWatchOnlyHDWallet(hd.AccountPublicKey())
+	watchOnly.GapLimit = 3
+	watched, err := watchOnly.Scan(utxos)
+	assert.Nil(t, err)
+	assert.Equal(t, found, watched)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+var (
+	ErrInvalidHeader = errors.New("header proof of work is not valid")
+	ErrBadDifficulty = errors.New("header difficulty does not follow the retarget schedule")
+	ErrNotInChain    = errors.New("block is not in the main chain")
+	ErrInvalidProof  = errors.New("merkle proof does not match the block")
+)
+
+// headerNode is a header of the HeaderChain tree
+type headerNode struct {
+	header BlockHeader
+	hash   []byte
+	height int
+	work   *big.Int // total work of the chain ending with this header
+	parent *headerNode
+}
+
+// HeaderChain is the chain of a light client: it keeps the block
+// headers only, validated without the block bodies. Like Blockchain,
+// it follows the branch with the most work. The transactions of its
+// blocks are verified with merkle proofs against the header merkle roots.
+type HeaderChain struct {
+	mu      sync.RWMutex
+	headers map[string]*headerNode // by hash
+	genesis *headerNode
+	tip     *headerNode
+}
+
+// NewHeaderChain returns a header chain starting with the given genesis
+// header, which is trusted
+func NewHeaderChain(genesis BlockHeader) *HeaderChain {
+	node := &headerNode{
+		header: genesis,
+		hash:   genesis.Hash(),
+		work:   headerWork(&genesis),
+	}
+	return &HeaderChain{
+		headers: map[string]*headerNode{hex.EncodeToString(node.hash): node},
+		genesis: node,
+		tip:     node,
+	}
+}
+
+// AddHeader validates the proof of work and the difficulty of the
+// header and adds it to the tree. It becomes the tip if its branch has
+// more work than the main chain. The parent of the header must be known.
+func (hc *HeaderChain) AddHeader(header BlockHeader) error {
+	if !header.ValidatePoW() {
+		return ErrInvalidHeader
+	}
+	hash := header.Hash()
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if _, ok := hc.headers[hex.EncodeToString(hash)]; ok {
+		return ErrBlockExists
+	}
+	parent, ok := hc.headers[hex.EncodeToString(header.PrevBlockHash)]
+	if !ok {
+		return ErrOrphanBlock
+	}
+	if bits, err := nextBits(&parent.header, parent.height, parent.ancestor); err != nil || bits != header.Bits {
+		return ErrBadDifficulty
+	}
+	node := &headerNode{
+		header: header,
+		hash:   hash,
+		height: parent.height + 1,
+		work:   new(big.Int).Add(parent.work, headerWork(&header)),
+		parent: parent,
+	}
+	hc.headers[hex.EncodeToString(hash)] = node
+	if node.work.Cmp(hc.tip.work) > 0 {
+		hc.tip = node
+	}
+	return nil
+}
+
+// Tip returns the hash and the height of the last header of the main chain
+func (hc *HeaderChain) Tip() ([]byte, int) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.tip.hash, hc.tip.height
+}
+
+// Height returns the height of the main chain
+func (hc *HeaderChain) Height() int {
+	_, height := hc.Tip()
+	return height
+}
+
+// Header returns the header with the given hash, of any branch
+func (hc *HeaderChain) Header(hash []byte) (*BlockHeader, bool) {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	node, ok := hc.headers[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, false
+	}
+	header := node.header
+	return &header, true
+}
+
+// InMainChain reports whether the header is part of the main chain
+func (hc *HeaderChain) InMainChain(hash []byte) bool {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.mainChainNode(hash) != nil
+}
+
+// Locator returns the hashes of main chain headers from the tip back
+// to the genesis, see Blockchain.BlockLocator
+func (hc *HeaderChain) Locator() [][]byte {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	var locator [][]byte
+	step := 1
+	for node := hc.tip; node != hc.genesis; {
+		locator = append(locator, node.hash)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		for i := 0; i < step && node != hc.genesis; i++ {
+			node = node.parent
+		}
+	}
+	return append(locator, hc.genesis.hash)
+}
+
+// VerifyTransaction checks that the transaction is included in the main
+// chain block with the given hash, using a merkle proof made by
+// Block.MerkleProof, and that its ID is its hash
+func (hc *HeaderChain) VerifyTransaction(blockHash []byte, tx *Transaction, proof MerkleProof) error {
+	hc.mu.RLock()
+	node := hc.mainChainNode(blockHash)
+	hc.mu.RUnlock()
+	if node == nil {
+		return ErrNotInChain
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return fmt.Errorf("%w: %v", ErrInvalidProof, ErrBadTxID)
+	}
+	if len(proof.proof) != len(proof.index) || !VerifyProof(node.header.MerkleRoot, merkleLeafHash(tx), proof) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// mainChainNode returns the node of the main chain with the given hash,
+// or nil. It must be called with hc.mu held.
+func (hc *HeaderChain) mainChainNode(hash []byte) *headerNode {
+	node, ok := hc.headers[hex.EncodeToString(hash)]
+	if !ok {
+		return nil
+	}
+	for ancestor := hc.tip; ancestor != nil && ancestor.height >= node.height; ancestor = ancestor.parent {
+		if ancestor == node {
+			return node
+		}
+	}
+	return nil
+}
+
+// MerkleProof returns the merkle proof of the inclusion of the
+// transaction in the block, to be checked against the merkle root
+// of the block header
+func (b *Block) MerkleProof(txID []byte) (MerkleProof, error) {
+	tx, err := b.FindTransaction(txID)
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	var leaves [][]byte
+	for _, tran := range b.Transactions {
+		leaves = append(leaves, tran.Serialize())
+	}
+	proof, index, err := NewMerkleTree(leaves).MakeMerkleProof(merkleLeafHash(tx))
+	if err != nil {
+		return MerkleProof{}, err
+	}
+	return MerkleProof{proof: proof, index: index}, nil
+}
+
+// merkleLeafHash returns the hash of the merkle tree leaf of
+// the transaction, see Block.HashTransactions
+func merkleLeafHash(tx *Transaction) []byte {
+	hash := sha256.Sum256(tx.Serialize())
+	return hash[:]
+}
+
+// headerWork returns the work of a header, see blockWork
+func headerWork(header *BlockHeader) *big.Int {
+	return blockWork(&Block{BlockHeader: *header})
+}
+
+// ancestor returns the header of the node's branch at the given height
+func (node *headerNode) ancestor(height int) (*BlockHeader, error) {
+	for node != nil && node.height > height {
+		node = node.parent
+	}
+	if node == nil {
+		return nil, ErrBlockNotFound
+	}
+	return &node.header, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderChain(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+	hc := NewHeaderChain(genesis.BlockHeader)
+
+	a1 := mineTestBlock(t, genesis, "a1")
+	a2 := mineTestBlock(t, a1, "a2")
+	b1 := mineTestBlock(t, genesis, "b1")
+	b2 := mineTestBlock(t, b1, "b2")
+	b3 := mineTestBlock(t, b2, "b3")
+
+	assert.ErrorIs(t, hc.AddHeader(a2.BlockHeader), ErrOrphanBlock)
+	assert.Nil(t, hc.AddHeader(a1.BlockHeader))
+	assert.Nil(t, hc.AddHeader(a2.BlockHeader))
+	assert.ErrorIs(t, hc.AddHeader(a2.BlockHeader), ErrBlockExists)
+	tip, height := hc.Tip()
+	assert.Equal(t, a2.Hash, tip)
+	assert.Equal(t, 2, height)
+
+	// the heavier branch becomes the main chain
+	for _, b := range []*Block{b1, b2, b3} {
+		assert.Nil(t, hc.AddHeader(b.BlockHeader))
+	}
+	tip, height = hc.Tip()
+	assert.Equal(t, b3.Hash, tip)
+	assert.Equal(t, 3, height)
+	assert.True(t, hc.InMainChain(b1.Hash))
+	assert.False(t, hc.InMainChain(a1.Hash))
+	header, ok := hc.Header(a1.Hash)
+	assert.True(t, ok)
+	assert.Equal(t, a1.BlockHeader, *header)
+	assert.Equal(t, [][]byte{b3.Hash, b2.Hash, b1.Hash, genesis.Hash}, hc.Locator())
+
+	// headers are validated without their block
+	tampered := mineTestBlock(t, b3, "b4").BlockHeader
+	tampered.Nonce++
+	assert.ErrorIs(t, hc.AddHeader(tampered), ErrInvalidHeader)
+	tampered.Nonce--
+	tampered.Bits++
+	assert.ErrorIs(t, hc.AddHeader(tampered), ErrInvalidHeader)
+}
+
+func TestHeaderChainVerifyTransaction(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	tip := bc.CurrentBlock()
+	spends := []*Transaction{
+		newTestSpend(t, bc, coinbases[0], 9),
+		newTestSpend(t, bc, coinbases[1], 8),
+	}
+	block := mineTestBlock(t, tip, "block", spends...)
+	side := mineTestBlock(t, tip, "side")
+
+	hc := NewHeaderChain(bc.GetGenesisBlock().BlockHeader)
+	for h := 1; h <= bc.Height(); h++ {
+		b, err := bc.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		assert.Nil(t, hc.AddHeader(b.BlockHeader))
+	}
+	assert.Nil(t, hc.AddHeader(block.BlockHeader))
+	assert.Nil(t, hc.AddHeader(side.BlockHeader))
+
+	// every transaction of a block with an odd number of them
+	for _, tx := range block.Transactions {
+		proof, err := block.MerkleProof(tx.ID)
+		assert.Nil(t, err)
+		assert.Nil(t, hc.VerifyTransaction(block.Hash, tx, proof))
+	}
+
+	proof, err := block.MerkleProof(spends[0].ID)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, hc.VerifyTransaction(block.Hash, spends[1], proof), ErrInvalidProof)
+	tampered := *spends[0]
+	tampered.Vout = []TXOutput{{Value: 10, PubKeyHash: spends[0].Vout[0].PubKeyHash}}
+	assert.ErrorIs(t, hc.VerifyTransaction(block.Hash, &tampered, proof), ErrInvalidProof)
+
+	// a block mined with a transaction whose ID is not its hash
+	forged := *spends[0]
+	forged.ID = spends[1].ID
+	forgedBlock := mineTestBlock(t, block, "forged", &forged)
+	assert.Nil(t, hc.AddHeader(forgedBlock.BlockHeader))
+	proof, err = forgedBlock.MerkleProof(forged.ID)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, hc.VerifyTransaction(forgedBlock.Hash, &forged, proof), ErrInvalidProof)
+
+	_, err = block.MerkleProof(side.Transactions[0].ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+	proof, err = side.MerkleProof(side.Transactions[0].ID)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, hc.VerifyTransaction(side.Hash, side.Transactions[0], proof), ErrNotInChain)
+}
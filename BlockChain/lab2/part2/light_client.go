@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// requestTimeout bounds the time a node has to answer a light client
+const requestTimeout = 30 * time.Second
+
+// LightClient follows a node's chain by downloading the block
+// headers only. Transactions are fetched with a merkle proof of their
+// inclusion, checked against the headers, so the client does not have
+// to trust the node nor to download the blocks.
+type LightClient struct {
+	// Headers is the chain of the headers received from the node
+	Headers *HeaderChain
+
+	mu   sync.Mutex // serializes the requests to the node
+	conn net.Conn
+}
+
+// DialLightClient connects to the node at addr and completes the
+// handshake. The genesis header is trusted and starts the header chain.
+func DialLightClient(addr string, genesis BlockHeader) (*LightClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &LightClient{Headers: NewHeaderChain(genesis), conn: conn}
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Sync downloads the headers following the client's main chain until
+// the node has no more to send. Headers are validated without the
+// block bodies, see HeaderChain.AddHeader.
+func (c *LightClient) Sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if err := writeMessage(c.conn, cmdGetHeaders, &getBlocksMsg{Locator: c.Headers.Locator()}); err != nil {
+			return err
+		}
+		var reply headersMsg
+		if err := c.readReply(cmdHeaders, &reply); err != nil {
+			return err
+		}
+		for _, data := range reply.Headers {
+			header, err := DeserializeBlockHeader(data)
+			if err != nil {
+				return err
+			}
+			if err := c.Headers.AddHeader(*header); err != nil && !errors.Is(err, ErrBlockExists) {
+				return fmt.Errorf("header %x: %w", header.Hash(), err)
+			}
+		}
+		if len(reply.Headers) < maxHeaders {
+			return nil
+		}
+	}
+}
+
+// FetchTransaction asks the node for a transaction of a block of the
+// main chain and checks the merkle proof of its inclusion
+func (c *LightClient) FetchTransaction(blockHash, txID []byte) (*Transaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeMessage(c.conn, cmdGetProof, &getProofMsg{Block: blockHash, Transaction: txID}); err != nil {
+		return nil, err
+	}
+	var reply proofMsg
+	if err := c.readReply(cmdProof, &reply); err != nil {
+		return nil, err
+	}
+	if len(reply.Transaction) == 0 {
+		return nil, ErrTxNotFound
+	}
+	tx, err := DeserializeTransaction(reply.Transaction)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tx.ID, txID) {
+		return nil, ErrInvalidProof
+	}
+	proof := MerkleProof{proof: reply.Hashes, index: reply.Index}
+	if err := c.Headers.VerifyTransaction(blockHash, tx, proof); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// SendTransaction hands a transaction to the node, which adds it to its
+// mempool and relays it to its peers. The node does not reply, an
+// invalid transaction is dropped.
+func (c *LightClient) SendTransaction(tx *Transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeMessage(c.conn, cmdTx, &txMsg{Transaction: tx.Serialize()})
+}
+
+// Close closes the connection to the node
+func (c *LightClient) Close() error {
+	return c.conn.Close()
+}
+
+// handshake exchanges the version messages with the node,
+// see P2PNode.handleVersion
+func (c *LightClient) handshake() error {
+	err := writeMessage(c.conn, cmdVersion, &versionMsg{
+		Version: protocolVersion,
+		Height:  c.Headers.Height(),
+		Genesis: c.Headers.genesis.hash,
+	})
+	if err != nil {
+		return err
+	}
+
+	var version versionMsg
+	if err := c.readReply(cmdVersion, &version); err != nil {
+		return err
+	}
+	if version.Version < protocolVersion {
+		return fmt.Errorf("%w: %d", ErrOldVersion, version.Version)
+	}
+	if !bytes.Equal(version.Genesis, c.Headers.genesis.hash) {
+		return ErrGenesisMismatch
+	}
+	if err := writeMessage(c.conn, cmdVerack, nil); err != nil {
+		return err
+	}
+	return c.readReply(cmdVerack, nil)
+}
+
+// readReply reads messages until one of the given command, and decodes
+// it into v. Other messages, such as inventory announcements, are ignored.
+func (c *LightClient) readReply(command string, v interface{}) error {
+	c.conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := readMessage(c.conn)
+		if err != nil {
+			return err
+		}
+		if msg.Command != command {
+			continue
+		}
+		if v == nil {
+			return nil
+		}
+		return msg.decode(v)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLightClient(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 4)
+	var blocks []*Block
+	for h := 0; h <= bc.Height(); h++ {
+		b, err := bc.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		blocks = append(blocks, b)
+	}
+	node, nodeChain := newTestNode(t, blocks...)
+
+	client, err := DialLightClient(node.Addr(), blocks[0].BlockHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	assert.Nil(t, client.Sync())
+	assert.Equal(t, 3, client.Headers.Height())
+
+	// new blocks are fetched by the next sync
+	spend := newTestSpend(t, nodeChain, coinbases[0], 9)
+	assert.Nil(t, node.SubmitTransaction(spend))
+	block := mineNodeBlock(t, node)
+	assert.Nil(t, client.Sync())
+	tip, height := client.Headers.Tip()
+	assert.Equal(t, block.Hash, tip)
+	assert.Equal(t, 4, height)
+
+	tx, err := client.FetchTransaction(block.Hash, spend.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, spend, tx)
+	_, err = client.FetchTransaction(block.Hash, coinbases[1].ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+}
+
+func TestLightClientRejectsOtherGenesis(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	node, _ := newTestNode(t, bc.CurrentBlock())
+
+	// the node disconnects clients of another chain
+	other := mineTestBlock(t, bc.CurrentBlock(), "other")
+	_, err := DialLightClient(node.Addr(), other.BlockHeader)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrInvalidTx   = errors.New("transaction is not valid")
+	ErrTxInMempool = errors.New("transaction already in the mempool")
+	ErrTxConflict  = errors.New("transaction spends an output already spent in the mempool")
+	ErrMempoolFull = errors.New("transaction fee rate is too low for the mempool")
+)
+
+// DefaultMempoolSize is the default limit of the size of the
+// transactions kept in a Mempool, in bytes
+const DefaultMempoolSize = 1 << 20
+
+// mempoolEntry is a transaction waiting in the mempool
+type mempoolEntry struct {
+	tx   *Transaction
+	fee  int // value of the inputs minus value of the outputs
+	size int // size of the serialized transaction
+}
+
+// feeRateAbove reports whether e pays a higher fee per byte than other
+func (e *mempoolEntry) feeRateAbove(other *mempoolEntry) bool {
+	return e.fee*other.size > other.fee*e.size
+}
+
+// Mempool keeps the valid transactions waiting to be mined.
+// Transactions are accepted only if they spend unspent outputs of
+// the main chain that no other transaction of the pool spends.
+// When the pool grows over its size limit, the transactions paying
+// the lowest fee rate are evicted. The pool follows the chain:
+// transactions included in a connected block leave the pool, and
+// the transactions of blocks disconnected by a reorganization
+// come back to it.
+type Mempool struct {
+	bc      *Blockchain
+	maxSize int
+
+	mu      sync.Mutex
+	entries map[string]*mempoolEntry // by transaction ID
+	spent   map[string]string        // outpoint key to ID of the spending transaction
+	size    int                      // total size of the entries
+}
+
+// NewMempool returns an empty mempool for the blockchain
+// holding at most maxSize bytes of transactions
+func NewMempool(bc *Blockchain, maxSize int) *Mempool {
+	mp := &Mempool{
+		bc:      bc,
+		maxSize: maxSize,
+		entries: make(map[string]*mempoolEntry),
+		spent:   make(map[string]string),
+	}
+	bc.OnReorg(mp.handleReorg)
+	bc.OnBlockConnected(mp.RemoveBlock)
+	return mp
+}
+
+// Add validates the transaction with Blockchain.VerifyTransaction
+// and adds it to the pool
+func (mp *Mempool) Add(tx *Transaction) error {
+	if err := CheckTransactionSanity(tx); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTx, err)
+	}
+	if tx.IsCoinbase() || !mp.bc.VerifyTransaction(tx) {
+		return ErrInvalidTx
+	}
+	fee, err := mp.bc.TransactionFee(tx)
+	if err != nil {
+		return err
+	}
+	entry := &mempoolEntry{tx: tx, fee: fee, size: len(tx.Serialize())}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	id := hex.EncodeToString(tx.ID)
+	if _, ok := mp.entries[id]; ok {
+		return ErrTxInMempool
+	}
+	for _, vin := range tx.Vin {
+		if spender, ok := mp.spent[outpointString(vin.Txid, vin.OutIdx)]; ok {
+			return fmt.Errorf("%w: %x:%d is spent by %s", ErrTxConflict, vin.Txid, vin.OutIdx, spender)
+		}
+	}
+
+	mp.insert(id, entry)
+	for mp.size > mp.maxSize {
+		evicted := mp.lowestFeeRate()
+		mp.remove(evicted)
+		if evicted == id {
+			return ErrMempoolFull
+		}
+	}
+	return nil
+}
+
+// Has reports whether the transaction is in the pool
+func (mp *Mempool) Has(ID []byte) bool {
+	_, ok := mp.Get(ID)
+	return ok
+}
+
+// Get returns the transaction of the pool with the given ID
+func (mp *Mempool) Get(ID []byte) (*Transaction, bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	entry, ok := mp.entries[hex.EncodeToString(ID)]
+	if !ok {
+		return nil, false
+	}
+	return entry.tx, true
+}
+
+// Count returns the number of transactions in the pool
+func (mp *Mempool) Count() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return len(mp.entries)
+}
+
+// Size returns the total size of the transactions in the pool
+func (mp *Mempool) Size() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.size
+}
+
+// Transactions returns the transactions of the pool,
+// from the highest to the lowest fee rate
+func (mp *Mempool) Transactions() []*Transaction {
+	var txs []*Transaction
+	for _, entry := range mp.sortedEntries() {
+		txs = append(txs, entry.tx)
+	}
+	return txs
+}
+
+// BlockTemplate returns the transactions a miner should include
+// in the next block: the transactions paying the highest fee rate
+// that are still valid on top of the current tip, up to
+// MaxBlockTxsSize bytes.
+// The coinbase transaction is not included.
+func (mp *Mempool) BlockTemplate() []*Transaction {
+	var txs []*Transaction
+	size := 0
+	for _, entry := range mp.sortedEntries() {
+		if size+entry.size > MaxBlockTxsSize {
+			continue
+		}
+		if !mp.bc.VerifyTransaction(entry.tx) {
+			continue
+		}
+		txs = append(txs, entry.tx)
+		size += entry.size
+	}
+	return txs
+}
+
+// RemoveBlock removes the transactions included in the block,
+// and the transactions spending the same outputs, from the pool.
+// It is called for every block connected to the main chain.
+func (mp *Mempool) RemoveBlock(block *Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, tx := range block.Transactions {
+		mp.remove(hex.EncodeToString(tx.ID))
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			if spender, ok := mp.spent[outpointString(vin.Txid, vin.OutIdx)]; ok {
+				mp.remove(spender)
+			}
+		}
+	}
+}
+
+// handleReorg puts back in the pool the transactions of the blocks
+// removed from the main chain. Those that are no longer valid are dropped.
+func (mp *Mempool) handleReorg(event ReorgEvent) {
+	for _, block := range event.Disconnected {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				mp.Add(tx)
+			}
+		}
+	}
+}
+
+func (mp *Mempool) sortedEntries() []*mempoolEntry {
+	mp.mu.Lock()
+	entries := make([]*mempoolEntry, 0, len(mp.entries))
+	for _, entry := range mp.entries {
+		entries = append(entries, entry)
+	}
+	mp.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.feeRateAbove(b) != b.feeRateAbove(a) {
+			return a.feeRateAbove(b)
+		}
+		// same fee rate, keep a stable order
+		return bytes.Compare(a.tx.ID, b.tx.ID) < 0
+	})
+	return entries
+}
+
+// lowestFeeRate returns the ID of the entry paying the lowest fee rate
+func (mp *Mempool) lowestFeeRate() string {
+	var lowestID string
+	var lowest *mempoolEntry
+	for id, entry := range mp.entries {
+		// on ties, the last one in the order of sortedEntries
+		if lowest == nil || lowest.feeRateAbove(entry) ||
+			(!entry.feeRateAbove(lowest) && id > lowestID) {
+			lowestID, lowest = id, entry
+		}
+	}
+	return lowestID
+}
+
+func (mp *Mempool) insert(id string, entry *mempoolEntry) {
+	mp.entries[id] = entry
+	mp.size += entry.size
+	for _, vin := range entry.tx.Vin {
+		mp.spent[outpointString(vin.Txid, vin.OutIdx)] = id
+	}
+}
+
+func (mp *Mempool) remove(id string) {
+	entry, ok := mp.entries[id]
+	if !ok {
+		return
+	}
+	delete(mp.entries, id)
+	mp.size -= entry.size
+	for _, vin := range entry.tx.Vin {
+		delete(mp.spent, outpointString(vin.Txid, vin.OutIdx))
+	}
+}
+
+// outpointString identifies a transaction output as txid:outIdx
+func outpointString(txID []byte, outIdx int) string {
+	return fmt.Sprintf("%x:%d", txID, outIdx)
+}
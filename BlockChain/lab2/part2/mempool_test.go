@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMempoolTestChain returns a chain whose blocks pay their coinbase
+// to the first test user, and the coinbase transactions of the blocks
+func newMempoolTestChain(t *testing.T, blocks int) (*Blockchain, []*Transaction) {
+	bc, err := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbases := []*Transaction{bc.CurrentBlock().Transactions[0]}
+	for i := 1; i < blocks; i++ {
+		b := mineTestBlock(t, bc.CurrentBlock(), string(rune('a'+i)))
+		mustStoreBlock(t, bc, b)
+		coinbases = append(coinbases, b.Transactions[0])
+	}
+	return bc, coinbases
+}
+
+// newTestSpend returns a transaction of the first test user spending the
+// first output of prev and sending value to Leander. The rest is the fee.
+func newTestSpend(t *testing.T, bc *Blockchain, prev *Transaction, value int) *Transaction {
+	tx := &Transaction{
+		Vin: []TXInput{{
+			Txid:   prev.ID,
+			OutIdx: 0,
+			PubKey: Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
+		}},
+		Vout: []TXOutput{{Value: value, PubKeyHash: Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")}},
+	}
+	tx.ID = tx.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	if err := bc.SignTransaction(tx, *privKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestMempoolAdd(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 2)
+	mp := NewMempool(bc, DefaultMempoolSize)
+
+	tx := newTestSpend(t, bc, coinbases[0], 9)
+	assert.Nil(t, mp.Add(tx))
+	assert.True(t, mp.Has(tx.ID))
+	assert.Equal(t, 1, mp.Count())
+	assert.Equal(t, len(tx.Serialize()), mp.Size())
+	assert.ErrorIs(t, mp.Add(tx), ErrTxInMempool)
+
+	// double spend of the same outpoint
+	conflict := newTestSpend(t, bc, coinbases[0], 8)
+	assert.ErrorIs(t, mp.Add(conflict), ErrTxConflict)
+	assert.False(t, mp.Has(conflict.ID))
+
+	unsigned := newTestSpend(t, bc, coinbases[1], 9)
+	unsigned.Vin[0].Signature = nil
+	assert.ErrorIs(t, mp.Add(unsigned), ErrInvalidTx)
+
+	// inputs must be unspent outputs of the main chain
+	unconfirmed := &Transaction{
+		Vin:  []TXInput{{Txid: tx.ID, OutIdx: 0, PubKey: tx.Vin[0].PubKey}},
+		Vout: []TXOutput{{Value: 8, PubKeyHash: tx.Vout[0].PubKeyHash}},
+	}
+	unconfirmed.ID = unconfirmed.Hash()
+	assert.ErrorIs(t, mp.Add(unconfirmed), ErrInvalidTx)
+	assert.ErrorIs(t, mp.Add(coinbases[1]), ErrInvalidTx)
+	assert.ErrorIs(t, mp.Add(&Transaction{Vout: unsigned.Vout}), ErrInvalidTx)
+	assert.Equal(t, 1, mp.Count())
+}
+
+func TestMempoolFeeOrdering(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	mp := NewMempool(bc, DefaultMempoolSize)
+
+	lowFee := newTestSpend(t, bc, coinbases[0], 9)
+	highFee := newTestSpend(t, bc, coinbases[1], 5)
+	midFee := newTestSpend(t, bc, coinbases[2], 7)
+	for _, tx := range []*Transaction{lowFee, highFee, midFee} {
+		assert.Nil(t, mp.Add(tx))
+	}
+
+	expected := [][]byte{highFee.ID, midFee.ID, lowFee.ID}
+	assert.Equal(t, expected, transactionIDs(mp.Transactions()))
+	assert.Equal(t, expected, transactionIDs(mp.BlockTemplate()))
+}
+
+func TestMempoolEviction(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 4)
+	lowFee := newTestSpend(t, bc, coinbases[0], 9)
+	highFee := newTestSpend(t, bc, coinbases[1], 5)
+	midFee := newTestSpend(t, bc, coinbases[2], 7)
+
+	// room for two transactions, DER signatures do not all have the same size
+	maxSize := 0
+	for _, tx := range []*Transaction{lowFee, highFee, midFee} {
+		if size := len(tx.Serialize()); size > maxSize {
+			maxSize = size
+		}
+	}
+	mp := NewMempool(bc, 2*maxSize)
+	assert.Nil(t, mp.Add(lowFee))
+	assert.Nil(t, mp.Add(highFee))
+	assert.Nil(t, mp.Add(midFee))
+	assert.False(t, mp.Has(lowFee.ID), "lowest fee rate should be evicted")
+	assert.Equal(t, [][]byte{highFee.ID, midFee.ID}, transactionIDs(mp.Transactions()))
+
+	// paying less than the pool
+	assert.ErrorIs(t, mp.Add(newTestSpend(t, bc, coinbases[3], 10)), ErrMempoolFull)
+	assert.Equal(t, 2, mp.Count())
+}
+
+func TestMempoolFollowsChain(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	mp := NewMempool(bc, DefaultMempoolSize)
+	fork := bc.CurrentBlock()
+
+	mined := newTestSpend(t, bc, coinbases[0], 9)
+	pending := newTestSpend(t, bc, coinbases[1], 9)
+	assert.Nil(t, mp.Add(mined))
+	assert.Nil(t, mp.Add(pending))
+
+	// a block spending the same output as pending
+	replaced := newTestSpend(t, bc, coinbases[1], 8)
+	a1 := mineTestBlock(t, fork, "a1", mined, replaced)
+	mustStoreBlock(t, bc, a1)
+	assert.Equal(t, 0, mp.Count(), "mined and conflicting transactions should leave the pool")
+
+	// a heavier branch without those transactions brings them back
+	b1 := mineTestBlock(t, fork, "b1")
+	b2 := mineTestBlock(t, b1, "b2")
+	mustStoreBlock(t, bc, b1)
+	mustStoreBlock(t, bc, b2)
+	assert.Equal(t, b2.Hash, bc.CurrentBlock().Hash)
+	assert.True(t, mp.Has(mined.ID))
+	assert.True(t, mp.Has(replaced.ID))
+	assert.False(t, mp.Has(pending.ID))
+}
+
+func transactionIDs(txs []*Transaction) [][]byte {
+	var ids [][]byte
+	for _, tx := range txs {
+		ids = append(ids, tx.ID)
+	}
+	return ids
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MiningStats reports the work done to mine a block
+type MiningStats struct {
+	Hashes   uint64        // number of block headers hashed
+	Duration time.Duration // time spent mining
+}
+
+// HashRate returns the number of headers hashed per second
+func (s MiningStats) HashRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+// MineContext mines the block on the given number of goroutines, or on
+// every core if workers is not positive. Each worker tries its own share
+// of the nonces. When they are all tried, the timestamp is increased and
+// the search starts again. Mining stops with the error of the context
+// when it is done, leaving the block unmined.
+func (b *Block) MineContext(ctx context.Context, workers int) (MiningStats, error) {
+	if workers <= 0 {
+		workers = MinerWorkers
+	}
+	b.prepareHeader()
+
+	var stats MiningStats
+	start := time.Now()
+	for {
+		nonce, hash := NewProofOfWork(b).searchParallel(ctx, workers, &stats.Hashes)
+		stats.Duration = time.Since(start)
+		if hash != nil {
+			b.Nonce = nonce
+			b.Hash = hash
+			return stats, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		b.Timestamp++
+	}
+}
+
+// searchParallel shares the nonce space between the workers, see search.
+// Nonces 0 and 1 are not tried, they are not valid, see ValidatePoW.
+func (pow *ProofOfWork) searchParallel(ctx context.Context, workers int, hashes *uint64) (int, []byte) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	header := pow.setupHeader()
+
+	type result struct {
+		nonce int
+		hash  []byte
+	}
+	found := make(chan result, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			if nonce, hash := pow.search(ctx, header, start, workers, hashes); hash != nil {
+				found <- result{nonce, hash}
+				// the other workers can stop
+				cancel()
+			}
+		}(2 + w)
+	}
+	wg.Wait()
+
+	select {
+	case r := <-found:
+		return r.nonce, r.hash
+	default:
+		return 0, nil
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setMaxNonce changes the end of the nonce space for the duration of the test
+func setMaxNonce(t *testing.T, nonce int) {
+	old := maxNonce
+	maxNonce = nonce
+	t.Cleanup(func() { maxNonce = old })
+}
+
+func TestMineContext(t *testing.T) {
+	for _, workers := range []int{0, 1, 4} {
+		b := NewBlock(TestBlockTime, testBlockchainData["block1"].Transactions, testBlockchainData["block0"].Hash)
+		// 16 leading zero bits
+		b.Bits = BigToCompact(new(big.Int).Rsh(powLimit, 8))
+		stats, err := b.MineContext(context.Background(), workers)
+		assert.Nil(t, err)
+		assert.True(t, b.ValidatePoW())
+		assert.Equal(t, b.BlockHeader.Hash(), b.Hash)
+		assert.Equal(t, b.HashTransactions(), b.MerkleRoot)
+		assert.NotZero(t, stats.Hashes)
+		assert.Greater(t, stats.HashRate(), 0.0)
+	}
+}
+
+func TestMineContextCancelled(t *testing.T) {
+	b := NewBlock(TestBlockTime, testBlockchainData["block1"].Transactions, testBlockchainData["block0"].Hash)
+	b.Bits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), 64))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	stats, err := b.MineContext(ctx, 2)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, b.Hash)
+	assert.NotZero(t, stats.Hashes)
+	assert.GreaterOrEqual(t, stats.Duration, 50*time.Millisecond)
+}
+
+func TestMineContextRollsTimestamp(t *testing.T) {
+	// the nonces 2 to 7 are not valid for this block, see TestRun
+	setMaxNonce(t, 8)
+	b := NewBlock(TestBlockTime, testBlockchainData["block0"].Transactions, nil)
+	stats, err := b.MineContext(context.Background(), 3)
+	assert.Nil(t, err)
+	assert.Greater(t, b.Timestamp, int64(TestBlockTime))
+	assert.Less(t, b.Nonce, 8)
+	assert.True(t, b.ValidatePoW())
+	assert.Equal(t, b.BlockHeader.Hash(), b.Hash)
+	assert.GreaterOrEqual(t, stats.Hashes, uint64(6))
+}
+
+func TestMiningStatsHashRate(t *testing.T) {
+	assert.Equal(t, 0.0, MiningStats{}.HashRate())
+	assert.Equal(t, 500.0, MiningStats{Hashes: 1000, Duration: 2 * time.Second}.HashRate())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Mnemonic seeds, as in BIP39
+// https://github.com/bitcoin/bips/blob/master/bip-0039.mediawiki
+//
+// A mnemonic encodes 128 to 256 bits of entropy, followed by the first
+// bits of its sha256 as a checksum, as a list of words: each word is 11
+// bits, the index of the word in the English word list. The seed of the
+// HD keys, see NewMasterKey, is derived from the mnemonic and an optional
+// passphrase with PBKDF2.
+
+//go:embed wordlists/english.txt
+var englishWords string
+
+var (
+	wordList  = strings.Fields(englishWords)
+	wordIndex = make(map[string]int, len(wordList))
+)
+
+func init() {
+	for i, word := range wordList {
+		wordIndex[word] = i
+	}
+}
+
+// DefaultEntropyBits is the entropy of the mnemonics of NewMnemonic,
+// encoded in 12 words
+const DefaultEntropyBits = 128
+
+var ErrInvalidMnemonic = errors.New("invalid mnemonic")
+
+// NewMnemonic returns a random mnemonic of the given entropy, a multiple
+// of 32 bits between 128 and 256
+func NewMnemonic(bits int) (string, error) {
+	if bits%32 != 0 || bits < 128 || bits > 256 {
+		return "", fmt.Errorf("%w: %d bits of entropy", ErrInvalidMnemonic, bits)
+	}
+	entropy := make([]byte, bits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// entropyToMnemonic returns the words encoding the entropy and its checksum
+func entropyToMnemonic(entropy []byte) string {
+	hash := sha256.Sum256(entropy)
+	checksumBits := len(entropy) / 4
+	data := new(big.Int).SetBytes(entropy)
+	data.Lsh(data, uint(checksumBits))
+	data.Or(data, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+
+	words := make([]string, (len(entropy)*8+checksumBits)/11)
+	mask := big.NewInt(2047)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = wordList[new(big.Int).And(data, mask).Int64()]
+		data.Rsh(data, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// mnemonicToEntropy decodes a mnemonic, checking its words and checksum
+func mnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words)%3 != 0 || len(words) < 12 || len(words) > 24 {
+		return nil, fmt.Errorf("%w: %d words", ErrInvalidMnemonic, len(words))
+	}
+	data := new(big.Int)
+	for _, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown word %q", ErrInvalidMnemonic, word)
+		}
+		data.Lsh(data, 11)
+		data.Or(data, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) / 3
+	checksum := new(big.Int).And(data, big.NewInt(1<<checksumBits-1)).Int64()
+	entropy := make([]byte, (len(words)*11-checksumBits)/8)
+	data.Rsh(data, uint(checksumBits)).FillBytes(entropy)
+	hash := sha256.Sum256(entropy)
+	if int64(hash[0]>>(8-checksumBits)) != checksum {
+		return nil, fmt.Errorf("%w: wrong checksum", ErrInvalidMnemonic)
+	}
+	return entropy, nil
+}
+
+// ValidateMnemonic reports whether the mnemonic has known words
+// and a valid checksum
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := mnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// MnemonicToSeed returns the seed of the mnemonic and the passphrase,
+// which may be empty
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := mnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotMultisig = errors.New("input does not spend a multisig address")
+	ErrNotCosigner = errors.New("key is not a key of the multisig address")
+	ErrTxMismatch  = errors.New("transactions are not the same unsigned transaction")
+)
+
+// MultisigAddress is an address shared by n public keys, of which m must
+// sign to spend its outputs. Its redeem script is the MultisigScript of
+// the keys and its outputs are locked with the P2SHScript of the hash of
+// the redeem script.
+//
+// The inputs spending it carry the signatures collected so far, in the
+// order of the keys, followed by the redeem script. Each co-signer adds
+// its signature with Transaction.SignMultisig, or the signatures of
+// copies of the transaction are merged with Transaction.CombineSignatures.
+// The input is valid once m signatures are collected.
+type MultisigAddress struct {
+	M            int
+	PubKeys      [][]byte
+	RedeemScript []byte
+}
+
+// NewMultisigAddress returns the address shared by the public keys,
+// m of which must sign to spend its outputs
+func NewMultisigAddress(m int, pubKeys [][]byte) (*MultisigAddress, error) {
+	script, err := MultisigScript(m, pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &MultisigAddress{M: m, PubKeys: pubKeys, RedeemScript: script}, nil
+}
+
+// ParseMultisigAddress returns the MultisigAddress of a redeem script
+// built by MultisigScript
+func ParseMultisigAddress(redeemScript []byte) (*MultisigAddress, error) {
+	ops, err := parseScript(redeemScript)
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) < 4 || ops[len(ops)-1].code != OP_CHECKMULTISIG {
+		return nil, fmt.Errorf("%w: not a multisig script", ErrScriptMalformed)
+	}
+	m, err := opInt(ops[0])
+	if err != nil {
+		return nil, err
+	}
+	var pubKeys [][]byte
+	for _, op := range ops[1 : len(ops)-2] {
+		pubKeys = append(pubKeys, op.data)
+	}
+	ms, err := NewMultisigAddress(int(m), pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	// the keys and n must be encoded as MultisigScript does
+	if !bytes.Equal(ms.RedeemScript, redeemScript) {
+		return nil, fmt.Errorf("%w: not a multisig script", ErrScriptMalformed)
+	}
+	return ms, nil
+}
+
+// opInt returns the number pushed by an opcode
+func opInt(op scriptOp) (int64, error) {
+	if op.code >= OP_1 && op.code <= OP_16 {
+		return int64(op.code-OP_1) + 1, nil
+	}
+	if !isPush(op.code) {
+		return 0, fmt.Errorf("%w: %#02x is not a push", ErrBadScriptNumber, op.code)
+	}
+	return parseScriptNum(op.data, maxScriptNumSize)
+}
+
+// ScriptHash returns the hash of the redeem script,
+// which the outputs of the address are locked with
+func (ms *MultisigAddress) ScriptHash() []byte {
+	return HashPubKey(ms.RedeemScript)
+}
+
+// Address returns the address of the redeem script,
+// with the scriptHashVersion
+func (ms *MultisigAddress) Address() string {
+	return string(encodeAddress(scriptHashVersion, ms.ScriptHash()))
+}
+
+// NewTransaction creates a transaction sending amount from the multisig
+// address to the address and leaving fee to the miner. The change goes
+// back to the multisig address.
+// NOTE: The returned tx is NOT signed, see Transaction.SignMultisig
+func (ms *MultisigAddress) NewTransaction(to string, amount, fee int, utxos UTXOFinder) (*Transaction, error) {
+	input := TXInput{ScriptSig: NewScriptBuilder().AddData(ms.RedeemScript).Script()}
+	change := TXOutput{Script: P2SHScript(ms.ScriptHash())}
+	return newSpendingTransaction(ms.ScriptHash(), input, change, to, amount, fee, utxos)
+}
+
+// multisigInput returns the multisig address of the input idx, spending
+// prevOut, and the signatures collected so far
+func (tx *Transaction) multisigInput(idx int, prevOut TXOutput) (*MultisigAddress, [][]byte, error) {
+	ops, err := parseScript(tx.Vin[idx].ScriptSig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ops) == 0 {
+		return nil, nil, fmt.Errorf("%w: input %d", ErrNotMultisig, idx)
+	}
+	for _, op := range ops {
+		if !isPush(op.code) {
+			return nil, nil, ErrNotPushOnly
+		}
+	}
+	ms, err := ParseMultisigAddress(ops[len(ops)-1].data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: input %d: %v", ErrNotMultisig, idx, err)
+	}
+	hash, ok := p2shHash(prevOut.Script)
+	if !ok || !bytes.Equal(hash, ms.ScriptHash()) {
+		return nil, nil, fmt.Errorf("%w: input %d spends %v", ErrNotMultisig, idx, prevOut)
+	}
+	var signatures [][]byte
+	for _, op := range ops[:len(ops)-1] {
+		if len(op.data) > 0 {
+			signatures = append(signatures, op.data)
+		}
+	}
+	return ms, signatures, nil
+}
+
+// setMultisigSignatures sets the unlocking script of the input idx,
+// spending prevOut locked to the multisig address, from the signatures.
+// Each signature is matched with the key it is valid for, the ones valid
+// for no key are dropped, and at most m are kept in the order of the keys.
+func (tx *Transaction) setMultisigSignatures(idx int, prevOut TXOutput, ms *MultisigAddress, signatures [][]byte) {
+	digest := tx.SignatureHash(idx, prevOut)
+	byKey := make([][]byte, len(ms.PubKeys))
+	for _, signature := range signatures {
+		for k, pubKey := range ms.PubKeys {
+			if byKey[k] == nil && verifyDigest(pubKey, digest, signature) {
+				byKey[k] = signature
+				break
+			}
+		}
+	}
+
+	b := NewScriptBuilder()
+	n := 0
+	for _, signature := range byKey {
+		if signature != nil && n < ms.M {
+			b.AddData(signature)
+			n++
+		}
+	}
+	tx.Vin[idx].ScriptSig = b.AddData(ms.RedeemScript).Script()
+}
+
+// prevOutput returns the output spent by the input idx
+func (tx *Transaction) prevOutput(idx int, prevTXs map[string]*Transaction) (TXOutput, error) {
+	vin := tx.Vin[idx]
+	prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+	if !ok || vin.OutIdx < 0 || vin.OutIdx >= len(prevTx.Vout) {
+		return TXOutput{}, ErrTxInputNotFound
+	}
+	return prevTx.Vout[vin.OutIdx], nil
+}
+
+// SignMultisig adds the signature of privKey to the inputs of the
+// transaction spending the outputs of a MultisigAddress it is a key of.
+// The other inputs are left untouched.
+func (tx *Transaction) SignMultisig(privKey ecdsa.PrivateKey, prevTXs map[string]*Transaction) error {
+	pubKey := pubKeyToByte(privKey.PublicKey)
+	signed := 0
+	for idx, vin := range tx.Vin {
+		if len(vin.ScriptSig) == 0 {
+			continue
+		}
+		prevOut, err := tx.prevOutput(idx, prevTXs)
+		if err != nil {
+			return err
+		}
+		ms, signatures, err := tx.multisigInput(idx, prevOut)
+		if errors.Is(err, ErrNotMultisig) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !ms.hasKey(pubKey) {
+			continue
+		}
+
+		signature, err := tx.SignInput(idx, prevOut, privKey)
+		if err != nil {
+			return err
+		}
+		tx.setMultisigSignatures(idx, prevOut, ms, append(signatures, signature))
+		signed++
+	}
+	if signed == 0 {
+		return ErrNotCosigner
+	}
+	return nil
+}
+
+func (ms *MultisigAddress) hasKey(pubKey []byte) bool {
+	for _, key := range ms.PubKeys {
+		if bytes.Equal(key, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// CombineSignatures merges into the multisig inputs of the transaction
+// the signatures of other, a copy of it signed by other co-signers
+func (tx *Transaction) CombineSignatures(other *Transaction, prevTXs map[string]*Transaction) error {
+	if !bytes.Equal(tx.TrimmedCopy().Serialize(), other.TrimmedCopy().Serialize()) {
+		return ErrTxMismatch
+	}
+	for idx, vin := range tx.Vin {
+		if len(vin.ScriptSig) == 0 {
+			continue
+		}
+		prevOut, err := tx.prevOutput(idx, prevTXs)
+		if err != nil {
+			return err
+		}
+		ms, signatures, err := tx.multisigInput(idx, prevOut)
+		if errors.Is(err, ErrNotMultisig) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, others, err := other.multisigInput(idx, prevOut)
+		if err != nil {
+			return err
+		}
+		tx.setMultisigSignatures(idx, prevOut, ms, append(signatures, others...))
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestMultisig returns a m-of-n MultisigAddress of new keys
+func newTestMultisig(t *testing.T, m, n int) (*MultisigAddress, []ecdsa.PrivateKey) {
+	var keys []ecdsa.PrivateKey
+	var pubKeys [][]byte
+	for i := 0; i < n; i++ {
+		key, pubKey := newKeyPair()
+		keys = append(keys, key)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	ms, err := NewMultisigAddress(m, pubKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ms, keys
+}
+
+// copyTransaction returns a deep copy of the transaction,
+// as received by another co-signer
+func copyTransaction(t *testing.T, tx *Transaction) *Transaction {
+	copyTx, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return copyTx
+}
+
+func TestMultisigAddress(t *testing.T) {
+	ms, _ := newTestMultisig(t, 2, 3)
+
+	address := ms.Address()
+	assert.True(t, strings.HasPrefix(address, "3"), address)
+	assert.True(t, ValidateAddress(address))
+	version, hash, err := DecodeAddress(address)
+	assert.Nil(t, err)
+	assert.Equal(t, scriptHashVersion, version)
+	assert.Equal(t, ms.ScriptHash(), hash)
+	assert.Equal(t, ms.ScriptHash(), GetPubKeyHashFromAddress(address))
+
+	// outputs sent to the address are locked with the hash of the redeem script
+	out := TXOutput{Value: 1}
+	out.Lock(address)
+	assert.Nil(t, out.PubKeyHash)
+	assert.Equal(t, P2SHScript(ms.ScriptHash()), out.Script)
+	assert.Equal(t, ms.ScriptHash(), out.LockHash())
+	assert.Equal(t, ms.ScriptHash(), (&TXOutput{Script: ms.RedeemScript}).LockHash())
+	disasm, _ := DisasmScript(out.Script)
+	assert.Equal(t, "OP_HASH160 "+hex.EncodeToString(ms.ScriptHash())+" OP_EQUAL", disasm)
+
+	parsed, err := ParseMultisigAddress(ms.RedeemScript)
+	assert.Nil(t, err)
+	assert.Equal(t, ms, parsed)
+	_, err = ParseMultisigAddress(P2PKHScript(ms.ScriptHash()))
+	assert.ErrorIs(t, err, ErrScriptMalformed)
+	_, err = NewMultisigAddress(4, ms.PubKeys)
+	assert.ErrorIs(t, err, ErrTooManyKeys)
+
+	// single key addresses keep their version and lock PubKeyHash
+	out = TXOutput{Value: 1}
+	out.Lock(testMinerAddress)
+	assert.Nil(t, out.Script)
+	assert.Equal(t, Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38"), out.PubKeyHash)
+}
+
+func TestP2SHScript(t *testing.T) {
+	ms, keys := newTestMultisig(t, 1, 2)
+	prevOut := TXOutput{Value: 10, Script: P2SHScript(ms.ScriptHash())}
+	prev := &Transaction{ID: Hex2Bytes("01"), Vout: []TXOutput{prevOut}}
+
+	spend := func(redeemScript []byte, signer int) *Transaction {
+		return newScriptInput(prev, 0, func(tx *Transaction) []byte {
+			return NewScriptBuilder().AddData(mustSignInput(t, tx, prevOut, keys[signer])).
+				AddData(redeemScript).Script()
+		})
+	}
+
+	assert.Nil(t, spend(ms.RedeemScript, 0).VerifyInputScript(0, prevOut))
+	assert.Nil(t, spend(ms.RedeemScript, 1).VerifyInputScript(0, prevOut))
+
+	// the redeem script must have the hash of the output, and succeed
+	other, otherKeys := newTestMultisig(t, 1, 2)
+	keys = append(keys, otherKeys...)
+	assert.ErrorIs(t, spend(other.RedeemScript, 2).VerifyInputScript(0, prevOut), ErrScriptFalse)
+	otherOut := TXOutput{Value: 10, Script: P2SHScript(other.ScriptHash())}
+	assert.ErrorIs(t, spend(other.RedeemScript, 0).VerifyInputScript(0, otherOut), ErrBadSignature)
+	withoutRedeem := newScriptInput(prev, 0, func(tx *Transaction) []byte { return NewScriptBuilder().AddOp(OP_0).Script() })
+	assert.ErrorIs(t, withoutRedeem.VerifyInputScript(0, prevOut), ErrScriptFalse)
+}
+
+func TestMultisigSignatures(t *testing.T) {
+	ms, keys := newTestMultisig(t, 2, 3)
+	prevOut := TXOutput{Value: 10, Script: P2SHScript(ms.ScriptHash())}
+	prev := &Transaction{ID: Hex2Bytes("01"), Vout: []TXOutput{prevOut}}
+	prevTXs := map[string]*Transaction{"01": prev}
+	unsigned := newScriptInput(prev, 0, func(tx *Transaction) []byte {
+		return NewScriptBuilder().AddData(ms.RedeemScript).Script()
+	})
+	signatures := func(tx *Transaction) [][]byte {
+		_, signatures, err := tx.multisigInput(0, prevOut)
+		assert.Nil(t, err)
+		return signatures
+	}
+
+	// partial signatures are not enough
+	tx := copyTransaction(t, unsigned)
+	assert.ErrorIs(t, tx.VerifyInputScript(0, prevOut), ErrStackUnderflow)
+	assert.Nil(t, tx.SignMultisig(keys[2], prevTXs))
+	assert.Len(t, signatures(tx), 1)
+	assert.ErrorIs(t, tx.VerifyInputScript(0, prevOut), ErrStackUnderflow)
+	assert.False(t, tx.Verify(prevTXs))
+
+	// signing again with the same key changes nothing
+	assert.Nil(t, tx.SignMultisig(keys[2], prevTXs))
+	assert.Len(t, signatures(tx), 1)
+
+	// the signatures are kept in the order of the keys
+	assert.Nil(t, tx.SignMultisig(keys[0], prevTXs))
+	assert.Len(t, signatures(tx), 2)
+	assert.Nil(t, tx.VerifyInputScript(0, prevOut))
+	assert.True(t, tx.Verify(prevTXs))
+
+	// and no more than m are kept
+	assert.Nil(t, tx.SignMultisig(keys[1], prevTXs))
+	assert.Len(t, signatures(tx), 2)
+	assert.Nil(t, tx.VerifyInputScript(0, prevOut))
+
+	outsider, _ := newKeyPair()
+	assert.ErrorIs(t, copyTransaction(t, unsigned).SignMultisig(outsider, prevTXs), ErrNotCosigner)
+	assert.ErrorIs(t, copyTransaction(t, unsigned).SignMultisig(keys[0], nil), ErrTxInputNotFound)
+}
+
+func TestCombineSignatures(t *testing.T) {
+	ms, keys := newTestMultisig(t, 2, 3)
+	prevOut := TXOutput{Value: 10, Script: P2SHScript(ms.ScriptHash())}
+	prev := &Transaction{ID: Hex2Bytes("01"), Vout: []TXOutput{prevOut}}
+	prevTXs := map[string]*Transaction{"01": prev}
+	unsigned := newScriptInput(prev, 0, func(tx *Transaction) []byte {
+		return NewScriptBuilder().AddData(ms.RedeemScript).Script()
+	})
+
+	// each co-signer signs its own copy
+	first := copyTransaction(t, unsigned)
+	assert.Nil(t, first.SignMultisig(keys[2], prevTXs))
+	second := copyTransaction(t, unsigned)
+	assert.Nil(t, second.SignMultisig(keys[1], prevTXs))
+
+	combined := copyTransaction(t, first)
+	assert.Nil(t, combined.CombineSignatures(second, prevTXs))
+	assert.Nil(t, combined.VerifyInputScript(0, prevOut))
+
+	// combining is symmetric and ignores the signatures already present
+	reversed := copyTransaction(t, second)
+	assert.Nil(t, reversed.CombineSignatures(first, prevTXs))
+	assert.Equal(t, combined.Vin[0].ScriptSig, reversed.Vin[0].ScriptSig)
+	assert.Nil(t, reversed.CombineSignatures(combined, prevTXs))
+	assert.Equal(t, combined.Vin[0].ScriptSig, reversed.Vin[0].ScriptSig)
+
+	// with the unsigned transaction, nothing is added
+	alone := copyTransaction(t, first)
+	assert.Nil(t, alone.CombineSignatures(unsigned, prevTXs))
+	assert.Equal(t, first.Vin[0].ScriptSig, alone.Vin[0].ScriptSig)
+
+	// only copies of the same transaction can be combined
+	other := copyTransaction(t, second)
+	other.Vout[0].Value--
+	assert.ErrorIs(t, first.CombineSignatures(other, prevTXs), ErrTxMismatch)
+}
+
+func TestMultisigInChain(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 1)
+	ms, keys := newTestMultisig(t, 2, 3)
+
+	// the treasury is funded like any address
+	fund := TXOutput{Value: BlockReward}
+	fund.Lock(ms.Address())
+	pay := newScriptSpend(t, bc, coinbases[0], fund)
+	mustStoreBlock(t, bc, mineTestBlock(t, bc.CurrentBlock(), "fund", pay))
+	utxos := bc.UTXOIndex()
+	assert.Equal(t, BlockReward, getBalance(ms.Address(), utxos))
+
+	_, err := ms.NewTransaction(testMinerAddress, BlockReward, 1, utxos)
+	assert.ErrorIs(t, err, ErrNoFunds)
+	unsigned, err := ms.NewTransaction(testMinerAddress, 6, 1, utxos)
+	assert.Nil(t, err)
+	assert.Len(t, unsigned.Vout, 2)
+	assert.ErrorIs(t, bc.CheckTransaction(unsigned), ErrStackUnderflow)
+	assert.False(t, bc.VerifyTransaction(unsigned))
+
+	first := copyTransaction(t, unsigned)
+	assert.Nil(t, bc.SignMultisigTransaction(first, keys[0]))
+	assert.False(t, bc.VerifyTransaction(first))
+	second := copyTransaction(t, unsigned)
+	assert.Nil(t, bc.SignMultisigTransaction(second, keys[2]))
+	assert.False(t, bc.VerifyTransaction(second))
+
+	assert.Nil(t, bc.CombineSignatures(first, second))
+	assert.Nil(t, bc.CheckTransaction(first))
+	assert.True(t, bc.VerifyTransaction(first))
+	assert.Nil(t, NewMempool(bc, DefaultMempoolSize).Add(first))
+	before := getBalance(testMinerAddress, utxos)
+	block := mineTestBlock(t, bc.CurrentBlock(), "spend", first)
+	mustStoreBlock(t, bc, block)
+
+	// the change goes back to the treasury
+	assert.Equal(t, BlockReward-7, getBalance(ms.Address(), utxos))
+	assert.Equal(t, before+6+block.Transactions[0].Vout[0].Value, getBalance(testMinerAddress, utxos))
+}
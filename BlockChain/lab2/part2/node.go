@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxInvItems is the maximum number of hashes sent in reply to getblocks.
+// A node that is further behind asks again once it got them all.
+const maxInvItems = 500
+
+// maxHeaders is the maximum number of headers sent in reply to getheaders
+const maxHeaders = 2000
+
+// handshakeTimeout bounds the time a peer has to complete the handshake
+const handshakeTimeout = 10 * time.Second
+
+var (
+	ErrNodeClosed       = errors.New("node is closed")
+	ErrOldVersion       = errors.New("peer protocol version is too old")
+	ErrGenesisMismatch  = errors.New("peer has a different genesis block")
+	ErrHandshakeMissing = errors.New("message received before the handshake")
+	ErrStaleTip         = errors.New("a block from a peer changed the tip while mining")
+)
+
+// P2PNode connects a Blockchain to its peers over TCP.
+// Blocks and transactions received from a peer are validated
+// and announced to the other peers. A node that is behind a peer
+// downloads the missing blocks when it connects to it.
+type P2PNode struct {
+	// Logger receives the messages about peers and relayed data
+	Logger *log.Logger
+
+	bc       *Blockchain
+	mempool  *Mempool
+	listener net.Listener
+
+	mu     sync.Mutex
+	peers  map[*peer]bool
+	closed bool
+	wg     sync.WaitGroup
+
+	// tipChanged is closed, and replaced, when a block received
+	// from a peer changes the tip, to stop the mining in progress
+	tipChanged chan struct{}
+}
+
+// peer is a connection to another node
+type peer struct {
+	conn     net.Conn
+	outbound bool // whether we dialed the connection
+
+	writeMu sync.Mutex // serializes the messages written to conn
+
+	// written by the read loop only, under P2PNode.mu
+	version   *versionMsg // nil until the peer's version is received
+	handshake bool        // whether the peer acknowledged our version
+
+	// used by the read loop only
+	sentVersion bool
+	syncTo      []byte // last block asked by the download in progress
+}
+
+// NewP2PNode returns a node serving the given blockchain.
+// Transactions received from peers are added to the mempool.
+func NewP2PNode(bc *Blockchain, mempool *Mempool) *P2PNode {
+	return &P2PNode{
+		Logger:     log.New(os.Stderr, "node: ", log.LstdFlags),
+		bc:         bc,
+		mempool:    mempool,
+		peers:      make(map[*peer]bool),
+		tipChanged: make(chan struct{}),
+	}
+}
+
+// Listen accepts connections from other nodes on the given TCP address.
+// Use Addr to get the address when the port is chosen by the system.
+func (n *P2PNode) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		listener.Close()
+		return ErrNodeClosed
+	}
+	n.listener = listener
+	n.wg.Add(1)
+	n.mu.Unlock()
+
+	go func() {
+		defer n.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			n.addPeer(conn, false)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the node is listening on,
+// or an empty string if it is not listening
+func (n *P2PNode) Addr() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listener == nil {
+		return ""
+	}
+	return n.listener.Addr().String()
+}
+
+// Connect dials the node at addr and starts the handshake
+func (n *P2PNode) Connect(addr string) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = n.addPeer(conn, true)
+	return err
+}
+
+// Peers returns the addresses of the peers that completed the handshake
+func (n *P2PNode) Peers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var addrs []string
+	for p := range n.peers {
+		if p.version != nil && p.handshake {
+			addrs = append(addrs, p.version.AddrFrom)
+		}
+	}
+	return addrs
+}
+
+// SubmitTransaction adds the transaction to the mempool
+// and announces it to the peers
+func (n *P2PNode) SubmitTransaction(tx *Transaction) error {
+	if err := n.mempool.Add(tx); err != nil {
+		return err
+	}
+	n.announce(nil, invTx, tx.ID)
+	return nil
+}
+
+// MineBlock mines a block with the transactions of the mempool
+// block template, paying the reward and the fees to the given address,
+// and announces it to the peers
+func (n *P2PNode) MineBlock(address string) (*Block, error) {
+	block, _, err := n.MineBlockContext(context.Background(), address)
+	return block, err
+}
+
+// MineBlockContext is MineBlock, stopped when the context is done.
+// It also stops with ErrStaleTip when a block received from a peer
+// changes the tip: the block being mined would not extend it.
+func (n *P2PNode) MineBlockContext(ctx context.Context, address string) (*Block, MiningStats, error) {
+	n.mu.Lock()
+	tipChanged := n.tipChanged
+	n.mu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-tipChanged:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	txs := n.mempool.BlockTemplate()
+	fees, err := n.bc.TotalFees(txs)
+	if err != nil {
+		return nil, MiningStats{}, err
+	}
+	coinbase, err := NewCoinbaseTXWithFees(address, "", n.bc.Height()+1, fees)
+	if err != nil {
+		return nil, MiningStats{}, err
+	}
+	block, stats, err := n.bc.MineBlockContext(ctx, append([]*Transaction{coinbase}, txs...))
+	if err != nil {
+		select {
+		case <-tipChanged:
+			return nil, stats, ErrStaleTip
+		default:
+			return nil, stats, err
+		}
+	}
+	n.Logger.Printf("mined block %x at %.0f hashes/s", block.Hash, stats.HashRate())
+	n.announce(nil, invBlock, block.Hash)
+	return block, stats, nil
+}
+
+// Close disconnects all the peers and stops listening
+func (n *P2PNode) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	var err error
+	if n.listener != nil {
+		err = n.listener.Close()
+	}
+	for p := range n.peers {
+		p.conn.Close()
+	}
+	n.mu.Unlock()
+
+	n.wg.Wait()
+	return err
+}
+
+// addPeer registers the connection and starts its read loop
+func (n *P2PNode) addPeer(conn net.Conn, outbound bool) (*peer, error) {
+	p := &peer{conn: conn, outbound: outbound}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		conn.Close()
+		return nil, ErrNodeClosed
+	}
+	n.peers[p] = true
+	n.wg.Add(1)
+	go n.handlePeer(p)
+	return p, nil
+}
+
+// handlePeer reads and handles the messages of a peer
+// until the connection is closed or the peer misbehaves
+func (n *P2PNode) handlePeer(p *peer) {
+	defer n.wg.Done()
+	defer func() {
+		n.mu.Lock()
+		delete(n.peers, p)
+		n.mu.Unlock()
+		p.conn.Close()
+	}()
+
+	p.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	if p.outbound {
+		if err := n.sendVersion(p); err != nil {
+			n.Logger.Printf("sending version to %s: %v", p.conn.RemoteAddr(), err)
+			return
+		}
+	}
+	for {
+		msg, err := readMessage(p.conn)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				n.Logger.Printf("reading from %s: %v", p.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if err := n.handleMessage(p, msg); err != nil {
+			n.Logger.Printf("disconnecting %s: %s: %v", p.conn.RemoteAddr(), msg.Command, err)
+			return
+		}
+	}
+}
+
+// handleMessage handles a single message received from p.
+// An error disconnects the peer.
+func (n *P2PNode) handleMessage(p *peer, msg *message) error {
+	switch msg.Command {
+	case cmdVersion:
+		var payload versionMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleVersion(p, &payload)
+	case cmdVerack:
+		if p.version == nil {
+			return ErrHandshakeMissing
+		}
+		n.mu.Lock()
+		p.handshake = true
+		n.mu.Unlock()
+		p.conn.SetReadDeadline(time.Time{})
+		return nil
+	}
+
+	if p.version == nil || !p.handshake {
+		return ErrHandshakeMissing
+	}
+
+	switch msg.Command {
+	case cmdGetBlocks:
+		var payload getBlocksMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		hashes := n.bc.BlocksAfter(payload.Locator, maxInvItems)
+		if len(hashes) == 0 {
+			return nil
+		}
+		return p.send(cmdInv, &invMsg{Type: invBlock, Items: hashes})
+
+	case cmdGetHeaders:
+		var payload getBlocksMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleGetHeaders(p, &payload)
+
+	case cmdGetProof:
+		var payload getProofMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleGetProof(p, &payload)
+
+	case cmdInv:
+		var payload invMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleInv(p, &payload)
+
+	case cmdGetData:
+		var payload invMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		return n.handleGetData(p, &payload)
+
+	case cmdBlock:
+		var payload blockMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		block, err := DeserializeBlock(payload.Block)
+		if err != nil {
+			return err
+		}
+		return n.handleBlock(p, block)
+
+	case cmdTx:
+		var payload txMsg
+		if err := msg.decode(&payload); err != nil {
+			return err
+		}
+		tx, err := DeserializeTransaction(payload.Transaction)
+		if err != nil {
+			return err
+		}
+		if err := n.mempool.Add(tx); err != nil {
+			if !errors.Is(err, ErrTxInMempool) {
+				n.Logger.Printf("ignoring transaction %x from %s: %v", tx.ID, p.conn.RemoteAddr(), err)
+			}
+			return nil
+		}
+		n.announce(p, invTx, tx.ID)
+		return nil
+
+	default:
+		n.Logger.Printf("ignoring unknown command %q from %s", msg.Command, p.conn.RemoteAddr())
+		return nil
+	}
+}
+
+// handleVersion checks that the peer follows the same chain and
+// starts the block download if the peer is ahead of us
+func (n *P2PNode) handleVersion(p *peer, version *versionMsg) error {
+	if p.version != nil {
+		return errors.New("duplicate version message")
+	}
+	if version.Version < protocolVersion {
+		return fmt.Errorf("%w: %d", ErrOldVersion, version.Version)
+	}
+	if genesis := n.bc.GetGenesisBlock(); genesis == nil || !bytes.Equal(genesis.Hash, version.Genesis) {
+		return ErrGenesisMismatch
+	}
+	n.mu.Lock()
+	p.version = version
+	n.mu.Unlock()
+
+	if !p.sentVersion {
+		if err := n.sendVersion(p); err != nil {
+			return err
+		}
+	}
+	if err := p.send(cmdVerack, nil); err != nil {
+		return err
+	}
+
+	if version.Height > n.bc.Height() {
+		return n.syncWith(p)
+	}
+	return nil
+}
+
+// handleInv asks for the announced items that are still unknown
+func (n *P2PNode) handleInv(p *peer, inv *invMsg) error {
+	var missing [][]byte
+	for _, hash := range inv.Items {
+		switch inv.Type {
+		case invBlock:
+			if !n.bc.HasBlock(hash) {
+				missing = append(missing, hash)
+			}
+		case invTx:
+			if !n.mempool.Has(hash) {
+				missing = append(missing, hash)
+			}
+		default:
+			return fmt.Errorf("unknown inventory type %q", inv.Type)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if inv.Type == invBlock {
+		p.syncTo = missing[len(missing)-1]
+	}
+	return p.send(cmdGetData, &invMsg{Type: inv.Type, Items: missing})
+}
+
+// handleGetData sends the requested items that we know about
+func (n *P2PNode) handleGetData(p *peer, inv *invMsg) error {
+	for _, hash := range inv.Items {
+		switch inv.Type {
+		case invBlock:
+			block, err := n.bc.GetBlock(hash)
+			if err != nil {
+				continue
+			}
+			if err := p.send(cmdBlock, &blockMsg{Block: block.Serialize()}); err != nil {
+				return err
+			}
+		case invTx:
+			tx, ok := n.mempool.Get(hash)
+			if !ok {
+				continue
+			}
+			if err := p.send(cmdTx, &txMsg{Transaction: tx.Serialize()}); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown inventory type %q", inv.Type)
+		}
+	}
+	return nil
+}
+
+// handleGetHeaders sends the headers of the main chain blocks following
+// the locator. The reply is sent even if there are none, so a light
+// client knows it is synchronized.
+func (n *P2PNode) handleGetHeaders(p *peer, getHeaders *getBlocksMsg) error {
+	headers := [][]byte{}
+	for _, hash := range n.bc.BlocksAfter(getHeaders.Locator, maxHeaders) {
+		header, err := n.bc.GetBlockHeader(hash)
+		if err != nil {
+			break
+		}
+		headers = append(headers, header.Serialize())
+	}
+	return p.send(cmdHeaders, &headersMsg{Headers: headers})
+}
+
+// handleGetProof sends the requested transaction with the merkle proof
+// of its inclusion in the block, or an empty proof if it is not there
+func (n *P2PNode) handleGetProof(p *peer, getProof *getProofMsg) error {
+	reply := &proofMsg{Block: getProof.Block}
+	if block, err := n.bc.GetBlock(getProof.Block); err == nil {
+		if proof, err := block.MerkleProof(getProof.Transaction); err == nil {
+			tx, _ := block.FindTransaction(getProof.Transaction)
+			reply.Transaction = tx.Serialize()
+			reply.Hashes = proof.proof
+			reply.Index = proof.index
+		}
+	}
+	return p.send(cmdProof, reply)
+}
+
+// handleBlock validates and stores a block received from p.
+// A block whose parent is unknown makes us ask p for the
+// blocks we are missing. Invalid blocks are ignored.
+func (n *P2PNode) handleBlock(p *peer, block *Block) error {
+	if n.bc.HasBlock(block.Hash) {
+		return nil
+	}
+	if block.PrevBlockHash != nil && !n.bc.HasBlock(block.PrevBlockHash) {
+		return n.syncWith(p)
+	}
+	if err := n.bc.CheckBlock(block); err != nil {
+		n.Logger.Printf("ignoring invalid block %x from %s: %v", block.Hash, p.conn.RemoteAddr(), err)
+		return nil
+	}
+	oldTip, _ := n.bc.Tip()
+	if err := n.bc.storeBlock(block); err != nil {
+		if errors.Is(err, ErrBlockExists) {
+			return nil
+		}
+		n.Logger.Printf("ignoring block %x from %s: %v", block.Hash, p.conn.RemoteAddr(), err)
+		return nil
+	}
+	if tip, _ := n.bc.Tip(); !bytes.Equal(tip, oldTip) {
+		n.mu.Lock()
+		close(n.tipChanged)
+		n.tipChanged = make(chan struct{})
+		n.mu.Unlock()
+	}
+
+	n.announce(p, invBlock, block.Hash)
+
+	// the last block of a full inv was received, ask for the next ones
+	if bytes.Equal(block.Hash, p.syncTo) {
+		p.syncTo = nil
+		if p.version.Height > n.bc.Height() {
+			return n.syncWith(p)
+		}
+	}
+	return nil
+}
+
+// syncWith asks p for the blocks following our main chain
+func (n *P2PNode) syncWith(p *peer) error {
+	return p.send(cmdGetBlocks, &getBlocksMsg{Locator: n.bc.BlockLocator()})
+}
+
+func (n *P2PNode) sendVersion(p *peer) error {
+	genesis := n.bc.GetGenesisBlock()
+	if genesis == nil {
+		return ErrBlockNotFound
+	}
+	p.sentVersion = true
+	return p.send(cmdVersion, &versionMsg{
+		Version:  protocolVersion,
+		Height:   n.bc.Height(),
+		AddrFrom: n.Addr(),
+		Genesis:  genesis.Hash,
+	})
+}
+
+// announce sends an inv of the given item to every peer but from
+func (n *P2PNode) announce(from *peer, invType string, hash []byte) {
+	n.mu.Lock()
+	var peers []*peer
+	for p := range n.peers {
+		if p != from && p.handshake {
+			peers = append(peers, p)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, p := range peers {
+		if err := p.send(cmdInv, &invMsg{Type: invType, Items: [][]byte{hash}}); err != nil {
+			n.Logger.Printf("announcing %s %x to %s: %v", invType, hash, p.conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// send writes a message to the peer
+func (p *peer) send(command string, payload interface{}) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	return writeMessage(p.conn, command, payload)
+}
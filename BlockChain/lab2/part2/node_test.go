@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestNode starts a node listening on loopback whose chain
+// contains the given blocks, the first one being the genesis
+func newTestNode(t *testing.T, blocks ...*Block) (*P2PNode, *Blockchain) {
+	bc := &Blockchain{db: NewMemoryStorage(), height: -1}
+	for _, b := range blocks {
+		mustStoreBlock(t, bc, b)
+	}
+	node := NewP2PNode(bc, NewMempool(bc, DefaultMempoolSize))
+	node.Logger = log.New(io.Discard, "", 0)
+	if err := node.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+	return node, bc
+}
+
+// mineNodeBlock mines a block with the node
+func mineNodeBlock(t *testing.T, node *P2PNode) *Block {
+	block, err := node.MineBlock(testMinerAddress)
+	if err != nil {
+		t.Fatal("unable to mine a block: ", err)
+	}
+	return block
+}
+
+// waitFor fails the test if cond is not true within a few seconds
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting: " + msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNodeInitialBlockDownload(t *testing.T) {
+	genesis, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	blocks := []*Block{genesis.CurrentBlock()}
+	for i := 0; i < 5; i++ {
+		blocks = append(blocks, mineTestBlock(t, blocks[i], string(rune('a'+i))))
+	}
+
+	full, fullChain := newTestNode(t, blocks...)
+	empty, emptyChain := newTestNode(t, blocks[0])
+
+	assert.Nil(t, empty.Connect(full.Addr()))
+	waitFor(t, "blocks downloaded", func() bool {
+		return emptyChain.Height() == fullChain.Height()
+	})
+	assert.Equal(t, blocks[5].Hash, emptyChain.CurrentBlock().Hash)
+	diff(t, fullChain.UTXOIndex().Snapshot(), emptyChain.UTXOIndex().Snapshot(), "UTXO index differs after download")
+	assert.Equal(t, []string{full.Addr()}, empty.Peers())
+}
+
+func TestNodeBlockRelay(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+
+	// a <- b <- c
+	a, aChain := newTestNode(t, genesis)
+	b, bChain := newTestNode(t, genesis)
+	c, cChain := newTestNode(t, genesis)
+	assert.Nil(t, b.Connect(a.Addr()))
+	assert.Nil(t, c.Connect(b.Addr()))
+	waitFor(t, "handshakes", func() bool {
+		return len(a.Peers()) == 1 && len(b.Peers()) == 2 && len(c.Peers()) == 1
+	})
+
+	block := mineNodeBlock(t, a)
+	waitFor(t, "block relayed to c", func() bool {
+		return cChain.Height() == 1
+	})
+	assert.Equal(t, block.Hash, bChain.CurrentBlock().Hash)
+	assert.Equal(t, block.Hash, cChain.CurrentBlock().Hash)
+
+	// blocks mined at the end of the line reach the start
+	block = mineNodeBlock(t, c)
+	waitFor(t, "block relayed to a", func() bool {
+		return aChain.Height() == 2
+	})
+	assert.Equal(t, block.Hash, aChain.CurrentBlock().Hash)
+}
+
+func TestNodeTransactionRelay(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+
+	a, aChain := newTestNode(t, genesis)
+	b, bChain := newTestNode(t, genesis)
+	c, _ := newTestNode(t, genesis)
+	assert.Nil(t, b.Connect(a.Addr()))
+	assert.Nil(t, c.Connect(b.Addr()))
+	waitFor(t, "handshakes", func() bool {
+		return len(b.Peers()) == 2 && len(c.Peers()) == 1
+	})
+
+	spend := &Transaction{
+		Vin: []TXInput{{
+			Txid:   genesis.Transactions[0].ID,
+			OutIdx: 0,
+			PubKey: Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
+		}},
+		Vout: []TXOutput{{Value: BlockReward, PubKeyHash: Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")}},
+	}
+	spend.ID = spend.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	if err := bc.SignTransaction(spend, *privKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// transactions with an invalid signature are not relayed
+	invalid := *spend
+	invalid.Vin = []TXInput{spend.Vin[0]}
+	invalid.Vin[0].Signature = Hex2Bytes("0102")
+	assert.ErrorIs(t, c.SubmitTransaction(&invalid), ErrInvalidTx)
+
+	assert.Nil(t, c.SubmitTransaction(spend))
+	waitFor(t, "transaction relayed to a", func() bool {
+		return a.mempool.Has(spend.ID)
+	})
+	assert.ErrorIs(t, a.SubmitTransaction(spend), ErrTxInMempool)
+
+	// mined transactions leave the pools
+	block := mineNodeBlock(t, a)
+	assert.Len(t, block.Transactions, 2)
+	assert.Equal(t, 0, a.mempool.Count())
+	waitFor(t, "block relayed to b", func() bool {
+		return bChain.Height() == aChain.Height()
+	})
+	waitFor(t, "transaction removed from pools", func() bool {
+		return b.mempool.Count() == 0 && c.mempool.Count() == 0
+	})
+	_, ok := bChain.UTXOIndex().FindOutput(spend.ID, 0)
+	assert.True(t, ok)
+}
+
+func TestNodeRejectsOtherGenesis(t *testing.T) {
+	bc1, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	bc2, _ := NewBlockchain(NewMemoryStorage(), "1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX")
+
+	a, _ := newTestNode(t, bc1.CurrentBlock())
+	b, _ := newTestNode(t, bc2.CurrentBlock())
+	assert.Nil(t, b.Connect(a.Addr()))
+
+	waitFor(t, "peer disconnected", func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(a.peers) == 0 && len(b.peers) == 0
+	})
+	assert.Empty(t, a.Peers())
+	assert.Empty(t, b.Peers())
+}
+
+func TestNodeMiningStopsOnNewTip(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+	node, nodeChain := newTestNode(t, genesis)
+	competing := mineTestBlock(t, genesis, "competing")
+
+	// without nonces to try, the mining never ends
+	setMaxNonce(t, 2)
+	done := make(chan error)
+	go func() {
+		_, _, err := node.MineBlockContext(context.Background(), testMinerAddress)
+		done <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, node.handleBlock(&peer{}, competing))
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrStaleTip)
+	case <-time.After(5 * time.Second):
+		t.Fatal("mining was not stopped")
+	}
+	assert.Equal(t, 1, nodeChain.Height())
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"math"
 	"math/big"
+	"sync/atomic"
 )
 
+// maxNonce is the end of the nonce space, see Block.MineContext
 var maxNonce = math.MaxInt64
 
-// TARGETBITS define the mining difficulty
+// checkInterval is the number of nonces tried between two checks
+// of the cancellation of the mining
+const checkInterval = 1 << 12
+
+// TARGETBITS define the initial mining difficulty, the number of leading
+// zero bits of the genesis block hash. Blocks are never easier to mine,
+// see difficulty.go for the difficulty of the following blocks.
 const TARGETBITS = 8
 
 // ProofOfWork represents a block mined with a target difficulty
@@ -17,33 +27,22 @@ type ProofOfWork struct {
 	target *big.Int
 }
 
-// NewProofOfWork builds a ProofOfWork
+// NewProofOfWork builds a ProofOfWork for the difficulty
+// in the block header
 func NewProofOfWork(block *Block) *ProofOfWork {
-	targetVal := new(big.Int).Exp(big.NewInt(2), big.NewInt(256-TARGETBITS), nil)
 	return &ProofOfWork{
 		block:  block,
-		target: targetVal,
+		target: CompactToBig(block.Bits),
 	}
 }
 
 // setupHeader prepare the header of the block
 func (pow *ProofOfWork) setupHeader() []byte {
 	// TODO(student)
-	var data []byte
-	var allTrans [][]byte
-	tstamp := IntToHex(pow.block.Timestamp)
-	tbit := IntToHex(TARGETBITS)
-	for _, tran := range pow.block.Transactions {
-		allTrans = append(allTrans, tran.Serialize())
-	}
-	mt := NewMerkleTree(allTrans)
-	mtHash := mt.MerkleRootHash()
-	data = append(data, pow.block.PrevBlockHash...)
-	data = append(data, mtHash...)
-	data = append(data, tstamp...)
-	data = append(data, tbit...)
-
-	return data
+	// The header is the encoding of BlockHeader without its
+	// last field, the nonce, which is added by addNonce
+	data := pow.block.BlockHeader.Serialize()
+	return data[:len(data)-8]
 }
 
 // addNonce adds a nonce to the header
@@ -55,24 +54,63 @@ func addNonce(nonce int, header []byte) []byte {
 	return data
 }
 
-// Run performs the proof-of-work
+// Run performs the proof-of-work on the current goroutine,
+// see Block.MineContext to mine on every core. Nonces 0 and 1 are
+// not tried, they are not valid, see ValidatePoW.
 func (pow *ProofOfWork) Run() (int, []byte) {
 	// TODO(student)
-	header := pow.setupHeader()
-	var nonce int
-	for nonce = 1; nonce < maxNonce; nonce++ {
-		data := addNonce(nonce, header)
+	return pow.search(context.Background(), pow.setupHeader(), 2, 1, nil)
+}
+
+// search tries the nonces start, start+step, ... below maxNonce, and
+// returns the first one whose hash is below the target. It returns a zero
+// nonce when the nonce space is exhausted or the context is done. The
+// number of hashes computed is added to hashes, if not nil.
+func (pow *ProofOfWork) search(ctx context.Context, header []byte, start, step int, hashes *uint64) (int, []byte) {
+	// the nonce is written in place, at the end of the header
+	data := addNonce(0, header)
+	nonceBytes := data[len(header):]
+	z := new(big.Int)
+	var count uint64
+	defer func() {
+		if hashes != nil {
+			atomic.AddUint64(hashes, count)
+		}
+	}()
+
+	for nonce := start; nonce < maxNonce; nonce += step {
+		if count%checkInterval == 0 && ctx.Err() != nil {
+			return 0, nil
+		}
+		binary.BigEndian.PutUint64(nonceBytes, uint64(nonce))
 		hash := sha256.Sum256(data)
-		z := new(big.Int)
-		z.SetBytes(hash[:])
-		if z.Cmp(pow.target) <= 0 {
-			return int(nonce), hash[:]
+		count++
+		if z.SetBytes(hash[:]).Cmp(pow.target) <= 0 {
+			return nonce, hash[:]
+		}
+		if nonce > maxNonce-step {
+			break
 		}
 	}
-
 	return 0, nil
 }
 
+// ValidatePoW validates the proof of work of a header alone, without
+// the block body: its hash must be below the target of its difficulty.
+// Whether the difficulty follows the retarget schedule depends on the
+// previous headers, see nextBits.
+func (h BlockHeader) ValidatePoW() bool {
+	if h.Version != blockVersion || h.Nonce <= 1 {
+		return false
+	}
+	target := CompactToBig(h.Bits)
+	if target.Sign() <= 0 || target.Cmp(powLimit) > 0 {
+		return false
+	}
+	hash := new(big.Int).SetBytes(h.Hash())
+	return hash.Cmp(target) <= 0
+}
+
 // Validate validates block's Proof-Of-Work
 // This function just validates if the block header hash
 // is less than the target AND equals to the mined block hash.
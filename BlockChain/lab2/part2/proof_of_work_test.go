@@ -9,26 +9,34 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// newMockHeader returns the encoding of a header without its nonce,
+// see serialization.go
 func newMockHeader(prevBlockHash []byte, merkleRoot []byte) []byte {
 	return bytes.Join(
 		[][]byte{
+			{0, 0, 0, byte(blockVersion)},
+			{0, 0, 0, byte(len(prevBlockHash))},
 			prevBlockHash,
+			{0, 0, 0, byte(len(merkleRoot))},
 			merkleRoot,
 			IntToHex(TestBlockTime),
-			IntToHex(TARGETBITS),
+			{0x20, 0x01, 0, 0}, // InitialBits
 		},
 		[]byte{},
 	)
 }
 
-// TARGETBITS == 8 => target difficulty of 2^248
+// InitialBits == 0x20010000 => target difficulty of 2^248
 // Hexadecimal: 100000000000000000000000000000000000000000000000000000000000000
 // Big Int: 452312848583266388373324160190187140051835877600158453279131187530910662656
 var testTargetDifficulty, _ = new(big.Int).SetString("452312848583266388373324160190187140051835877600158453279131187530910662656", 10)
 
 func TestNewProofOfWork(t *testing.T) {
 	b := &Block{
-		Timestamp:    TestBlockTime,
+		BlockHeader: BlockHeader{
+			Timestamp: TestBlockTime,
+			Bits:      InitialBits,
+		},
 		Transactions: []*Transaction{testTransactions["tx0"]},
 	}
 
@@ -40,21 +48,18 @@ func TestNewProofOfWork(t *testing.T) {
 
 func TestSetupHeader(t *testing.T) {
 	pow := &ProofOfWork{
-		block: &Block{
-			Timestamp:    TestBlockTime,
-			Transactions: []*Transaction{testTransactions["tx0"]},
-		},
+		block:  NewGenesisBlock(TestBlockTime, testTransactions["tx0"]),
 		target: testTargetDifficulty,
 	}
 	header := pow.setupHeader()
 
-	expectedHeader := newMockHeader(nil, Hex2Bytes("fdfa9ad1db072757d55c11ba05aecae0bbd99e29b8dc2a869a68ebeb1ca09147"))
+	expectedHeader := newMockHeader(nil, Hex2Bytes("426b361af5b5c788b8b14b7c5ba11d42f385b45e3923a875f516ee55e1d9d830"))
 	assert.Equalf(t, expectedHeader, header, "The current block header: %x isn't equal to the expected %x\n", header, expectedHeader)
 }
 
 func TestAddNonce(t *testing.T) {
-	header := newMockHeader(nil, Hex2Bytes("fdfa9ad1db072757d55c11ba05aecae0bbd99e29b8dc2a869a68ebeb1ca09147"))
-	expectedHeader := Hex2Bytes("fdfa9ad1db072757d55c11ba05aecae0bbd99e29b8dc2a869a68ebeb1ca09147000000005d372e8c00000000000000080000000000000009")
+	header := newMockHeader(nil, Hex2Bytes("426b361af5b5c788b8b14b7c5ba11d42f385b45e3923a875f516ee55e1d9d830"))
+	expectedHeader := Hex2Bytes("00000001" + "00000000" + "00000020426b361af5b5c788b8b14b7c5ba11d42f385b45e3923a875f516ee55e1d9d830" + "000000005d372e8c" + "20010000" + "0000000000000009")
 
 	diff(t, expectedHeader, addNonce(9, header), "addNonce failed")
 }
@@ -62,11 +67,7 @@ func TestAddNonce(t *testing.T) {
 func TestRun(t *testing.T) {
 	for k, block := range testBlockchainData {
 		t.Run(k, func(t *testing.T) {
-			b := &Block{
-				Timestamp:     TestBlockTime,
-				Transactions:  block.Transactions,
-				PrevBlockHash: block.PrevBlockHash,
-			}
+			b := NewBlock(TestBlockTime, block.Transactions, block.PrevBlockHash)
 			pow := &ProofOfWork{b, testTargetDifficulty}
 			nonce, hash := pow.Run()
 			diff(t, testBlockchainData[k].Nonce, nonce, fmt.Sprintf("wrong nonce for %s", k))
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrBlockPruned   = errors.New("block body is pruned")
+	ErrPruneDepth    = errors.New("prune depth is below MinPruneDepth")
+	ErrPrunedTxIndex = errors.New("transaction indexes need the blocks of a chain that is not pruned")
+)
+
+// A pruned chain discards the bodies of the blocks of the main chain
+// deeper than a given depth below the tip, with their undo data, so its
+// storage does not grow with the transactions of the whole history.
+// It keeps their headers, to validate the difficulty and the timestamps
+// of the new blocks and to serve light clients, and the UTXO index,
+// to validate their transactions. The genesis block is always kept.
+//
+// A pruned chain cannot be reorganized below the pruned blocks, rebuild
+// the UTXO index or the transaction indexes, nor send the pruned blocks
+// to its peers.
+var (
+	// headersBucket maps the hash of a block whose body is pruned to
+	// its header
+	headersBucket = []byte("headers")
+
+	// pruneDepthKey is set in the meta bucket while the chain is pruned,
+	// to the number of blocks below the tip whose bodies are kept
+	pruneDepthKey = []byte("prunedepth")
+	// prunedHeightKey is set in the meta bucket to the height of the
+	// last block of the main chain whose body is pruned
+	prunedHeightKey = []byte("prunedheight")
+)
+
+// EnablePruning prunes the bodies of the blocks of the main chain more
+// than depth blocks below the tip, and keeps pruning them as the chain
+// grows. The pruned bodies cannot be restored.
+func (bc *Blockchain) EnablePruning(depth int) error {
+	if depth < MinPruneDepth {
+		return fmt.Errorf("%w: %d < %d", ErrPruneDepth, depth, MinPruneDepth)
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.db.Update(func(tx StorageTx) error {
+		if tx.Get(metaBucket, txIndexKey) != nil {
+			return ErrPrunedTxIndex
+		}
+		if err := tx.Put(metaBucket, pruneDepthKey, heightKey(depth)); err != nil {
+			return err
+		}
+		return pruneBlocks(tx, bc.height)
+	})
+}
+
+// DisablePruning stops pruning the chain, the new blocks are kept
+func (bc *Blockchain) DisablePruning() error {
+	return bc.db.Update(func(tx StorageTx) error {
+		return tx.Delete(metaBucket, pruneDepthKey)
+	})
+}
+
+// PruneDepth returns the number of blocks below the tip whose bodies
+// are kept, 0 if the chain is not pruned
+func (bc *Blockchain) PruneDepth() int {
+	var depth int
+	bc.db.View(func(tx StorageTx) error {
+		depth = getMetaInt(tx, pruneDepthKey)
+		return nil
+	})
+	return depth
+}
+
+// PrunedHeight returns the height of the last block of the main chain
+// whose body is pruned, 0 if none is
+func (bc *Blockchain) PrunedHeight() int {
+	var height int
+	bc.db.View(func(tx StorageTx) error {
+		height = getMetaInt(tx, prunedHeightKey)
+		return nil
+	})
+	return height
+}
+
+// isPruned reports whether blocks are pruned or to be pruned
+func isPruned(tx StorageTx) bool {
+	return tx.Get(metaBucket, pruneDepthKey) != nil || tx.Get(metaBucket, prunedHeightKey) != nil
+}
+
+// getMetaInt returns an integer of the meta bucket encoded by heightKey,
+// 0 if it is not set
+func getMetaInt(tx StorageTx, key []byte) int {
+	data := tx.Get(metaBucket, key)
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// pruneBlocks prunes the blocks of the main chain too deep below the tip
+// at the given height, if the chain is pruned. It runs after connectBlock.
+func pruneBlocks(tx StorageTx, height int) error {
+	depth := getMetaInt(tx, pruneDepthKey)
+	if depth == 0 {
+		return nil
+	}
+	pruned := getMetaInt(tx, prunedHeightKey)
+	if pruned >= height-depth {
+		return nil
+	}
+	for h := pruned + 1; h <= height-depth; h++ {
+		hash := tx.Get(heightsBucket, heightKey(h))
+		if err := pruneBlock(tx, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Put(metaBucket, prunedHeightKey, heightKey(height-depth))
+}
+
+// pruneBlock replaces the body of a block and its undo data by its header
+func pruneBlock(tx StorageTx, hash []byte) error {
+	block, err := getBlock(tx, hash)
+	if err != nil {
+		return err
+	}
+	if err := tx.Put(headersBucket, hash, block.BlockHeader.Serialize()); err != nil {
+		return err
+	}
+	if err := tx.Delete(blocksBucket, hash); err != nil {
+		return err
+	}
+	return tx.Delete(undoBucket, hash)
+}
+
+// GetBlockHeader returns the header of a known block, pruned or not
+func (bc *Blockchain) GetBlockHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+	err := bc.db.View(func(tx StorageTx) error {
+		var err error
+		header, err = getHeader(tx, hash)
+		return err
+	})
+	return header, err
+}
+
+func getHeader(tx StorageTx, hash []byte) (*BlockHeader, error) {
+	if data := tx.Get(headersBucket, hash); data != nil {
+		return DeserializeBlockHeader(data)
+	}
+	block, err := getBlock(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &block.BlockHeader, nil
+}
+
+// unspentOutputsOf returns the unspent outputs of a transaction of the
+// main chain, read from the UTXO index, for a transaction whose block
+// is pruned. They are the outputs of a Transaction with its ID, at their
+// index, and its other outputs are empty.
+func (bc *Blockchain) unspentOutputsOf(txID []byte) (*Transaction, error) {
+	tran := &Transaction{ID: txID}
+	err := bc.db.View(func(tx StorageTx) error {
+		return tx.ForEach(utxoBucket, txID, func(k, v []byte) error {
+			id, outIdx := splitOutpointKey(k)
+			if len(id) != len(txID) {
+				return nil
+			}
+			entry, err := deserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			for len(tran.Vout) <= outIdx {
+				tran.Vout = append(tran.Vout, TXOutput{})
+			}
+			tran.Vout[outIdx] = entry.Output
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(tran.Vout) == 0 {
+		return nil, ErrTxNotFound
+	}
+	return tran, nil
+}
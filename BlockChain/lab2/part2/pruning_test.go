@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruning(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 10)
+	early, err := bc.GetBlockByHeight(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 0, bc.PrunedHeight())
+
+	assert.ErrorIs(t, bc.EnablePruning(MinPruneDepth-1), ErrPruneDepth)
+	assert.Nil(t, bc.EnablePruning(MinPruneDepth))
+	assert.Equal(t, MinPruneDepth, bc.PruneDepth())
+	assert.Equal(t, 9-MinPruneDepth, bc.PrunedHeight())
+
+	// the bodies are gone, not the headers
+	_, err = bc.GetBlockByHeight(1)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	_, err = bc.GetBlock(early.Hash)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	header, err := bc.GetBlockHeader(early.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, early.BlockHeader, *header)
+	assert.NotNil(t, bc.GetGenesisBlock())
+	_, err = bc.GetBlockByHeight(bc.PrunedHeight() + 1)
+	assert.Nil(t, err)
+	var heights []int
+	bc.forEachBlock(func(block *Block, height int) error {
+		heights = append(heights, height)
+		return nil
+	})
+	assert.Equal(t, []int{0, 4, 5, 6, 7, 8, 9}, heights)
+
+	// the outputs of the pruned blocks are spent from the UTXO index
+	_, err = bc.FindTransaction(coinbases[1].ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+	spend := newTestSpend(t, bc, coinbases[1], 7)
+	assert.True(t, bc.VerifyTransaction(spend))
+	b := mineTestBlock(t, bc.CurrentBlock(), "b", spend)
+	assert.Nil(t, bc.addBlock(b))
+	assert.Equal(t, 10-MinPruneDepth, bc.PrunedHeight())
+
+	// the blocks kept are enough to reorganize
+	tip := bc.CurrentBlock()
+	parent, _ := bc.GetBlock(tip.PrevBlockHash)
+	c1 := mineTestBlock(t, parent, "c1")
+	c2 := mineTestBlock(t, c1, "c2")
+	mustStoreBlock(t, bc, c1)
+	mustStoreBlock(t, bc, c2)
+	assert.Equal(t, c2.Hash, bc.CurrentBlock().Hash)
+
+	// but not below the pruned blocks: a heavier branch forking from
+	// a pruned block is rejected
+	utxos := bc.FindUTXOSet()
+	branch := early
+	for i := 0; i < 9; i++ {
+		branch = mineTestBlock(t, branch, "x")
+		mustStoreBlock(t, bc, branch)
+	}
+	branch = mineTestBlock(t, branch, "x")
+	assert.ErrorIs(t, bc.storeBlock(branch), ErrBlockPruned)
+	assert.Equal(t, c2.Hash, bc.CurrentBlock().Hash)
+	assert.Equal(t, utxos, bc.FindUTXOSet())
+
+	assert.ErrorIs(t, bc.UTXOIndex().Reindex(), ErrBlockPruned)
+	assert.Equal(t, utxos, bc.FindUTXOSet())
+	assert.ErrorIs(t, bc.EnableTxIndex(), ErrPrunedTxIndex)
+
+	// the new blocks are kept once pruning stops
+	pruned := bc.PrunedHeight()
+	assert.Nil(t, bc.DisablePruning())
+	assert.Equal(t, 0, bc.PruneDepth())
+	mustStoreBlock(t, bc, mineTestBlock(t, c2, "e"))
+	assert.Equal(t, pruned, bc.PrunedHeight())
+	assert.ErrorIs(t, bc.EnableTxIndex(), ErrPrunedTxIndex)
+}
+
+func TestPruningTxIndex(t *testing.T) {
+	bc, _ := newMempoolTestChain(t, 2)
+	assert.Nil(t, bc.EnableTxIndex())
+	assert.ErrorIs(t, bc.EnablePruning(MinPruneDepth), ErrPrunedTxIndex)
+	assert.Equal(t, 0, bc.PruneDepth())
+}
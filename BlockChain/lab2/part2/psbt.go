@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrPrevOutMismatch = errors.New("previous outputs do not match the inputs")
+	ErrNothingToSign   = errors.New("key signs no input of the transaction")
+	ErrIncomplete      = errors.New("transaction is not fully signed")
+)
+
+// PartialTransaction is a transaction being signed, with the transactions
+// its inputs spend, similar to Bitcoin's PSBT (BIP 174). It holds
+// everything needed to sign the transaction, so a wallet without the
+// chain, on an air-gapped machine, can sign it. The node holding the
+// chain creates it, see Blockchain.PartialTransaction, and once signed
+// finalizes it into the transaction it broadcasts.
+//
+// The signatures do not commit to the values of the spent outputs, see
+// SignatureHash, so, as the non_witness_utxo of BIP 174, the whole spent
+// transactions are given: the signer checks their hashes before signing,
+// and so the fee it shows.
+type PartialTransaction struct {
+	Tx *Transaction
+	// PrevTXs are the transactions spent by the inputs, in their order
+	PrevTXs []*Transaction
+	// PrevOuts are the outputs spent by the inputs, in their order
+	PrevOuts []TXOutput
+}
+
+// NewPartialTransaction returns a copy of the transaction with the
+// transactions spent by its inputs, found in prevTXs by their ID
+func NewPartialTransaction(tx *Transaction, prevTXs map[string]*Transaction) (*PartialTransaction, error) {
+	if len(tx.Vin) == 0 {
+		return nil, ErrEmptyTx
+	}
+	if tx.IsCoinbase() {
+		return nil, fmt.Errorf("%w: coinbase transaction", ErrPrevOutMismatch)
+	}
+	txCopy, err := DeserializeTransaction(tx.Serialize())
+	if err != nil {
+		return nil, err
+	}
+	p := &PartialTransaction{Tx: txCopy}
+	for idx, vin := range tx.Vin {
+		prevOut, err := tx.prevOutput(idx, prevTXs)
+		if err != nil {
+			return nil, fmt.Errorf("%w: input %d: %v", ErrPrevOutMismatch, idx, err)
+		}
+		prevTx, err := DeserializeTransaction(prevTXs[hex.EncodeToString(vin.Txid)].Serialize())
+		if err != nil {
+			return nil, err
+		}
+		p.PrevTXs = append(p.PrevTXs, prevTx)
+		p.PrevOuts = append(p.PrevOuts, prevOut)
+	}
+	if err := p.checkPrevTXs(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PartialTransaction returns the transaction with the transactions it
+// spends, to be signed offline. The spent transactions of pruned blocks
+// are not known in full, so they cannot be spent offline.
+func (bc *Blockchain) PartialTransaction(tx *Transaction) (*PartialTransaction, error) {
+	prevTXs := make(map[string]*Transaction)
+	for _, vin := range tx.Vin {
+		prevTx, err := bc.FindTransaction(vin.Txid)
+		if errors.Is(err, ErrTxNotFound) && bc.PrunedHeight() > 0 {
+			return nil, fmt.Errorf("%w: transaction %x", ErrBlockPruned, vin.Txid)
+		}
+		if err != nil {
+			return nil, err
+		}
+		prevTXs[hex.EncodeToString(vin.Txid)] = prevTx
+	}
+	return NewPartialTransaction(tx, prevTXs)
+}
+
+// checkPrevTXs checks that the spent transactions are the ones of the
+// inputs, their hash is the Txid of the input, and that the spent
+// outputs are theirs
+func (p *PartialTransaction) checkPrevTXs() error {
+	if len(p.PrevTXs) != len(p.Tx.Vin) || len(p.PrevOuts) != len(p.Tx.Vin) {
+		return fmt.Errorf("%w: %d transactions and %d outputs for %d inputs", ErrPrevOutMismatch, len(p.PrevTXs), len(p.PrevOuts), len(p.Tx.Vin))
+	}
+	for idx, vin := range p.Tx.Vin {
+		prevTx := p.PrevTXs[idx]
+		if !bytes.Equal(prevTx.Hash(), vin.Txid) {
+			return fmt.Errorf("%w: input %d: transaction %x is not %x", ErrPrevOutMismatch, idx, prevTx.Hash(), vin.Txid)
+		}
+		if vin.OutIdx < 0 || vin.OutIdx >= len(prevTx.Vout) ||
+			!bytes.Equal(prevTx.Vout[vin.OutIdx].Serialize(), p.PrevOuts[idx].Serialize()) {
+			return fmt.Errorf("%w: input %d", ErrPrevOutMismatch, idx)
+		}
+	}
+	return nil
+}
+
+// Sign signs the inputs spending the outputs of the key, and adds its
+// signature to the inputs spending a MultisigAddress it is a key of.
+// It returns ErrNothingToSign if the key signs no input, and
+// ErrPrevOutMismatch if the spent outputs are not the ones of the
+// spent transactions.
+func (p *PartialTransaction) Sign(privKey ecdsa.PrivateKey) error {
+	if err := p.checkPrevTXs(); err != nil {
+		return err
+	}
+	tx := p.Tx
+	pubKey := pubKeyToByte(privKey.PublicKey)
+	signed := 0
+	for idx, vin := range tx.Vin {
+		prevOut := p.PrevOuts[idx]
+		if len(vin.ScriptSig) > 0 {
+			ms, signatures, err := tx.multisigInput(idx, prevOut)
+			if errors.Is(err, ErrNotMultisig) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !ms.hasKey(pubKey) {
+				continue
+			}
+			signature, err := tx.SignInput(idx, prevOut, privKey)
+			if err != nil {
+				return err
+			}
+			tx.setMultisigSignatures(idx, prevOut, ms, append(signatures, signature))
+			signed++
+			continue
+		}
+
+		if !prevOut.IsLockedWithKey(HashPubKey(pubKey)) || (vin.PubKey != nil && !bytes.Equal(vin.PubKey, pubKey)) {
+			continue
+		}
+		signature, err := tx.SignInput(idx, prevOut, privKey)
+		if err != nil {
+			return err
+		}
+		tx.Vin[idx].Signature = signature
+		tx.Vin[idx].PubKey = pubKey
+		signed++
+	}
+	if signed == 0 {
+		return ErrNothingToSign
+	}
+	return nil
+}
+
+// Combine merges into p the signatures of other, a copy of the same
+// transaction signed by other keys
+func (p *PartialTransaction) Combine(other *PartialTransaction) error {
+	if !bytes.Equal(p.Tx.TrimmedCopy().Serialize(), other.Tx.TrimmedCopy().Serialize()) {
+		return ErrTxMismatch
+	}
+	for idx, prevOut := range p.PrevOuts {
+		if !bytes.Equal(prevOut.Serialize(), other.PrevOuts[idx].Serialize()) {
+			return fmt.Errorf("%w: input %d", ErrPrevOutMismatch, idx)
+		}
+	}
+
+	tx := p.Tx
+	for idx, prevOut := range p.PrevOuts {
+		if p.IsSigned(idx) {
+			continue
+		}
+		if other.IsSigned(idx) {
+			tx.Vin[idx] = other.Tx.Vin[idx]
+			continue
+		}
+		// the signatures of a multisig input signed by neither
+		ms, signatures, err := tx.multisigInput(idx, prevOut)
+		if errors.Is(err, ErrNotMultisig) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, others, err := other.Tx.multisigInput(idx, prevOut)
+		if err != nil {
+			return err
+		}
+		tx.setMultisigSignatures(idx, prevOut, ms, append(signatures, others...))
+	}
+	return nil
+}
+
+// IsSigned reports whether the input idx is fully signed
+func (p *PartialTransaction) IsSigned(idx int) bool {
+	return p.Tx.VerifyInputScript(idx, p.PrevOuts[idx]) == nil
+}
+
+// Fee returns the fee paid by the transaction, see Transaction.Fee,
+// once the spent outputs are checked against the spent transactions
+func (p *PartialTransaction) Fee() (int, error) {
+	if err := p.checkPrevTXs(); err != nil {
+		return 0, err
+	}
+	return p.Tx.Fee(p.PrevOuts)
+}
+
+// Finalize returns the transaction, once all its inputs are signed
+func (p *PartialTransaction) Finalize() (*Transaction, error) {
+	for idx, prevOut := range p.PrevOuts {
+		if err := p.Tx.VerifyInputScript(idx, prevOut); err != nil {
+			return nil, fmt.Errorf("%w: input %d: %v", ErrIncomplete, idx, err)
+		}
+	}
+	return DeserializeTransaction(p.Tx.Serialize())
+}
+
+// Serialize returns the binary encoding of the PartialTransaction,
+// see serialization.go
+func (p *PartialTransaction) Serialize() []byte {
+	var e encoder
+	e.putUint32(partialTxVersion)
+	e.putTransaction(p.Tx)
+	e.putUint32(uint32(len(p.PrevTXs)))
+	for _, prevTx := range p.PrevTXs {
+		e.putTransaction(prevTx)
+	}
+	return e.buf.Bytes()
+}
+
+// DeserializePartialTransaction decodes a PartialTransaction serialized
+// by PartialTransaction.Serialize
+func DeserializePartialTransaction(data []byte) (*PartialTransaction, error) {
+	d := decoder{data: data}
+	d.version(partialTxVersion)
+	tx := d.transaction()
+	prevTXs := make(map[string]*Transaction)
+	// a transaction takes at least 16 bytes
+	n := d.count(16)
+	for i := 0; i < n; i++ {
+		prevTx := d.transaction()
+		prevTXs[hex.EncodeToString(prevTx.Hash())] = prevTx
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	if n != len(tx.Vin) {
+		return nil, fmt.Errorf("%w: %d transactions for %d inputs", ErrMalformed, n, len(tx.Vin))
+	}
+	p, err := NewPartialTransaction(tx, prevTXs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return p, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyPartialTransaction returns a deep copy of p, as read by another signer
+func copyPartialTransaction(t *testing.T, p *PartialTransaction) *PartialTransaction {
+	copyP, err := DeserializePartialTransaction(p.Serialize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return copyP
+}
+
+func TestPartialTransaction(t *testing.T) {
+	privKey, utxos, prevTXs := newTestCoins()
+	builder := NewTxBuilder(pubKeyToByte(privKey.PublicKey), utxos)
+	builder.Selector = SmallestFirst
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 2500))
+	builder.Fee = 10
+	tx, coins, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPartialTransaction(tx, prevTXs)
+	assert.Nil(t, err)
+	assert.Equal(t, spentOutputs(coins), p.PrevOuts)
+	assert.Equal(t, copyPartialTransaction(t, p), p)
+	fee, err := p.Fee()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, fee)
+	assert.False(t, p.IsSigned(0))
+	_, err = p.Finalize()
+	assert.ErrorIs(t, err, ErrIncomplete)
+
+	// the signer needs nothing but p
+	other, _ := newKeyPair()
+	assert.ErrorIs(t, p.Sign(other), ErrNothingToSign)
+	offline := copyPartialTransaction(t, p)
+	assert.Nil(t, offline.Sign(*privKey))
+	assert.True(t, offline.IsSigned(0))
+	assert.True(t, offline.IsSigned(1))
+	signed, err := offline.Finalize()
+	assert.Nil(t, err)
+	assert.True(t, signed.Verify(prevTXs))
+	assert.Equal(t, tx.ID, signed.ID)
+
+	// the online copy gets the signatures back
+	assert.Nil(t, p.Combine(offline))
+	final, err := p.Finalize()
+	assert.Nil(t, err)
+	assert.Equal(t, signed, final)
+
+	missing := make(map[string]*Transaction)
+	for id, prevTx := range prevTXs {
+		missing[id] = prevTx
+	}
+	delete(missing, hex.EncodeToString(tx.Vin[len(tx.Vin)-1].Txid))
+	_, err = NewPartialTransaction(tx, missing)
+	assert.ErrorIs(t, err, ErrPrevOutMismatch)
+	coinbase, _ := NewCoinbaseTX(testMinerAddress, "", 1)
+	_, err = NewPartialTransaction(coinbase, prevTXs)
+	assert.ErrorIs(t, err, ErrPrevOutMismatch)
+}
+
+func TestPartialTransactionTamperedPrevOut(t *testing.T) {
+	privKey, utxos, prevTXs := newTestCoins()
+	builder := NewTxBuilder(pubKeyToByte(privKey.PublicKey), utxos)
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 100))
+	builder.Fee = 10
+	tx, _, _ := builder.Build()
+	p, err := NewPartialTransaction(tx, prevTXs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an online node lying about the value of a spent output
+	lying := copyPartialTransaction(t, p)
+	lying.PrevOuts[0].Value += 1000
+	_, err = lying.Fee()
+	assert.ErrorIs(t, err, ErrPrevOutMismatch)
+	assert.ErrorIs(t, lying.Sign(*privKey), ErrPrevOutMismatch)
+	assert.False(t, lying.IsSigned(0))
+
+	// and about the spent transaction too
+	lying.PrevTXs[0].Vout[tx.Vin[0].OutIdx].Value += 1000
+	assert.ErrorIs(t, lying.Sign(*privKey), ErrPrevOutMismatch)
+	_, err = DeserializePartialTransaction(lying.Serialize())
+	assert.ErrorIs(t, err, ErrMalformed)
+	forged := *tx
+	forged.Vin = append([]TXInput{}, tx.Vin...)
+	forged.Vin[0].Txid = lying.PrevTXs[0].Hash()
+	_, err = NewPartialTransaction(&forged, map[string]*Transaction{hex.EncodeToString(lying.PrevTXs[0].ID): lying.PrevTXs[0]})
+	assert.ErrorIs(t, err, ErrPrevOutMismatch)
+
+	fee, err := p.Fee()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, fee)
+	assert.Nil(t, p.Sign(*privKey))
+}
+
+func TestPartialTransactionEncoding(t *testing.T) {
+	privKey, utxos, prevTXs := newTestCoins()
+	builder := NewTxBuilder(pubKeyToByte(privKey.PublicKey), utxos)
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 100))
+	tx, _, _ := builder.Build()
+	p, _ := NewPartialTransaction(tx, prevTXs)
+	data := p.Serialize()
+
+	_, err := DeserializePartialTransaction(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = DeserializePartialTransaction(append(data, 0))
+	assert.ErrorIs(t, err, ErrMalformed)
+	data[3] = 1
+	_, err = DeserializePartialTransaction(data)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+
+	// the transactions must match the inputs
+	p.PrevTXs = nil
+	_, err = DeserializePartialTransaction(p.Serialize())
+	assert.ErrorIs(t, err, ErrMalformed)
+	p.Tx.Vin = nil
+	_, err = DeserializePartialTransaction(p.Serialize())
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = NewPartialTransaction(p.Tx, nil)
+	assert.ErrorIs(t, err, ErrEmptyTx)
+}
+
+func TestPartialTransactionCombine(t *testing.T) {
+	privKey, pubKey := newKeyPair()
+	ms, keys := newTestMultisig(t, 2, 3)
+	prev := &Transaction{Vout: []TXOutput{
+		{Value: 10, PubKeyHash: HashPubKey(pubKey)},
+		{Value: 20, Script: P2SHScript(ms.ScriptHash())},
+	}}
+	prev.ID = prev.Hash()
+	tx := &Transaction{
+		Vin: []TXInput{
+			{Txid: prev.ID, OutIdx: 0},
+			{Txid: prev.ID, OutIdx: 1, ScriptSig: NewScriptBuilder().AddData(ms.RedeemScript).Script()},
+		},
+		Vout: []TXOutput{{Value: 25, PubKeyHash: HashPubKey([]byte("payee"))}},
+	}
+	tx.ID = tx.Hash()
+	unsigned, err := NewPartialTransaction(tx, map[string]*Transaction{hex.EncodeToString(prev.ID): prev})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each signer signs its own copy
+	single := copyPartialTransaction(t, unsigned)
+	assert.Nil(t, single.Sign(privKey))
+	assert.Equal(t, pubKey, single.Tx.Vin[0].PubKey)
+	assert.False(t, single.IsSigned(1))
+	first := copyPartialTransaction(t, unsigned)
+	assert.Nil(t, first.Sign(keys[0]))
+	second := copyPartialTransaction(t, unsigned)
+	assert.Nil(t, second.Sign(keys[2]))
+	assert.False(t, second.IsSigned(1))
+
+	combined := copyPartialTransaction(t, single)
+	assert.Nil(t, combined.Combine(first))
+	_, err = combined.Finalize()
+	assert.ErrorIs(t, err, ErrIncomplete)
+	assert.Nil(t, combined.Combine(second))
+	assert.True(t, combined.IsSigned(0))
+	assert.True(t, combined.IsSigned(1))
+	final, err := combined.Finalize()
+	assert.Nil(t, err)
+	for idx, prevOut := range prev.Vout {
+		assert.Nil(t, final.VerifyInputScript(idx, prevOut))
+	}
+
+	// combining again changes nothing
+	again := copyPartialTransaction(t, combined)
+	assert.Nil(t, again.Combine(unsigned))
+	assert.Nil(t, again.Combine(first))
+	assert.Equal(t, combined, again)
+
+	other := copyPartialTransaction(t, first)
+	other.Tx.Vout[0].Value--
+	assert.ErrorIs(t, combined.Combine(other), ErrTxMismatch)
+	other = copyPartialTransaction(t, first)
+	other.PrevOuts[0].Value++
+	assert.ErrorIs(t, combined.Combine(other), ErrPrevOutMismatch)
+}
+
+func TestBlockchainPartialTransaction(t *testing.T) {
+	bc, err := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	tx, err := NewUTXOTransactionWithFee(pubKeyToByte(privKey.PublicKey), addressTable[0].address, 3, 1, bc.UTXOIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := bc.PartialTransaction(tx)
+	assert.Nil(t, err)
+	assert.Equal(t, []TXOutput{bc.GetGenesisBlock().Transactions[0].Vout[0]}, p.PrevOuts)
+
+	assert.Nil(t, p.Sign(*privKey))
+	signed, err := p.Finalize()
+	assert.Nil(t, err)
+	assert.True(t, bc.VerifyTransaction(signed))
+	assert.Nil(t, NewMempool(bc, DefaultMempoolSize).Add(signed))
+
+	tx.Vin[0].Txid = make([]byte, 32)
+	_, err = bc.PartialTransaction(tx)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+)
+
+// The RPC server answers JSON-RPC 2.0 requests posted over HTTP, see
+// https://www.jsonrpc.org/specification. The parameters of the methods
+// are positional, as in Bitcoin:
+//
+//	getblock            [hash]               the block, see blockResult
+//	getblockbyheight    [height]             the block of the main chain
+//	gettransaction      [txid]               the transaction, mined or in the mempool
+//	getbalance          [address]            the spendable value of the address
+//	listunspent         [address]            the unspent outputs of the address
+//	getaddresshistory   [address]            the transactions of the address, with the transaction indexes
+//	getspendingtx       [txid, outIdx]       the input spending the output, with the transaction indexes
+//	sendrawtransaction  [hex]                adds the serialized transaction to the mempool, returns its ID
+//	getmempoolinfo      []                   the size of the mempool
+//	getmininginfo       []                   the height and the difficulty of the next block
+//
+// Hashes and serialized data are hex strings.
+
+// rpcMaxRequestSize bounds the size of a request body
+const rpcMaxRequestSize = 1 << 20
+
+// The error codes of JSON-RPC, and the ones of Bitcoin for the errors of
+// the methods
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcNotFound       = -5  // no block, transaction or address of the parameters
+	rpcDecodeError    = -22 // the transaction cannot be decoded
+	rpcVerifyRejected = -26 // the transaction was not accepted in the mempool
+)
+
+// RPCError is the error of a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// RPCServer is the http.Handler of the JSON-RPC API of a chain and its
+// mempool
+type RPCServer struct {
+	bc      *Blockchain
+	mempool *Mempool
+	// Node relays the transactions sent to the server, when set
+	Node *P2PNode
+
+	methods map[string]func(params []json.RawMessage) (interface{}, error)
+}
+
+// NewRPCServer returns the server of the chain and the mempool
+func NewRPCServer(bc *Blockchain, mempool *Mempool) *RPCServer {
+	s := &RPCServer{bc: bc, mempool: mempool}
+	s.methods = map[string]func(params []json.RawMessage) (interface{}, error){
+		"getblock":           s.getBlock,
+		"getblockbyheight":   s.getBlockByHeight,
+		"gettransaction":     s.getTransaction,
+		"getbalance":         s.getBalance,
+		"listunspent":        s.listUnspent,
+		"getaddresshistory":  s.getAddressHistory,
+		"getspendingtx":      s.getSpendingTx,
+		"sendrawtransaction": s.sendRawTransaction,
+		"getmempoolinfo":     s.getMempoolInfo,
+		"getmininginfo":      s.getMiningInfo,
+	}
+	return s
+}
+
+// ServeHTTP answers a request, or a batch of requests
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests are posted", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, rpcMaxRequestSize+1))
+	if err != nil {
+		return
+	}
+	var response interface{}
+	switch {
+	case len(body) > rpcMaxRequestSize:
+		response = rpcErrorResponse(nil, rpcInvalidRequest, "request too large")
+	case !json.Valid(body):
+		response = rpcErrorResponse(nil, rpcParseError, "invalid JSON")
+	case body[firstNonSpace(body)] == '[':
+		var batch []json.RawMessage
+		json.Unmarshal(body, &batch)
+		if len(batch) == 0 {
+			response = rpcErrorResponse(nil, rpcInvalidRequest, "empty batch")
+			break
+		}
+		responses := make([]rpcResponse, len(batch))
+		for i, req := range batch {
+			responses[i] = s.handle(req)
+		}
+		response = responses
+	default:
+		response = s.handle(body)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func firstNonSpace(data []byte) int {
+	for i, c := range data {
+		if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return i
+		}
+	}
+	return 0
+}
+
+// handle answers a single request
+func (s *RPCServer) handle(data []byte) rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, rpcInvalidRequest, "invalid request")
+	}
+	method, ok := s.methods[req.Method]
+	if !ok {
+		return rpcErrorResponse(req.ID, rpcMethodNotFound, "unknown method "+req.Method)
+	}
+	result, err := method(req.Params)
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: rpcErrorCode(err), Message: err.Error()}
+		}
+		return rpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+	}
+	data, err = json.Marshal(result)
+	if err != nil {
+		return rpcErrorResponse(req.ID, rpcInternalError, err.Error())
+	}
+	return rpcResponse{JSONRPC: "2.0", Result: data, ID: req.ID}
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", Error: &RPCError{Code: code, Message: message}, ID: id}
+}
+
+// rpcErrorCode returns the code of an error of a method
+func rpcErrorCode(err error) int {
+	switch {
+	case errors.Is(err, ErrBlockNotFound), errors.Is(err, ErrTxNotFound), errors.Is(err, ErrInvalidAddress),
+		errors.Is(err, ErrOutputNotSpent), errors.Is(err, ErrNoTxIndex), errors.Is(err, ErrBlockPruned):
+		return rpcNotFound
+	case errors.Is(err, ErrMalformed), errors.Is(err, ErrUnknownVersion):
+		return rpcDecodeError
+	case errors.Is(err, ErrInvalidTx), errors.Is(err, ErrTxInMempool), errors.Is(err, ErrTxConflict), errors.Is(err, ErrMempoolFull),
+		errors.Is(err, ErrTxInputNotFound), errors.Is(err, ErrValueNotConserved), errors.Is(err, ErrNegativeValue),
+		errors.Is(err, ErrValueTooLarge):
+		return rpcVerifyRejected
+	}
+	return rpcInternalError
+}
+
+// parseParams decodes the positional parameters into the pointers of v,
+// all required
+func parseParams(params []json.RawMessage, v ...interface{}) error {
+	if len(params) != len(v) {
+		return &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf("%d parameters expected, got %d", len(v), len(params))}
+	}
+	for i, param := range params {
+		if err := json.Unmarshal(param, v[i]); err != nil {
+			return &RPCError{Code: rpcInvalidParams, Message: fmt.Sprintf("parameter %d: %v", i, err)}
+		}
+	}
+	return nil
+}
+
+// parseHashParam decodes a single hex parameter
+func parseHashParam(params []json.RawMessage) ([]byte, error) {
+	var s string
+	if err := parseParams(params, &s); err != nil {
+		return nil, err
+	}
+	hash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, &RPCError{Code: rpcInvalidParams, Message: "parameter 0: " + err.Error()}
+	}
+	return hash, nil
+}
+
+// parseAddressParam decodes a single address parameter and returns the
+// hash the outputs paying it are locked with
+func parseAddressParam(params []json.RawMessage) ([]byte, error) {
+	var address string
+	if err := parseParams(params, &address); err != nil {
+		return nil, err
+	}
+	_, hash, err := DecodeAddress(address)
+	return hash, err
+}
+
+func (s *RPCServer) getBlock(params []json.RawMessage) (interface{}, error) {
+	hash, err := parseHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.bc.GetBlock(hash)
+	if err != nil {
+		return nil, err
+	}
+	height, err := s.bc.blockHeight(hash)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockResult(block, height), nil
+}
+
+func (s *RPCServer) getBlockByHeight(params []json.RawMessage) (interface{}, error) {
+	var height int
+	if err := parseParams(params, &height); err != nil {
+		return nil, err
+	}
+	block, err := s.bc.GetBlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockResult(block, height), nil
+}
+
+// rpcTransaction is the result of gettransaction
+type rpcTransaction struct {
+	txResult
+	Hex           string `json:"hex"`
+	BlockHash     string `json:"blockHash,omitempty"` // empty while in the mempool
+	Height        int    `json:"height,omitempty"`
+	Confirmations int    `json:"confirmations"`
+}
+
+func (s *RPCServer) getTransaction(params []json.RawMessage) (interface{}, error) {
+	txID, err := parseHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	if tx, ok := s.mempool.Get(txID); ok {
+		return rpcTransaction{txResult: newTxResult(tx), Hex: hex.EncodeToString(tx.Serialize())}, nil
+	}
+
+	// without the transaction indexes, the blocks are scanned
+	tx, loc, err := s.bc.findIndexedTransaction(txID)
+	if err == nil {
+		return rpcTransaction{
+			txResult:      newTxResult(tx),
+			Hex:           hex.EncodeToString(tx.Serialize()),
+			BlockHash:     hex.EncodeToString(loc.BlockHash),
+			Height:        loc.Height,
+			Confirmations: s.bc.Height() - loc.Height + 1,
+		}, nil
+	}
+	if !errors.Is(err, ErrNoTxIndex) {
+		return nil, err
+	}
+	var result *rpcTransaction
+	err = s.bc.forEachBlock(func(block *Block, height int) error {
+		if tx, err := block.FindTransaction(txID); err == nil {
+			result = &rpcTransaction{
+				txResult:      newTxResult(tx),
+				Hex:           hex.EncodeToString(tx.Serialize()),
+				BlockHash:     hex.EncodeToString(block.Hash),
+				Height:        height,
+				Confirmations: s.bc.Height() - height + 1,
+			}
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	if result == nil {
+		return nil, ErrTxNotFound
+	}
+	return result, nil
+}
+
+func (s *RPCServer) getBalance(params []json.RawMessage) (interface{}, error) {
+	hash, err := parseAddressParam(params)
+	if err != nil {
+		return nil, err
+	}
+	balance, _ := s.bc.UTXOIndex().FindSpendableOutputs(hash, 0)
+	return balance, nil
+}
+
+// rpcUnspent is an output of listunspent
+type rpcUnspent struct {
+	Txid          string `json:"txid"`
+	OutIdx        int    `json:"outIdx"`
+	Value         int    `json:"value"`
+	Script        string `json:"script"` // the locking script
+	Height        int    `json:"height"`
+	Confirmations int    `json:"confirmations"`
+	Coinbase      bool   `json:"coinbase"`
+	Spendable     bool   `json:"spendable"` // false for the immature coinbases
+}
+
+func (s *RPCServer) listUnspent(params []json.RawMessage) (interface{}, error) {
+	hash, err := parseAddressParam(params)
+	if err != nil {
+		return nil, err
+	}
+	tip := s.bc.Height()
+	unspent := []rpcUnspent{}
+	s.bc.UTXOIndex().forEachOutput(hash, func(txID []byte, outIdx int, entry *utxoEntry) {
+		unspent = append(unspent, rpcUnspent{
+			Txid:          hex.EncodeToString(txID),
+			OutIdx:        outIdx,
+			Value:         entry.Output.Value,
+			Script:        hex.EncodeToString(entry.Output.LockingScript()),
+			Height:        entry.Height,
+			Confirmations: tip - entry.Height + 1,
+			Coinbase:      entry.Coinbase,
+			Spendable:     entry.spendableAt(tip + 1),
+		})
+	})
+	return unspent, nil
+}
+
+// rpcAddressTx is a transaction of getaddresshistory
+type rpcAddressTx struct {
+	Txid          string `json:"txid"`
+	Height        int    `json:"height"`
+	Confirmations int    `json:"confirmations"`
+	Received      int    `json:"received"`
+	Sent          int    `json:"sent"`
+}
+
+func (s *RPCServer) getAddressHistory(params []json.RawMessage) (interface{}, error) {
+	hash, err := parseAddressParam(params)
+	if err != nil {
+		return nil, err
+	}
+	history, err := s.bc.AddressHistory(hash)
+	if err != nil {
+		return nil, err
+	}
+	tip := s.bc.Height()
+	result := []rpcAddressTx{}
+	for _, h := range history {
+		result = append(result, rpcAddressTx{
+			Txid:          hex.EncodeToString(h.Txid),
+			Height:        h.Height,
+			Confirmations: tip - h.Height + 1,
+			Received:      h.Received,
+			Sent:          h.Sent,
+		})
+	}
+	return result, nil
+}
+
+// rpcSpendingTx is the result of getspendingtx
+type rpcSpendingTx struct {
+	Txid          string `json:"txid"`
+	InIdx         int    `json:"inIdx"`
+	Height        int    `json:"height"`
+	Confirmations int    `json:"confirmations"`
+}
+
+func (s *RPCServer) getSpendingTx(params []json.RawMessage) (interface{}, error) {
+	var txid string
+	var outIdx int
+	if err := parseParams(params, &txid, &outIdx); err != nil {
+		return nil, err
+	}
+	txID, err := hex.DecodeString(txid)
+	if err != nil {
+		return nil, &RPCError{Code: rpcInvalidParams, Message: "parameter 0: " + err.Error()}
+	}
+	input, err := s.bc.FindSpendingInput(txID, outIdx)
+	if err != nil {
+		return nil, err
+	}
+	return rpcSpendingTx{
+		Txid:          hex.EncodeToString(input.Txid),
+		InIdx:         input.InIdx,
+		Height:        input.Height,
+		Confirmations: s.bc.Height() - input.Height + 1,
+	}, nil
+}
+
+func (s *RPCServer) sendRawTransaction(params []json.RawMessage) (interface{}, error) {
+	data, err := parseHashParam(params)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return nil, err
+	}
+	if s.Node != nil {
+		err = s.Node.SubmitTransaction(tx)
+	} else {
+		err = s.mempool.Add(tx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// rpcMempoolInfo is the result of getmempoolinfo
+type rpcMempoolInfo struct {
+	Size    int `json:"size"`    // number of transactions
+	Bytes   int `json:"bytes"`   // size of the transactions
+	MaxSize int `json:"maxSize"` // limit of Bytes
+}
+
+func (s *RPCServer) getMempoolInfo(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+	return rpcMempoolInfo{Size: s.mempool.Count(), Bytes: s.mempool.Size(), MaxSize: s.mempool.maxSize}, nil
+}
+
+// rpcMiningInfo is the result of getmininginfo
+type rpcMiningInfo struct {
+	Blocks     int     `json:"blocks"` // height of the tip
+	BestBlock  string  `json:"bestBlock"`
+	Bits       uint32  `json:"bits"` // of the next block
+	Target     string  `json:"target"`
+	Difficulty float64 `json:"difficulty"` // the target of the genesis block over the target
+	PooledTx   int     `json:"pooledTx"`
+}
+
+func (s *RPCServer) getMiningInfo(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params); err != nil {
+		return nil, err
+	}
+	tip, height := s.bc.Tip()
+	bits, err := s.bc.NextBits(tip)
+	if err != nil {
+		return nil, err
+	}
+	target := CompactToBig(bits)
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(powLimit), new(big.Float).SetInt(target)).Float64()
+	return rpcMiningInfo{
+		Blocks:     height,
+		BestBlock:  hex.EncodeToString(tip),
+		Bits:       bits,
+		Target:     fmt.Sprintf("%064x", target),
+		Difficulty: difficulty,
+		PooledTx:   s.mempool.Count(),
+	}, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestRPCServer serves the RPC API of a new chain paying its genesis
+// reward to testMinerAddress
+func newTestRPCServer(t *testing.T) (*httptest.Server, *Blockchain, *Mempool) {
+	bc, err := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mempool := NewMempool(bc, DefaultMempoolSize)
+	server := httptest.NewServer(NewRPCServer(bc, mempool))
+	t.Cleanup(server.Close)
+	return server, bc, mempool
+}
+
+// postRPC posts a request body and returns the response body
+func postRPC(t *testing.T, server *httptest.Server, body string) []byte {
+	t.Helper()
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var out bytes.Buffer
+	out.ReadFrom(resp.Body)
+	return out.Bytes()
+}
+
+// callRPC calls a method and decodes its result into v, or returns the
+// error of the response
+func callRPC(t *testing.T, server *httptest.Server, v interface{}, method string, params ...interface{}) *RPCError {
+	t.Helper()
+	if params == nil {
+		params = []interface{}{}
+	}
+	req, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 7, "method": method, "params": params})
+	var resp rpcResponse
+	if err := json.Unmarshal(postRPC(t, server, string(req)), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "2.0", resp.JSONRPC)
+	assert.Equal(t, "7", string(resp.ID))
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if err := json.Unmarshal(resp.Result, v); err != nil {
+		t.Fatal(err)
+	}
+	return nil
+}
+
+func TestRPCChain(t *testing.T) {
+	server, bc, _ := newTestRPCServer(t)
+	genesis := bc.GetGenesisBlock()
+
+	var info rpcMiningInfo
+	assert.Nil(t, callRPC(t, server, &info, "getmininginfo"))
+	assert.Equal(t, 0, info.Blocks)
+	assert.Equal(t, hex.EncodeToString(genesis.Hash), info.BestBlock)
+	assert.Equal(t, InitialBits, info.Bits)
+	assert.Equal(t, 1.0, info.Difficulty)
+
+	var block, byHeight blockResult
+	assert.Nil(t, callRPC(t, server, &block, "getblock", hex.EncodeToString(genesis.Hash)))
+	assert.Equal(t, newBlockResult(genesis, 0), block)
+	assert.Nil(t, callRPC(t, server, &byHeight, "getblockbyheight", 0))
+	assert.Equal(t, block, byHeight)
+
+	var balance int
+	assert.Nil(t, callRPC(t, server, &balance, "getbalance", testMinerAddress))
+	assert.Equal(t, BlockSubsidy(0), balance)
+	assert.Nil(t, callRPC(t, server, &balance, "getbalance", addressTable[0].address))
+	assert.Equal(t, 0, balance)
+
+	var unspent []rpcUnspent
+	assert.Nil(t, callRPC(t, server, &unspent, "listunspent", testMinerAddress))
+	if assert.Len(t, unspent, 1) {
+		assert.Equal(t, hex.EncodeToString(genesis.Transactions[0].ID), unspent[0].Txid)
+		assert.Equal(t, BlockSubsidy(0), unspent[0].Value)
+		assert.Equal(t, 1, unspent[0].Confirmations)
+		assert.True(t, unspent[0].Coinbase)
+		assert.True(t, unspent[0].Spendable)
+	}
+
+	unknown := hex.EncodeToString(make([]byte, 32))
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "getblock", unknown).Code)
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "getblockbyheight", 1).Code)
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "gettransaction", unknown).Code)
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "getbalance", "1nope").Code)
+	assert.Equal(t, rpcInvalidParams, callRPC(t, server, nil, "getblock", "xyz").Code)
+	assert.Equal(t, rpcInvalidParams, callRPC(t, server, nil, "getblockbyheight", "0").Code)
+	assert.Equal(t, rpcInvalidParams, callRPC(t, server, nil, "listunspent").Code)
+	assert.Equal(t, rpcInvalidParams, callRPC(t, server, nil, "getmempoolinfo", 1).Code)
+	assert.Equal(t, rpcMethodNotFound, callRPC(t, server, nil, "stop").Code)
+}
+
+func TestRPCSendRawTransaction(t *testing.T) {
+	server, bc, mempool := newTestRPCServer(t)
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	tx, err := NewUTXOTransactionWithFee(pubKeyToByte(privKey.PublicKey), addressTable[0].address, 3, 1, bc.UTXOIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SignTransaction(tx, *privKey); err != nil {
+		t.Fatal(err)
+	}
+	raw := hex.EncodeToString(tx.Serialize())
+
+	var txid string
+	assert.Nil(t, callRPC(t, server, &txid, "sendrawtransaction", raw))
+	assert.Equal(t, hex.EncodeToString(tx.ID), txid)
+	assert.True(t, mempool.Has(tx.ID))
+	assert.Equal(t, rpcVerifyRejected, callRPC(t, server, nil, "sendrawtransaction", raw).Code)
+	assert.Equal(t, rpcDecodeError, callRPC(t, server, nil, "sendrawtransaction", "00").Code)
+
+	var mempoolInfo rpcMempoolInfo
+	assert.Nil(t, callRPC(t, server, &mempoolInfo, "getmempoolinfo"))
+	assert.Equal(t, rpcMempoolInfo{Size: 1, Bytes: len(tx.Serialize()), MaxSize: DefaultMempoolSize}, mempoolInfo)
+
+	var pending rpcTransaction
+	assert.Nil(t, callRPC(t, server, &pending, "gettransaction", txid))
+	assert.Equal(t, rpcTransaction{txResult: newTxResult(tx), Hex: raw}, pending)
+
+	// once mined
+	coinbase, _ := NewCoinbaseTXWithFees(testMinerAddress, "", 1, 1)
+	block, err := bc.MineBlock([]*Transaction{coinbase, tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mined rpcTransaction
+	assert.Nil(t, callRPC(t, server, &mined, "gettransaction", txid))
+	assert.Equal(t, hex.EncodeToString(block.Hash), mined.BlockHash)
+	assert.Equal(t, 1, mined.Height)
+	assert.Equal(t, 1, mined.Confirmations)
+	assert.Nil(t, callRPC(t, server, &mempoolInfo, "getmempoolinfo"))
+	assert.Equal(t, 0, mempoolInfo.Size)
+
+	var unspent []rpcUnspent
+	assert.Nil(t, callRPC(t, server, &unspent, "listunspent", addressTable[0].address))
+	if assert.Len(t, unspent, 1) {
+		assert.Equal(t, rpcUnspent{Txid: txid, Value: 3, Script: hex.EncodeToString(tx.Vout[0].LockingScript()), Height: 1, Confirmations: 1, Spendable: true}, unspent[0])
+	}
+}
+
+func TestRPCProtocol(t *testing.T) {
+	server, _, _ := newTestRPCServer(t)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	var single rpcResponse
+	assert.Nil(t, json.Unmarshal(postRPC(t, server, `{"jsonrpc": "2.0", "method": "getblockbyheight"`), &single))
+	assert.Equal(t, rpcParseError, single.Error.Code)
+	assert.Equal(t, "null", string(single.ID))
+	assert.Nil(t, single.Result)
+	assert.Nil(t, json.Unmarshal(postRPC(t, server, `{"method": "getmininginfo", "id": "a"}`), &single))
+	assert.Equal(t, rpcInvalidRequest, single.Error.Code)
+	assert.Equal(t, `"a"`, string(single.ID))
+	assert.Nil(t, json.Unmarshal(postRPC(t, server, `[]`), &single))
+	assert.Equal(t, rpcInvalidRequest, single.Error.Code)
+
+	// a batch is answered in order, errors included
+	var batch []rpcResponse
+	body := `[{"jsonrpc": "2.0", "id": 1, "method": "getblockbyheight", "params": [0]},
+		{"jsonrpc": "2.0", "id": 2, "method": "nope"},
+		{"jsonrpc": "2.0", "id": 3, "method": "getbalance", "params": ["` + testMinerAddress + `"]}]`
+	assert.Nil(t, json.Unmarshal(postRPC(t, server, body), &batch))
+	if assert.Len(t, batch, 3) {
+		assert.Nil(t, batch[0].Error)
+		assert.Equal(t, rpcMethodNotFound, batch[1].Error.Code)
+		assert.Equal(t, "3", string(batch[2].ID))
+		assert.Equal(t, fmt.Sprint(BlockSubsidy(0)), string(batch[2].Result))
+	}
+}
+
+func TestRPCTxIndex(t *testing.T) {
+	server, bc, _ := newTestRPCServer(t)
+	genesisTx := bc.GetGenesisBlock().Transactions[0]
+	txid := hex.EncodeToString(genesisTx.ID)
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "getaddresshistory", testMinerAddress).Code)
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "getspendingtx", txid, 0).Code)
+
+	assert.Nil(t, bc.EnableTxIndex())
+	spend := newTestSpend(t, bc, genesisTx, 4)
+	block := mineTestBlock(t, bc.CurrentBlock(), "spend", spend)
+	mustStoreBlock(t, bc, block)
+
+	var mined rpcTransaction
+	assert.Nil(t, callRPC(t, server, &mined, "gettransaction", hex.EncodeToString(spend.ID)))
+	assert.Equal(t, rpcTransaction{txResult: newTxResult(spend), Hex: hex.EncodeToString(spend.Serialize()), BlockHash: hex.EncodeToString(block.Hash), Height: 1, Confirmations: 1}, mined)
+
+	var history []rpcAddressTx
+	assert.Nil(t, callRPC(t, server, &history, "getaddresshistory", testMinerAddress))
+	assert.Equal(t, []rpcAddressTx{
+		{Txid: txid, Height: 0, Confirmations: 2, Received: BlockSubsidy(0)},
+		{Txid: hex.EncodeToString(block.Transactions[0].ID), Height: 1, Confirmations: 1, Received: BlockSubsidy(1)},
+		{Txid: hex.EncodeToString(spend.ID), Height: 1, Confirmations: 1, Sent: BlockSubsidy(0)},
+	}, history)
+
+	var spender rpcSpendingTx
+	assert.Nil(t, callRPC(t, server, &spender, "getspendingtx", txid, 0))
+	assert.Equal(t, rpcSpendingTx{Txid: hex.EncodeToString(spend.ID), InIdx: 0, Height: 1, Confirmations: 1}, spender)
+	assert.Equal(t, rpcNotFound, callRPC(t, server, nil, "getspendingtx", hex.EncodeToString(spend.ID), 0).Code)
+	assert.Equal(t, rpcInvalidParams, callRPC(t, server, nil, "getspendingtx", txid).Code)
+	assert.Equal(t, rpcInvalidParams, callRPC(t, server, nil, "getspendingtx", "xyz", 0).Code)
+}
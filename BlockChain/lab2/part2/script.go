@@ -0,0 +1,649 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A small subset of Bitcoin's script language
+//
+// A TXOutput is locked by a script, its LockingScript, and the TXInput
+// spending it carries the script unlocking it, its UnlockingScript. The
+// unlocking script, made of pushes only, runs first. The locking script
+// then runs on the stack it leaves, and the spend is valid if the stack
+// ends with a true value on top. See Transaction.VerifyInputScript.
+//
+// A locking script can also be the P2SHScript of the hash of another
+// script, the redeem script, as in Bitcoin's pay-to-script-hash. The last
+// push of the unlocking script is then the redeem script, which runs in
+// turn on the stack left by the other pushes, see MultisigAddress.
+//
+// Only the following opcodes are known, with the values and semantics of
+// Bitcoin's: the pushes, OP_VERIFY, OP_RETURN, OP_DROP, OP_DUP, OP_EQUAL,
+// OP_EQUALVERIFY, OP_HASH160, OP_CHECKSIG, OP_CHECKMULTISIG and
+// OP_CHECKLOCKTIMEVERIFY. Unlike Bitcoin's, OP_CHECKMULTISIG does not pop
+// an extra item, and a failed signature check must have empty signatures.
+
+// Opcodes of the script language
+const (
+	OP_0                   byte = 0x00
+	OP_PUSHDATA1           byte = 0x4c
+	OP_PUSHDATA2           byte = 0x4d
+	OP_1                   byte = 0x51
+	OP_16                  byte = 0x60
+	OP_VERIFY              byte = 0x69
+	OP_RETURN              byte = 0x6a
+	OP_DROP                byte = 0x75
+	OP_DUP                 byte = 0x76
+	OP_EQUAL               byte = 0x87
+	OP_EQUALVERIFY         byte = 0x88
+	OP_HASH160             byte = 0xa9
+	OP_CHECKSIG            byte = 0xac
+	OP_CHECKMULTISIG       byte = 0xae
+	OP_CHECKLOCKTIMEVERIFY byte = 0xb1
+)
+
+var opcodeNames = map[byte]string{
+	OP_0:                   "OP_0",
+	OP_VERIFY:              "OP_VERIFY",
+	OP_RETURN:              "OP_RETURN",
+	OP_DROP:                "OP_DROP",
+	OP_DUP:                 "OP_DUP",
+	OP_EQUAL:               "OP_EQUAL",
+	OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+	OP_HASH160:             "OP_HASH160",
+	OP_CHECKSIG:            "OP_CHECKSIG",
+	OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+	OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+}
+
+const (
+	// maxScriptSize is the maximum size of a script
+	maxScriptSize = 10000
+	// maxElementSize is the maximum size of a stack item
+	maxElementSize = 520
+	// maxStackSize is the maximum number of stack items
+	maxStackSize = 1000
+	// maxMultisigKeys is the maximum number of keys of OP_CHECKMULTISIG
+	maxMultisigKeys = 20
+	// maxDataCarrierSize is the maximum size of the data of DataScript
+	maxDataCarrierSize = 80
+	// maxScriptNumSize is the maximum size of a number operand, but
+	// OP_CHECKLOCKTIMEVERIFY takes 5 bytes to reach the uint32 lock times
+	maxScriptNumSize = 4
+)
+
+var (
+	ErrScriptMalformed   = errors.New("script: malformed script")
+	ErrScriptTooBig      = errors.New("script: script or stack too big")
+	ErrUnknownOpcode     = errors.New("script: unknown opcode")
+	ErrNotPushOnly       = errors.New("script: unlocking script is not push only")
+	ErrStackUnderflow    = errors.New("script: not enough stack items")
+	ErrBadScriptNumber   = errors.New("script: invalid number")
+	ErrVerifyFailed      = errors.New("script: verify failed")
+	ErrEqualVerifyFailed = errors.New("script: equal verify failed")
+	ErrOpReturn          = errors.New("script: OP_RETURN executed")
+	ErrScriptFalse       = errors.New("script: evaluated to false")
+	ErrTooManyKeys       = errors.New("script: invalid number of multisig keys or signatures")
+	ErrLockTime          = errors.New("script: lock time not reached")
+)
+
+// ScriptBuilder builds a script one opcode or push at a time
+type ScriptBuilder struct {
+	script []byte
+}
+
+// NewScriptBuilder returns an empty ScriptBuilder
+func NewScriptBuilder() *ScriptBuilder {
+	return &ScriptBuilder{}
+}
+
+// AddOp appends an opcode to the script
+func (b *ScriptBuilder) AddOp(op byte) *ScriptBuilder {
+	b.script = append(b.script, op)
+	return b
+}
+
+// AddData appends the shortest push of the data to the script
+func (b *ScriptBuilder) AddData(data []byte) *ScriptBuilder {
+	b.script = append(b.script, pushData(data)...)
+	return b
+}
+
+// AddInt appends the push of a number to the script,
+// OP_0 to OP_16 for the small ones
+func (b *ScriptBuilder) AddInt(n int64) *ScriptBuilder {
+	if n >= 1 && n <= 16 {
+		return b.AddOp(OP_1 + byte(n-1))
+	}
+	return b.AddData(scriptNum(n))
+}
+
+// Script returns the built script
+func (b *ScriptBuilder) Script() []byte {
+	return b.script
+}
+
+// P2PKHScript returns the script locking an output to a public key hash:
+// OP_DUP OP_HASH160 <pubKeyHash> OP_EQUALVERIFY OP_CHECKSIG.
+// It is unlocked by <signature> <pubKey>.
+func P2PKHScript(pubKeyHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_DUP).AddOp(OP_HASH160).AddData(pubKeyHash).
+		AddOp(OP_EQUALVERIFY).AddOp(OP_CHECKSIG).Script()
+}
+
+// P2SHScript returns the script locking an output to the hash of a redeem
+// script: OP_HASH160 <scriptHash> OP_EQUAL. It is unlocked by the
+// unlocking script of the redeem script followed by the redeem script.
+func P2SHScript(scriptHash []byte) []byte {
+	return NewScriptBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// p2shHash returns the hash of the redeem script
+// when the script is a P2SHScript
+func p2shHash(script []byte) ([]byte, bool) {
+	if len(script) != 23 || script[0] != OP_HASH160 || script[1] != 20 || script[22] != OP_EQUAL {
+		return nil, false
+	}
+	return script[2:22], true
+}
+
+// MultisigScript returns the script locking an output to m signatures
+// of the n public keys: <m> <pubKey>... <n> OP_CHECKMULTISIG.
+// It is unlocked by the signatures, in the order of the keys.
+func MultisigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if m < 1 || m > n || n > maxMultisigKeys {
+		return nil, fmt.Errorf("%w: %d of %d", ErrTooManyKeys, m, n)
+	}
+	b := NewScriptBuilder().AddInt(int64(m))
+	for _, pubKey := range pubKeys {
+		if _, err := parsePubKey(pubKey); err != nil {
+			return nil, err
+		}
+		b.AddData(pubKey)
+	}
+	return b.AddInt(int64(n)).AddOp(OP_CHECKMULTISIG).Script(), nil
+}
+
+// TimelockScript returns the script locking an output to a public key
+// hash until the lock time, a height or a timestamp, see
+// Transaction.IsFinal: <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP
+// followed by the P2PKHScript.
+func TimelockScript(lockTime uint32, pubKeyHash []byte) []byte {
+	b := NewScriptBuilder().AddInt(int64(lockTime)).AddOp(OP_CHECKLOCKTIMEVERIFY).AddOp(OP_DROP)
+	return append(b.Script(), P2PKHScript(pubKeyHash)...)
+}
+
+// DataScript returns the script of an unspendable output carrying the
+// data: OP_RETURN <data>
+func DataScript(data []byte) ([]byte, error) {
+	if len(data) > maxDataCarrierSize {
+		return nil, fmt.Errorf("%w: %d bytes of data", ErrScriptTooBig, len(data))
+	}
+	return NewScriptBuilder().AddOp(OP_RETURN).AddData(data).Script(), nil
+}
+
+// pushData returns the shortest push of the data
+func pushData(data []byte) []byte {
+	n := len(data)
+	switch {
+	case n < int(OP_PUSHDATA1):
+		return append([]byte{byte(n)}, data...)
+	case n <= 0xff:
+		return append([]byte{OP_PUSHDATA1, byte(n)}, data...)
+	default:
+		size := make([]byte, 2)
+		binary.LittleEndian.PutUint16(size, uint16(n))
+		return append(append([]byte{OP_PUSHDATA2}, size...), data...)
+	}
+}
+
+// scriptNum encodes a number as a stack item: little-endian on as few
+// bytes as possible, with the highest bit of the last byte as the sign
+func scriptNum(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	var num []byte
+	for ; n > 0; n >>= 8 {
+		num = append(num, byte(n))
+	}
+	switch {
+	case num[len(num)-1]&0x80 != 0 && negative:
+		num = append(num, 0x80)
+	case num[len(num)-1]&0x80 != 0:
+		num = append(num, 0)
+	case negative:
+		num[len(num)-1] |= 0x80
+	}
+	return num
+}
+
+// parseScriptNum decodes a number encoded by scriptNum on at most
+// maxSize bytes, rejecting the encodings that are not minimal
+func parseScriptNum(item []byte, maxSize int) (int64, error) {
+	if len(item) > maxSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrBadScriptNumber, len(item))
+	}
+	if len(item) == 0 {
+		return 0, nil
+	}
+	last := item[len(item)-1]
+	// a last byte without other bits than the sign is only needed when
+	// the previous byte uses its highest bit
+	if last&0x7f == 0 && (len(item) == 1 || item[len(item)-2]&0x80 == 0) {
+		return 0, fmt.Errorf("%w: not minimal", ErrBadScriptNumber)
+	}
+	var n int64
+	for i := len(item) - 1; i >= 0; i-- {
+		n = n<<8 | int64(item[i])
+	}
+	if last&0x80 != 0 {
+		n &^= int64(0x80) << (8 * uint(len(item)-1))
+		n = -n
+	}
+	return n, nil
+}
+
+// scriptOp is an opcode of a script, with its data for a push
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+// parseScript splits a script into its opcodes
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > maxScriptSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrScriptTooBig, len(script))
+	}
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		op := scriptOp{code: script[i]}
+		i++
+		var size int
+		switch {
+		case op.code < OP_PUSHDATA1:
+			size = int(op.code)
+		case op.code == OP_PUSHDATA1:
+			if i+1 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrScriptMalformed)
+			}
+			size = int(script[i])
+			i++
+		case op.code == OP_PUSHDATA2:
+			if i+2 > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrScriptMalformed)
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		}
+		if isPush(op.code) {
+			if i+size > len(script) {
+				return nil, fmt.Errorf("%w: truncated push", ErrScriptMalformed)
+			}
+			op.data = script[i : i+size]
+			i += size
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// isPush reports whether the opcode pushes data or a small number
+func isPush(code byte) bool {
+	return code <= OP_PUSHDATA2 || (code >= OP_1 && code <= OP_16)
+}
+
+// DisasmScript returns a human-readable form of the script
+func DisasmScript(script []byte) (string, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, op := range ops {
+		switch {
+		case op.code >= OP_1 && op.code <= OP_16:
+			parts = append(parts, fmt.Sprintf("OP_%d", op.code-OP_1+1))
+		case op.code == OP_0:
+			parts = append(parts, "OP_0")
+		case isPush(op.code):
+			parts = append(parts, fmt.Sprintf("%x", op.data))
+		case opcodeNames[op.code] != "":
+			parts = append(parts, opcodeNames[op.code])
+		default:
+			parts = append(parts, fmt.Sprintf("OP_UNKNOWN(%#02x)", op.code))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// disasmOrHex returns DisasmScript of the script,
+// or its hexadecimal form when it is malformed
+func disasmOrHex(script []byte) string {
+	s, err := DisasmScript(script)
+	if err != nil {
+		return fmt.Sprintf("%x", script)
+	}
+	return s
+}
+
+// scriptChecker checks the signatures and lock times of the scripts
+// of an input against its transaction
+type scriptChecker interface {
+	checkSig(signature, pubKey []byte) bool
+	checkLockTime(lockTime int64) error
+}
+
+// inputChecker is the scriptChecker of the input idx of a transaction,
+// which spends prevOut
+type inputChecker struct {
+	tx      *Transaction
+	idx     int
+	prevOut TXOutput
+	digest  []byte
+}
+
+func (c *inputChecker) checkSig(signature, pubKey []byte) bool {
+	if c.digest == nil {
+		c.digest = c.tx.SignatureHash(c.idx, c.prevOut)
+	}
+	return verifyDigest(pubKey, c.digest, signature)
+}
+
+// checkLockTime checks that the transaction cannot be in a block before
+// the lock time: both are heights or both timestamps, see
+// Transaction.IsFinal, and the one of the transaction is not earlier
+func (c *inputChecker) checkLockTime(lockTime int64) error {
+	txLockTime := int64(c.tx.LockTime)
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return fmt.Errorf("%w: %d and %d are not of the same kind", ErrLockTime, lockTime, txLockTime)
+	}
+	if lockTime > txLockTime {
+		return fmt.Errorf("%w: %d > %d", ErrLockTime, lockTime, txLockTime)
+	}
+	return nil
+}
+
+// scriptStack is the stack of the script engine
+type scriptStack [][]byte
+
+func (s *scriptStack) push(item []byte) error {
+	if len(*s) >= maxStackSize {
+		return fmt.Errorf("%w: %d stack items", ErrScriptTooBig, len(*s))
+	}
+	*s = append(*s, item)
+	return nil
+}
+
+func (s *scriptStack) pop() ([]byte, error) {
+	item, err := s.peek()
+	if err != nil {
+		return nil, err
+	}
+	*s = (*s)[:len(*s)-1]
+	return item, nil
+}
+
+func (s *scriptStack) peek() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	return (*s)[len(*s)-1], nil
+}
+
+func (s *scriptStack) popInt() (int64, error) {
+	item, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	return parseScriptNum(item, maxScriptNumSize)
+}
+
+// asBool returns the truth value of a stack item:
+// false for zero, negative zero included, true otherwise
+func asBool(item []byte) bool {
+	for i, b := range item {
+		if b != 0 {
+			return i != len(item)-1 || b != 0x80
+		}
+	}
+	return false
+}
+
+func boolItem(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return nil
+}
+
+// verifyScripts runs the unlocking script then the locking script on the
+// resulting stack, and succeeds when they leave a true value on top.
+// When the locking script is a P2SHScript, the redeem script must then
+// succeed as well.
+func verifyScripts(unlocking, locking []byte, checker scriptChecker) error {
+	unlockingOps, err := parseScript(unlocking)
+	if err != nil {
+		return err
+	}
+	for _, op := range unlockingOps {
+		if !isPush(op.code) {
+			return ErrNotPushOnly
+		}
+	}
+	lockingOps, err := parseScript(locking)
+	if err != nil {
+		return err
+	}
+
+	var stack scriptStack
+	if err := executeScript(unlockingOps, &stack, checker); err != nil {
+		return err
+	}
+	_, isP2SH := p2shHash(locking)
+	redeemStack := append(scriptStack(nil), stack...)
+	if err := executeScript(lockingOps, &stack, checker); err != nil {
+		return err
+	}
+	if err := checkTop(stack); err != nil || !isP2SH {
+		return err
+	}
+
+	// the locking script checked the hash of the redeem script
+	redeem, err := redeemStack.pop()
+	if err != nil {
+		return err
+	}
+	redeemOps, err := parseScript(redeem)
+	if err != nil {
+		return err
+	}
+	if err := executeScript(redeemOps, &redeemStack, checker); err != nil {
+		return err
+	}
+	return checkTop(redeemStack)
+}
+
+// checkTop succeeds when the stack has a true value on top
+func checkTop(stack scriptStack) error {
+	top, err := stack.peek()
+	if err != nil || !asBool(top) {
+		return ErrScriptFalse
+	}
+	return nil
+}
+
+// executeScript runs the opcodes of a script on the stack
+func executeScript(ops []scriptOp, stack *scriptStack, checker scriptChecker) error {
+	for _, op := range ops {
+		if err := executeOp(op, stack, checker); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func executeOp(op scriptOp, stack *scriptStack, checker scriptChecker) error {
+	switch {
+	case op.code >= OP_1 && op.code <= OP_16:
+		return stack.push(scriptNum(int64(op.code-OP_1) + 1))
+	case isPush(op.code):
+		if len(op.data) > maxElementSize {
+			return fmt.Errorf("%w: push of %d bytes", ErrScriptTooBig, len(op.data))
+		}
+		return stack.push(op.data)
+	}
+
+	switch op.code {
+	case OP_VERIFY:
+		item, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		if !asBool(item) {
+			return ErrVerifyFailed
+		}
+
+	case OP_RETURN:
+		return ErrOpReturn
+
+	case OP_DROP:
+		_, err := stack.pop()
+		return err
+
+	case OP_DUP:
+		item, err := stack.peek()
+		if err != nil {
+			return err
+		}
+		return stack.push(item)
+
+	case OP_EQUAL, OP_EQUALVERIFY:
+		a, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		b, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if op.code == OP_EQUALVERIFY {
+			if !equal {
+				return ErrEqualVerifyFailed
+			}
+			return nil
+		}
+		return stack.push(boolItem(equal))
+
+	case OP_HASH160:
+		item, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		return stack.push(HashPubKey(item))
+
+	case OP_CHECKSIG:
+		pubKey, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		signature, err := stack.pop()
+		if err != nil {
+			return err
+		}
+		valid := checker.checkSig(signature, pubKey)
+		if !valid && len(signature) > 0 {
+			return ErrBadSignature
+		}
+		return stack.push(boolItem(valid))
+
+	case OP_CHECKMULTISIG:
+		valid, err := checkMultisig(stack, checker)
+		if err != nil {
+			return err
+		}
+		return stack.push(boolItem(valid))
+
+	case OP_CHECKLOCKTIMEVERIFY:
+		item, err := stack.peek()
+		if err != nil {
+			return err
+		}
+		lockTime, err := parseScriptNum(item, 5)
+		if err != nil {
+			return err
+		}
+		if lockTime < 0 {
+			return fmt.Errorf("%w: negative lock time", ErrLockTime)
+		}
+		return checker.checkLockTime(lockTime)
+
+	default:
+		return fmt.Errorf("%w: %#02x", ErrUnknownOpcode, op.code)
+	}
+	return nil
+}
+
+// checkMultisig pops <signature>... <m> <pubKey>... <n> and reports
+// whether the signatures match m of the keys, in the same order
+func checkMultisig(stack *scriptStack, checker scriptChecker) (bool, error) {
+	n, err := stack.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > maxMultisigKeys {
+		return false, fmt.Errorf("%w: %d keys", ErrTooManyKeys, n)
+	}
+	pubKeys := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubKeys[i], err = stack.pop(); err != nil {
+			return false, err
+		}
+	}
+	m, err := stack.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, fmt.Errorf("%w: %d of %d", ErrTooManyKeys, m, n)
+	}
+	signatures := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if signatures[i], err = stack.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	// each signature is checked against the keys following the key
+	// of the previous signature
+	valid := true
+	key := 0
+	for _, signature := range signatures {
+		for key < len(pubKeys) && !checker.checkSig(signature, pubKeys[key]) {
+			key++
+		}
+		if key == len(pubKeys) {
+			valid = false
+			break
+		}
+		key++
+	}
+	if !valid {
+		for _, signature := range signatures {
+			if len(signature) > 0 {
+				return false, ErrBadSignature
+			}
+		}
+	}
+	return valid, nil
+}
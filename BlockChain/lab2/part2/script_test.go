@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newScriptSpend returns a transaction of the first test user spending
+// the first output of prev, a coinbase paid to testMinerAddress, to the
+// given outputs
+func newScriptSpend(t *testing.T, bc *Blockchain, prev *Transaction, outs ...TXOutput) *Transaction {
+	tx := &Transaction{
+		Vin: []TXInput{{
+			Txid:   prev.ID,
+			OutIdx: 0,
+			PubKey: Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
+		}},
+		Vout: outs,
+	}
+	tx.ID = tx.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	if err := bc.SignTransaction(tx, *privKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// newScriptInput returns a transaction spending the output 0 of prev
+// to Leander, with the unlocking script built by unlock from the
+// transaction
+func newScriptInput(prev *Transaction, lockTime uint32, unlock func(tx *Transaction) []byte) *Transaction {
+	tx := &Transaction{
+		Vin:      []TXInput{{Txid: prev.ID, OutIdx: 0}},
+		Vout:     []TXOutput{{Value: prev.Vout[0].Value, PubKeyHash: Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")}},
+		LockTime: lockTime,
+	}
+	tx.Vin[0].ScriptSig = unlock(tx)
+	tx.ID = tx.Hash()
+	return tx
+}
+
+func mustSignInput(t *testing.T, tx *Transaction, prevOut TXOutput, privKey ecdsa.PrivateKey) []byte {
+	signature, err := tx.SignInput(0, prevOut, privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestScriptNum(t *testing.T) {
+	for _, test := range []struct {
+		n       int64
+		encoded string
+	}{
+		{0, ""},
+		{1, "01"},
+		{-1, "81"},
+		{127, "7f"},
+		{128, "8000"},
+		{-128, "8080"},
+		{255, "ff00"},
+		{256, "0001"},
+		{-256, "0081"},
+		{1 << 31, "0000008000"},
+	} {
+		assert.Equal(t, test.encoded, hex.EncodeToString(scriptNum(test.n)))
+		n, err := parseScriptNum(Hex2Bytes(test.encoded), 5)
+		assert.Nil(t, err)
+		assert.Equal(t, test.n, n)
+	}
+
+	for _, encoded := range []string{"00", "80", "0100", "ff0080"} {
+		_, err := parseScriptNum(Hex2Bytes(encoded), 5)
+		assert.ErrorIs(t, err, ErrBadScriptNumber, encoded)
+	}
+	_, err := parseScriptNum(Hex2Bytes("0000008000"), maxScriptNumSize)
+	assert.ErrorIs(t, err, ErrBadScriptNumber)
+}
+
+func TestParseScript(t *testing.T) {
+	pkh := Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38")
+	script, err := DisasmScript(P2PKHScript(pkh))
+	assert.Nil(t, err)
+	assert.Equal(t, "OP_DUP OP_HASH160 2b02ea4c157844ec0b034fdde3379726ea228b38 OP_EQUALVERIFY OP_CHECKSIG", script)
+
+	script, err = DisasmScript(TimelockScript(100, pkh))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(script, "64 OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP"), script)
+
+	// the pushes of each size
+	for _, size := range []int{0, 1, 75, 76, 255, 256, maxElementSize} {
+		data := make([]byte, size)
+		ops, err := parseScript(pushData(data))
+		assert.Nil(t, err)
+		assert.Len(t, ops, 1)
+		assert.Equal(t, size, len(ops[0].data))
+	}
+
+	for _, script := range []string{"02ff", "4c", "4c02ff", "4d01", "4d0200ff"} {
+		_, err := parseScript(Hex2Bytes(script))
+		assert.ErrorIs(t, err, ErrScriptMalformed, script)
+	}
+	_, err = parseScript(make([]byte, maxScriptSize+1))
+	assert.ErrorIs(t, err, ErrScriptTooBig)
+}
+
+func TestP2PKHScript(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 2)
+	privKey, pubKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	otherKey, otherPubKey := newKeyPair()
+	prevOut := TXOutput{Value: 9, Script: P2PKHScript(HashPubKey(pubKeyToByte(*pubKey)))}
+	prev := newScriptSpend(t, bc, coinbases[0], prevOut)
+	mustStoreBlock(t, bc, mineTestBlock(t, bc.CurrentBlock(), "p2pkh", prev))
+
+	unlock := func(key ecdsa.PrivateKey, pub []byte) func(*Transaction) []byte {
+		return func(tx *Transaction) []byte {
+			return NewScriptBuilder().AddData(mustSignInput(t, tx, prevOut, key)).AddData(pub).Script()
+		}
+	}
+	valid := newScriptInput(prev, 0, unlock(*privKey, pubKeyToByte(*pubKey)))
+	assert.Nil(t, valid.VerifyInputScript(0, prevOut))
+	assert.Nil(t, bc.CheckTransaction(valid))
+
+	wrongKey := newScriptInput(prev, 0, unlock(otherKey, otherPubKey))
+	assert.ErrorIs(t, wrongKey.VerifyInputScript(0, prevOut), ErrEqualVerifyFailed)
+	assert.ErrorIs(t, bc.CheckTransaction(wrongKey), ErrEqualVerifyFailed)
+
+	badSignature := newScriptInput(prev, 0, unlock(otherKey, pubKeyToByte(*pubKey)))
+	assert.ErrorIs(t, badSignature.VerifyInputScript(0, prevOut), ErrBadSignature)
+
+	noSignature := newScriptInput(prev, 0, func(*Transaction) []byte {
+		return NewScriptBuilder().AddOp(OP_0).AddData(pubKeyToByte(*pubKey)).Script()
+	})
+	assert.ErrorIs(t, noSignature.VerifyInputScript(0, prevOut), ErrScriptFalse)
+
+	notPushOnly := newScriptInput(prev, 0, func(tx *Transaction) []byte {
+		return append(unlock(*privKey, pubKeyToByte(*pubKey))(tx), OP_DUP)
+	})
+	assert.ErrorIs(t, notPushOnly.VerifyInputScript(0, prevOut), ErrNotPushOnly)
+
+	noSignatureItem := newScriptInput(prev, 0, func(*Transaction) []byte {
+		return NewScriptBuilder().AddData(pubKeyToByte(*pubKey)).Script()
+	})
+	assert.ErrorIs(t, noSignatureItem.VerifyInputScript(0, prevOut), ErrStackUnderflow)
+
+	// an empty ScriptSig is no ScriptSig, as it decodes to nil
+	empty := newScriptInput(prev, 0, func(*Transaction) []byte { return []byte{} })
+	assert.Equal(t, empty.Vin[0].UnlockingScript(), NewScriptBuilder().AddData(nil).AddData(nil).Script())
+	assert.ErrorIs(t, empty.VerifyInputScript(0, prevOut), ErrEqualVerifyFailed)
+
+	// the outputs locked with a PubKeyHash are P2PKH outputs
+	legacy := TXOutput{Value: 9, PubKeyHash: HashPubKey(pubKeyToByte(*pubKey))}
+	assert.Equal(t, P2PKHScript(legacy.PubKeyHash), legacy.LockingScript())
+	tx := newScriptInput(prev, 0, func(*Transaction) []byte { return nil })
+	tx.Vin[0].PubKey = pubKeyToByte(*pubKey)
+	tx.Vin[0].Signature = mustSignInput(t, tx, legacy, *privKey)
+	assert.Nil(t, tx.VerifyInputScript(0, legacy))
+}
+
+func TestMultisigScript(t *testing.T) {
+	var keys []ecdsa.PrivateKey
+	var pubKeys [][]byte
+	for i := 0; i < 3; i++ {
+		key, pubKey := newKeyPair()
+		keys = append(keys, key)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	script, err := MultisigScript(2, pubKeys)
+	assert.Nil(t, err)
+	prevOut := TXOutput{Value: 10, Script: script}
+	prev := &Transaction{ID: Hex2Bytes("01"), Vout: []TXOutput{prevOut}}
+
+	signedBy := func(signers ...int) *Transaction {
+		return newScriptInput(prev, 0, func(tx *Transaction) []byte {
+			b := NewScriptBuilder()
+			for _, i := range signers {
+				if i < 0 {
+					b.AddOp(OP_0)
+				} else {
+					b.AddData(mustSignInput(t, tx, prevOut, keys[i]))
+				}
+			}
+			return b.Script()
+		})
+	}
+
+	for _, signers := range [][]int{{0, 1}, {0, 2}, {1, 2}} {
+		assert.Nil(t, signedBy(signers...).VerifyInputScript(0, prevOut), "signed by %v", signers)
+	}
+	assert.ErrorIs(t, signedBy(1, 0).VerifyInputScript(0, prevOut), ErrBadSignature, "not in the order of the keys")
+	assert.ErrorIs(t, signedBy(0, 0).VerifyInputScript(0, prevOut), ErrBadSignature, "same key twice")
+	assert.ErrorIs(t, signedBy(-1, -1).VerifyInputScript(0, prevOut), ErrScriptFalse)
+	assert.ErrorIs(t, signedBy(0).VerifyInputScript(0, prevOut), ErrStackUnderflow)
+
+	_, err = MultisigScript(3, pubKeys[:2])
+	assert.ErrorIs(t, err, ErrTooManyKeys)
+	_, err = MultisigScript(0, pubKeys)
+	assert.ErrorIs(t, err, ErrTooManyKeys)
+	_, err = MultisigScript(1, [][]byte{Hex2Bytes("0102")})
+	assert.ErrorIs(t, err, ErrInvalidPubKey)
+}
+
+func TestTimelockScript(t *testing.T) {
+	privKey, pubKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	pub := pubKeyToByte(*pubKey)
+
+	for _, test := range []struct {
+		name     string
+		lock     uint32
+		lockTime uint32
+		err      error
+	}{
+		{"height reached", 100, 100, nil},
+		{"later height", 100, 150, nil},
+		{"height not reached", 100, 99, ErrLockTime},
+		{"no lock time", 100, 0, ErrLockTime},
+		{"timestamp reached", uint32(TestBlockTime), uint32(TestBlockTime), nil},
+		{"timestamp not reached", uint32(TestBlockTime), uint32(TestBlockTime - 1), ErrLockTime},
+		{"height for a timestamp", uint32(TestBlockTime), 100, ErrLockTime},
+		{"timestamp for a height", 100, uint32(TestBlockTime), ErrLockTime},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			prevOut := TXOutput{Value: 10, Script: TimelockScript(test.lock, HashPubKey(pub))}
+			prev := &Transaction{ID: Hex2Bytes("01"), Vout: []TXOutput{prevOut}}
+			tx := newScriptInput(prev, test.lockTime, func(tx *Transaction) []byte {
+				return NewScriptBuilder().AddData(mustSignInput(t, tx, prevOut, *privKey)).AddData(pub).Script()
+			})
+			err := tx.VerifyInputScript(0, prevOut)
+			if test.err == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestTransactionLockTime(t *testing.T) {
+	tx := Transaction{LockTime: 10}
+	assert.False(t, tx.IsFinal(10, TestBlockTime))
+	assert.True(t, tx.IsFinal(11, TestBlockTime))
+	tx.LockTime = uint32(TestBlockTime)
+	assert.False(t, tx.IsFinal(1<<20, TestBlockTime))
+	assert.True(t, tx.IsFinal(0, TestBlockTime+1))
+	tx.LockTime = 0
+	assert.True(t, tx.IsFinal(0, 0))
+
+	bc, coinbases := newMempoolTestChain(t, 2)
+	tip := bc.CurrentBlock()
+	locked := newTestSpend(t, bc, coinbases[0], 9)
+	locked.LockTime = uint32(bc.Height() + 1)
+	locked.ID = locked.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	assert.Nil(t, bc.SignTransaction(locked, *privKey))
+	assert.ErrorIs(t, bc.CheckTransaction(locked), ErrNonFinalTx)
+	assert.ErrorIs(t, bc.CheckBlock(mineTestBlock(t, tip, "locked", locked)), ErrNonFinalTx)
+
+	// the lock time is signed
+	unlocked := *locked
+	unlocked.LockTime = uint32(bc.Height())
+	unlocked.ID = unlocked.Hash()
+	assert.ErrorIs(t, bc.CheckTransaction(&unlocked), ErrBadSignature)
+
+	mustStoreBlock(t, bc, mineTestBlock(t, tip, "next"))
+	assert.Nil(t, bc.CheckTransaction(locked))
+	assert.Nil(t, bc.CheckBlock(mineTestBlock(t, bc.CurrentBlock(), "unlocked", locked)))
+}
+
+func TestDataScript(t *testing.T) {
+	script, err := DataScript([]byte("hello"))
+	assert.Nil(t, err)
+	out := TXOutput{Script: script}
+	assert.True(t, out.IsUnspendable())
+	assert.Equal(t, "{0, OP_RETURN 68656c6c6f}", out.String())
+
+	prev := &Transaction{ID: Hex2Bytes("01"), Vout: []TXOutput{out}}
+	tx := newScriptInput(prev, 0, func(*Transaction) []byte { return nil })
+	assert.ErrorIs(t, tx.VerifyInputScript(0, out), ErrOpReturn)
+
+	_, err = DataScript(make([]byte, maxDataCarrierSize+1))
+	assert.ErrorIs(t, err, ErrScriptTooBig)
+}
+
+func TestScriptOutputsInChain(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 2)
+	key1, pubKey1 := newKeyPair()
+	key2, pubKey2 := newKeyPair()
+	multisig, err := MultisigScript(2, [][]byte{pubKey1, pubKey2})
+	assert.Nil(t, err)
+	data, err := DataScript([]byte("data"))
+	assert.Nil(t, err)
+
+	pay := newScriptSpend(t, bc, coinbases[0], TXOutput{Value: 9, Script: multisig}, TXOutput{Script: data})
+	assert.Nil(t, bc.CheckTransaction(pay))
+	decoded, err := DeserializeTransaction(pay.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, pay, decoded)
+	mustStoreBlock(t, bc, mineTestBlock(t, bc.CurrentBlock(), "script", pay))
+
+	// the data output is not in the UTXO index, the multisig one is
+	// found by the hash of its script
+	utxos := bc.UTXOIndex()
+	_, ok := utxos.FindOutput(pay.ID, 1)
+	assert.False(t, ok)
+	balance, outputs := utxos.FindSpendableOutputs(HashPubKey(multisig), 9)
+	assert.Equal(t, 9, balance)
+	assert.Equal(t, map[string][]int{hex.EncodeToString(pay.ID): {0}}, outputs)
+
+	prevOut := pay.Vout[0]
+	spend := newScriptInput(pay, 0, func(tx *Transaction) []byte {
+		return NewScriptBuilder().AddData(mustSignInput(t, tx, prevOut, key1)).
+			AddData(mustSignInput(t, tx, prevOut, key2)).Script()
+	})
+	assert.Nil(t, bc.CheckTransaction(spend))
+	assert.True(t, bc.VerifyTransaction(spend))
+	mustStoreBlock(t, bc, mineTestBlock(t, bc.CurrentBlock(), "multisig", spend))
+	_, ok = utxos.FindOutput(pay.ID, 0)
+	assert.False(t, ok)
+
+	// a single signature does not unlock it
+	oneSignature := newScriptInput(pay, 0, func(tx *Transaction) []byte {
+		return NewScriptBuilder().AddData(mustSignInput(t, tx, prevOut, key1)).AddOp(OP_0).Script()
+	})
+	assert.ErrorIs(t, checkInputs(oneSignature, []TXOutput{prevOut}), ErrBadSignature)
+}
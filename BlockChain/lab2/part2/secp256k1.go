@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// The secp256k1 curve of Bitcoin, y² = x³ + 7
+// https://www.secg.org/sec2-v2.pdf
+//
+// The standard library only has the NIST curves, so secp256k1 is an
+// elliptic.Curve of its own, in Jacobian coordinates on math/big. It is
+// not constant time: good enough for a toy chain, not for real money.
+//
+// The Schnorr keys, see Secp256k1Schnorr, are on a copy of the curve,
+// so that the scheme of a key is known from its curve alone, see
+// SchemeOfKey.
+
+var ErrSchnorrNonce = errors.New("schnorr nonce is zero")
+
+type secp256k1Curve struct {
+	params *elliptic.CurveParams
+}
+
+var (
+	secp256k1Params = &elliptic.CurveParams{
+		P:       hexInt("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"),
+		N:       hexInt("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"),
+		B:       big.NewInt(7),
+		Gx:      hexInt("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		Gy:      hexInt("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+		BitSize: 256,
+		Name:    "secp256k1",
+	}
+	secp256k1 = &secp256k1Curve{secp256k1Params}
+
+	// secp256k1Schnorr is the curve of the Schnorr keys
+	secp256k1Schnorr = &secp256k1Curve{&elliptic.CurveParams{
+		P: secp256k1Params.P, N: secp256k1Params.N, B: secp256k1Params.B,
+		Gx: secp256k1Params.Gx, Gy: secp256k1Params.Gy,
+		BitSize: 256, Name: "secp256k1-schnorr",
+	}}
+)
+
+func hexInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 16)
+	return n
+}
+
+func (c *secp256k1Curve) Params() *elliptic.CurveParams {
+	return c.params
+}
+
+func (c *secp256k1Curve) IsOnCurve(x, y *big.Int) bool {
+	p := c.params.P
+	if x.Sign() < 0 || x.Cmp(p) >= 0 || y.Sign() < 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, p)
+	return y2.Cmp(c.polynomial(x)) == 0
+}
+
+// polynomial returns x³ + 7
+func (c *secp256k1Curve) polynomial(x *big.Int) *big.Int {
+	x3 := new(big.Int).Mul(x, x)
+	x3.Mul(x3, x)
+	x3.Add(x3, c.params.B)
+	return x3.Mod(x3, c.params.P)
+}
+
+// jacobianPoint is the point (x/z², y/z³), the point at infinity when z is 0
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+// toJacobian returns the point (x, y), where (0, 0) is the point at infinity
+func toJacobian(x, y *big.Int) jacobianPoint {
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	return jacobianPoint{new(big.Int).Set(x), new(big.Int).Set(y), big.NewInt(1)}
+}
+
+func (c *secp256k1Curve) fromJacobian(pt jacobianPoint) (*big.Int, *big.Int) {
+	if pt.z.Sign() == 0 {
+		return new(big.Int), new(big.Int)
+	}
+	p := c.params.P
+	zinv := new(big.Int).ModInverse(pt.z, p)
+	zinv2 := new(big.Int).Mul(zinv, zinv)
+	x := new(big.Int).Mul(pt.x, zinv2)
+	x.Mod(x, p)
+	y := zinv2.Mul(zinv2, zinv)
+	y.Mul(y, pt.y)
+	y.Mod(y, p)
+	return x, y
+}
+
+// double returns 2·pt, see dbl-2009-l of the Explicit-Formulas Database
+func (c *secp256k1Curve) double(pt jacobianPoint) jacobianPoint {
+	p := c.params.P
+	if pt.z.Sign() == 0 || pt.y.Sign() == 0 {
+		return jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	a := new(big.Int).Mul(pt.x, pt.x)
+	a.Mod(a, p)
+	b := new(big.Int).Mul(pt.y, pt.y)
+	b.Mod(b, p)
+	cc := new(big.Int).Mul(b, b)
+	cc.Mod(cc, p)
+	// d = 2·((x + b)² - a - cc)
+	d := new(big.Int).Add(pt.x, b)
+	d.Mul(d, d)
+	d.Sub(d, a)
+	d.Sub(d, cc)
+	d.Lsh(d, 1)
+	d.Mod(d, p)
+	e := new(big.Int).Lsh(a, 1)
+	e.Add(e, a)
+	f := new(big.Int).Mul(e, e)
+
+	x3 := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1))
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(d, x3)
+	y3.Mul(y3, e)
+	y3.Sub(y3, cc.Lsh(cc, 3))
+	y3.Mod(y3, p)
+	z3 := new(big.Int).Mul(pt.y, pt.z)
+	z3.Lsh(z3, 1)
+	z3.Mod(z3, p)
+	return jacobianPoint{x3, y3, z3}
+}
+
+// add returns p1 + p2, see add-2007-bl of the Explicit-Formulas Database
+func (c *secp256k1Curve) add(p1, p2 jacobianPoint) jacobianPoint {
+	p := c.params.P
+	if p1.z.Sign() == 0 {
+		return p2
+	}
+	if p2.z.Sign() == 0 {
+		return p1
+	}
+	z1z1 := new(big.Int).Mul(p1.z, p1.z)
+	z1z1.Mod(z1z1, p)
+	z2z2 := new(big.Int).Mul(p2.z, p2.z)
+	z2z2.Mod(z2z2, p)
+	u1 := new(big.Int).Mul(p1.x, z2z2)
+	u1.Mod(u1, p)
+	u2 := new(big.Int).Mul(p2.x, z1z1)
+	u2.Mod(u2, p)
+	s1 := new(big.Int).Mul(p1.y, p2.z)
+	s1.Mul(s1, z2z2)
+	s1.Mod(s1, p)
+	s2 := new(big.Int).Mul(p2.y, p1.z)
+	s2.Mul(s2, z1z1)
+	s2.Mod(s2, p)
+
+	h := new(big.Int).Sub(u2, u1)
+	h.Mod(h, p)
+	r := new(big.Int).Sub(s2, s1)
+	r.Mod(r, p)
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return c.double(p1)
+		}
+		return jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	hh := new(big.Int).Mul(h, h)
+	hh.Mod(hh, p)
+	hhh := new(big.Int).Mul(h, hh)
+	hhh.Mod(hhh, p)
+	v := new(big.Int).Mul(u1, hh)
+	v.Mod(v, p)
+
+	x3 := new(big.Int).Mul(r, r)
+	x3.Sub(x3, hhh)
+	x3.Sub(x3, new(big.Int).Lsh(v, 1))
+	x3.Mod(x3, p)
+	y3 := new(big.Int).Sub(v, x3)
+	y3.Mul(y3, r)
+	y3.Sub(y3, s1.Mul(s1, hhh))
+	y3.Mod(y3, p)
+	z3 := new(big.Int).Mul(p1.z, p2.z)
+	z3.Mul(z3, h)
+	z3.Mod(z3, p)
+	return jacobianPoint{x3, y3, z3}
+}
+
+func (c *secp256k1Curve) Add(x1, y1, x2, y2 *big.Int) (*big.Int, *big.Int) {
+	return c.fromJacobian(c.add(toJacobian(x1, y1), toJacobian(x2, y2)))
+}
+
+func (c *secp256k1Curve) Double(x1, y1 *big.Int) (*big.Int, *big.Int) {
+	return c.fromJacobian(c.double(toJacobian(x1, y1)))
+}
+
+func (c *secp256k1Curve) ScalarMult(x1, y1 *big.Int, k []byte) (*big.Int, *big.Int) {
+	pt := toJacobian(x1, y1)
+	acc := jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	for _, b := range k {
+		for bit := 7; bit >= 0; bit-- {
+			acc = c.double(acc)
+			if b>>uint(bit)&1 == 1 {
+				acc = c.add(acc, pt)
+			}
+		}
+	}
+	return c.fromJacobian(acc)
+}
+
+func (c *secp256k1Curve) ScalarBaseMult(k []byte) (*big.Int, *big.Int) {
+	return c.ScalarMult(c.params.Gx, c.params.Gy, k)
+}
+
+// liftX returns the point of the curve with the x coordinate
+// and an even y, or nil when there is none
+func (c *secp256k1Curve) liftX(x *big.Int) (*big.Int, *big.Int) {
+	p := c.params.P
+	if x.Cmp(p) >= 0 {
+		return nil, nil
+	}
+	y2 := c.polynomial(x)
+	// p = 3 mod 4, so the square root is y2^((p+1)/4)
+	exp := new(big.Int).Add(p, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(y2, exp, p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(y2) != 0 {
+		return nil, nil
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(p, y)
+	}
+	return x, y
+}
+
+// marshalCompressed returns the 33 bytes SEC1 compressed encoding of the point
+func (c *secp256k1Curve) marshalCompressed(x, y *big.Int) []byte {
+	pubKey := make([]byte, 33)
+	pubKey[0] = byte(2 + y.Bit(0))
+	x.FillBytes(pubKey[1:])
+	return pubKey
+}
+
+// unmarshalCompressed decodes a point encoded by marshalCompressed,
+// or returns nil
+func (c *secp256k1Curve) unmarshalCompressed(pubKey []byte) (*big.Int, *big.Int) {
+	if len(pubKey) != 33 || (pubKey[0] != 2 && pubKey[0] != 3) {
+		return nil, nil
+	}
+	x, y := c.liftX(new(big.Int).SetBytes(pubKey[1:]))
+	if x == nil {
+		return nil, nil
+	}
+	if y.Bit(0) != uint(pubKey[0]-2) {
+		y.Sub(c.params.P, y)
+	}
+	return x, y
+}
+
+// Schnorr signatures, as in BIP340
+// https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki
+//
+// The public key is the x coordinate of the point with an even y, and the
+// signature is the x coordinate of the nonce point R followed by s, 64
+// bytes. Unlike ECDSA, a signature cannot be modified into another valid
+// one, so there is no low S rule.
+
+// taggedHash returns sha256(sha256(tag) | sha256(tag) | data...)
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func bytes32(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
+
+// schnorrSign signs the message with the private key d, mixing the
+// auxiliary random data aux in the nonce
+func schnorrSign(d *big.Int, msg, aux []byte) ([]byte, error) {
+	curve := secp256k1
+	n := curve.params.N
+	if d.Sign() <= 0 || d.Cmp(n) >= 0 {
+		return nil, ErrInvalidWalletKey
+	}
+	px, py := curve.ScalarBaseMult(bytes32(d))
+	if py.Bit(0) == 1 {
+		d = new(big.Int).Sub(n, d)
+	}
+	t := taggedHash("BIP0340/aux", aux)
+	for i, b := range bytes32(d) {
+		t[i] ^= b
+	}
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, bytes32(px), msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, ErrSchnorrNonce
+	}
+	rx, ry := curve.ScalarBaseMult(bytes32(k))
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", bytes32(rx), bytes32(px), msg))
+	e.Mod(e, n)
+	s := e.Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+	return append(bytes32(rx), bytes32(s)...), nil
+}
+
+// schnorrVerify checks a signature of the message made by the owner
+// of the x-only public key
+func schnorrVerify(pubKey, msg, signature []byte) bool {
+	curve := secp256k1
+	if len(pubKey) != 32 || len(signature) != 64 {
+		return false
+	}
+	px, py := curve.liftX(new(big.Int).SetBytes(pubKey))
+	if px == nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(curve.params.P) >= 0 || s.Cmp(curve.params.N) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", signature[:32], pubKey, msg))
+	e.Mod(e, curve.params.N)
+
+	// R = s·G - e·P
+	e.Sub(curve.params.N, e)
+	sg := toJacobian(curve.ScalarBaseMult(bytes32(s)))
+	ep := toJacobian(curve.ScalarMult(px, py, bytes32(e)))
+	rx, ry := curve.fromJacobian(curve.add(sg, ep))
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+	return ry.Bit(0) == 0 && bytes.Equal(bytes32(rx), signature[:32])
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Binary encoding of blocks and transactions
+//
+// Integers are big endian. A byte string is written as its length,
+// a uint32, followed by its bytes; an empty string and a nil one have
+// the same encoding and both decode to nil. A list is written as its
+// number of items, a uint32, followed by its items.
+//
+//	TXInput:     Txid bytes | OutIdx int32 | Signature bytes | PubKey bytes
+//	TXOutput:    Value int64 | PubKeyHash bytes
+//	Transaction: version uint32 | ID bytes | Vin []TXInput | Vout []TXOutput
+//
+// A transaction with non-empty scripts or a lock time, see script.go, has
+// the version txScriptVersion, where:
+//
+//	TXInput:     Txid bytes | OutIdx int32 | Signature bytes | PubKey bytes |
+//	             ScriptSig bytes
+//	TXOutput:    Value int64 | PubKeyHash bytes | Script bytes
+//	Transaction: version uint32 | ID bytes | Vin []TXInput | Vout []TXOutput |
+//	             LockTime uint32
+//
+// An empty script decodes to nil, so it is no script, see
+// Transaction.encodingVersion. A TXOutput encoded on its own always has
+// a Script.
+//
+//	BlockHeader: version uint32 | PrevBlockHash bytes | MerkleRoot bytes |
+//	             Timestamp int64 | Bits uint32 | Nonce int64
+//	Block:       BlockHeader | Hash bytes | Transactions []Transaction
+//
+// A PartialTransaction, see psbt.go, holds the transactions its inputs
+// spend, in their order:
+//
+//	PartialTransaction: version uint32 | Transaction | PrevTXs []Transaction
+//
+// A UTXOSnapshot, see utxo_snapshot.go, holds its genesis block as a byte
+// string and its outputs with the block of their transaction:
+//
+//	SnapshotOutput: Txid bytes | OutIdx uint32 | TXOutput | Height uint32 |
+//	                Coinbase uint32
+//	UTXOSnapshot:   version uint32 | Genesis bytes | Headers []BlockHeader |
+//	                Outputs []SnapshotOutput
+//
+// The hash of a transaction is the sha256 of its encoding with an empty
+// ID and, but for a coinbase, inputs without Signature, PubKey nor
+// ScriptSig, and the hash of a block is the sha256 of the encoding of its header.
+// A decoder must reject an encoding of an unknown version.
+
+const (
+	// txVersion is the version of the encoding of transactions
+	txVersion uint32 = 1
+	// txScriptVersion is the version of the encoding of transactions
+	// with scripts or a lock time
+	txScriptVersion uint32 = 2
+	// blockVersion is the version of the encoding of block headers
+	blockVersion uint32 = 1
+	// partialTxVersion is the version of the encoding of partial
+	// transactions, the first one held the spent outputs only
+	partialTxVersion uint32 = 2
+	// utxoSnapshotVersion is the version of the encoding of UTXO snapshots
+	utxoSnapshotVersion uint32 = 1
+)
+
+var (
+	ErrUnknownVersion = errors.New("encoding: unknown version")
+	ErrMalformed      = errors.New("encoding: malformed data")
+)
+
+// encoder appends the values of the binary encoding to a buffer
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) putUint32(v uint32) {
+	binary.Write(&e.buf, binary.BigEndian, v)
+}
+
+func (e *encoder) putInt32(v int32) {
+	binary.Write(&e.buf, binary.BigEndian, v)
+}
+
+func (e *encoder) putInt64(v int64) {
+	binary.Write(&e.buf, binary.BigEndian, v)
+}
+
+func (e *encoder) putBytes(data []byte) {
+	e.putUint32(uint32(len(data)))
+	e.buf.Write(data)
+}
+
+func (e *encoder) putInput(in TXInput, version uint32) {
+	e.putBytes(in.Txid)
+	e.putInt32(int32(in.OutIdx))
+	e.putBytes(in.Signature)
+	e.putBytes(in.PubKey)
+	if version >= txScriptVersion {
+		e.putBytes(in.ScriptSig)
+	}
+}
+
+func (e *encoder) putOutput(out TXOutput, version uint32) {
+	e.putInt64(int64(out.Value))
+	e.putBytes(out.PubKeyHash)
+	if version >= txScriptVersion {
+		e.putBytes(out.Script)
+	}
+}
+
+func (e *encoder) putTransaction(tx *Transaction) {
+	version := tx.encodingVersion()
+	e.putUint32(version)
+	e.putBytes(tx.ID)
+	e.putUint32(uint32(len(tx.Vin)))
+	for _, in := range tx.Vin {
+		e.putInput(in, version)
+	}
+	e.putUint32(uint32(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		e.putOutput(out, version)
+	}
+	if version >= txScriptVersion {
+		e.putUint32(tx.LockTime)
+	}
+}
+
+func (e *encoder) putHeader(h *BlockHeader) {
+	e.putUint32(h.Version)
+	e.putBytes(h.PrevBlockHash)
+	e.putBytes(h.MerkleRoot)
+	e.putInt64(h.Timestamp)
+	e.putUint32(h.Bits)
+	e.putInt64(int64(h.Nonce))
+}
+
+// decoder reads the values of the binary encoding. The first error is
+// kept and the following reads return zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = fmt.Errorf("%w: unexpected end of data", ErrMalformed)
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.next(4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	return int32(d.uint32())
+}
+
+func (d *decoder) int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if n == 0 {
+		return nil
+	}
+	v := d.next(int(n))
+	if v == nil {
+		return nil
+	}
+	return append([]byte(nil), v...)
+}
+
+// count reads the length of a list whose items take at least minSize
+// bytes, so a corrupted count cannot make us allocate more than the data
+func (d *decoder) count(minSize int) int {
+	n := d.uint32()
+	if d.err == nil && uint64(n)*uint64(minSize) > uint64(len(d.data)) {
+		d.err = fmt.Errorf("%w: %d items do not fit in %d bytes", ErrMalformed, n, len(d.data))
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) version(expected ...uint32) uint32 {
+	v := d.uint32()
+	if d.err != nil {
+		return v
+	}
+	for _, e := range expected {
+		if v == e {
+			return v
+		}
+	}
+	d.err = fmt.Errorf("%w: %d", ErrUnknownVersion, v)
+	return v
+}
+
+func (d *decoder) input(version uint32) TXInput {
+	in := TXInput{
+		Txid:      d.bytes(),
+		OutIdx:    int(d.int32()),
+		Signature: d.bytes(),
+		PubKey:    d.bytes(),
+	}
+	if version >= txScriptVersion {
+		in.ScriptSig = d.bytes()
+	}
+	return in
+}
+
+func (d *decoder) output(version uint32) TXOutput {
+	out := TXOutput{Value: int(d.int64()), PubKeyHash: d.bytes()}
+	if version >= txScriptVersion {
+		out.Script = d.bytes()
+	}
+	return out
+}
+
+func (d *decoder) transaction() *Transaction {
+	tx := &Transaction{}
+	version := d.version(txVersion, txScriptVersion)
+	tx.ID = d.bytes()
+	// an input takes at least 16 bytes and an output 12
+	if n := d.count(16); n > 0 {
+		tx.Vin = make([]TXInput, n)
+		for i := range tx.Vin {
+			tx.Vin[i] = d.input(version)
+		}
+	}
+	if n := d.count(12); n > 0 {
+		tx.Vout = make([]TXOutput, n)
+		for i := range tx.Vout {
+			tx.Vout[i] = d.output(version)
+		}
+	}
+	if version >= txScriptVersion {
+		tx.LockTime = d.uint32()
+	}
+	return tx
+}
+
+func (d *decoder) header() BlockHeader {
+	var h BlockHeader
+	h.Version = d.version(blockVersion)
+	h.PrevBlockHash = d.bytes()
+	h.MerkleRoot = d.bytes()
+	h.Timestamp = d.int64()
+	h.Bits = d.uint32()
+	h.Nonce = int(d.int64())
+	return h
+}
+
+// finish returns the first error of the decoder,
+// or an error if some data was not decoded
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		d.err = fmt.Errorf("%w: %d trailing bytes", ErrMalformed, len(d.data))
+	}
+	return d.err
+}
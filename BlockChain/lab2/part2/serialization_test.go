@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionEncoding(t *testing.T) {
+	tx := &Transaction{
+		ID: Hex2Bytes("aabb"),
+		Vin: []TXInput{
+			{Txid: Hex2Bytes("01"), OutIdx: 2, Signature: Hex2Bytes("0304"), PubKey: Hex2Bytes("05")},
+		},
+		Vout: []TXOutput{{Value: 7, PubKeyHash: Hex2Bytes("06")}},
+	}
+
+	// the documented encoding, see serialization.go
+	expected := Hex2Bytes("00000001" + "00000002aabb" +
+		"00000001" + "0000000101" + "00000002" + "000000020304" + "0000000105" +
+		"00000001" + "0000000000000007" + "0000000106")
+	assert.Equal(t, expected, tx.Serialize())
+
+	decoded, err := DeserializeTransaction(expected)
+	assert.Nil(t, err)
+	assert.Equal(t, tx, decoded)
+
+	// the ID and the unlocking data are not part of the hash
+	hash := tx.Hash()
+	tx.ID = nil
+	assert.Equal(t, hash, tx.Hash())
+	tx.Vin[0].Signature, tx.Vin[0].PubKey = nil, nil
+	assert.Equal(t, hash, tx.Hash())
+	assert.Equal(t, Hex2Bytes("c15e544ccd0bc594eeb3bcd8eeac618d1ebafe7f8fb5ad9515631f03e99d60a6"), hash)
+}
+
+func TestScriptTransactionEncoding(t *testing.T) {
+	tx := &Transaction{
+		ID: Hex2Bytes("aabb"),
+		Vin: []TXInput{
+			{Txid: Hex2Bytes("01"), OutIdx: 2, ScriptSig: Hex2Bytes("0107")},
+		},
+		Vout:     []TXOutput{{Value: 7, Script: Hex2Bytes("6a")}},
+		LockTime: 9,
+	}
+
+	// the documented encoding of txScriptVersion, see serialization.go
+	expected := Hex2Bytes("00000002" + "00000002aabb" +
+		"00000001" + "0000000101" + "00000002" + "00000000" + "00000000" + "000000020107" +
+		"00000001" + "0000000000000007" + "00000000" + "000000016a" +
+		"00000009")
+	assert.Equal(t, expected, tx.Serialize())
+
+	decoded, err := DeserializeTransaction(expected)
+	assert.Nil(t, err)
+	assert.Equal(t, tx, decoded)
+
+	// the scripts of the inputs are not signed, but they are hashed
+	assert.Equal(t, txScriptVersion, tx.encodingVersion())
+	tx.Vin[0].ScriptSig = nil
+	assert.Equal(t, txScriptVersion, tx.encodingVersion())
+	tx.LockTime = 0
+	assert.Equal(t, txScriptVersion, tx.encodingVersion())
+	tx.Vout[0].Script = nil
+	assert.Equal(t, txVersion, tx.encodingVersion())
+}
+
+func TestEmptyScriptEncoding(t *testing.T) {
+	tx := &Transaction{
+		Vin:  []TXInput{{Txid: Hex2Bytes("01"), OutIdx: 2, Signature: Hex2Bytes("0304"), PubKey: Hex2Bytes("05"), ScriptSig: []byte{}}},
+		Vout: []TXOutput{{Value: 7, PubKeyHash: Hex2Bytes("06"), Script: []byte{}}},
+	}
+	tx.ID = tx.Hash()
+
+	// empty scripts are no scripts, they decode to nil
+	assert.Equal(t, txVersion, tx.encodingVersion())
+	decoded, err := DeserializeTransaction(tx.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, tx.Serialize(), decoded.Serialize())
+	assert.Equal(t, tx.ID, decoded.Hash())
+	assert.Nil(t, CheckTransactionSanity(decoded))
+	assert.Equal(t, tx.Vout[0].LockingScript(), decoded.Vout[0].LockingScript())
+	assert.Equal(t, tx.Vin[0].UnlockingScript(), decoded.Vin[0].UnlockingScript())
+}
+
+func TestTransactionEncodingRoundTrip(t *testing.T) {
+	for name, tx := range testTransactions {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := DeserializeTransaction(tx.Serialize())
+			assert.Nil(t, err)
+			assert.Equal(t, tx, decoded)
+			assert.Equal(t, tx.Hash(), decoded.Hash())
+		})
+	}
+
+	out := TXOutput{Value: -3, PubKeyHash: Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38")}
+	decoded, err := DeserializeOutput(out.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, out, *decoded)
+}
+
+func TestBlockEncodingRoundTrip(t *testing.T) {
+	for name, block := range testBlockchainData {
+		t.Run(name, func(t *testing.T) {
+			data := block.Serialize()
+			decoded, err := DeserializeBlock(data)
+			assert.Nil(t, err)
+			assert.Equal(t, block, decoded)
+			assert.Equal(t, data, decoded.Serialize())
+		})
+	}
+
+	header := BlockHeader{
+		Version:       blockVersion,
+		PrevBlockHash: Hex2Bytes("0102"),
+		MerkleRoot:    Hex2Bytes("03"),
+		Timestamp:     TestBlockTime,
+		Bits:          InitialBits,
+		Nonce:         9,
+	}
+	decoded, err := DeserializeBlockHeader(header.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, header, *decoded)
+}
+
+func TestMinedBlockHash(t *testing.T) {
+	b := NewGenesisBlock(TestBlockTime, testTransactions["tx0"])
+	b.Mine()
+	header := b.BlockHeader
+	assert.Equal(t, header.Hash(), b.Hash, "the block hash is the hash of its header")
+	assert.True(t, NewProofOfWork(b).Validate())
+}
+
+func TestDecodeRejected(t *testing.T) {
+	data := testTransactions["tx1"].Serialize()
+
+	_, err := DeserializeTransaction(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = DeserializeTransaction(append(data, 0))
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	newer := append([]byte{}, data...)
+	newer[3] = 3
+	_, err = DeserializeTransaction(newer)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+
+	// a count larger than the data does not allocate
+	huge := Hex2Bytes("00000001" + "00000000" + "ffffffff")
+	_, err = DeserializeTransaction(huge)
+	assert.ErrorIs(t, err, ErrMalformed)
+
+	_, err = DeserializeBlock(Hex2Bytes("00000001"))
+	assert.ErrorIs(t, err, ErrMalformed)
+	_, err = DeserializeBlockHeader(BlockHeader{Version: 5}.Serialize())
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
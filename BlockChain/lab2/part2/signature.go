@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// SigHashAll is the hash type of every signature: the signature of an
+// input commits to all the inputs and all the outputs of the transaction
+const SigHashAll uint32 = 1
+
+var (
+	ErrInvalidSignature = errors.New("signature is not a canonical DER encoding")
+	ErrHighS            = errors.New("signature S value is not normalized")
+	ErrInvalidPubKey    = errors.New("public key is not a point of the curve")
+)
+
+// ecdsaSignature is the ASN.1 structure of a DER encoded signature
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// SignatureHash returns the digest signed by the input idx of the
+// transaction, which spends prevOut. Similar to Bitcoin's SIGHASH_ALL,
+// it is the double sha256 of the transaction without signatures, where
+// the public key of the signed input is replaced by the PubKeyHash of
+// prevOut, or its Script, and the public keys of the other inputs are
+// left empty, followed by the hash type.
+//
+// The outputs and the lock time are written as in the encoding of the
+// transaction, see serialization.go. When the transaction has scripts or
+// a lock time, the digest starts with zero inputs, which no transaction
+// has, and txScriptVersion.
+func (tx *Transaction) SignatureHash(idx int, prevOut TXOutput) []byte {
+	var e encoder
+	version := tx.signedVersion()
+	if version >= txScriptVersion {
+		e.putUint32(0)
+		e.putUint32(version)
+	}
+	e.putUint32(uint32(len(tx.Vin)))
+	for i, vin := range tx.Vin {
+		e.putBytes(vin.Txid)
+		e.putInt32(int32(vin.OutIdx))
+		switch {
+		case i != idx:
+			e.putBytes(nil)
+		case len(prevOut.Script) > 0:
+			e.putBytes(prevOut.Script)
+		default:
+			e.putBytes(prevOut.PubKeyHash)
+		}
+	}
+	e.putUint32(uint32(len(tx.Vout)))
+	for _, out := range tx.Vout {
+		e.putOutput(out, version)
+	}
+	if version >= txScriptVersion {
+		e.putUint32(tx.LockTime)
+	}
+	e.putUint32(SigHashAll)
+
+	first := sha256.Sum256(e.buf.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// signDigest signs the digest with the SignatureScheme of the key.
+// ECDSA signatures are DER encoded, see parseSignature.
+func signDigest(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	scheme, err := SchemeOfKey(privKey.Curve)
+	if err != nil {
+		return nil, err
+	}
+	return scheme.Sign(privKey, digest)
+}
+
+// verifyDigest checks a signature of the digest made by the owner
+// of the public key, with the SignatureScheme of the key
+func verifyDigest(pubKey, digest, signature []byte) bool {
+	scheme, err := SchemeOfPublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	pub, err := scheme.ParsePublicKey(pubKey)
+	if err != nil {
+		return false
+	}
+	return scheme.Verify(pub, digest, signature)
+}
+
+// parseSignature decodes a DER encoded signature, accepting only the
+// canonical encoding with R and S in range and a low S
+func parseSignature(signature []byte, curve elliptic.Curve) (*big.Int, *big.Int, error) {
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil || len(rest) != 0 {
+		return nil, nil, ErrInvalidSignature
+	}
+	// asn1 accepts some non minimal encodings, marshalling again must
+	// give back the same bytes
+	if canonical, err := asn1.Marshal(sig); err != nil || !bytes.Equal(canonical, signature) {
+		return nil, nil, ErrInvalidSignature
+	}
+	n := curve.Params().N
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.Cmp(n) >= 0 {
+		return nil, nil, ErrInvalidSignature
+	}
+	if sig.S.Cmp(halfOrder(n)) > 0 {
+		return nil, nil, ErrHighS
+	}
+	return sig.R, sig.S, nil
+}
+
+// parsePubKey decodes a public key encoded by pubKeyToByte
+func parsePubKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	scheme, err := SchemeOfPublicKey(pubKey)
+	if err != nil {
+		return nil, ErrInvalidPubKey
+	}
+	return scheme.ParsePublicKey(pubKey)
+}
+
+func halfOrder(n *big.Int) *big.Int {
+	return new(big.Int).Rsh(n, 1)
+}
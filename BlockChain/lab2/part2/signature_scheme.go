@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var ErrUnknownScheme = errors.New("unknown signature scheme")
+
+// SignatureScheme is a kind of key pair: its curve, how its public keys
+// are encoded in the inputs and scripts, and how it signs. The scheme of
+// a public key is known from the size of its encoding, see
+// SchemeOfPublicKey, and the scheme of a private key from its curve, see
+// SchemeOfKey, so the keys are ecdsa keys whatever their scheme.
+//
+// The address of a public key records its scheme in its version, see
+// GetAddress.
+type SignatureScheme interface {
+	// Name is the name of the scheme in the wallets and the PEM keys
+	Name() string
+	AddressVersion() byte
+	Curve() elliptic.Curve
+	PublicKeySize() int
+	EncodePublicKey(pub ecdsa.PublicKey) []byte
+	ParsePublicKey(pubKey []byte) (*ecdsa.PublicKey, error)
+	Sign(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error)
+	Verify(pub *ecdsa.PublicKey, digest, signature []byte) bool
+}
+
+var (
+	// P256ECDSA is the scheme of the first wallets: ECDSA on P-256 and
+	// public keys encoded as the concatenation of their coordinates
+	P256ECDSA SignatureScheme = p256ECDSA{}
+	// Secp256k1ECDSA is ECDSA on secp256k1 with compressed public keys,
+	// as in Bitcoin
+	Secp256k1ECDSA SignatureScheme = secp256k1ECDSA{}
+	// Secp256k1Schnorr is the Schnorr signature of BIP340 with x-only
+	// public keys
+	Secp256k1Schnorr SignatureScheme = secp256k1SchnorrScheme{}
+
+	// DefaultSignatureScheme is the scheme of the new keys, see newKeyPair
+	DefaultSignatureScheme = Secp256k1ECDSA
+
+	signatureSchemes = []SignatureScheme{P256ECDSA, Secp256k1ECDSA, Secp256k1Schnorr}
+)
+
+// SchemeOfKey returns the scheme of the keys on the curve
+func SchemeOfKey(curve elliptic.Curve) (SignatureScheme, error) {
+	for _, scheme := range signatureSchemes {
+		if scheme.Curve() == curve {
+			return scheme, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: curve %s", ErrUnknownScheme, curve.Params().Name)
+}
+
+// SchemeOfPublicKey returns the scheme of an encoded public key
+func SchemeOfPublicKey(pubKey []byte) (SignatureScheme, error) {
+	for _, scheme := range signatureSchemes {
+		if scheme.PublicKeySize() == len(pubKey) {
+			return scheme, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d bytes public key", ErrUnknownScheme, len(pubKey))
+}
+
+// SchemeByName returns the scheme of the name
+func SchemeByName(name string) (SignatureScheme, error) {
+	for _, scheme := range signatureSchemes {
+		if scheme.Name() == name {
+			return scheme, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownScheme, name)
+}
+
+// ecdsaSign signs the digest and returns the DER encoded signature.
+// S is normalized to the lower half of the curve order, so that the
+// signature cannot be modified into another valid one.
+func ecdsaSign(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	n := privKey.Curve.Params().N
+	if s.Cmp(halfOrder(n)) > 0 {
+		s.Sub(n, s)
+	}
+	return asn1.Marshal(ecdsaSignature{r, s})
+}
+
+// ecdsaVerify checks a DER encoded signature, see parseSignature
+func ecdsaVerify(pub *ecdsa.PublicKey, digest, signature []byte) bool {
+	r, s, err := parseSignature(signature, pub.Curve)
+	if err != nil {
+		return false
+	}
+	return ecdsa.Verify(pub, digest, r, s)
+}
+
+type p256ECDSA struct{}
+
+func (p256ECDSA) Name() string          { return "p256-ecdsa" }
+func (p256ECDSA) AddressVersion() byte  { return version }
+func (p256ECDSA) Curve() elliptic.Curve { return elliptic.P256() }
+func (p256ECDSA) PublicKeySize() int    { return 64 }
+
+// EncodePublicKey concatenates the coordinates, padded to the curve size
+// so the key always splits in two halves
+func (p256ECDSA) EncodePublicKey(pub ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, 64)
+	pub.X.FillBytes(pubKey[:32])
+	pub.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+func (p256ECDSA) ParsePublicKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	if len(pubKey) != 64 {
+		return nil, ErrInvalidPubKey
+	}
+	x := new(big.Int).SetBytes(pubKey[:32])
+	y := new(big.Int).SetBytes(pubKey[32:])
+	if !curve.IsOnCurve(x, y) {
+		return nil, ErrInvalidPubKey
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (p256ECDSA) Sign(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	return ecdsaSign(privKey, digest)
+}
+
+func (p256ECDSA) Verify(pub *ecdsa.PublicKey, digest, signature []byte) bool {
+	return ecdsaVerify(pub, digest, signature)
+}
+
+type secp256k1ECDSA struct{}
+
+func (secp256k1ECDSA) Name() string          { return "secp256k1-ecdsa" }
+func (secp256k1ECDSA) AddressVersion() byte  { return 0x01 }
+func (secp256k1ECDSA) Curve() elliptic.Curve { return secp256k1 }
+func (secp256k1ECDSA) PublicKeySize() int    { return 33 }
+
+func (secp256k1ECDSA) EncodePublicKey(pub ecdsa.PublicKey) []byte {
+	return secp256k1.marshalCompressed(pub.X, pub.Y)
+}
+
+func (secp256k1ECDSA) ParsePublicKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	x, y := secp256k1.unmarshalCompressed(pubKey)
+	if x == nil {
+		return nil, ErrInvalidPubKey
+	}
+	return &ecdsa.PublicKey{Curve: secp256k1, X: x, Y: y}, nil
+}
+
+func (secp256k1ECDSA) Sign(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	return ecdsaSign(privKey, digest)
+}
+
+func (secp256k1ECDSA) Verify(pub *ecdsa.PublicKey, digest, signature []byte) bool {
+	return ecdsaVerify(pub, digest, signature)
+}
+
+type secp256k1SchnorrScheme struct{}
+
+func (secp256k1SchnorrScheme) Name() string          { return "secp256k1-schnorr" }
+func (secp256k1SchnorrScheme) AddressVersion() byte  { return 0x02 }
+func (secp256k1SchnorrScheme) Curve() elliptic.Curve { return secp256k1Schnorr }
+func (secp256k1SchnorrScheme) PublicKeySize() int    { return 32 }
+
+func (secp256k1SchnorrScheme) EncodePublicKey(pub ecdsa.PublicKey) []byte {
+	return bytes32(pub.X)
+}
+
+// ParsePublicKey returns the point of the x-only key with an even y
+func (secp256k1SchnorrScheme) ParsePublicKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	if len(pubKey) != 32 {
+		return nil, ErrInvalidPubKey
+	}
+	x, y := secp256k1Schnorr.liftX(new(big.Int).SetBytes(pubKey))
+	if x == nil {
+		return nil, ErrInvalidPubKey
+	}
+	return &ecdsa.PublicKey{Curve: secp256k1Schnorr, X: x, Y: y}, nil
+}
+
+func (secp256k1SchnorrScheme) Sign(privKey *ecdsa.PrivateKey, digest []byte) ([]byte, error) {
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		return nil, err
+	}
+	return schnorrSign(privKey.D, digest, aux)
+}
+
+func (secp256k1SchnorrScheme) Verify(pub *ecdsa.PublicKey, digest, signature []byte) bool {
+	return schnorrVerify(bytes32(pub.X), digest, signature)
+}
+
+// newSchemeKeyPair creates a new key pair of the scheme
+func newSchemeKeyPair(scheme SignatureScheme) (ecdsa.PrivateKey, []byte) {
+	privSt, err := ecdsa.GenerateKey(scheme.Curve(), rand.Reader)
+	if err != nil {
+		return ecdsa.PrivateKey{}, nil
+	}
+	return *privSt, scheme.EncodePublicKey(privSt.PublicKey)
+}
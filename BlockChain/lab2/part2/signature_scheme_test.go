@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecp256k1Curve(t *testing.T) {
+	curve := secp256k1
+	params := curve.Params()
+	assert.True(t, curve.IsOnCurve(params.Gx, params.Gy))
+	assert.False(t, curve.IsOnCurve(params.Gx, new(big.Int).Add(params.Gy, big.NewInt(1))))
+
+	// 2G, from the addition and the doubling
+	x, y := curve.Double(params.Gx, params.Gy)
+	assert.Equal(t, "c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5", x.Text(16))
+	assert.Equal(t, "1ae168fea63dc339a3c58419466ceaeef7f632653266d0e1236431a950cfe52a", y.Text(16))
+	ax, ay := curve.Add(params.Gx, params.Gy, params.Gx, params.Gy)
+	assert.Equal(t, x, ax)
+	assert.Equal(t, y, ay)
+	sx, sy := curve.ScalarBaseMult([]byte{2})
+	assert.Equal(t, x, sx)
+	assert.Equal(t, y, sy)
+
+	// (n-1)G = -G and nG is the point at infinity
+	x, y = curve.ScalarBaseMult(new(big.Int).Sub(params.N, big.NewInt(1)).Bytes())
+	assert.Equal(t, params.Gx, x)
+	assert.Equal(t, new(big.Int).Sub(params.P, params.Gy), y)
+	x, y = curve.Add(x, y, params.Gx, params.Gy)
+	assert.Zero(t, x.Sign())
+	assert.Zero(t, y.Sign())
+	x, y = curve.ScalarBaseMult(params.N.Bytes())
+	assert.Zero(t, x.Sign())
+	assert.Zero(t, y.Sign())
+
+	// the compressed encoding of G, and of -G
+	g := curve.marshalCompressed(params.Gx, params.Gy)
+	assert.Equal(t, Hex2Bytes("0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"), g)
+	x, y = curve.unmarshalCompressed(g)
+	assert.Equal(t, params.Gx, x)
+	assert.Equal(t, params.Gy, y)
+	g[0] = 3
+	_, y = curve.unmarshalCompressed(g)
+	assert.Equal(t, new(big.Int).Sub(params.P, params.Gy), y)
+	g[0] = 4
+	x, _ = curve.unmarshalCompressed(g)
+	assert.Nil(t, x)
+}
+
+var schnorrTable = []struct {
+	privKey, pubKey, aux, msg, signature string
+	valid                                bool
+}{
+	// test vectors 0, 1 and 5 of BIP340
+	{
+		"0000000000000000000000000000000000000000000000000000000000000003",
+		"f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"e907831f80848d1069a5371b402410364bdf1c5f8307b0084c55f1ce2dca821525f66a4a85ea8b71e482a74f382d2ce5ebeee8fdb2172f477df4900d310536c0",
+		true,
+	},
+	{
+		"b7e151628aed2a6abf7158809cf4f3c762e7160f38b4da56a784d9045190cfef",
+		"dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+		"0000000000000000000000000000000000000000000000000000000000000001",
+		"243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+		"6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a",
+		true,
+	},
+	{
+		"",
+		"eefdea4cdb677750a420fee807eacf21eb9898ae79b9768766e4faa04a2d4a34",
+		"",
+		"243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89",
+		"6cff5c3ba86c69ea4b7376f31a9bcb4f74c1976089b2d9963da2e5543e17776969e89b4c5564d00349106b8497785dd7d1d713a8ae82b32fa79d5f7fc407d39b",
+		false,
+	},
+}
+
+func TestSchnorrVectors(t *testing.T) {
+	for i, test := range schnorrTable {
+		if test.privKey != "" {
+			signature, err := schnorrSign(new(big.Int).SetBytes(Hex2Bytes(test.privKey)), Hex2Bytes(test.msg), Hex2Bytes(test.aux))
+			assert.Nil(t, err)
+			assert.Equalf(t, test.signature, hex.EncodeToString(signature), "vector %d", i)
+
+			x, _ := secp256k1.ScalarBaseMult(Hex2Bytes(test.privKey))
+			assert.Equal(t, test.pubKey, hex.EncodeToString(bytes32(x)))
+		}
+		assert.Equalf(t, test.valid, schnorrVerify(Hex2Bytes(test.pubKey), Hex2Bytes(test.msg), Hex2Bytes(test.signature)), "vector %d", i)
+	}
+
+	// any change of the signature or the message is detected
+	test := schnorrTable[1]
+	signature := Hex2Bytes(test.signature)
+	for _, i := range []int{0, 31, 32, 63} {
+		tampered := append([]byte{}, signature...)
+		tampered[i] ^= 1
+		assert.False(t, schnorrVerify(Hex2Bytes(test.pubKey), Hex2Bytes(test.msg), tampered))
+	}
+	assert.False(t, schnorrVerify(Hex2Bytes(test.pubKey), Hex2Bytes(test.aux), signature))
+	assert.False(t, schnorrVerify(Hex2Bytes(test.pubKey), Hex2Bytes(test.msg), signature[:63]))
+}
+
+func TestSignatureSchemes(t *testing.T) {
+	digest := make([]byte, 32)
+	rand.Read(digest)
+	versions := make(map[byte]bool)
+	for _, scheme := range signatureSchemes {
+		privKey, pubKey := newSchemeKeyPair(scheme)
+		assert.Len(t, pubKey, scheme.PublicKeySize())
+		assert.Equal(t, pubKey, pubKeyToByte(privKey.PublicKey))
+
+		found, err := SchemeOfKey(privKey.Curve)
+		assert.Nil(t, err)
+		assert.Equal(t, scheme, found)
+		found, err = SchemeOfPublicKey(pubKey)
+		assert.Nil(t, err)
+		assert.Equal(t, scheme, found)
+		found, err = SchemeByName(scheme.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, scheme, found)
+
+		// the address records the scheme
+		version, hash, err := DecodeAddress(GetStringAddress(GetAddress(pubKey)))
+		assert.Nil(t, err)
+		assert.Equal(t, scheme.AddressVersion(), version)
+		assert.Equal(t, HashPubKey(pubKey), hash)
+		assert.False(t, versions[version], "each scheme has its own address version")
+		assert.NotEqual(t, scriptHashVersion, version)
+		versions[version] = true
+
+		signature, err := signDigest(&privKey, digest)
+		assert.Nil(t, err)
+		assert.True(t, verifyDigest(pubKey, digest, signature))
+		digest[0] ^= 1
+		assert.False(t, verifyDigest(pubKey, digest, signature))
+
+		// the PEM encoding keeps the scheme
+		decoded := decodePrivateKey(encodePrivateKey(&privKey))
+		if assert.NotNil(t, decoded) {
+			assert.Equal(t, privKey.Curve, decoded.Curve)
+			assert.Equal(t, privKey.D, decoded.D)
+			assert.Equal(t, pubKey, pubKeyToByte(decoded.PublicKey))
+		}
+	}
+
+	// a signature of one scheme is not valid for the other ones
+	privKey, _ := newSchemeKeyPair(Secp256k1ECDSA)
+	signature, _ := signDigest(&privKey, digest)
+	schnorrKey := privKey
+	schnorrKey.Curve = secp256k1Schnorr
+	assert.False(t, verifyDigest(pubKeyToByte(schnorrKey.PublicKey), digest, signature))
+	signature, _ = signDigest(&schnorrKey, digest)
+	assert.False(t, verifyDigest(pubKeyToByte(privKey.PublicKey), digest, signature))
+
+	_, err := SchemeOfPublicKey(make([]byte, 65))
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+	_, err = SchemeByName("rsa")
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+	_, err = parsePubKey(make([]byte, 65))
+	assert.ErrorIs(t, err, ErrInvalidPubKey)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSignatureTestTx returns a transaction spending the first output of
+// prev and of a second previous transaction, both locked to the key
+func newSignatureTestTx(t *testing.T, pubKey []byte) (*Transaction, map[string]*Transaction) {
+	pkh := HashPubKey(pubKey)
+	prevTXs := make(map[string]*Transaction)
+	tx := &Transaction{Vout: []TXOutput{{Value: 7, PubKeyHash: Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")}}}
+	for i := 0; i < 2; i++ {
+		prev := &Transaction{Vout: []TXOutput{{Value: 5, PubKeyHash: pkh}}}
+		prev.ID = make([]byte, 32)
+		if _, err := rand.Read(prev.ID); err != nil {
+			t.Fatal(err)
+		}
+		prevTXs[hex.EncodeToString(prev.ID)] = prev
+		tx.Vin = append(tx.Vin, TXInput{Txid: prev.ID, OutIdx: 0, PubKey: pubKey})
+	}
+	tx.ID = tx.Hash()
+	return tx, prevTXs
+}
+
+func TestSignVerifyRandomKeys(t *testing.T) {
+	for _, scheme := range signatureSchemes {
+		for i := 0; i < 50; i++ {
+			privKey, pubKey := newSchemeKeyPair(scheme)
+			assert.Len(t, pubKey, scheme.PublicKeySize())
+
+			tx, prevTXs := newSignatureTestTx(t, pubKey)
+			assert.Nil(t, tx.Sign(privKey, prevTXs))
+			if !tx.Verify(prevTXs) {
+				t.Fatalf("%s signature of key %d is not valid", scheme.Name(), i)
+			}
+
+			n := privKey.Curve.Params().N
+			for idx, vin := range tx.Vin {
+				if scheme == Secp256k1Schnorr {
+					assert.Len(t, vin.Signature, 64)
+					continue
+				}
+				r, s, err := parseSignature(vin.Signature, privKey.Curve)
+				assert.Nil(t, err)
+				assert.True(t, s.Cmp(halfOrder(n)) <= 0, "S should be normalized")
+				assert.True(t, ecdsa.Verify(&privKey.PublicKey, tx.SignatureHash(idx, TXOutput{Value: 5, PubKeyHash: HashPubKey(pubKey)}), r, s))
+			}
+
+			// the signatures do not verify for another key
+			_, otherPubKey := newSchemeKeyPair(scheme)
+			for idx := range tx.Vin {
+				tx.Vin[idx].PubKey = otherPubKey
+			}
+			assert.False(t, tx.Verify(prevTXs))
+		}
+	}
+}
+
+func TestSignatureHash(t *testing.T) {
+	_, pubKey := newKeyPair()
+	tx, prevTXs := newSignatureTestTx(t, pubKey)
+	prevOut := prevTXs[hex.EncodeToString(tx.Vin[0].Txid)].Vout[0]
+
+	digest := tx.SignatureHash(0, prevOut)
+	assert.Len(t, digest, 32)
+	assert.Equal(t, digest, tx.SignatureHash(0, prevOut))
+	assert.NotEqual(t, digest, tx.SignatureHash(1, prevOut), "each input signs its own digest")
+
+	// signatures and public keys are not part of the digest
+	tx.Vin[0].Signature = []byte{1, 2, 3}
+	tx.Vin[1].PubKey = nil
+	assert.Equal(t, digest, tx.SignatureHash(0, prevOut))
+
+	// anything else is
+	tx.Vout[0].Value++
+	assert.NotEqual(t, digest, tx.SignatureHash(0, prevOut))
+	tx.Vout[0].Value--
+	tx.Vin[1].OutIdx = 1
+	assert.NotEqual(t, digest, tx.SignatureHash(0, prevOut))
+}
+
+func TestVerifyTampered(t *testing.T) {
+	privKey, pubKey := newKeyPair()
+	tx, prevTXs := newSignatureTestTx(t, pubKey)
+	assert.Nil(t, tx.Sign(privKey, prevTXs))
+	assert.True(t, tx.Verify(prevTXs))
+
+	tampered := *tx
+	tampered.Vout = []TXOutput{{Value: 10, PubKeyHash: tx.Vout[0].PubKeyHash}}
+	assert.False(t, tampered.Verify(prevTXs), "outputs are signed")
+
+	// swapping the signatures of the inputs
+	tampered = *tx
+	tampered.Vin = []TXInput{tx.Vin[0], tx.Vin[1]}
+	tampered.Vin[0].Signature, tampered.Vin[1].Signature = tx.Vin[1].Signature, tx.Vin[0].Signature
+	assert.False(t, tampered.Verify(prevTXs), "inputs are signed separately")
+}
+
+func TestVerifyRejectsNonCanonicalSignatures(t *testing.T) {
+	for _, scheme := range []SignatureScheme{P256ECDSA, Secp256k1ECDSA} {
+		t.Run(scheme.Name(), func(t *testing.T) {
+			testVerifyRejectsNonCanonicalSignatures(t, scheme)
+		})
+	}
+}
+
+func testVerifyRejectsNonCanonicalSignatures(t *testing.T, scheme SignatureScheme) {
+	privKey, pubKey := newSchemeKeyPair(scheme)
+	digest := make([]byte, 32)
+	rand.Read(digest)
+	signature, err := signDigest(&privKey, digest)
+	assert.Nil(t, err)
+	assert.True(t, verifyDigest(pubKey, digest, signature))
+
+	r, s, err := parseSignature(signature, privKey.Curve)
+	assert.Nil(t, err)
+
+	// the high S twin of the signature is valid ECDSA but rejected
+	highS := new(big.Int).Sub(privKey.Curve.Params().N, s)
+	assert.True(t, ecdsa.Verify(&privKey.PublicKey, digest, r, highS))
+	malleated, _ := asn1.Marshal(ecdsaSignature{r, highS})
+	_, _, err = parseSignature(malleated, privKey.Curve)
+	assert.ErrorIs(t, err, ErrHighS)
+	assert.False(t, verifyDigest(pubKey, digest, malleated))
+
+	// trailing bytes
+	assert.False(t, verifyDigest(pubKey, digest, append(append([]byte{}, signature...), 0)))
+
+	// the old fixed width encoding
+	size := 32
+	fixed := make([]byte, 2*size)
+	r.FillBytes(fixed[:size])
+	s.FillBytes(fixed[size:])
+	assert.False(t, verifyDigest(pubKey, digest, fixed))
+
+	// non minimal length encoding of the sequence
+	long := append([]byte{0x30, 0x81, signature[1]}, signature[2:]...)
+	_, _, err = parseSignature(long, privKey.Curve)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	// zero R
+	zero, _ := asn1.Marshal(ecdsaSignature{big.NewInt(0), s})
+	assert.False(t, verifyDigest(pubKey, digest, zero))
+
+	assert.False(t, verifyDigest(nil, digest, signature))
+	assert.False(t, verifyDigest(pubKey[:len(pubKey)-1], digest, signature))
+	// about half of the x coordinates of secp256k1 are on the curve
+	notOnCurve := append([]byte{}, pubKey...)
+	for {
+		notOnCurve[len(notOnCurve)-1]++
+		if _, err = parsePubKey(notOnCurve); err != nil {
+			break
+		}
+	}
+	assert.ErrorIs(t, err, ErrInvalidPubKey)
+	assert.False(t, verifyDigest(notOnCurve, digest, signature))
+}
@@ -0,0 +1,68 @@
+package main
+
+import "errors"
+
+var (
+	ErrStorageClosed = errors.New("storage is closed")
+	ErrTxNotWritable = errors.New("storage transaction is read-only")
+)
+
+// Storage is the persistence backend of the Blockchain.
+// Data is kept as key/value pairs grouped in named buckets, and every
+// access happens inside a transaction, so that a block and everything
+// derived from it are written atomically.
+type Storage interface {
+	// View runs fn inside a read-only transaction
+	View(fn func(tx StorageTx) error) error
+	// Update runs fn inside a read-write transaction.
+	// If fn returns an error, none of its writes are committed.
+	Update(fn func(tx StorageTx) error) error
+	// Close releases the resources held by the backend
+	Close() error
+}
+
+// StorageTx represents a transaction on a Storage backend
+type StorageTx interface {
+	// Get returns a copy of the value stored under key,
+	// or nil if the bucket or the key does not exist
+	Get(bucket, key []byte) []byte
+	// Put stores value under key, creating the bucket if needed
+	Put(bucket, key, value []byte) error
+	// Delete removes key from bucket
+	Delete(bucket, key []byte) error
+	// ForEach calls fn for every pair of the bucket whose key starts
+	// with prefix, in ascending key order. An empty prefix visits the
+	// whole bucket. Returning an error from fn stops the iteration.
+	ForEach(bucket, prefix []byte, fn func(k, v []byte) error) error
+}
+
+// clearBucket removes all the keys of a bucket
+func clearBucket(tx StorageTx, bucket []byte) error {
+	var keys [][]byte
+	err := tx.ForEach(bucket, nil, func(k, v []byte) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := tx.Delete(bucket, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Buckets used by the Blockchain
+var (
+	blocksBucket  = []byte("blocks")  // block hash -> serialized block
+	heightsBucket = []byte("heights") // block height -> block hash
+	metaBucket    = []byte("meta")    // chain metadata (e.g., tip)
+)
+
+// Keys of the meta bucket
+var (
+	tipKey        = []byte("tip")    // hash of the last block
+	heightKeyName = []byte("height") // height of the last block
+)
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStorage is a Storage backend that keeps the data
+// in a single file using the embedded bbolt key/value store
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// OpenBoltStorage opens (or creates) the database file at path
+func OpenBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+// View runs fn inside a read-only transaction
+func (s *BoltStorage) View(fn func(tx StorageTx) error) error {
+	return s.db.View(func(btx *bolt.Tx) error {
+		return fn(&boltTx{btx})
+	})
+}
+
+// Update runs fn inside a read-write transaction
+func (s *BoltStorage) Update(fn func(tx StorageTx) error) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		return fn(&boltTx{btx})
+	})
+}
+
+// Close closes the database file
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+// boltTx adapts a bolt transaction to the StorageTx interface.
+// Values returned by bolt are only valid during the transaction,
+// thus they are always copied before handing them to the caller.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t *boltTx) Get(bucket, key []byte) []byte {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return copyBytes(b.Get(key))
+}
+
+func (t *boltTx) Put(bucket, key, value []byte) error {
+	if !t.tx.Writable() {
+		return ErrTxNotWritable
+	}
+	b, err := t.tx.CreateBucketIfNotExists(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, value)
+}
+
+func (t *boltTx) Delete(bucket, key []byte) error {
+	if !t.tx.Writable() {
+		return ErrTxNotWritable
+	}
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	return b.Delete(key)
+}
+
+func (t *boltTx) ForEach(bucket, prefix []byte, fn func(k, v []byte) error) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(copyBytes(k), copyBytes(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
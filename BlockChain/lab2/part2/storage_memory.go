@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// MemoryStorage is a volatile Storage backend.
+// It is mostly useful for tests and short-lived chains.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// NewMemoryStorage creates an empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{buckets: make(map[string]map[string][]byte)}
+}
+
+// View runs fn inside a read-only transaction
+func (m *MemoryStorage) View(fn func(tx StorageTx) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrStorageClosed
+	}
+	return fn(&memoryTx{storage: m})
+}
+
+// Update runs fn inside a read-write transaction.
+// The writes are buffered and only applied if fn succeeds.
+func (m *MemoryStorage) Update(fn func(tx StorageTx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrStorageClosed
+	}
+	tx := &memoryTx{storage: m, writable: true, pending: make(map[string]map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for bucket, pairs := range tx.pending {
+		b, ok := m.buckets[bucket]
+		if !ok {
+			b = make(map[string][]byte)
+			m.buckets[bucket] = b
+		}
+		for k, v := range pairs {
+			if v == nil {
+				delete(b, k)
+			} else {
+				b[k] = v
+			}
+		}
+	}
+	return nil
+}
+
+// Close discards the stored data
+func (m *MemoryStorage) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.buckets = nil
+	return nil
+}
+
+// memoryTx is a transaction on a MemoryStorage.
+// Pending writes are kept apart (a nil value marks a deletion)
+// until the transaction is committed.
+type memoryTx struct {
+	storage  *MemoryStorage
+	writable bool
+	pending  map[string]map[string][]byte
+}
+
+func (tx *memoryTx) Get(bucket, key []byte) []byte {
+	if pairs, ok := tx.pending[string(bucket)]; ok {
+		if v, ok := pairs[string(key)]; ok {
+			return copyBytes(v)
+		}
+	}
+	return copyBytes(tx.storage.buckets[string(bucket)][string(key)])
+}
+
+func (tx *memoryTx) Put(bucket, key, value []byte) error {
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	tx.pendingBucket(bucket)[string(key)] = copyBytes(value)
+	return nil
+}
+
+func (tx *memoryTx) Delete(bucket, key []byte) error {
+	if !tx.writable {
+		return ErrTxNotWritable
+	}
+	tx.pendingBucket(bucket)[string(key)] = nil
+	return nil
+}
+
+func (tx *memoryTx) ForEach(bucket, prefix []byte, fn func(k, v []byte) error) error {
+	merged := make(map[string][]byte)
+	for k, v := range tx.storage.buckets[string(bucket)] {
+		merged[k] = v
+	}
+	for k, v := range tx.pending[string(bucket)] {
+		merged[k] = v
+	}
+
+	var keys []string
+	for k, v := range merged {
+		if v != nil && bytes.HasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := fn([]byte(k), copyBytes(merged[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *memoryTx) pendingBucket(bucket []byte) map[string][]byte {
+	pairs, ok := tx.pending[string(bucket)]
+	if !ok {
+		pairs = make(map[string][]byte)
+		tx.pending[string(bucket)] = pairs
+	}
+	return pairs
+}
+
+// copyBytes returns a copy of b, preserving nil
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStorages(t *testing.T) map[string]Storage {
+	bolt, err := OpenBoltStorage(filepath.Join(t.TempDir(), DBFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+
+	return map[string]Storage{
+		"memory": NewMemoryStorage(),
+		"bolt":   bolt,
+	}
+}
+
+func TestStoragePutGet(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			bucket := []byte("test")
+			err := db.Update(func(tx StorageTx) error {
+				assert.Nil(t, tx.Get(bucket, []byte("k1")))
+				if err := tx.Put(bucket, []byte("k1"), []byte("v1")); err != nil {
+					return err
+				}
+				// writes are visible inside the same transaction
+				assert.Equal(t, []byte("v1"), tx.Get(bucket, []byte("k1")))
+				return tx.Put(bucket, []byte("k2"), []byte("v2"))
+			})
+			assert.Nil(t, err)
+
+			err = db.Update(func(tx StorageTx) error {
+				return tx.Delete(bucket, []byte("k2"))
+			})
+			assert.Nil(t, err)
+
+			err = db.View(func(tx StorageTx) error {
+				assert.Equal(t, []byte("v1"), tx.Get(bucket, []byte("k1")))
+				assert.Nil(t, tx.Get(bucket, []byte("k2")))
+				assert.ErrorIs(t, tx.Put(bucket, []byte("k3"), []byte("v3")), ErrTxNotWritable)
+				return nil
+			})
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestStorageRollback(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			bucket := []byte("test")
+			failure := errors.New("failure")
+			err := db.Update(func(tx StorageTx) error {
+				tx.Put(bucket, []byte("k1"), []byte("v1"))
+				return failure
+			})
+			assert.ErrorIs(t, err, failure)
+
+			db.View(func(tx StorageTx) error {
+				assert.Nil(t, tx.Get(bucket, []byte("k1")))
+				return nil
+			})
+		})
+	}
+}
+
+func TestStorageForEach(t *testing.T) {
+	for name, db := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			bucket := []byte("test")
+			db.Update(func(tx StorageTx) error {
+				tx.Put(bucket, []byte("b2"), []byte("2"))
+				tx.Put(bucket, []byte("a1"), []byte("1"))
+				tx.Put(bucket, []byte("b1"), []byte("1"))
+				tx.Put(bucket, []byte("c1"), []byte("1"))
+				return nil
+			})
+
+			var keys []string
+			db.View(func(tx StorageTx) error {
+				return tx.ForEach(bucket, []byte("b"), func(k, v []byte) error {
+					keys = append(keys, string(k))
+					return nil
+				})
+			})
+			assert.Equal(t, []string{"b1", "b2"}, keys)
+
+			keys = nil
+			db.View(func(tx StorageTx) error {
+				return tx.ForEach(bucket, nil, func(k, v []byte) error {
+					keys = append(keys, string(k))
+					return nil
+				})
+			})
+			assert.Equal(t, []string{"a1", "b1", "b2", "c1"}, keys)
+		})
+	}
+}
@@ -51,9 +51,9 @@ func removeTXInputSignature(tx *Transaction) {
 	var inputs []TXInput
 
 	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.OutIdx, nil, vin.PubKey})
+		inputs = append(inputs, TXInput{vin.Txid, vin.OutIdx, nil, vin.PubKey, vin.ScriptSig})
 	}
-	*tx = Transaction{tx.ID, inputs, tx.Vout}
+	*tx = Transaction{tx.ID, inputs, tx.Vout, tx.LockTime}
 }
 
 // Transactions example flow:
@@ -67,16 +67,17 @@ func removeTXInputSignature(tx *Transaction) {
 // get 1 coin as remainder
 // tx5: Using tx3 and tx4 outputs, Leander sent 3 "coins" to Rodrigo
 //
-// NOTE: The mocked txs below ignores the tx signature!
+// The transactions are signed with the keys of Rodrigo and Leander,
+// see keys/testEncPrivKeyUser1.key and keys/testEncPrivKeyUser2.key.
 var testTransactions = map[string]*Transaction{
 	"tx0": {
-		ID: Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+		ID: Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 		Vin: []TXInput{
 			{
 				Txid:      nil,
 				OutIdx:    -1,
 				Signature: nil,
-				PubKey:    []byte(GenesisCoinbaseData),
+				PubKey:    coinbaseScript(0, []byte(GenesisCoinbaseData)),
 			},
 		},
 		Vout: []TXOutput{
@@ -87,12 +88,12 @@ var testTransactions = map[string]*Transaction{
 		},
 	},
 	"tx1": {
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
-				Signature: nil,
+				Signature: Hex2Bytes("3045022100ae8cdb5a2884b0cb70cab16f3a5d31533a650271ba551b1aac54c60ff95cc8390220482f43005c48d82d31a7c057ac9a49b1edc2c2fed1c5dcbece551740740cdb57"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
 			},
 		},
@@ -108,12 +109,12 @@ var testTransactions = map[string]*Transaction{
 		},
 	},
 	"tx2": {
-		ID: Hex2Bytes("dcd76d254f7a41888e6bda9958c4ceadf510e1bd5fd251f617c91b704fbf9492"),
+		ID: Hex2Bytes("ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+				Txid:      Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 				OutIdx:    0,
-				Signature: nil,
+				Signature: Hex2Bytes("3044022064c2dc29c020a5d935ad109006f969aac46b40978d7213ef66427452229705d2022079cc29e0c25fa72519aa1d6aa43c623e07b0e6342fc12d9705302912a8fd01cb"),
 				PubKey:    Hex2Bytes("c36d68bc641029e53a38252b436c596ef3d03a4a754743da50fb9a321020e882dd401732381783c7444112abc729b3bee04643015d80fe67e0c28a5b28a20910"),
 			},
 		},
@@ -129,12 +130,12 @@ var testTransactions = map[string]*Transaction{
 		},
 	},
 	"tx3": {
-		ID: Hex2Bytes("e9e5fc159f24b2b33310f77aef4e425e77ed71be87dbf9a0c7764b5417bd3e4b"),
+		ID: Hex2Bytes("efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+				Txid:      Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 				OutIdx:    1,
-				Signature: nil,
+				Signature: Hex2Bytes("304402205d55c4fad1e21f8f9f27da8a9cf2453abc721d47a7d92ed974d6dab955a6c3b202202153632ad6ea47b840c5853ceef828b7e665253f668d3c8debd6881b777b0aa1"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
 			},
 		},
@@ -150,12 +151,12 @@ var testTransactions = map[string]*Transaction{
 		},
 	},
 	"tx4": {
-		ID: Hex2Bytes("91d6fe8fe351e50fa6e16bb391ff74f5dc650646ce6ad02442e647742566b31b"),
+		ID: Hex2Bytes("7f036b2af8b0b39d63a2aef7c9d98dcbb3ead00f0443661bd326161e92d0e2d9"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("dcd76d254f7a41888e6bda9958c4ceadf510e1bd5fd251f617c91b704fbf9492"),
+				Txid:      Hex2Bytes("ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4"),
 				OutIdx:    0,
-				Signature: nil,
+				Signature: Hex2Bytes("3044022016d2015067e24bc94241fbb32076d8c51aeb40f64c0f35ca47f3aad1774c6b6002203e5c55546484a52a8af6ca3af3b30686aa629d4c8a2a929d357031e491208a4d"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
 			},
 		},
@@ -171,18 +172,18 @@ var testTransactions = map[string]*Transaction{
 		},
 	},
 	"tx5": {
-		ID: Hex2Bytes("b63d956b234d27c3494d9935ac9764634db0232f32ef7f576979d8ba5ec93fbc"),
+		ID: Hex2Bytes("81416a4fe2821f0409f24b8ca51cb5b8589507a41c827fdce4dca8eb1b15dbef"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("e9e5fc159f24b2b33310f77aef4e425e77ed71be87dbf9a0c7764b5417bd3e4b"),
+				Txid:      Hex2Bytes("efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496"),
 				OutIdx:    0,
-				Signature: nil,
+				Signature: Hex2Bytes("304402201b1fec46b762668192e9ddb1fb07e9d12bc2f65cf0395299b8fe2e71b3618cb9022026ac46291d0f1169f11a67f59d0b9b035439202fe32ca5d944e04b079b04b3bd"),
 				PubKey:    Hex2Bytes("c36d68bc641029e53a38252b436c596ef3d03a4a754743da50fb9a321020e882dd401732381783c7444112abc729b3bee04643015d80fe67e0c28a5b28a20910"),
 			},
 			{
-				Txid:      Hex2Bytes("91d6fe8fe351e50fa6e16bb391ff74f5dc650646ce6ad02442e647742566b31b"),
+				Txid:      Hex2Bytes("7f036b2af8b0b39d63a2aef7c9d98dcbb3ead00f0443661bd326161e92d0e2d9"),
 				OutIdx:    0,
-				Signature: nil,
+				Signature: Hex2Bytes("3045022100837577d45be99187cd69e8bf9e73815307505141e5ce5b2610402b0f542ce1cd02200727d8e47d2e93407f0479baea83258a853ff8a0311078e3449d3506a4317a8c"),
 				PubKey:    Hex2Bytes("c36d68bc641029e53a38252b436c596ef3d03a4a754743da50fb9a321020e882dd401732381783c7444112abc729b3bee04643015d80fe67e0c28a5b28a20910"),
 			},
 		},
@@ -195,11 +196,19 @@ var testTransactions = map[string]*Transaction{
 	},
 }
 
+// unsignedTestTransaction returns a copy of a test transaction
+// without its signatures
+func unsignedTestTransaction(name string) *Transaction {
+	tx := *testTransactions[name]
+	removeTXInputSignature(&tx)
+	return &tx
+}
+
 func getTestInputsTX(tx string) []TXInput {
 	return testTransactions[tx].Vin
 }
 
-func newMockCoinbaseTX(to, data, txID string) *Transaction {
+func newMockCoinbaseTX(to, data string, height int, txID string) *Transaction {
 	tx := &Transaction{
 		ID: Hex2Bytes(txID),
 		Vin: []TXInput{
@@ -207,7 +216,7 @@ func newMockCoinbaseTX(to, data, txID string) *Transaction {
 				Txid:      nil,
 				OutIdx:    -1,
 				Signature: nil,
-				PubKey:    []byte(data),
+				PubKey:    coinbaseScript(height, []byte(data)),
 			},
 		},
 		Vout: []TXOutput{
@@ -222,62 +231,87 @@ func newMockCoinbaseTX(to, data, txID string) *Transaction {
 
 // Miner address: 12znKfjybYauJASaggYEKCWyN9MLKYfA5i
 var minerCoinbaseTx = map[string]*Transaction{
-	"tx1": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "1", "0ca136effc2424a42d2bcf6b498e7c0c226ada6eff5499a7fa600c0ae6bad9c0"),
-	"tx2": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "2", "64e97834110d5525f68fbf719743cd22feffb4e91ffb50639f5e232228e3f1e5"),
-	"tx3": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "3", "68f0b05abdfa09bbfb732e37248ccb2a737db189d03d22224c3aa13afe593994"),
-	"tx4": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "4", "c8b152a0040e1f98b261b41444d6eeca09c3bfcc7d1ec69a792748f70efa1efb"),
+	"tx1": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "1", 1, "d2c7f786e3d25bf89ddda8c5fe7623864d45b1d9c85542dfb6329b968bca253b"),
+	"tx2": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "2", 2, "7b69ef32a9e2aa9ae13438b329e2b1e9f20f21c8e637d09fe3c69ba8cf4b659e"),
+	"tx3": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "3", 3, "0591963910ca99fca8772e8136b813bd4bbbb808ba94231d16140b3d9a0d4ed8"),
+	"tx4": newMockCoinbaseTX("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971", "4", 4, "a062bd71061115f704221274e4475c15305bc241673e48681069ffa8f1a17bf7"),
 }
 
 var testBlockchainData = map[string]*Block{
 	"block0": { // genesis block
-		Timestamp: TestBlockTime,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: nil,
+			MerkleRoot:    Hex2Bytes("426b361af5b5c788b8b14b7c5ba11d42f385b45e3923a875f516ee55e1d9d830"),
+			Timestamp:     TestBlockTime,
+			Bits:          InitialBits,
+			Nonce:         153,
+		},
 		Transactions: []*Transaction{
 			testTransactions["tx0"],
 		},
-		PrevBlockHash: nil,
-		Hash:          Hex2Bytes("00d4eeaee903dce5468d4c6975376dfbc4c45ea1bc6c5bbbfd8e13b26aaf6e3b"),
-		Nonce:         59,
+		Hash: Hex2Bytes("001e9301a2c9f2e7f35f7b628cf2e806d4c9c6060a5b36d9a2804581b41e4550"),
 	},
 	"block1": {
-		Timestamp: TestBlockTime,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: Hex2Bytes("001e9301a2c9f2e7f35f7b628cf2e806d4c9c6060a5b36d9a2804581b41e4550"),
+			MerkleRoot:    Hex2Bytes("073c61b6216704c54ea31616c1fe9fe3b0b4e5ad32e373c089247e02fbabe912"),
+			Timestamp:     TestBlockTime,
+			Bits:          InitialBits,
+			Nonce:         240,
+		},
 		Transactions: []*Transaction{
 			minerCoinbaseTx["tx1"],
 			testTransactions["tx1"],
 		},
-		PrevBlockHash: Hex2Bytes("00d4eeaee903dce5468d4c6975376dfbc4c45ea1bc6c5bbbfd8e13b26aaf6e3b"),
-		Hash:          Hex2Bytes("0017379eb3ca189e5deaecc58523533883452ffe7dbba43cd71c3319d392a931"),
-		Nonce:         35,
+		Hash: Hex2Bytes("00b44ac4016b8f887e29d12c1cd6e69146f6fef653d7a1611ae050c023617517"),
 	},
 	"block2": {
-		Timestamp: TestBlockTime,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: Hex2Bytes("00b44ac4016b8f887e29d12c1cd6e69146f6fef653d7a1611ae050c023617517"),
+			MerkleRoot:    Hex2Bytes("2816a55d11574dc751fd052bdb016c2c6259c3f4d8d22c0d179bf17db6748749"),
+			Timestamp:     TestBlockTime,
+			Bits:          InitialBits,
+			Nonce:         177,
+		},
 		Transactions: []*Transaction{
 			minerCoinbaseTx["tx2"],
 			testTransactions["tx3"],
 			testTransactions["tx2"],
 		},
-		PrevBlockHash: Hex2Bytes("0017379eb3ca189e5deaecc58523533883452ffe7dbba43cd71c3319d392a931"),
-		Hash:          Hex2Bytes("00f53a20971ded89b7e8192fbac5255211bc538e1ef705255dff352a78855b46"),
-		Nonce:         626,
+		Hash: Hex2Bytes("005042b7b4e0ffb9f20ac45bea651bb67ec7502cb10500e37f3ffbc2373ab564"),
 	},
 	"block3": {
-		Timestamp: TestBlockTime,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: Hex2Bytes("005042b7b4e0ffb9f20ac45bea651bb67ec7502cb10500e37f3ffbc2373ab564"),
+			MerkleRoot:    Hex2Bytes("383945e2f61f4bf94639a5f21fd14eaf94ac1aee7bc6f363738b78d80f12e23f"),
+			Timestamp:     TestBlockTime,
+			Bits:          InitialBits,
+			Nonce:         64,
+		},
 		Transactions: []*Transaction{
 			minerCoinbaseTx["tx3"],
 			testTransactions["tx4"],
 		},
-		PrevBlockHash: Hex2Bytes("00f53a20971ded89b7e8192fbac5255211bc538e1ef705255dff352a78855b46"),
-		Hash:          Hex2Bytes("00efff94a452db304494aecb66dde7dad4d4ddea986d6eb7a7c71b70d6512a7d"),
-		Nonce:         279,
+		Hash: Hex2Bytes("001d982bdbfd08f42a462b2648db4491e99efa15d8e5e79fba48e85edf56d6b3"),
 	},
 	"block4": {
-		Timestamp: TestBlockTime,
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: Hex2Bytes("001d982bdbfd08f42a462b2648db4491e99efa15d8e5e79fba48e85edf56d6b3"),
+			MerkleRoot:    Hex2Bytes("8b6e0c9f524e54655b30956476958ff9dade6c5a13ad278e08d6c7062e1f8fd2"),
+			Timestamp:     TestBlockTime,
+			Bits:          InitialBits,
+			Nonce:         10,
+		},
 		Transactions: []*Transaction{
 			minerCoinbaseTx["tx4"],
 			testTransactions["tx5"],
 		},
-		PrevBlockHash: Hex2Bytes("00efff94a452db304494aecb66dde7dad4d4ddea986d6eb7a7c71b70d6512a7d"),
-		Hash:          Hex2Bytes("00cb768ee28075b65bec7bb949571a1a940863389bfbbbcce9a3e82b78a94feb"),
-		Nonce:         154,
+		Hash: Hex2Bytes("009fe2565046c2c1175a5ff2a31a50b66a6a41fc42a29e023177bcf367c58ff9"),
 	},
 }
 
@@ -288,21 +322,21 @@ var testUTXOs = map[string]struct {
 	"block0": { // (0 input -> 1 output, generating "coins")
 		utxos: UTXOSet{},
 		expectedUTXOs: UTXOSet{
-			"9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2": {0: testTransactions["tx0"].Vout[0]},
+			"c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa": {0: testTransactions["tx0"].Vout[0]},
 			// tx0: Address 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh create coinbase transaction and received 10 "coins"
 		},
 	},
 	"block1": { // (1 input -> 2 outputs, splitting one input)
 		utxos: UTXOSet{
-			"9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2": {0: testTransactions["tx0"].Vout[0]},
+			"c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa": {0: testTransactions["tx0"].Vout[0]},
 		},
 		expectedUTXOs: UTXOSet{
-			"397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13": {
+			"ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49": {
 				0: testTransactions["tx1"].Vout[0],
 				1: testTransactions["tx1"].Vout[1],
 			},
 			// tx1: 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh sent 5 "coins" to 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX and get 5 as remainder
-			"0ca136effc2424a42d2bcf6b498e7c0c226ada6eff5499a7fa600c0ae6bad9c0": {
+			"d2c7f786e3d25bf89ddda8c5fe7623864d45b1d9c85542dfb6329b968bca253b": {
 				0: {
 					Value:      BlockReward,
 					PubKeyHash: Hex2Bytes("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971"),
@@ -312,23 +346,23 @@ var testUTXOs = map[string]struct {
 	},
 	"block2": { // (1 input -> 2 output, with multiple txs)
 		utxos: UTXOSet{
-			"397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13": {
+			"ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49": {
 				0: testTransactions["tx1"].Vout[0],
 				1: testTransactions["tx1"].Vout[1],
 			},
 		},
 		expectedUTXOs: UTXOSet{
-			"dcd76d254f7a41888e6bda9958c4ceadf510e1bd5fd251f617c91b704fbf9492": {
+			"ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4": {
 				0: testTransactions["tx2"].Vout[0],
 				1: testTransactions["tx2"].Vout[1],
 			},
 			// tx2: 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh sent 1 "coin" to 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX and get 4 as remainder
-			"e9e5fc159f24b2b33310f77aef4e425e77ed71be87dbf9a0c7764b5417bd3e4b": {
+			"efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496": {
 				0: testTransactions["tx3"].Vout[0],
 				1: testTransactions["tx3"].Vout[1],
 			},
 			// tx3: 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX sent 3 "coins" to 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh and get 2 as remainder
-			"64e97834110d5525f68fbf719743cd22feffb4e91ffb50639f5e232228e3f1e5": {
+			"7b69ef32a9e2aa9ae13438b329e2b1e9f20f21c8e637d09fe3c69ba8cf4b659e": {
 				0: {
 					Value:      BlockReward,
 					PubKeyHash: Hex2Bytes("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971"),
@@ -340,19 +374,19 @@ var testUTXOs = map[string]struct {
 	"block3": { // (1 input -> 2 outputs)
 		utxos: UTXOSet{
 			// tx3 was intentionally ignored
-			"dcd76d254f7a41888e6bda9958c4ceadf510e1bd5fd251f617c91b704fbf9492": {
+			"ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4": {
 				0: testTransactions["tx2"].Vout[0],
 				1: testTransactions["tx2"].Vout[1],
 			},
 		},
 		expectedUTXOs: UTXOSet{
-			"dcd76d254f7a41888e6bda9958c4ceadf510e1bd5fd251f617c91b704fbf9492": {1: testTransactions["tx2"].Vout[1]},
-			"91d6fe8fe351e50fa6e16bb391ff74f5dc650646ce6ad02442e647742566b31b": {
+			"ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4": {1: testTransactions["tx2"].Vout[1]},
+			"7f036b2af8b0b39d63a2aef7c9d98dcbb3ead00f0443661bd326161e92d0e2d9": {
 				0: testTransactions["tx4"].Vout[0],
 				1: testTransactions["tx4"].Vout[1],
 			},
 			// tx4: 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh sent 2 "coins" to 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX and get 1 as remainder
-			"68f0b05abdfa09bbfb732e37248ccb2a737db189d03d22224c3aa13afe593994": {
+			"0591963910ca99fca8772e8136b813bd4bbbb808ba94231d16140b3d9a0d4ed8": {
 				0: {
 					Value:      BlockReward,
 					PubKeyHash: Hex2Bytes("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971"),
@@ -362,21 +396,21 @@ var testUTXOs = map[string]struct {
 	},
 	"block4": { // (2 inputs -> 1 output)
 		utxos: UTXOSet{
-			"e9e5fc159f24b2b33310f77aef4e425e77ed71be87dbf9a0c7764b5417bd3e4b": {
+			"efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496": {
 				0: testTransactions["tx3"].Vout[0],
 				1: testTransactions["tx3"].Vout[1],
 			},
-			"91d6fe8fe351e50fa6e16bb391ff74f5dc650646ce6ad02442e647742566b31b": {
+			"7f036b2af8b0b39d63a2aef7c9d98dcbb3ead00f0443661bd326161e92d0e2d9": {
 				0: testTransactions["tx4"].Vout[0],
 				1: testTransactions["tx4"].Vout[1],
 			},
 		},
 		expectedUTXOs: UTXOSet{
-			"e9e5fc159f24b2b33310f77aef4e425e77ed71be87dbf9a0c7764b5417bd3e4b": {1: testTransactions["tx3"].Vout[1]},
-			"91d6fe8fe351e50fa6e16bb391ff74f5dc650646ce6ad02442e647742566b31b": {1: testTransactions["tx4"].Vout[1]},
-			"b63d956b234d27c3494d9935ac9764634db0232f32ef7f576979d8ba5ec93fbc": {0: testTransactions["tx5"].Vout[0]},
+			"efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496": {1: testTransactions["tx3"].Vout[1]},
+			"7f036b2af8b0b39d63a2aef7c9d98dcbb3ead00f0443661bd326161e92d0e2d9": {1: testTransactions["tx4"].Vout[1]},
+			"81416a4fe2821f0409f24b8ca51cb5b8589507a41c827fdce4dca8eb1b15dbef": {0: testTransactions["tx5"].Vout[0]},
 			// tx5: 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX sent 3 "coins" to 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh
-			"c8b152a0040e1f98b261b41444d6eeca09c3bfcc7d1ec69a792748f70efa1efb": {
+			"a062bd71061115f704221274e4475c15305bc241673e48681069ffa8f1a17bf7": {
 				0: {
 					Value:      BlockReward,
 					PubKeyHash: Hex2Bytes("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971"),
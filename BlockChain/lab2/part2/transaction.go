@@ -3,31 +3,49 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/big"
 	"strings"
 )
 
 var (
-	ErrNoFunds         = errors.New("not enough funds")
-	ErrTxInputNotFound = errors.New("transaction input not found")
+	ErrNoFunds           = errors.New("not enough funds")
+	ErrTxInputNotFound   = errors.New("transaction input not found")
+	ErrNegativeValue     = errors.New("transaction output value is negative")
+	ErrValueTooLarge     = errors.New("transaction output value exceeds MaxMoney")
+	ErrValueNotConserved = errors.New("transaction outputs exceed its inputs")
+	ErrCoinbaseValue     = errors.New("coinbase claims more than the block reward and fees")
 )
 
+// LockTimeThreshold separates the lock times that are block heights,
+// below, from the ones that are timestamps, see Transaction.IsFinal
+const LockTimeThreshold = 500000000
+
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
-	ID   []byte
-	Vin  []TXInput
-	Vout []TXOutput
+	ID       []byte
+	Vin      []TXInput
+	Vout     []TXOutput
+	LockTime uint32 // The height or timestamp before which the transaction cannot be in a block
+}
+
+// NewCoinbaseTX creates a new coinbase transaction of the block
+// at the given height, claiming the block reward
+func NewCoinbaseTX(to, data string, height int) (*Transaction, error) {
+	return NewCoinbaseTXWithFees(to, data, height, 0)
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) (*Transaction, error) {
+// NewCoinbaseTXWithFees creates a new coinbase transaction of the block at
+// the given height, claiming the block reward plus the fees paid by the
+// other transactions of the block. The height is encoded before the data,
+// see coinbase.go.
+func NewCoinbaseTXWithFees(to, data string, height, fees int) (*Transaction, error) {
+	if fees < 0 {
+		return nil, ErrNegativeValue
+	}
 	if len(data) == 0 {
 		b := make([]byte, 10)
 		if _, err := rand.Read(b); err != nil {
@@ -38,27 +56,55 @@ func NewCoinbaseTX(to, data string) (*Transaction, error) {
 	vin := TXInput{
 		Txid:   nil,
 		OutIdx: -1,
-		PubKey: []byte(data),
+		PubKey: coinbaseScript(height, []byte(data)),
 	}
 
 	vout := TXOutput{
-		Value: BlockReward,
+		Value: BlockSubsidy(height) + fees,
+	}
+	if err := vout.Lock(to); err != nil {
+		return nil, err
 	}
-	vout.Lock(to)
 	tx := &Transaction{Vin: []TXInput{vin}, Vout: []TXOutput{vout}}
 	tx.ID = tx.Hash()
 	return tx, nil
 }
 
-// NewUTXOTransaction creates a new UTXO transaction
+// UTXOFinder finds the unspent outputs that a key can spend.
+// It is implemented by both UTXOSet and UTXOIndex.
+type UTXOFinder interface {
+	FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int)
+}
+
+// NewUTXOTransaction creates a new UTXO transaction without fee
 // NOTE: The returned tx is NOT signed!
-func NewUTXOTransaction(pubKey []byte, to string, amount int, utxos UTXOSet) (*Transaction, error) {
+func NewUTXOTransaction(pubKey []byte, to string, amount int, utxos UTXOFinder) (*Transaction, error) {
+	return NewUTXOTransactionWithFee(pubKey, to, amount, 0, utxos)
+}
+
+// NewUTXOTransactionWithFee creates a new UTXO transaction sending amount
+// to the address and leaving fee to the miner. The change goes back to the
+// owner of pubKey.
+// NOTE: The returned tx is NOT signed!
+func NewUTXOTransactionWithFee(pubKey []byte, to string, amount, fee int, utxos UTXOFinder) (*Transaction, error) {
 	// TODO(student)
 	// Modify your function to use the address instead of just strings
 	// And also sign the new transaction before return
-	spendableAmt, unspentOutputs := utxos.FindSpendableOutputs(HashPubKey(pubKey), amount)
+	change := TXOutput{PubKeyHash: HashPubKey(pubKey)}
+	return newSpendingTransaction(HashPubKey(pubKey), TXInput{PubKey: pubKey}, change, to, amount, fee, utxos)
+}
 
-	if spendableAmt < amount {
+// newSpendingTransaction creates a transaction spending the outputs locked
+// with lockHash, see TXOutput.LockHash, to send amount to the address and
+// leave fee to the miner. The inputs are copies of input and the change
+// output a copy of change.
+func newSpendingTransaction(lockHash []byte, input TXInput, change TXOutput, to string, amount, fee int, utxos UTXOFinder) (*Transaction, error) {
+	if amount < 0 || fee < 0 {
+		return nil, ErrNegativeValue
+	}
+	spendableAmt, unspentOutputs := utxos.FindSpendableOutputs(lockHash, amount+fee)
+
+	if spendableAmt < amount+fee {
 		return nil, ErrNoFunds
 	}
 
@@ -66,11 +112,9 @@ func NewUTXOTransaction(pubKey []byte, to string, amount int, utxos UTXOSet) (*T
 	var Vout []TXOutput
 	for prevTxId, outInfo := range unspentOutputs {
 		for _, outIdx := range outInfo {
-			vin := TXInput{
-				Txid:   Hex2Bytes(prevTxId),
-				OutIdx: outIdx,
-				PubKey: pubKey,
-			}
+			vin := input
+			vin.Txid = Hex2Bytes(prevTxId)
+			vin.OutIdx = outIdx
 
 			Vin = append(Vin, vin)
 		}
@@ -80,16 +124,14 @@ func NewUTXOTransaction(pubKey []byte, to string, amount int, utxos UTXOSet) (*T
 	vout := TXOutput{
 		Value: amount,
 	}
-	vout.Lock(to)
+	if err := vout.Lock(to); err != nil {
+		return nil, err
+	}
 
 	Vout = append(Vout, vout)
-	if spendableAmt > amount {
-		change := TXOutput{
-			Value: spendableAmt - amount,
-		}
-		change.PubKeyHash = HashPubKey(pubKey)
+	if spendableAmt > amount+fee {
+		change.Value = spendableAmt - amount - fee
 		Vout = append(Vout, change)
-
 	}
 
 	tx := &Transaction{Vin: Vin, Vout: Vout}
@@ -97,9 +139,98 @@ func NewUTXOTransaction(pubKey []byte, to string, amount int, utxos UTXOSet) (*T
 	return tx, nil
 }
 
-// IsCoinbase checks whether the transaction is coinbase
+// Fee returns the fee paid by the transaction, i.e., the value of the spent
+// outputs minus the value of its outputs. The spent outputs are given in
+// the order of the inputs. Transactions creating value are rejected.
+func (tx Transaction) Fee(spent []TXOutput) (int, error) {
+	if len(spent) != len(tx.Vin) {
+		return 0, ErrTxInputNotFound
+	}
+	in, err := sumValues(spent)
+	if err != nil {
+		return 0, err
+	}
+	out, err := sumValues(tx.Vout)
+	if err != nil {
+		return 0, err
+	}
+	if out > in {
+		return 0, ErrValueNotConserved
+	}
+	return in - out, nil
+}
+
+// sumValues returns the total value of the outputs, each of them and
+// their total in [0, MaxMoney], so the sum does not overflow
+func sumValues(outs []TXOutput) (int, error) {
+	total := 0
+	for _, out := range outs {
+		if out.Value < 0 {
+			return 0, ErrNegativeValue
+		}
+		if out.Value > MaxMoney-total {
+			return 0, fmt.Errorf("%w: %d + %d", ErrValueTooLarge, total, out.Value)
+		}
+		total += out.Value
+	}
+	return total, nil
+}
+
+// checkCoinbaseValue checks that the coinbase of the block at the given
+// height claims at most the block reward plus the fees of the block
+func checkCoinbaseValue(coinbase *Transaction, height, fees int) error {
+	value, err := sumValues(coinbase.Vout)
+	if err != nil {
+		return err
+	}
+	if subsidy := BlockSubsidy(height); value > subsidy+fees {
+		return fmt.Errorf("%w: %d > %d + %d", ErrCoinbaseValue, value, subsidy, fees)
+	}
+	return nil
+}
+
+// IsFinal reports whether the transaction can be in the block at the
+// given height and time: it has no lock time, or its lock time, a height
+// or a timestamp depending on LockTimeThreshold, is before the block
+func (tx Transaction) IsFinal(height int, blockTime int64) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+	if tx.LockTime < LockTimeThreshold {
+		return int64(tx.LockTime) < int64(height)
+	}
+	return int64(tx.LockTime) < blockTime
+}
+
+// encodingVersion returns the version of the encoding of the transaction,
+// see serialization.go
+func (tx *Transaction) encodingVersion() uint32 {
+	for _, in := range tx.Vin {
+		if len(in.ScriptSig) > 0 {
+			return txScriptVersion
+		}
+	}
+	return tx.signedVersion()
+}
+
+// signedVersion returns the version of the encoding of the signed part
+// of the transaction, which leaves out the scripts of the inputs
+func (tx *Transaction) signedVersion() uint32 {
+	if tx.LockTime != 0 {
+		return txScriptVersion
+	}
+	for _, out := range tx.Vout {
+		if len(out.Script) > 0 {
+			return txScriptVersion
+		}
+	}
+	return txVersion
+}
+
+// IsCoinbase checks whether the transaction is coinbase: its single
+// input spends no output
 func (tx Transaction) IsCoinbase() bool {
-	return tx.Vin[0].OutIdx == -1
+	return len(tx.Vin) == 1 && tx.Vin[0].OutIdx == -1
 }
 
 // Equals checks if the given transaction ID matches the ID of tx
@@ -107,20 +238,36 @@ func (tx Transaction) Equals(ID []byte) bool {
 	return bytes.Equal(tx.ID, ID)
 }
 
-// Serialize returns a serialized Transaction
+// Serialize returns the binary encoding of the Transaction,
+// see serialization.go
 func (tx Transaction) Serialize() []byte {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	err := enc.Encode(tx)
-	if err != nil {
-		return nil
+	var e encoder
+	e.putTransaction(&tx)
+	return e.buf.Bytes()
+}
+
+// DeserializeTransaction decodes a Transaction serialized by Transaction.Serialize
+func DeserializeTransaction(data []byte) (*Transaction, error) {
+	d := decoder{data: data}
+	tx := d.transaction()
+	if err := d.finish(); err != nil {
+		return nil, err
 	}
-	return buffer.Bytes()
+	return tx, nil
 }
 
-// Hash returns the hash of the Transaction
+// Hash returns the hash of the Transaction: the sha256 of its encoding
+// without ID nor the unlocking data of its inputs, which is set once the
+// ID is known, so signing a transaction does not change its ID. The data
+// of the coinbase input is kept, it holds the height of the block.
 func (tx *Transaction) Hash() []byte {
-	tx1 := Transaction{ID: []byte{}, Vin: tx.Vin, Vout: tx.Vout}
+	tx1 := Transaction{ID: []byte{}, Vin: tx.Vin, Vout: tx.Vout, LockTime: tx.LockTime}
+	if !tx.IsCoinbase() {
+		tx1.Vin = make([]TXInput, len(tx.Vin))
+		for i, vin := range tx.Vin {
+			tx1.Vin[i] = TXInput{Txid: vin.Txid, OutIdx: vin.OutIdx}
+		}
+	}
 	data := tx1.Serialize()
 	hash := sha256.Sum256(data)
 	return hash[:]
@@ -128,20 +275,36 @@ func (tx *Transaction) Hash() []byte {
 
 // TrimmedCopy creates a trimmed copy of Transaction to be used in signing
 func (tx Transaction) TrimmedCopy() Transaction {
-	copyTx := new(Transaction)
-
-	buf := new(bytes.Buffer)
-	gob.NewEncoder(buf).Encode(tx)
-	gob.NewDecoder(buf).Decode(copyTx)
+	copyTx, _ := DeserializeTransaction(tx.Serialize())
 
 	for idx := range copyTx.Vin {
 		copyTx.Vin[idx].Signature = nil
 		copyTx.Vin[idx].PubKey = nil
+		copyTx.Vin[idx].ScriptSig = nil
 	}
 
 	return *copyTx
 }
 
+// SignInput returns the signature of the input idx, spending prevOut,
+// to put in its unlocking script, see script.go
+func (tx *Transaction) SignInput(idx int, prevOut TXOutput, privKey ecdsa.PrivateKey) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.Vin) {
+		return nil, ErrTxInputNotFound
+	}
+	return signDigest(&privKey, tx.SignatureHash(idx, prevOut))
+}
+
+// VerifyInputScript runs the unlocking script of the input idx and the
+// locking script of prevOut, the output it spends, see script.go
+func (tx *Transaction) VerifyInputScript(idx int, prevOut TXOutput) error {
+	if idx < 0 || idx >= len(tx.Vin) {
+		return ErrTxInputNotFound
+	}
+	checker := &inputChecker{tx: tx, idx: idx, prevOut: prevOut}
+	return verifyScripts(tx.Vin[idx].UnlockingScript(), prevOut.LockingScript(), checker)
+}
+
 // Sign signs each input of a Transaction
 func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]*Transaction) error {
 	// TODO(student)
@@ -155,6 +318,7 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]*Transa
 	// Make sure that each input of the copy to be signed
 	// have the correct PubKeyHash of each output in the prevTXs
 	// Store the signature as a concatenation of R and S fields
+	// (the signature is now DER encoded with a low S, see signDigest)
 
 	// Coinbase transactions are not signed.
 	if tx.IsCoinbase() {
@@ -169,25 +333,18 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]*Transa
 		}
 	}
 
-	copyTx := tx.TrimmedCopy()
-
-	for idx, vin := range copyTx.Vin {
-		// tid := fmt.Sprintf("%x", vin.Txid)
-		tid := hex.EncodeToString(vin.Txid)
-		prevOut := prevTXs[tid]
-		for _, out := range prevOut.Vout {
-			if bytes.Equal(vin.Txid, prevOut.ID) {
-				copyTx.Vin[idx].PubKey = out.PubKeyHash
-				break
-			}
+	for idx, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.OutIdx < 0 || vin.OutIdx >= len(prevTx.Vout) {
+			return ErrTxInputNotFound
 		}
-		r, s, _ := ecdsa.Sign(rand.Reader, &privKey, vin.PubKey)
-		var concat []byte
-		concat = append(concat, r.Bytes()...)
-		concat = append(concat, s.Bytes()...)
 
-		tx.Vin[idx].Signature = concat
-		copyTx.Vin[idx].PubKey = nil
+		// each input signs its own digest, see SignatureHash
+		signature, err := signDigest(&privKey, tx.SignatureHash(idx, prevTx.Vout[vin.OutIdx]))
+		if err != nil {
+			return err
+		}
+		tx.Vin[idx].Signature = signature
 	}
 
 	return nil
@@ -225,43 +382,14 @@ func (tx Transaction) Verify(prevTXs map[string]*Transaction) bool {
 		}
 	}
 
-	copyTx := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
-	for idx, vin := range copyTx.Vin {
-		// tid := fmt.Sprintf("%x", vin.Txid)
-		tid := hex.EncodeToString(vin.Txid)
-		prevOut := prevTXs[tid]
-		for _, out := range prevOut.Vout {
-			if bytes.Equal(vin.Txid, prevOut.ID) {
-				copyTx.Vin[idx].PubKey = out.PubKeyHash
-				break
-			}
+	for idx, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.OutIdx < 0 || vin.OutIdx >= len(prevTx.Vout) {
+			return false
 		}
-
-		r := new(big.Int)
-		s := new(big.Int)
-		x := new(big.Int)
-		y := new(big.Int)
-
-		sign := tx.Vin[idx].Signature
-		r.SetBytes(sign[:len(sign)/2])
-		s.SetBytes(sign[len(sign)/2:])
-
-		pubKey := tx.Vin[idx].PubKey
-		x.SetBytes(pubKey[0 : len(pubKey)/2])
-		y.SetBytes(pubKey[len(pubKey)/2:])
-
-		pub := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
-		hash := copyTx.Serialize()
-
-		status := ecdsa.Verify(&pub, hash, r, s)
-
-		if !status {
+		if tx.VerifyInputScript(idx, prevTx.Vout[vin.OutIdx]) != nil {
 			return false
 		}
-		copyTx.Vin[idx].PubKey = nil
-
 	}
 
 	return true
@@ -279,12 +407,21 @@ func (tx Transaction) String() string {
 		lines = append(lines, fmt.Sprintf("       OutIdx:    %d", input.OutIdx))
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey: %x", input.PubKey))
+		if len(input.ScriptSig) > 0 {
+			lines = append(lines, fmt.Sprintf("       ScriptSig: %s", disasmOrHex(input.ScriptSig)))
+		}
 	}
 
 	for i, output := range tx.Vout {
 		lines = append(lines, fmt.Sprintf("     Output %d:", i))
 		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
 		lines = append(lines, fmt.Sprintf("       PubKeyHash: %x", output.PubKeyHash))
+		if len(output.Script) > 0 {
+			lines = append(lines, fmt.Sprintf("       Script: %s", disasmOrHex(output.Script)))
+		}
+	}
+	if tx.LockTime != 0 {
+		lines = append(lines, fmt.Sprintf("     LockTime: %d", tx.LockTime))
 	}
 
 	return strings.Join(lines, "\n")
@@ -8,6 +8,16 @@ type TXInput struct {
 	OutIdx    int    // The index of the specific output in the transaction. The first output is 0, etc.
 	Signature []byte // The signature of this input
 	PubKey    []byte // The logic that authorizes the use of this input by satisfying the output's PubKeyHash. In this demo we will be using the raw public key (not hashed)
+	ScriptSig []byte // The unlocking script of the input, when it does not spend the output with Signature and PubKey, see UnlockingScript
+}
+
+// UnlockingScript returns the script unlocking the spent output, see
+// script.go: the ScriptSig of the input, or <Signature> <PubKey>
+func (in *TXInput) UnlockingScript() []byte {
+	if len(in.ScriptSig) > 0 {
+		return in.ScriptSig
+	}
+	return NewScriptBuilder().AddData(in.Signature).AddData(in.PubKey).Script()
 }
 
 // UsesKey checks whether the address initiated the transaction
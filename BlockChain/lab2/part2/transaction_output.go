@@ -9,18 +9,57 @@ import (
 type TXOutput struct {
 	Value      int    // The transaction value
 	PubKeyHash []byte // The conditions to claim this output. For this demo we will use the hash of the public key (used to "lock" the output)
+	Script     []byte // The locking script of the output, when it is not locked with PubKeyHash, see LockingScript
+}
+
+// LockingScript returns the script locking the output, see script.go:
+// its Script, or the P2PKHScript of its PubKeyHash
+func (out *TXOutput) LockingScript() []byte {
+	if len(out.Script) > 0 {
+		return out.Script
+	}
+	return P2PKHScript(out.PubKeyHash)
+}
+
+// LockHash returns the hash the output is locked with: its PubKeyHash,
+// the hash of the redeem script of a P2SHScript, or the hash of its Script.
+// The outputs of the UTXO index are found by this hash, so the outputs
+// locked with a script and with its P2SHScript are found together.
+func (out *TXOutput) LockHash() []byte {
+	if hash, ok := p2shHash(out.Script); ok {
+		return hash
+	}
+	if len(out.Script) > 0 {
+		return HashPubKey(out.Script)
+	}
+	return out.PubKeyHash
+}
+
+// IsUnspendable reports whether the output can never be spent,
+// as the outputs carrying data, see DataScript
+func (out *TXOutput) IsUnspendable() bool {
+	return len(out.Script) > 0 && out.Script[0] == OP_RETURN
 }
 
 // Lock locks the transaction to a specific address
-// Only this address owns this transaction
-func (out *TXOutput) Lock(address string) {
-	pubKey := GetPubKeyHashFromAddress(address)
-	out.PubKeyHash = pubKey
+// Only this address owns this transaction. The addresses of scripts,
+// see MultisigAddress, lock it with a P2SHScript.
+func (out *TXOutput) Lock(address string) error {
+	version, hash, err := DecodeAddress(address)
+	if err != nil {
+		return err
+	}
+	if version == scriptHashVersion {
+		out.Script = P2SHScript(hash)
+		return nil
+	}
+	out.PubKeyHash = hash
+	return nil
 }
 
 // IsLockedWithKey checks if the output can be used by the owner of the pubkey
 func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
-	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+	return bytes.Equal(out.LockHash(), pubKeyHash)
 }
 
 // NewTXOutput create a new TXOutput
@@ -28,6 +67,27 @@ func NewTXOutput(value int, address string) *TXOutput {
 	return &TXOutput{Value: value, PubKeyHash: []byte(address)}
 }
 
+// Serialize returns the binary encoding of the TXOutput,
+// see serialization.go
+func (out TXOutput) Serialize() []byte {
+	var e encoder
+	e.putOutput(out, txScriptVersion)
+	return e.buf.Bytes()
+}
+
+// DeserializeOutput decodes a TXOutput serialized by TXOutput.Serialize
+func DeserializeOutput(data []byte) (*TXOutput, error) {
+	d := decoder{data: data}
+	out := d.output(txScriptVersion)
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
 func (out TXOutput) String() string {
+	if len(out.Script) > 0 {
+		return fmt.Sprintf("{%d, %s}", out.Value, disasmOrHex(out.Script))
+	}
 	return fmt.Sprintf("{%d, %x}", out.Value, out.PubKeyHash)
 }
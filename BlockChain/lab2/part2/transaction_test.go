@@ -2,7 +2,7 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -37,9 +37,7 @@ func TestSerialize(t *testing.T) {
 		t.Run(name, func(t *testing.T) {
 			serialized := tx.Serialize()
 
-			dec := gob.NewDecoder(bytes.NewReader(serialized))
-			decoded := &Transaction{}
-			err := dec.Decode(decoded)
+			decoded, err := DeserializeTransaction(serialized)
 			if err != nil {
 				t.Fatalf("error decoding tx: %v", err)
 			}
@@ -65,16 +63,20 @@ func TestIsCoinbase(t *testing.T) {
 
 	tx1 := testTransactions["tx1"]
 	assert.False(t, tx1.IsCoinbase())
+
+	assert.False(t, Transaction{}.IsCoinbase())
+	twoInputs := Transaction{Vin: []TXInput{tx0.Vin[0], tx0.Vin[0]}}
+	assert.False(t, twoInputs.IsCoinbase())
 }
 
 func TestNewCoinbaseTXWithData(t *testing.T) {
 	// Passing data to the coinbase transaction
-	tx, err := NewCoinbaseTX("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh", GenesisCoinbaseData)
+	tx, err := NewCoinbaseTX("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh", GenesisCoinbaseData, 0)
 	if tx == nil {
 		t.Fatal("NewCoinbaseTX returned nil")
 	}
 	assert.Nil(t, err)
-	assert.Equal(t, Hex2Bytes("5468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73"), tx.Vin[0].PubKey)
+	assert.Equal(t, Hex2Bytes("00"+"5468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73"), tx.Vin[0].PubKey)
 	assert.Equal(t, -1, tx.Vin[0].OutIdx)
 	assert.Nil(t, tx.Vin[0].Txid)
 	assert.Nil(t, tx.Vin[0].Signature)
@@ -84,7 +86,7 @@ func TestNewCoinbaseTXWithData(t *testing.T) {
 
 func TestNewCoinbaseTXWithDefaultData(t *testing.T) {
 	// Using default data
-	tx, err := NewCoinbaseTX("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh", "")
+	tx, err := NewCoinbaseTX("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh", "", 1)
 	if tx == nil {
 		t.Fatal("NewCoinbaseTX returned nil")
 	}
@@ -105,7 +107,7 @@ func TestNewUTXOTransaction(t *testing.T) {
 	// "from" address have 10 (i.e., genesis coinbase) and "to" address have 0
 	bc := newMockBlockchain()
 	utxos := UTXOSet{
-		"9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2": {0: testTransactions["tx0"].Vout[0]},
+		"c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa": {0: testTransactions["tx0"].Vout[0]},
 	}
 
 	// Reject if there is not sufficient funds
@@ -122,12 +124,12 @@ func TestNewUTXOTransaction(t *testing.T) {
 
 	removeTXInputSignature(tx1)
 
-	diff(t, testTransactions["tx1"], tx1, "incorrect transaction")
+	diff(t, unsignedTestTransaction("tx1"), tx1, "incorrect transaction")
 
 	// update utxo and blockchain with tx1
 	addMockBlock(bc, testBlockchainData["block1"])
 	utxos = UTXOSet{
-		"397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13": {
+		"ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49": {
 			0: testTransactions["tx1"].Vout[0],
 			1: testTransactions["tx1"].Vout[1],
 		},
@@ -136,22 +138,85 @@ func TestNewUTXOTransaction(t *testing.T) {
 	tx2, err := NewUTXOTransaction(pubKey2Bytes, fromAddress, 3, utxos)
 	assert.Nil(t, err)
 	removeTXInputSignature(tx2)
-	diff(t, testTransactions["tx2"], tx2, "incorrect transaction")
+	diff(t, unsignedTestTransaction("tx2"), tx2, "incorrect transaction")
 
 	tx3, err := NewUTXOTransaction(pubKey1Bytes, toAddress, 1, utxos)
 	assert.Nil(t, err)
 	removeTXInputSignature(tx3)
-	diff(t, testTransactions["tx3"], tx3, "incorrect transaction")
+	diff(t, unsignedTestTransaction("tx3"), tx3, "incorrect transaction")
+}
+
+func TestNewUTXOTransactionWithFee(t *testing.T) {
+	pubKey1Bytes := Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748")
+	toAddress := "1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX"
+	utxos := UTXOSet{
+		"c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa": {0: testTransactions["tx0"].Vout[0]},
+	}
+
+	tx, err := NewUTXOTransactionWithFee(pubKey1Bytes, toAddress, 5, 2, utxos)
+	assert.Nil(t, err)
+	assert.Equal(t, []TXOutput{
+		{Value: 5, PubKeyHash: Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")},
+		{Value: 3, PubKeyHash: Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38")},
+	}, tx.Vout)
+	fee, err := tx.Fee([]TXOutput{testTransactions["tx0"].Vout[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, fee)
+
+	// no change output when the fee takes the rest
+	tx, err = NewUTXOTransactionWithFee(pubKey1Bytes, toAddress, 5, 5, utxos)
+	assert.Nil(t, err)
+	assert.Len(t, tx.Vout, 1)
+
+	_, err = NewUTXOTransactionWithFee(pubKey1Bytes, toAddress, 5, 6, utxos)
+	assert.ErrorIs(t, err, ErrNoFunds)
+	_, err = NewUTXOTransactionWithFee(pubKey1Bytes, toAddress, 5, -1, utxos)
+	assert.ErrorIs(t, err, ErrNegativeValue)
+}
+
+func TestFee(t *testing.T) {
+	tx1 := testTransactions["tx1"]
+	fee, err := tx1.Fee([]TXOutput{testTransactions["tx0"].Vout[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fee)
+
+	_, err = tx1.Fee([]TXOutput{{Value: 9}})
+	assert.ErrorIs(t, err, ErrValueNotConserved)
+	_, err = tx1.Fee(nil)
+	assert.ErrorIs(t, err, ErrTxInputNotFound)
+
+	negative := Transaction{Vin: tx1.Vin, Vout: []TXOutput{{Value: -1}, {Value: 11}}}
+	_, err = negative.Fee([]TXOutput{{Value: 10}})
+	assert.ErrorIs(t, err, ErrNegativeValue)
+
+	// the sums of the values cannot overflow
+	overflow := Transaction{Vin: tx1.Vin, Vout: []TXOutput{{Value: math.MaxInt64}, {Value: math.MaxInt64}}}
+	_, err = overflow.Fee([]TXOutput{{Value: 10}})
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	_, err = tx1.Fee([]TXOutput{{Value: math.MaxInt64}})
+	assert.ErrorIs(t, err, ErrValueTooLarge)
+	assert.ErrorIs(t, checkCoinbaseValue(&overflow, 1, 0), ErrValueTooLarge)
+}
+
+func TestNewCoinbaseTXWithFees(t *testing.T) {
+	tx, err := NewCoinbaseTXWithFees("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh", "data", 1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, BlockReward+3, tx.Vout[0].Value)
+	assert.Nil(t, checkCoinbaseValue(tx, 1, 3))
+	assert.ErrorIs(t, checkCoinbaseValue(tx, 1, 2), ErrCoinbaseValue)
+
+	_, err = NewCoinbaseTXWithFees("14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh", "data", 1, -1)
+	assert.ErrorIs(t, err, ErrNegativeValue)
 }
 
 func TestSign(t *testing.T) {
-	privKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
 
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
 				Signature: nil,
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
@@ -170,7 +235,7 @@ func TestSign(t *testing.T) {
 	}
 
 	prevTXs := make(map[string]*Transaction)
-	prevTXs["9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"] = testTransactions["tx0"]
+	prevTXs["c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"] = testTransactions["tx0"]
 
 	err := tx.Sign(*privKey, prevTXs)
 	assert.Nil(t, err)
@@ -178,10 +243,10 @@ func TestSign(t *testing.T) {
 }
 
 func TestSignIgnoreCoinbaseTX(t *testing.T) {
-	privKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
 
 	tx := &Transaction{
-		ID: Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+		ID: Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 		Vin: []TXInput{
 			{Txid: nil, OutIdx: -1, Signature: nil, PubKey: []byte(GenesisCoinbaseData)},
 		},
@@ -200,10 +265,10 @@ func TestSignIgnoreCoinbaseTX(t *testing.T) {
 }
 
 func TestSignInvalidInputTX(t *testing.T) {
-	privKey, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
 
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
 				Txid:      Hex2Bytes("non-existentID"),
@@ -225,7 +290,7 @@ func TestSignInvalidInputTX(t *testing.T) {
 	}
 
 	prevTXs := make(map[string]*Transaction)
-	prevTXs["9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"] = testTransactions["tx0"]
+	prevTXs["c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"] = testTransactions["tx0"]
 
 	err := tx.Sign(*privKey, prevTXs)
 	assert.ErrorIs(t, err, ErrTxInputNotFound)
@@ -240,12 +305,12 @@ func TestVerifyIgnoreCoinbaseTX(t *testing.T) {
 
 func TestVerify(t *testing.T) {
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
-				Signature: Hex2Bytes("17b6db89942bb02b485332c9a3b37638e02a3dfafdf4c3a4fad7fc4c7b062cc8156b75957050e049cd307853522f5ef49339b1b1230359f59571af12c612bde2"),
+				Signature: Hex2Bytes("3045022100ae8cdb5a2884b0cb70cab16f3a5d31533a650271ba551b1aac54c60ff95cc8390220482f43005c48d82d31a7c057ac9a49b1edc2c2fed1c5dcbece551740740cdb57"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
 			},
 		},
@@ -262,14 +327,14 @@ func TestVerify(t *testing.T) {
 	}
 
 	prevTXs := make(map[string]*Transaction)
-	prevTXs["9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"] = testTransactions["tx0"]
+	prevTXs["c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"] = testTransactions["tx0"]
 
 	assert.True(t, tx.Verify(prevTXs))
 }
 
 func TestVerifyInvalidInputTX(t *testing.T) {
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
 				Txid:      Hex2Bytes("non-existentID"),
@@ -291,17 +356,17 @@ func TestVerifyInvalidInputTX(t *testing.T) {
 	}
 
 	prevTXs := make(map[string]*Transaction)
-	prevTXs["9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"] = testTransactions["tx0"]
+	prevTXs["c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"] = testTransactions["tx0"]
 
 	assert.False(t, tx.Verify(prevTXs))
 }
 
 func TestVerifyInvalidSignature(t *testing.T) {
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
 				Signature: Hex2Bytes("invalid"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
@@ -320,17 +385,17 @@ func TestVerifyInvalidSignature(t *testing.T) {
 	}
 
 	prevTXs := make(map[string]*Transaction)
-	prevTXs["9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"] = testTransactions["tx0"]
+	prevTXs["c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"] = testTransactions["tx0"]
 
 	assert.False(t, tx.Verify(prevTXs))
 }
 
 func TestTrimmedCopy(t *testing.T) {
 	tx := &Transaction{
-		ID: Hex2Bytes("397b990007845099b4fe50ba23490f277b3bf6f5316b4082c343b14c5504ab13"),
+		ID: Hex2Bytes("ee1078a8bd41b63a01e57db3a836fddad30c3186733a959504b9ea4e9480ce49"),
 		Vin: []TXInput{
 			{
-				Txid:      Hex2Bytes("9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"),
+				Txid:      Hex2Bytes("c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"),
 				OutIdx:    0,
 				Signature: Hex2Bytes("17b6db89942bb02b485332c9a3b37638e02a3dfafdf4c3a4fad7fc4c7b062cc8156b75957050e049cd307853522f5ef49339b1b1230359f59571af12c612bde2"),
 				PubKey:    Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"),
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoOutputs   = errors.New("transaction has no outputs")
+	ErrOutputValue = errors.New("output value must be positive")
+)
+
+// maxSignatureSize is the size of the largest DER encoded ECDSA
+// signature, larger than a Schnorr signature. The fee of an input is
+// computed with it before the input is signed.
+const maxSignatureSize = 72
+
+// Coin is an unspent output with its outpoint
+type Coin struct {
+	Txid   []byte
+	OutIdx int
+	Output TXOutput
+}
+
+// spentOutputs returns the outputs of the coins, the outputs spent by
+// the inputs of the transaction built with them
+func spentOutputs(coins []Coin) []TXOutput {
+	spent := make([]TXOutput, len(coins))
+	for i, coin := range coins {
+		spent[i] = coin.Output
+	}
+	return spent
+}
+
+// CoinFinder lists the unspent outputs locked with a lock hash, see
+// TXOutput.LockHash, that the next block can spend.
+// It is implemented by both UTXOSet and UTXOIndex.
+type CoinFinder interface {
+	FindCoins(lockHash []byte) []Coin
+}
+
+// TxBuilder builds an unsigned transaction paying several addresses with
+// the coins of a key. The coins spent are chosen by a CoinSelector, and
+// the fee is paid at a rate per byte of the signed transaction. The
+// change goes back to the key, or to a change address.
+//
+// The transaction is signed afterwards, possibly on another machine,
+// with the outputs it spends, see Transaction.Sign.
+type TxBuilder struct {
+	// Selector chooses the coins spent, LargestFirst by default
+	Selector CoinSelector
+	// FeeRate is the fee paid per byte of the signed transaction
+	FeeRate int
+	// Fee is a fixed fee, paid on top of the fee of FeeRate
+	Fee int
+	// LockTime is the lock time of the transaction, see Transaction.IsFinal
+	LockTime uint32
+
+	pubKey  []byte
+	coins   CoinFinder
+	outputs []TXOutput
+	change  TXOutput
+}
+
+// NewTxBuilder returns a builder spending the coins of the public key,
+// which receives the change
+func NewTxBuilder(pubKey []byte, coins CoinFinder) *TxBuilder {
+	return &TxBuilder{
+		Selector: LargestFirst,
+		pubKey:   pubKey,
+		coins:    coins,
+		change:   TXOutput{PubKeyHash: HashPubKey(pubKey)},
+	}
+}
+
+// AddOutput adds an output paying value to the address
+func (b *TxBuilder) AddOutput(address string, value int) error {
+	if value <= 0 {
+		return fmt.Errorf("%w: %d", ErrOutputValue, value)
+	}
+	out := TXOutput{Value: value}
+	if err := out.Lock(address); err != nil {
+		return err
+	}
+	b.outputs = append(b.outputs, out)
+	return nil
+}
+
+// SetChangeAddress sends the change to the address instead of the key
+func (b *TxBuilder) SetChangeAddress(address string) error {
+	var change TXOutput
+	if err := change.Lock(address); err != nil {
+		return err
+	}
+	b.change = change
+	return nil
+}
+
+// Build selects the coins and returns the unsigned transaction with the
+// coins it spends, in the order of its inputs. The change output, if
+// any, is the last output. A change worth less than the fee of creating
+// and spending it is left to the miner.
+func (b *TxBuilder) Build() (*Transaction, []Coin, error) {
+	if len(b.outputs) == 0 {
+		return nil, nil, ErrNoOutputs
+	}
+	if b.FeeRate < 0 || b.Fee < 0 {
+		return nil, nil, ErrNegativeValue
+	}
+	tx := &Transaction{Vout: append([]TXOutput{}, b.outputs...), LockTime: b.LockTime}
+
+	// the sizes of the parts of the signed transaction, in the encoding
+	// of the transaction with a change output, see encodingVersion
+	sized := &Transaction{ID: make([]byte, 32), Vout: append(append([]TXOutput{}, b.outputs...), b.change), LockTime: b.LockTime}
+	version := sized.signedVersion()
+	var enc encoder
+	enc.putInput(TXInput{Txid: sized.ID, Signature: make([]byte, maxSignatureSize), PubKey: b.pubKey}, version)
+	inputSize := enc.buf.Len()
+	enc = encoder{}
+	enc.putOutput(b.change, version)
+	changeSize := enc.buf.Len()
+	baseSize := len(sized.Serialize()) - changeSize
+
+	target := b.Fee + b.FeeRate*baseSize
+	for _, out := range b.outputs {
+		target += out.Value
+	}
+	costOfChange := b.FeeRate * (changeSize + inputSize)
+
+	// the coins worth less than the fee of spending them are left out
+	var candidates []Coin
+	var values []int
+	for _, coin := range b.coins.FindCoins(HashPubKey(b.pubKey)) {
+		if value := coin.Output.Value - b.FeeRate*inputSize; value > 0 {
+			candidates = append(candidates, coin)
+			values = append(values, value)
+		}
+	}
+	selected, err := b.Selector.SelectCoins(values, target, costOfChange)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coins := make([]Coin, len(selected))
+	excess := -target
+	for i, idx := range selected {
+		coins[i] = candidates[idx]
+		excess += values[idx]
+		tx.Vin = append(tx.Vin, TXInput{Txid: coins[i].Txid, OutIdx: coins[i].OutIdx, PubKey: b.pubKey})
+	}
+	if excess > costOfChange {
+		change := b.change
+		change.Value = excess - b.FeeRate*changeSize
+		tx.Vout = append(tx.Vout, change)
+	}
+	tx.ID = tx.Hash()
+	return tx, coins, nil
+}
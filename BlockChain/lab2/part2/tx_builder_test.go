@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestCoins returns the key of testEncPrivKeyUser1 with the UTXO set
+// of coins worth 1000, 2000 and 5000 locked to it, and the transactions
+// of these coins
+func newTestCoins() (*ecdsa.PrivateKey, UTXOSet, map[string]*Transaction) {
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	pubKeyHash := HashPubKey(pubKeyToByte(privKey.PublicKey))
+	other := HashPubKey([]byte("someone else"))
+	prevTXs := []*Transaction{
+		{Vout: []TXOutput{{Value: 1000, PubKeyHash: pubKeyHash}, {Value: 2000, PubKeyHash: pubKeyHash}}},
+		{Vout: []TXOutput{{Value: 7000, PubKeyHash: other}, {Value: 5000, PubKeyHash: pubKeyHash}}},
+	}
+	txs := make(map[string]*Transaction)
+	for _, tx := range prevTXs {
+		tx.ID = tx.Hash()
+		txs[hex.EncodeToString(tx.ID)] = tx
+	}
+	utxos := make(UTXOSet)
+	utxos.Update(prevTXs)
+	return privKey, utxos, txs
+}
+
+func TestTxBuilder(t *testing.T) {
+	privKey, utxos, prevTXs := newTestCoins()
+	pubKey := pubKeyToByte(privKey.PublicKey)
+
+	// several recipients and the change
+	builder := NewTxBuilder(pubKey, utxos)
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 1500))
+	assert.Nil(t, builder.AddOutput(addressTable[1].address, 2500))
+	tx, coins, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, coins, 1) {
+		assert.Equal(t, 5000, coins[0].Output.Value)
+		assert.Equal(t, TXInput{Txid: coins[0].Txid, OutIdx: 1, PubKey: pubKey}, tx.Vin[0])
+	}
+	if assert.Len(t, tx.Vout, 3) {
+		assert.Equal(t, 1500, tx.Vout[0].Value)
+		assert.Equal(t, 2500, tx.Vout[1].Value)
+		assert.Equal(t, TXOutput{Value: 1000, PubKeyHash: HashPubKey(pubKey)}, tx.Vout[2])
+	}
+	assert.Equal(t, tx.Hash(), tx.ID)
+
+	// the unsigned transaction is serialized, then signed
+	unsigned, err := DeserializeTransaction(tx.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, tx, unsigned)
+	assert.Nil(t, unsigned.Sign(*privKey, prevTXs))
+	assert.True(t, unsigned.Verify(prevTXs))
+	fee, err := unsigned.Fee(spentOutputs(coins))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, fee)
+
+	// the coins are spent in the order of the selector
+	builder = NewTxBuilder(pubKey, utxos)
+	builder.Selector = SmallestFirst
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 2500))
+	tx, coins, err = builder.Build()
+	assert.Nil(t, err)
+	if assert.Len(t, coins, 2) && assert.Len(t, tx.Vin, 2) {
+		assert.Equal(t, 1000, coins[0].Output.Value)
+		assert.Equal(t, 2000, coins[1].Output.Value)
+		assert.Equal(t, coins[1].Txid, tx.Vin[1].Txid)
+		assert.Equal(t, coins[1].OutIdx, tx.Vin[1].OutIdx)
+	}
+}
+
+func TestTxBuilderFeeRate(t *testing.T) {
+	privKey, utxos, prevTXs := newTestCoins()
+	pubKey := pubKeyToByte(privKey.PublicKey)
+
+	build := func(selector CoinSelector, value int) (*Transaction, int) {
+		builder := NewTxBuilder(pubKey, utxos)
+		builder.Selector = selector
+		builder.FeeRate = 1
+		assert.Nil(t, builder.AddOutput(addressTable[0].address, value))
+		tx, coins, err := builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, tx.Sign(*privKey, prevTXs))
+		assert.True(t, tx.Verify(prevTXs))
+		fee, err := tx.Fee(spentOutputs(coins))
+		assert.Nil(t, err)
+		return tx, fee
+	}
+
+	// the fee pays for the size of the signed transaction, the signatures
+	// being at most maxSignatureSize bytes
+	for _, selector := range []CoinSelector{LargestFirst, SmallestFirst} {
+		tx, fee := build(selector, 1500)
+		assert.Len(t, tx.Vout, 2, selector.Name())
+		size := len(tx.Serialize())
+		assert.GreaterOrEqual(t, fee, size, selector.Name())
+		assert.LessOrEqual(t, fee, size+8*len(tx.Vin), selector.Name())
+	}
+
+	// a change worth less than the fee of spending it goes to the miner
+	tx, fee := build(LargestFirst, 2500)
+	if assert.Len(t, tx.Vout, 2) {
+		change := tx.Vout[1].Value
+		tx, noChangeFee := build(LargestFirst, 2500+change)
+		assert.Len(t, tx.Vout, 1)
+		assert.Equal(t, fee, noChangeFee)
+	}
+}
+
+func TestTxBuilderChangeAddress(t *testing.T) {
+	privKey, utxos, _ := newTestCoins()
+	builder := NewTxBuilder(pubKeyToByte(privKey.PublicKey), utxos)
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 100))
+	assert.ErrorIs(t, builder.SetChangeAddress("1nope"), ErrInvalidAddress)
+	assert.Nil(t, builder.SetChangeAddress(addressTable[1].address))
+	builder.LockTime = 7
+	tx, _, err := builder.Build()
+	assert.Nil(t, err)
+	if assert.Len(t, tx.Vout, 2) {
+		var change TXOutput
+		assert.Nil(t, change.Lock(addressTable[1].address))
+		change.Value = 4900
+		assert.Equal(t, change, tx.Vout[1])
+	}
+	assert.Equal(t, uint32(7), tx.LockTime)
+}
+
+func TestTxBuilderErrors(t *testing.T) {
+	privKey, utxos, _ := newTestCoins()
+	builder := NewTxBuilder(pubKeyToByte(privKey.PublicKey), utxos)
+	_, _, err := builder.Build()
+	assert.ErrorIs(t, err, ErrNoOutputs)
+	assert.ErrorIs(t, builder.AddOutput(addressTable[0].address, 0), ErrOutputValue)
+	assert.ErrorIs(t, builder.AddOutput("1nope", 1), ErrInvalidAddress)
+
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 8001))
+	_, _, err = builder.Build()
+	assert.ErrorIs(t, err, ErrNoFunds)
+
+	builder = NewTxBuilder(pubKeyToByte(privKey.PublicKey), utxos)
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 1))
+	builder.FeeRate = -1
+	_, _, err = builder.Build()
+	assert.ErrorIs(t, err, ErrNegativeValue)
+
+	// the coins are not worth the fee of spending them
+	builder.FeeRate = 100
+	_, _, err = builder.Build()
+	assert.ErrorIs(t, err, ErrNoFunds)
+}
+
+func TestTxBuilderUTXOIndex(t *testing.T) {
+	bc, err := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	builder := NewTxBuilder(pubKeyToByte(privKey.PublicKey), bc.UTXOIndex())
+	builder.Selector = BranchAndBound
+	assert.Nil(t, builder.AddOutput(addressTable[0].address, 3))
+	builder.Fee = 1
+	tx, coins, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, coins, 1)
+	assert.Nil(t, bc.SignTransaction(tx, *privKey))
+	assert.True(t, bc.VerifyTransaction(tx))
+	fee, err := bc.TransactionFee(tx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, fee)
+	assert.Equal(t, BlockSubsidy(0)-4, tx.Vout[1].Value)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoTxIndex      = errors.New("transaction indexes are disabled, see EnableTxIndex")
+	ErrOutputNotSpent = errors.New("output is not spent in the main chain")
+)
+
+// The transaction indexes are optional secondary indexes of the main
+// chain, for block explorers and audits. Once enabled, see EnableTxIndex,
+// they are kept up to date with every block connected or disconnected,
+// in the same storage transaction as the UTXO index.
+var (
+	// txIndexBucket maps the txid of a transaction of the main chain to
+	// its location: the hash of its block|its index in the block (uint32)
+	txIndexBucket = []byte("txindex")
+	// addrIndexBucket maps len(pkh)|pkh|height|index of the transaction
+	// in the block (uint32) to txid|received|sent, for every transaction
+	// paying or spending outputs locked with pkh, see AddressTx.
+	// The keys of a pkh are in the order of the chain.
+	addrIndexBucket = []byte("addrindex")
+	// spentByBucket maps the outpoint of a spent output (txid|outIdx) to
+	// the input spending it: its txid|its index in the transaction (uint32)
+	spentByBucket = []byte("spentby")
+
+	// txIndexKey is set in the meta bucket while the indexes are kept
+	txIndexKey = []byte("txindex")
+)
+
+// TxLocation is the position of a transaction in the main chain
+type TxLocation struct {
+	BlockHash []byte
+	Height    int
+	Index     int // of the transaction in the block
+}
+
+// AddressTx is a transaction of the main chain paying or spending
+// outputs of an address
+type AddressTx struct {
+	Txid     []byte
+	Height   int
+	Received int // the value of its outputs paying the address
+	Sent     int // the value of the outputs of the address it spends
+}
+
+// SpendingInput is the input of a transaction of the main chain spending
+// an output
+type SpendingInput struct {
+	Txid   []byte
+	InIdx  int
+	Height int
+}
+
+// EnableTxIndex builds the transaction indexes from the blocks of the main
+// chain, and keeps them up to date from then on. The chain must not be
+// pruned, see EnablePruning.
+func (bc *Blockchain) EnableTxIndex() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.db.Update(func(tx StorageTx) error {
+		if isPruned(tx) {
+			return ErrPrunedTxIndex
+		}
+		if err := clearTxIndex(tx); err != nil {
+			return err
+		}
+		if err := tx.Put(metaBucket, txIndexKey, []byte{1}); err != nil {
+			return err
+		}
+		for height := 0; ; height++ {
+			hash := tx.Get(heightsBucket, heightKey(height))
+			if hash == nil {
+				return nil
+			}
+			block, err := getBlock(tx, hash)
+			if err != nil {
+				return err
+			}
+			if err := connectTxIndex(tx, block, height); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// DisableTxIndex drops the transaction indexes
+func (bc *Blockchain) DisableTxIndex() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.db.Update(func(tx StorageTx) error {
+		if err := clearTxIndex(tx); err != nil {
+			return err
+		}
+		return tx.Delete(metaBucket, txIndexKey)
+	})
+}
+
+// HasTxIndex reports whether the transaction indexes are kept
+func (bc *Blockchain) HasTxIndex() bool {
+	var enabled bool
+	bc.db.View(func(tx StorageTx) error {
+		enabled = tx.Get(metaBucket, txIndexKey) != nil
+		return nil
+	})
+	return enabled
+}
+
+func clearTxIndex(tx StorageTx) error {
+	for _, bucket := range [][]byte{txIndexBucket, addrIndexBucket, spentByBucket} {
+		if err := clearBucket(tx, bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindTxLocation returns the location of a transaction of the main chain
+func (bc *Blockchain) FindTxLocation(txID []byte) (*TxLocation, error) {
+	var loc *TxLocation
+	err := bc.db.View(func(tx StorageTx) error {
+		var err error
+		loc, err = findTxLocation(tx, txID)
+		return err
+	})
+	return loc, err
+}
+
+func findTxLocation(tx StorageTx, txID []byte) (*TxLocation, error) {
+	if tx.Get(metaBucket, txIndexKey) == nil {
+		return nil, ErrNoTxIndex
+	}
+	data := tx.Get(txIndexBucket, txID)
+	if data == nil {
+		return nil, ErrTxNotFound
+	}
+	hash, index := splitOutpointKey(data)
+	entry, err := getIndexEntry(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &TxLocation{BlockHash: hash, Height: entry.Height, Index: index}, nil
+}
+
+// findIndexedTransaction returns a transaction of the main chain with
+// its location, or ErrNoTxIndex
+func (bc *Blockchain) findIndexedTransaction(txID []byte) (*Transaction, *TxLocation, error) {
+	loc, err := bc.FindTxLocation(txID)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := bc.GetBlock(loc.BlockHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc.Index >= len(block.Transactions) {
+		return nil, nil, fmt.Errorf("%w: index %d of block %x", ErrTxNotFound, loc.Index, loc.BlockHash)
+	}
+	return block.Transactions[loc.Index], loc, nil
+}
+
+// AddressHistory returns the transactions of the main chain paying or
+// spending outputs locked with lockHash, see TXOutput.LockHash, in the
+// order of the chain
+func (bc *Blockchain) AddressHistory(lockHash []byte) ([]AddressTx, error) {
+	var history []AddressTx
+	err := bc.db.View(func(tx StorageTx) error {
+		if tx.Get(metaBucket, txIndexKey) == nil {
+			return ErrNoTxIndex
+		}
+		prefix := pkhPrefix(lockHash)
+		return tx.ForEach(addrIndexBucket, prefix, func(k, v []byte) error {
+			if len(k) != len(prefix)+12 {
+				return fmt.Errorf("%w: address index key %x", ErrMalformed, k)
+			}
+			d := decoder{data: v}
+			entry := AddressTx{Txid: d.bytes(), Height: int(binary.BigEndian.Uint64(k[len(prefix):]))}
+			entry.Received = int(d.int64())
+			entry.Sent = int(d.int64())
+			if err := d.finish(); err != nil {
+				return err
+			}
+			history = append(history, entry)
+			return nil
+		})
+	})
+	return history, err
+}
+
+// FindSpendingInput returns the input of the main chain spending an
+// output, or ErrOutputNotSpent
+func (bc *Blockchain) FindSpendingInput(txID []byte, outIdx int) (*SpendingInput, error) {
+	var input *SpendingInput
+	err := bc.db.View(func(tx StorageTx) error {
+		if tx.Get(metaBucket, txIndexKey) == nil {
+			return ErrNoTxIndex
+		}
+		data := tx.Get(spentByBucket, outpointKey(txID, outIdx))
+		if data == nil {
+			return ErrOutputNotSpent
+		}
+		spender, inIdx := splitOutpointKey(data)
+		loc, err := findTxLocation(tx, spender)
+		if err != nil {
+			return err
+		}
+		input = &SpendingInput{Txid: spender, InIdx: inIdx, Height: loc.Height}
+		return nil
+	})
+	return input, err
+}
+
+// txIndexRecord is a key of the transaction indexes with its value
+type txIndexRecord struct {
+	bucket, key, value []byte
+}
+
+// txIndexRecords returns the records of the transactions of a block
+// connected at the given height. The outputs spent by the block are read
+// from its undo data, see connectUTXO.
+func txIndexRecords(tx StorageTx, block *Block, height int) ([]txIndexRecord, error) {
+	spent, err := getUndo(tx, block.Hash)
+	if err != nil {
+		return nil, err
+	}
+	var records []txIndexRecord
+	for pos, tran := range block.Transactions {
+		records = append(records, txIndexRecord{txIndexBucket, tran.ID, outpointKey(block.Hash, pos)})
+
+		// the amounts of each address, in the order first seen
+		var lockHashes [][]byte
+		amounts := make(map[string]*AddressTx)
+		amount := func(lockHash []byte) *AddressTx {
+			a, ok := amounts[string(lockHash)]
+			if !ok {
+				a = &AddressTx{}
+				amounts[string(lockHash)] = a
+				lockHashes = append(lockHashes, lockHash)
+			}
+			return a
+		}
+		if !tran.IsCoinbase() {
+			for inIdx, vin := range tran.Vin {
+				if len(spent) == 0 {
+					return nil, fmt.Errorf("%w: undo data of block %x", ErrMalformed, block.Hash)
+				}
+				out := spent[0].Output
+				spent = spent[1:]
+				amount(out.LockHash()).Sent += out.Value
+				records = append(records, txIndexRecord{spentByBucket, outpointKey(vin.Txid, vin.OutIdx), outpointKey(tran.ID, inIdx)})
+			}
+		}
+		for _, out := range tran.Vout {
+			if !out.IsUnspendable() {
+				amount(out.LockHash()).Received += out.Value
+			}
+		}
+
+		for _, lockHash := range lockHashes {
+			var e encoder
+			e.putBytes(tran.ID)
+			e.putInt64(int64(amounts[string(lockHash)].Received))
+			e.putInt64(int64(amounts[string(lockHash)].Sent))
+			key := append(append(pkhPrefix(lockHash), heightKey(height)...), outpointKey(nil, pos)...)
+			records = append(records, txIndexRecord{addrIndexBucket, key, e.buf.Bytes()})
+		}
+	}
+	return records, nil
+}
+
+// connectTxIndex adds the transactions of a block connected at the given
+// height to the indexes, if they are kept. It runs after connectUTXO.
+func connectTxIndex(tx StorageTx, block *Block, height int) error {
+	if tx.Get(metaBucket, txIndexKey) == nil {
+		return nil
+	}
+	records, err := txIndexRecords(tx, block, height)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := tx.Put(r.bucket, r.key, r.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectTxIndex removes the transactions of a block disconnected from
+// the given height from the indexes, if they are kept. It runs before
+// disconnectUTXO, which deletes the undo data of the block.
+func disconnectTxIndex(tx StorageTx, block *Block, height int) error {
+	if tx.Get(metaBucket, txIndexKey) == nil {
+		return nil
+	}
+	records, err := txIndexRecords(tx, block, height)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := tx.Delete(r.bucket, r.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxIndex(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 2)
+	spend := newTestSpend(t, bc, coinbases[0], 7)
+	b := mineTestBlock(t, bc.CurrentBlock(), "b", spend)
+	mustStoreBlock(t, bc, b)
+	minerHash := GetPubKeyHashFromAddress(testMinerAddress)
+	payeeHash := spend.Vout[0].PubKeyHash
+
+	assert.False(t, bc.HasTxIndex())
+	_, err := bc.FindTxLocation(spend.ID)
+	assert.ErrorIs(t, err, ErrNoTxIndex)
+	_, err = bc.AddressHistory(minerHash)
+	assert.ErrorIs(t, err, ErrNoTxIndex)
+	_, err = bc.FindSpendingInput(coinbases[0].ID, 0)
+	assert.ErrorIs(t, err, ErrNoTxIndex)
+
+	// the indexes are built from the blocks of the chain
+	assert.Nil(t, bc.EnableTxIndex())
+	assert.True(t, bc.HasTxIndex())
+	loc, err := bc.FindTxLocation(spend.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, &TxLocation{BlockHash: b.Hash, Height: 2, Index: 1}, loc)
+	loc, err = bc.FindTxLocation(coinbases[0].ID)
+	assert.Nil(t, err)
+	assert.Equal(t, &TxLocation{BlockHash: bc.GetGenesisBlock().Hash, Height: 0, Index: 0}, loc)
+	_, err = bc.FindTxLocation(make([]byte, 32))
+	assert.ErrorIs(t, err, ErrTxNotFound)
+	found, err := bc.FindTransaction(spend.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, spend, found)
+
+	history, err := bc.AddressHistory(minerHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []AddressTx{
+		{Txid: coinbases[0].ID, Height: 0, Received: BlockSubsidy(0)},
+		{Txid: coinbases[1].ID, Height: 1, Received: BlockSubsidy(1)},
+		{Txid: b.Transactions[0].ID, Height: 2, Received: BlockSubsidy(2)},
+		{Txid: spend.ID, Height: 2, Sent: BlockSubsidy(0)},
+	}, history)
+	history, err = bc.AddressHistory(payeeHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []AddressTx{{Txid: spend.ID, Height: 2, Received: 7}}, history)
+	history, err = bc.AddressHistory(HashPubKey([]byte("nobody")))
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+
+	input, err := bc.FindSpendingInput(coinbases[0].ID, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, &SpendingInput{Txid: spend.ID, InIdx: 0, Height: 2}, input)
+	_, err = bc.FindSpendingInput(spend.ID, 0)
+	assert.ErrorIs(t, err, ErrOutputNotSpent)
+
+	// and kept up to date with the new blocks
+	pay := TXOutput{Value: 5, PubKeyHash: payeeHash}
+	c := mineTestBlock(t, b, "c", newScriptSpend(t, bc, coinbases[1], pay))
+	mustStoreBlock(t, bc, c)
+	input, err = bc.FindSpendingInput(coinbases[1].ID, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, &SpendingInput{Txid: c.Transactions[1].ID, InIdx: 0, Height: 3}, input)
+	history, err = bc.AddressHistory(payeeHash)
+	assert.Nil(t, err)
+	assert.Len(t, history, 2)
+
+	assert.Nil(t, bc.DisableTxIndex())
+	assert.False(t, bc.HasTxIndex())
+	_, err = bc.FindTxLocation(spend.ID)
+	assert.ErrorIs(t, err, ErrNoTxIndex)
+	found, err = bc.FindTransaction(spend.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, spend, found)
+}
+
+func TestTxIndexReorg(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 1)
+	assert.Nil(t, bc.EnableTxIndex())
+	genesis := bc.CurrentBlock()
+	minerHash := GetPubKeyHashFromAddress(testMinerAddress)
+
+	spend := newTestSpend(t, bc, coinbases[0], BlockReward)
+	a1 := mineTestBlock(t, genesis, "a1", spend)
+	mustStoreBlock(t, bc, a1)
+	b1 := mineTestBlock(t, genesis, "b1")
+	b2 := mineTestBlock(t, b1, "b2")
+	mustStoreBlock(t, bc, b1)
+	mustStoreBlock(t, bc, b2)
+	assert.Equal(t, b2.Hash, bc.CurrentBlock().Hash)
+
+	// the transactions of branch a left the indexes
+	_, err := bc.FindTxLocation(spend.ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+	_, err = bc.FindSpendingInput(coinbases[0].ID, 0)
+	assert.ErrorIs(t, err, ErrOutputNotSpent)
+	history, err := bc.AddressHistory(minerHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []AddressTx{
+		{Txid: coinbases[0].ID, Height: 0, Received: BlockSubsidy(0)},
+		{Txid: b1.Transactions[0].ID, Height: 1, Received: BlockSubsidy(1)},
+		{Txid: b2.Transactions[0].ID, Height: 2, Received: BlockSubsidy(2)},
+	}, history)
+
+	// a rebuilt index matches the incrementally maintained one
+	assert.Nil(t, bc.EnableTxIndex())
+	rebuilt, err := bc.AddressHistory(minerHash)
+	assert.Nil(t, err)
+	assert.Equal(t, history, rebuilt)
+
+	// and back to branch a
+	a2 := mineTestBlock(t, a1, "a2")
+	a3 := mineTestBlock(t, a2, "a3")
+	mustStoreBlock(t, bc, a2)
+	mustStoreBlock(t, bc, a3)
+	assert.Equal(t, a3.Hash, bc.CurrentBlock().Hash)
+	loc, err := bc.FindTxLocation(spend.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, &TxLocation{BlockHash: a1.Hash, Height: 1, Index: 1}, loc)
+	input, err := bc.FindSpendingInput(coinbases[0].ID, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, spend.ID, input.Txid)
+	_, err = bc.FindTxLocation(b1.Transactions[0].ID)
+	assert.ErrorIs(t, err, ErrTxNotFound)
+}
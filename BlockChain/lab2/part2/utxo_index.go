@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+)
+
+// Buckets used by the UTXO index
+var (
+	// utxoBucket maps an outpoint (txid|outIdx) to its utxoEntry
+	utxoBucket = []byte("utxo")
+	// utxoPKHBucket maps len(pkh)|pkh|txid|outIdx to the utxoEntry,
+	// so all outputs locked to a key share the same key prefix.
+	// The pkh is the LockHash of the output.
+	utxoPKHBucket = []byte("utxo-pkh")
+)
+
+// utxoEntry is an unspent output of the index, with the
+// block of its transaction
+type utxoEntry struct {
+	Output   TXOutput
+	Height   int  // height of the block of the transaction
+	Coinbase bool // whether the transaction is a coinbase
+}
+
+// Serialize encodes the entry: the output encoding, see
+// TXOutput.Serialize, followed by the height and the coinbase flag
+func (e utxoEntry) Serialize() []byte {
+	var enc encoder
+	enc.putOutput(e.Output, txScriptVersion)
+	enc.putUint32(uint32(e.Height))
+	coinbase := uint32(0)
+	if e.Coinbase {
+		coinbase = 1
+	}
+	enc.putUint32(coinbase)
+	return enc.buf.Bytes()
+}
+
+// deserializeUTXOEntry decodes an entry encoded by utxoEntry.Serialize
+func deserializeUTXOEntry(data []byte) (*utxoEntry, error) {
+	d := &decoder{data: data}
+	e := &utxoEntry{Output: d.output(txScriptVersion)}
+	e.Height = int(d.uint32())
+	e.Coinbase = d.uint32() == 1
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// spendableAt reports whether the output can be spent by a transaction
+// of the block at the given height, see CoinbaseMaturity
+func (e utxoEntry) spendableAt(height int) bool {
+	return !e.Coinbase || height-e.Height >= CoinbaseMaturity
+}
+
+// UTXOIndex is the persisted set of unspent transaction outputs
+// of a Blockchain. It is kept up to date with every block stored
+// in the chain and answers queries by public key hash without
+// walking the blocks.
+type UTXOIndex struct {
+	bc *Blockchain
+}
+
+// UTXOIndex returns the UTXO index of the blockchain
+func (bc *Blockchain) UTXOIndex() *UTXOIndex {
+	return &UTXOIndex{bc: bc}
+}
+
+// FindSpendableOutputs finds and returns unspent outputs in the UTXO index
+// to reference in inputs. The outputs of coinbases that are not mature
+// in the next block are left out.
+func (u *UTXOIndex) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	var accumulatedBal int
+	unspentOutputs := make(map[string][]int)
+
+	height := u.bc.Height() + 1
+	u.forEachOutput(pubKeyHash, func(txID []byte, outIdx int, entry *utxoEntry) {
+		if !entry.spendableAt(height) {
+			return
+		}
+		id := hex.EncodeToString(txID)
+		unspentOutputs[id] = append(unspentOutputs[id], outIdx)
+		accumulatedBal += entry.Output.Value
+	})
+
+	return accumulatedBal, unspentOutputs
+}
+
+// FindUTXO finds all unspent outputs that can be unlocked by the given key
+func (u *UTXOIndex) FindUTXO(pubKeyHash []byte) []TXOutput {
+	var UTXO []TXOutput
+	u.forEachOutput(pubKeyHash, func(txID []byte, outIdx int, entry *utxoEntry) {
+		UTXO = append(UTXO, entry.Output)
+	})
+	return UTXO
+}
+
+// FindCoins returns the unspent outputs locked with lockHash that the
+// next block can spend, see UTXOIndex.FindSpendableOutputs
+func (u *UTXOIndex) FindCoins(lockHash []byte) []Coin {
+	var coins []Coin
+	height := u.bc.Height() + 1
+	u.forEachOutput(lockHash, func(txID []byte, outIdx int, entry *utxoEntry) {
+		if entry.spendableAt(height) {
+			coins = append(coins, Coin{Txid: txID, OutIdx: outIdx, Output: entry.Output})
+		}
+	})
+	return coins
+}
+
+// FindOutput returns the unspent output referenced by the given outpoint
+func (u *UTXOIndex) FindOutput(txID []byte, outIdx int) (TXOutput, bool) {
+	entry, ok := u.findEntry(txID, outIdx)
+	if !ok {
+		return TXOutput{}, false
+	}
+	return entry.Output, true
+}
+
+// findEntry returns the index entry of the given outpoint
+func (u *UTXOIndex) findEntry(txID []byte, outIdx int) (*utxoEntry, bool) {
+	var entry *utxoEntry
+	u.bc.db.View(func(tx StorageTx) error {
+		data := tx.Get(utxoBucket, outpointKey(txID, outIdx))
+		if data != nil {
+			entry, _ = deserializeUTXOEntry(data)
+		}
+		return nil
+	})
+	return entry, entry != nil
+}
+
+// CountUTXOs returns the number of transactions outputs in the UTXO index
+func (u *UTXOIndex) CountUTXOs() int {
+	var UXTOCnt int
+	u.bc.db.View(func(tx StorageTx) error {
+		return tx.ForEach(utxoBucket, nil, func(k, v []byte) error {
+			UXTOCnt++
+			return nil
+		})
+	})
+	return UXTOCnt
+}
+
+// Snapshot returns a copy of the UTXO index as an in-memory UTXOSet
+func (u *UTXOIndex) Snapshot() UTXOSet {
+	utxos := make(UTXOSet)
+	u.bc.db.View(func(tx StorageTx) error {
+		return tx.ForEach(utxoBucket, nil, func(k, v []byte) error {
+			txID, outIdx := splitOutpointKey(k)
+			entry, err := deserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			id := hex.EncodeToString(txID)
+			if _, ok := utxos[id]; !ok {
+				utxos[id] = make(map[int]TXOutput)
+			}
+			utxos[id][outIdx] = entry.Output
+			return nil
+		})
+	})
+	return utxos
+}
+
+// Reindex rebuilds the UTXO index from the blocks of the chain
+func (u *UTXOIndex) Reindex() error {
+	return u.bc.db.Update(func(tx StorageTx) error {
+		if err := clearBucket(tx, utxoBucket); err != nil {
+			return err
+		}
+		if err := clearBucket(tx, utxoPKHBucket); err != nil {
+			return err
+		}
+		for height := 0; ; height++ {
+			hash := tx.Get(heightsBucket, heightKey(height))
+			if hash == nil {
+				break
+			}
+			block, err := getBlock(tx, hash)
+			if err != nil {
+				return err
+			}
+			if err := connectUTXO(tx, block, height); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// forEachOutput calls fn for every unspent output locked with pubKeyHash
+func (u *UTXOIndex) forEachOutput(pubKeyHash []byte, fn func(txID []byte, outIdx int, entry *utxoEntry)) {
+	prefix := pkhPrefix(pubKeyHash)
+	u.bc.db.View(func(tx StorageTx) error {
+		return tx.ForEach(utxoPKHBucket, prefix, func(k, v []byte) error {
+			entry, err := deserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			txID, outIdx := splitOutpointKey(k[len(prefix):])
+			fn(txID, outIdx, entry)
+			return nil
+		})
+	})
+}
+
+// connectUTXO updates the UTXO index with the transactions of the block
+// at the given height: the outputs referenced by the inputs are removed
+// and the new outputs are added, but the unspendable ones.
+// Blocks whose transactions create value, spend immature coinbases or
+// do not unlock the outputs they spend are rejected.
+// The removed outputs are kept as undo data of the block.
+func connectUTXO(tx StorageTx, block *Block, height int) error {
+	var spent []spentOutput
+	fees := 0
+	for _, tran := range block.Transactions {
+		if !tran.IsCoinbase() {
+			var inputs []TXOutput
+			for _, vin := range tran.Vin {
+				entry, err := spendUTXO(tx, vin.Txid, vin.OutIdx)
+				if err != nil {
+					return err
+				}
+				if !entry.spendableAt(height) {
+					return fmt.Errorf("%w: %s", ErrImmatureCoinbase, outpointString(vin.Txid, vin.OutIdx))
+				}
+				inputs = append(inputs, entry.Output)
+				spent = append(spent, spentOutput{vin.Txid, vin.OutIdx, entry.Output, entry.Height, entry.Coinbase})
+			}
+			fee, err := tran.Fee(inputs)
+			if err != nil {
+				return err
+			}
+			if err := checkInputs(tran, inputs); err != nil {
+				return fmt.Errorf("transaction %x: %w", tran.ID, err)
+			}
+			fees += fee
+		}
+		for outIdx, out := range tran.Vout {
+			if out.IsUnspendable() {
+				continue
+			}
+			entry := utxoEntry{Output: out, Height: height, Coinbase: tran.IsCoinbase()}
+			if err := addUTXO(tx, tran.ID, outIdx, entry); err != nil {
+				return err
+			}
+		}
+	}
+	if len(block.Transactions) > 0 {
+		if err := checkCoinbaseValue(block.Transactions[0], height, fees); err != nil {
+			return err
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(spent); err != nil {
+		return err
+	}
+	return tx.Put(undoBucket, block.Hash, buffer.Bytes())
+}
+
+// disconnectUTXO reverts connectUTXO: the outputs of the block
+// are removed and the outputs it spent are restored. The transactions
+// are reverted from the last one, so the outputs spent by a later
+// transaction of the block are restored before being removed.
+func disconnectUTXO(tx StorageTx, block *Block) error {
+	spent, err := getUndo(tx, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tran := block.Transactions[i]
+		for outIdx, out := range tran.Vout {
+			if out.IsUnspendable() {
+				continue
+			}
+			if _, err := spendUTXO(tx, tran.ID, outIdx); err != nil {
+				return err
+			}
+		}
+		if tran.IsCoinbase() {
+			continue
+		}
+		if len(spent) < len(tran.Vin) {
+			return fmt.Errorf("%w: undo data of block %x", ErrMalformed, block.Hash)
+		}
+		for _, s := range spent[len(spent)-len(tran.Vin):] {
+			entry := utxoEntry{Output: s.Output, Height: s.Height, Coinbase: s.Coinbase}
+			if err := addUTXO(tx, s.Txid, s.OutIdx, entry); err != nil {
+				return err
+			}
+		}
+		spent = spent[:len(spent)-len(tran.Vin)]
+	}
+	return tx.Delete(undoBucket, block.Hash)
+}
+
+// getUndo returns the outputs spent by a connected block, in the order
+// of the inputs of its transactions
+func getUndo(tx StorageTx, hash []byte) ([]spentOutput, error) {
+	data := tx.Get(undoBucket, hash)
+	if data == nil {
+		return nil, ErrBlockNotFound
+	}
+	var spent []spentOutput
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&spent); err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+func addUTXO(tx StorageTx, txID []byte, outIdx int, entry utxoEntry) error {
+	data := entry.Serialize()
+	if err := tx.Put(utxoBucket, outpointKey(txID, outIdx), data); err != nil {
+		return err
+	}
+	return tx.Put(utxoPKHBucket, pkhKey(entry.Output.LockHash(), txID, outIdx), data)
+}
+
+// spendUTXO removes an output from the index and returns its entry
+func spendUTXO(tx StorageTx, txID []byte, outIdx int) (*utxoEntry, error) {
+	key := outpointKey(txID, outIdx)
+	data := tx.Get(utxoBucket, key)
+	if data == nil {
+		return nil, ErrTxInputNotFound
+	}
+	entry, err := deserializeUTXOEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Delete(utxoBucket, key); err != nil {
+		return nil, err
+	}
+	return entry, tx.Delete(utxoPKHBucket, pkhKey(entry.Output.LockHash(), txID, outIdx))
+}
+
+// outpointKey encodes a reference to a transaction output as txid|outIdx
+func outpointKey(txID []byte, outIdx int) []byte {
+	key := make([]byte, len(txID)+4)
+	copy(key, txID)
+	binary.BigEndian.PutUint32(key[len(txID):], uint32(outIdx))
+	return key
+}
+
+// splitOutpointKey decodes a key encoded by outpointKey
+func splitOutpointKey(key []byte) ([]byte, int) {
+	n := len(key) - 4
+	return key[:n], int(binary.BigEndian.Uint32(key[n:]))
+}
+
+// pkhPrefix is the prefix shared by all the index keys of a public key hash.
+// The length is included, so the hash of one key is never a prefix of another.
+func pkhPrefix(pubKeyHash []byte) []byte {
+	return append([]byte{byte(len(pubKeyHash))}, pubKeyHash...)
+}
+
+func pkhKey(pubKeyHash, txID []byte, outIdx int) []byte {
+	return append(pkhPrefix(pubKeyHash), outpointKey(txID, outIdx)...)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockFullBlockchain returns a chain with all the test blocks
+func newMockFullBlockchain() *Blockchain {
+	bc := newMockBlockchain()
+	for _, b := range []string{"block1", "block2", "block3", "block4"} {
+		addMockBlock(bc, testBlockchainData[b])
+	}
+	return bc
+}
+
+func TestUTXOIndexUpdatedWithBlocks(t *testing.T) {
+	bc := newMockBlockchain()
+	utxos := bc.UTXOIndex()
+	diff(t, getTestExpectedUTXOSet("block0"), utxos.Snapshot(), "incorrect UTXO index")
+
+	addMockBlock(bc, testBlockchainData["block1"])
+	diff(t, getTestExpectedUTXOSet("block1"), utxos.Snapshot(), "incorrect UTXO index")
+	assert.Equal(t, 3, utxos.CountUTXOs())
+}
+
+func TestUTXOIndexFindSpendableOutputs(t *testing.T) {
+	bc := newMockFullBlockchain()
+	utxos := bc.UTXOIndex()
+
+	rodrigoPubKeyHash := Hex2Bytes("2b02ea4c157844ec0b034fdde3379726ea228b38")
+	leanderPubKeyHash := Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")
+	minerPubKeyHash := Hex2Bytes("15e5ab1b9f1e79b58c95a1a0b3caa63c61617971")
+
+	amount, outputs := utxos.FindSpendableOutputs(rodrigoPubKeyHash, 5)
+	assert.Equal(t, 8, amount)
+	assert.Equal(t, map[string][]int{
+		"efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496": {1},
+		"7f036b2af8b0b39d63a2aef7c9d98dcbb3ead00f0443661bd326161e92d0e2d9": {1},
+		"81416a4fe2821f0409f24b8ca51cb5b8589507a41c827fdce4dca8eb1b15dbef": {0},
+	}, outputs)
+
+	amount, outputs = utxos.FindSpendableOutputs(leanderPubKeyHash, 1)
+	assert.Equal(t, 2, amount)
+	assert.Equal(t, map[string][]int{
+		"ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4": {1},
+	}, outputs)
+
+	amount, _ = utxos.FindSpendableOutputs(minerPubKeyHash, 1)
+	assert.Equal(t, 4*BlockReward, amount)
+
+	amount, outputs = utxos.FindSpendableOutputs(Hex2Bytes("00"), 1)
+	assert.Equal(t, 0, amount)
+	assert.Empty(t, outputs)
+}
+
+func TestUTXOIndexFindUTXO(t *testing.T) {
+	bc := newMockFullBlockchain()
+	utxos := bc.UTXOIndex()
+
+	leanderPubKeyHash := Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")
+	assert.Equal(t, []TXOutput{{Value: 2, PubKeyHash: leanderPubKeyHash}}, utxos.FindUTXO(leanderPubKeyHash))
+
+	// The index agrees with the in-memory set on every key
+	snapshot := utxos.Snapshot()
+	for _, pkh := range []string{
+		"2b02ea4c157844ec0b034fdde3379726ea228b38",
+		"b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04",
+		"15e5ab1b9f1e79b58c95a1a0b3caa63c61617971",
+	} {
+		assert.ElementsMatch(t, snapshot.FindUTXO(Hex2Bytes(pkh)), utxos.FindUTXO(Hex2Bytes(pkh)))
+	}
+}
+
+func TestUTXOIndexFindOutput(t *testing.T) {
+	bc := newMockFullBlockchain()
+	utxos := bc.UTXOIndex()
+
+	out, ok := utxos.FindOutput(testTransactions["tx5"].ID, 0)
+	assert.True(t, ok)
+	assert.Equal(t, testTransactions["tx5"].Vout[0], out)
+
+	// spent output
+	_, ok = utxos.FindOutput(testTransactions["tx1"].ID, 0)
+	assert.False(t, ok)
+}
+
+func TestUTXOIndexReindex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DBFile)
+	db, err := OpenBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := &Blockchain{db: db, height: -1}
+	for _, b := range []string{"block0", "block1", "block2", "block3", "block4"} {
+		addMockBlock(bc, testBlockchainData[b])
+	}
+	expected := bc.UTXOIndex().Snapshot()
+	assert.Equal(t, 8, bc.UTXOIndex().CountUTXOs())
+
+	// The index survives a restart
+	assert.Nil(t, bc.Close())
+	db, err = OpenBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	bc, err = NewBlockchain(db, "")
+	assert.Nil(t, err)
+	diff(t, expected, bc.UTXOIndex().Snapshot(), "UTXO index changed after reopening")
+
+	// Wipe the index and rebuild it from the blocks
+	db.Update(func(tx StorageTx) error {
+		clearBucket(tx, utxoBucket)
+		return clearBucket(tx, utxoPKHBucket)
+	})
+	assert.Equal(t, 0, bc.UTXOIndex().CountUTXOs())
+
+	assert.Nil(t, bc.UTXOIndex().Reindex())
+	diff(t, expected, bc.UTXOIndex().Snapshot(), "incorrect UTXO index after reindex")
+}
+
+func TestUTXOIndexRejectsUnknownInput(t *testing.T) {
+	bc := newMockBlockchain()
+
+	// tx2 spends an output of tx1, which is not in the chain
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Timestamp:     TestBlockTime,
+			PrevBlockHash: testBlockchainData["block0"].Hash,
+		},
+		Transactions: []*Transaction{
+			minerCoinbaseTx["tx2"],
+			testTransactions["tx2"],
+		},
+		Hash: testBlockchainData["block2"].Hash,
+	}
+	err := bc.storeBlock(block)
+	assert.ErrorIs(t, err, ErrTxInputNotFound)
+
+	// Neither the block nor the UTXO changes were stored
+	assert.Equal(t, 0, bc.Height())
+	assert.False(t, bc.HasBlock(block.Hash))
+	_, err = bc.GetBlock(block.Hash)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+	diff(t, getTestExpectedUTXOSet("block0"), bc.UTXOIndex().Snapshot(), "UTXO index changed")
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -45,6 +47,26 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXO
 }
 
+// FindCoins returns the unspent outputs locked with lockHash, ordered by
+// outpoint
+func (u UTXOSet) FindCoins(lockHash []byte) []Coin {
+	var coins []Coin
+	for txID, outputs := range u {
+		for outIdx, out := range outputs {
+			if out.IsLockedWithKey(lockHash) {
+				coins = append(coins, Coin{Txid: Hex2Bytes(txID), OutIdx: outIdx, Output: out})
+			}
+		}
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		if c := bytes.Compare(coins[i].Txid, coins[j].Txid); c != 0 {
+			return c < 0
+		}
+		return coins[i].OutIdx < coins[j].OutIdx
+	})
+	return coins
+}
+
 // CountUTXOs returns the number of transactions outputs in the UTXO set
 func (u UTXOSet) CountUTXOs() int {
 	var UXTOCnt int
@@ -74,7 +96,7 @@ func (u UTXOSet) Update(transactions []*Transaction) {
 				prevTxId := hex.EncodeToString(txVin.Txid)
 
 				prevOutBlock := u[prevTxId][txVin.OutIdx]
-				if !prevOutBlock.IsLockedWithKey(HashPubKey(txVin.PubKey)) {
+				if len(txVin.ScriptSig) == 0 && !prevOutBlock.IsLockedWithKey(HashPubKey(txVin.PubKey)) {
 					// not authorized
 					continue
 				}
@@ -98,7 +120,9 @@ func (u UTXOSet) Update(transactions []*Transaction) {
 		currTxID := hex.EncodeToString(tx.ID)
 
 		for outIdx, txVout := range tx.Vout {
-			newTxOutMap[outIdx] = txVout
+			if !txVout.IsUnspendable() {
+				newTxOutMap[outIdx] = txVout
+			}
 		}
 
 		u[currTxID] = newTxOutMap
@@ -9,7 +9,7 @@ import (
 
 func TestFindSpendableOutputsFromOneOutput(t *testing.T) {
 	utxos := getTestExpectedUTXOSet("block0")
-	expectedOut := utxos["9402c56f49de02d2b9c4633837d82e3881227a3ea90c4073c02815fdcf5afaa2"]
+	expectedOut := utxos["c12ae4c66105ef98fad55c01f18f211393475022a4bc11eb08e8623112e123aa"]
 	expectedValue := expectedOut[0].Value
 	pubKeyHash := expectedOut[0].PubKeyHash
 	expectedUnspentOutputs := getTestSpendableOutputs(utxos, pubKeyHash)
@@ -23,8 +23,8 @@ func TestFindSpendableOutputsFromOneOutput(t *testing.T) {
 
 func TestFindSpendableOutputsFromMultipleOutputs(t *testing.T) {
 	utxos := getTestExpectedUTXOSet("block2")
-	out1 := utxos["e9e5fc159f24b2b33310f77aef4e425e77ed71be87dbf9a0c7764b5417bd3e4b"]
-	out2 := utxos["dcd76d254f7a41888e6bda9958c4ceadf510e1bd5fd251f617c91b704fbf9492"]
+	out1 := utxos["efa41817865fc618a165714f48f79fa34a517306c3afe2590b7f4c30c3139496"]
+	out2 := utxos["ed55e252f376302baf937fb868b22d83e038641edfbbbdafa5df0c6de8154de4"]
 	expectedValue := out1[1].Value + out2[0].Value
 
 	expectedUnspentOutputs := getTestSpendableOutputs(utxos, out1[1].PubKeyHash)
@@ -44,7 +44,7 @@ func TestFindUTXO(t *testing.T) {
 	leanderPubKeyHash := Hex2Bytes("b8f3e65b3cabc93fb9459b7e8182fa5ec4e58f04")
 
 	utxoRodrigo := utxos.FindUTXO(rodrigoPubKeyHash)
-	assert.Equal(t, []TXOutput{{BlockReward, rodrigoPubKeyHash}}, utxoRodrigo)
+	assert.Equal(t, []TXOutput{{Value: BlockReward, PubKeyHash: rodrigoPubKeyHash}}, utxoRodrigo)
 
 	utxoLeander := utxos.FindUTXO(leanderPubKeyHash)
 	assert.Equal(t, []TXOutput(nil), utxoLeander)
@@ -53,10 +53,10 @@ func TestFindUTXO(t *testing.T) {
 	// update utxo
 	utxos = getTestExpectedUTXOSet("block1")
 	utxoRodrigo = utxos.FindUTXO(rodrigoPubKeyHash)
-	assert.Equal(t, []TXOutput{{5, rodrigoPubKeyHash}}, utxoRodrigo)
+	assert.Equal(t, []TXOutput{{Value: 5, PubKeyHash: rodrigoPubKeyHash}}, utxoRodrigo)
 
 	utxoLeander = utxos.FindUTXO(leanderPubKeyHash)
-	assert.Equal(t, []TXOutput{{5, leanderPubKeyHash}}, utxoLeander)
+	assert.Equal(t, []TXOutput{{Value: 5, PubKeyHash: leanderPubKeyHash}}, utxoLeander)
 
 	// 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh sent 1 "coin" to 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX and
 	// 1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX sent 3 "coins" to 14vRYoWsjqC61tNmaLPPzjKnxirSxFoehh
@@ -65,15 +65,15 @@ func TestFindUTXO(t *testing.T) {
 
 	utxoRodrigo = utxos.FindUTXO(rodrigoPubKeyHash)
 	assert.ElementsMatch(t, []TXOutput{
-		{4, rodrigoPubKeyHash},
-		{3, rodrigoPubKeyHash},
+		{Value: 4, PubKeyHash: rodrigoPubKeyHash},
+		{Value: 3, PubKeyHash: rodrigoPubKeyHash},
 	}, utxoRodrigo)
 	assert.Equal(t, 2, len(utxoRodrigo))
 
 	utxoLeander = utxos.FindUTXO(leanderPubKeyHash)
 	assert.ElementsMatch(t, []TXOutput{
-		{2, leanderPubKeyHash},
-		{1, leanderPubKeyHash},
+		{Value: 2, PubKeyHash: leanderPubKeyHash},
+		{Value: 1, PubKeyHash: leanderPubKeyHash},
 	}, utxoLeander)
 	assert.Equal(t, 2, len(utxoLeander))
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrSnapshotCommitment = errors.New("snapshot does not match the commitment")
+	ErrInvalidSnapshot    = errors.New("snapshot is not valid")
+)
+
+// UTXOSnapshot is the UTXO set of the main chain at a block, with the
+// headers of the chain up to that block. A new node imports it, see
+// NewBlockchainFromSnapshot, to start from that block without
+// downloading and replaying the blocks before it.
+//
+// The headers are validated, but the UTXO set cannot be checked against
+// them: the node must get the commitment of the snapshot, see
+// UTXOSnapshot.Commitment, from a source it trusts, such as another
+// node it runs.
+type UTXOSnapshot struct {
+	Genesis *Block
+	// Headers are the headers of the blocks following the genesis,
+	// up to the block of the snapshot
+	Headers []BlockHeader
+	// Outputs are the unspent outputs, in the order of their outpoint
+	Outputs []SnapshotOutput
+}
+
+// SnapshotOutput is an unspent output of a UTXOSnapshot, with the
+// block of its transaction
+type SnapshotOutput struct {
+	Coin
+	Height   int  // see utxoEntry
+	Coinbase bool // see utxoEntry
+}
+
+// Height returns the height of the block of the snapshot
+func (s *UTXOSnapshot) Height() int {
+	return len(s.Headers)
+}
+
+// BlockHash returns the hash of the block of the snapshot
+func (s *UTXOSnapshot) BlockHash() []byte {
+	if len(s.Headers) == 0 {
+		return s.Genesis.Hash
+	}
+	return s.Headers[len(s.Headers)-1].Hash()
+}
+
+// Commitment returns the hash committing to the UTXO set of the
+// snapshot and to its block: the sha256 of the hash of the block
+// followed by the encoding of the outputs, see serialization.go.
+// Two nodes get the same commitment for the same chain.
+func (s *UTXOSnapshot) Commitment() []byte {
+	var e encoder
+	e.putBytes(s.BlockHash())
+	e.putSnapshotOutputs(s.Outputs)
+	hash := sha256.Sum256(e.buf.Bytes())
+	return hash[:]
+}
+
+// Serialize returns the binary encoding of the snapshot,
+// see serialization.go
+func (s *UTXOSnapshot) Serialize() []byte {
+	var e encoder
+	e.putUint32(utxoSnapshotVersion)
+	e.putBytes(s.Genesis.Serialize())
+	e.putUint32(uint32(len(s.Headers)))
+	for i := range s.Headers {
+		e.putHeader(&s.Headers[i])
+	}
+	e.putSnapshotOutputs(s.Outputs)
+	return e.buf.Bytes()
+}
+
+// DeserializeUTXOSnapshot decodes a snapshot serialized by
+// UTXOSnapshot.Serialize
+func DeserializeUTXOSnapshot(data []byte) (*UTXOSnapshot, error) {
+	d := decoder{data: data}
+	d.version(utxoSnapshotVersion)
+	genesis := d.bytes()
+	s := &UTXOSnapshot{}
+	// a header takes at least 36 bytes
+	if n := d.count(36); n > 0 {
+		s.Headers = make([]BlockHeader, n)
+		for i := range s.Headers {
+			s.Headers[i] = d.header()
+		}
+	}
+	// an output takes at least 32 bytes
+	if n := d.count(32); n > 0 {
+		s.Outputs = make([]SnapshotOutput, n)
+		for i := range s.Outputs {
+			s.Outputs[i] = d.snapshotOutput()
+		}
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	block, err := DeserializeBlock(genesis)
+	if err != nil {
+		return nil, err
+	}
+	s.Genesis = block
+	return s, nil
+}
+
+func (e *encoder) putSnapshotOutputs(outputs []SnapshotOutput) {
+	e.putUint32(uint32(len(outputs)))
+	for _, out := range outputs {
+		e.putBytes(out.Txid)
+		e.putUint32(uint32(out.OutIdx))
+		e.buf.Write(utxoEntry{Output: out.Output, Height: out.Height, Coinbase: out.Coinbase}.Serialize())
+	}
+}
+
+func (d *decoder) snapshotOutput() SnapshotOutput {
+	out := SnapshotOutput{Coin: Coin{Txid: d.bytes(), OutIdx: int(d.uint32()), Output: d.output(txScriptVersion)}}
+	out.Height = int(d.uint32())
+	out.Coinbase = d.uint32() == 1
+	return out
+}
+
+// UTXOSnapshot returns the snapshot of the UTXO set at the tip
+func (bc *Blockchain) UTXOSnapshot() (*UTXOSnapshot, error) {
+	s := &UTXOSnapshot{}
+	err := bc.db.View(func(tx StorageTx) error {
+		// the tip of the storage transaction, with the UTXO set of the same state
+		height := getMetaInt(tx, heightKeyName)
+		var err error
+		if s.Genesis, err = getBlock(tx, tx.Get(heightsBucket, heightKey(0))); err != nil {
+			return err
+		}
+		for h := 1; h <= height; h++ {
+			header, err := getHeader(tx, tx.Get(heightsBucket, heightKey(h)))
+			if err != nil {
+				return err
+			}
+			s.Headers = append(s.Headers, *header)
+		}
+		return tx.ForEach(utxoBucket, nil, func(k, v []byte) error {
+			txID, outIdx := splitOutpointKey(k)
+			entry, err := deserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			s.Outputs = append(s.Outputs, SnapshotOutput{
+				Coin:     Coin{Txid: txID, OutIdx: outIdx, Output: entry.Output},
+				Height:   entry.Height,
+				Coinbase: entry.Coinbase,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewBlockchainFromSnapshot creates in an empty storage the blockchain of
+// a snapshot whose commitment is the given one. Its blocks are pruned
+// up to the block of the snapshot, see EnablePruning, which is the tip.
+func NewBlockchainFromSnapshot(db Storage, s *UTXOSnapshot, commitment []byte) (*Blockchain, error) {
+	if HasBlockchain(db) {
+		return nil, ErrChainExists
+	}
+	if !bytes.Equal(s.Commitment(), commitment) {
+		return nil, fmt.Errorf("%w: %x", ErrSnapshotCommitment, commitment)
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+
+	err := db.Update(func(tx StorageTx) error {
+		genesis := s.Genesis
+		if err := tx.Put(blocksBucket, genesis.Hash, genesis.Serialize()); err != nil {
+			return err
+		}
+		work := headerWork(&genesis.BlockHeader)
+		if err := putIndexEntry(tx, genesis.Hash, blockIndexEntry{Height: 0, Work: work.Bytes()}); err != nil {
+			return err
+		}
+		if err := tx.Put(heightsBucket, heightKey(0), genesis.Hash); err != nil {
+			return err
+		}
+		for i := range s.Headers {
+			header, height := &s.Headers[i], i+1
+			hash := header.Hash()
+			work = new(big.Int).Add(work, headerWork(header))
+			if err := tx.Put(headersBucket, hash, header.Serialize()); err != nil {
+				return err
+			}
+			if err := putIndexEntry(tx, hash, blockIndexEntry{Height: height, Work: work.Bytes()}); err != nil {
+				return err
+			}
+			if err := tx.Put(heightsBucket, heightKey(height), hash); err != nil {
+				return err
+			}
+		}
+
+		for _, out := range s.Outputs {
+			entry := utxoEntry{Output: out.Output, Height: out.Height, Coinbase: out.Coinbase}
+			if err := addUTXO(tx, out.Txid, out.OutIdx, entry); err != nil {
+				return err
+			}
+		}
+		if s.Height() > 0 {
+			if err := tx.Put(metaBucket, prunedHeightKey, heightKey(s.Height())); err != nil {
+				return err
+			}
+		}
+		if err := tx.Put(metaBucket, heightKeyName, heightKey(s.Height())); err != nil {
+			return err
+		}
+		return tx.Put(metaBucket, tipKey, s.BlockHash())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockchain(db, "")
+}
+
+// check validates the genesis block and the headers of the snapshot,
+// like a HeaderChain, and the order of its outputs
+func (s *UTXOSnapshot) check() error {
+	genesis := s.Genesis
+	if err := CheckBlockSanity(genesis); err != nil {
+		return fmt.Errorf("%w: genesis block: %v", ErrInvalidSnapshot, err)
+	}
+	if genesis.PrevBlockHash != nil || genesis.Bits != InitialBits {
+		return fmt.Errorf("%w: genesis block %x", ErrInvalidSnapshot, genesis.Hash)
+	}
+
+	hc := NewHeaderChain(genesis.BlockHeader)
+	prevHash := genesis.Hash
+	for i, header := range s.Headers {
+		if !bytes.Equal(header.PrevBlockHash, prevHash) {
+			return fmt.Errorf("%w: header %d does not follow the previous one", ErrInvalidSnapshot, i+1)
+		}
+		if err := hc.AddHeader(header); err != nil {
+			return fmt.Errorf("%w: header %d: %v", ErrInvalidSnapshot, i+1, err)
+		}
+		prevHash = header.Hash()
+	}
+
+	var prev []byte
+	for _, out := range s.Outputs {
+		key := outpointKey(out.Txid, out.OutIdx)
+		if bytes.Compare(prev, key) >= 0 {
+			return fmt.Errorf("%w: outputs are not in the order of their outpoint", ErrInvalidSnapshot)
+		}
+		if out.Height > s.Height() {
+			return fmt.Errorf("%w: output %s above the snapshot block", ErrInvalidSnapshot, outpointString(out.Txid, out.OutIdx))
+		}
+		prev = key
+	}
+	return nil
+}
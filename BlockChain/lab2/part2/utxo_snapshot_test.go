@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestSnapshot returns a chain of 5 blocks spending outputs, with its
+// snapshot
+func newTestSnapshot(t *testing.T) (*Blockchain, []*Transaction, *UTXOSnapshot) {
+	bc, coinbases := newMempoolTestChain(t, 4)
+	b := mineTestBlock(t, bc.CurrentBlock(), "b", newTestSpend(t, bc, coinbases[0], 7))
+	mustStoreBlock(t, bc, b)
+	s, err := bc.UTXOSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bc, coinbases, s
+}
+
+func TestUTXOSnapshot(t *testing.T) {
+	bc, coinbases, s := newTestSnapshot(t)
+	tip, height := bc.Tip()
+	assert.Equal(t, height, s.Height())
+	assert.Equal(t, tip, s.BlockHash())
+	assert.Len(t, s.Outputs, bc.UTXOIndex().CountUTXOs())
+
+	decoded, err := DeserializeUTXOSnapshot(s.Serialize())
+	assert.Nil(t, err)
+	assert.Equal(t, s, decoded)
+	assert.Equal(t, s.Commitment(), decoded.Commitment())
+
+	// the new node starts at the tip, without the blocks
+	imported, err := NewBlockchainFromSnapshot(NewMemoryStorage(), decoded, s.Commitment())
+	if err != nil {
+		t.Fatal(err)
+	}
+	importedTip, importedHeight := imported.Tip()
+	assert.Equal(t, tip, importedTip)
+	assert.Equal(t, height, importedHeight)
+	assert.Equal(t, bc.Work(), imported.Work())
+	assert.Equal(t, bc.FindUTXOSet(), imported.FindUTXOSet())
+	assert.Equal(t, bc.GetGenesisBlock(), imported.GetGenesisBlock())
+	assert.Equal(t, height, imported.PrunedHeight())
+	assert.Equal(t, bc.BlockLocator(), imported.BlockLocator())
+	_, err = imported.GetBlock(tip)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+
+	// and follows the chain
+	spend := newTestSpend(t, imported, coinbases[1], 5)
+	next := mineTestBlock(t, bc.CurrentBlock(), "c", spend)
+	assert.Nil(t, bc.addBlock(next))
+	assert.Nil(t, imported.addBlock(next))
+	s, _ = bc.UTXOSnapshot()
+	again, _ := imported.UTXOSnapshot()
+	assert.Equal(t, s.Commitment(), again.Commitment())
+
+	assert.ErrorIs(t, imported.EnableTxIndex(), ErrPrunedTxIndex)
+	_, err = NewBlockchainFromSnapshot(bc.db, s, s.Commitment())
+	assert.ErrorIs(t, err, ErrChainExists)
+}
+
+func TestUTXOSnapshotInvalid(t *testing.T) {
+	_, _, s := newTestSnapshot(t)
+	commitment := s.Commitment()
+	data := s.Serialize()
+
+	_, err := NewBlockchainFromSnapshot(NewMemoryStorage(), s, make([]byte, 32))
+	assert.ErrorIs(t, err, ErrSnapshotCommitment)
+	s.Outputs[0].Output.Value++
+	_, err = NewBlockchainFromSnapshot(NewMemoryStorage(), s, commitment)
+	assert.ErrorIs(t, err, ErrSnapshotCommitment)
+
+	// the headers before the snapshot block are not committed, but checked
+	s, _ = DeserializeUTXOSnapshot(data)
+	s.Headers[0].Timestamp++
+	_, err = NewBlockchainFromSnapshot(NewMemoryStorage(), s, commitment)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	s, _ = DeserializeUTXOSnapshot(data)
+	s.Outputs[0], s.Outputs[1] = s.Outputs[1], s.Outputs[0]
+	_, err = NewBlockchainFromSnapshot(NewMemoryStorage(), s, s.Commitment())
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	_, err = DeserializeUTXOSnapshot(data[:len(data)-1])
+	assert.ErrorIs(t, err, ErrMalformed)
+	data[3] = 2
+	_, err = DeserializeUTXOSnapshot(data)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// The consensus rules of blocks and transactions. Each broken rule has
+// its own error, wrapped with the details of the violation, so that
+// callers can tell with errors.Is why a block or a transaction is rejected.
+//
+// A block is checked first on its own by CheckBlockSanity, then in the
+// context of the chain by Blockchain.CheckBlock.
+
+var (
+	ErrNoTransactions     = errors.New("block has no transactions")
+	ErrFirstTxNotCoinbase = errors.New("first transaction of the block is not a coinbase")
+	ErrMultipleCoinbases  = errors.New("block has more than one coinbase")
+	ErrBlockTooBig        = errors.New("block is too big")
+	ErrBadBlockHash       = errors.New("block hash does not match its header")
+	ErrBadMerkleRoot      = errors.New("merkle root does not match the transactions")
+	ErrDuplicateTx        = errors.New("transaction appears twice in the block")
+	ErrDoubleSpend        = errors.New("output spent twice in the block")
+	ErrTimeTooOld         = errors.New("block timestamp is before the median time past")
+	ErrTimeTooNew         = errors.New("block timestamp is too far in the future")
+	ErrEmptyTx            = errors.New("transaction has no inputs or no outputs")
+	ErrBadCoinbase        = errors.New("coinbase transaction is malformed")
+	ErrWrongKey           = errors.New("input key does not unlock the spent output")
+	ErrBadSignature       = errors.New("input signature is not valid")
+	ErrNonFinalTx         = errors.New("transaction lock time is not reached")
+	ErrBadTxID            = errors.New("transaction ID does not match its hash")
+)
+
+// medianTimeBlocks is the number of blocks whose median timestamp
+// bounds the timestamp of the next block
+const medianTimeBlocks = 11
+
+// maxFutureBlockTime is how far in the future of the local clock
+// a block timestamp can be
+const maxFutureBlockTime = 2 * time.Hour
+
+// CheckBlockSanity checks the rules a block must follow regardless of the
+// chain: a coinbase and only one at index 0, a bounded size, a hash and a
+// proof of work matching the header, a merkle root matching the
+// transactions, no duplicate transactions nor outputs spent twice.
+func CheckBlockSanity(block *Block) error {
+	if block == nil || len(block.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+	// the first transaction must be sane to tell whether it is a coinbase
+	if err := CheckTransactionSanity(block.Transactions[0]); err != nil {
+		return fmt.Errorf("transaction %x: %w", block.Transactions[0].ID, err)
+	}
+	if !block.Transactions[0].IsCoinbase() {
+		return ErrFirstTxNotCoinbase
+	}
+	if size := len(block.Serialize()); size > MaxBlockSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrBlockTooBig, size, MaxBlockSize)
+	}
+
+	// The header must carry a valid proof of work
+	// and commit to the transactions of the block
+	if !bytes.Equal(block.Hash, block.BlockHeader.Hash()) {
+		return ErrBadBlockHash
+	}
+	if !block.ValidatePoW() {
+		return ErrInvalidHeader
+	}
+	if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+		return ErrBadMerkleRoot
+	}
+
+	txIDs := make(map[string]bool)
+	spent := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if err := CheckTransactionSanity(tx); err != nil {
+			return fmt.Errorf("transaction %x: %w", tx.ID, err)
+		}
+		if i > 0 && tx.IsCoinbase() {
+			return fmt.Errorf("%w: transaction %d", ErrMultipleCoinbases, i)
+		}
+		id := hex.EncodeToString(tx.ID)
+		if txIDs[id] {
+			return fmt.Errorf("%w: %s", ErrDuplicateTx, id)
+		}
+		txIDs[id] = true
+		if tx.IsCoinbase() {
+			continue
+		}
+		for _, vin := range tx.Vin {
+			key := outpointString(vin.Txid, vin.OutIdx)
+			if spent[key] {
+				return fmt.Errorf("%w: %s", ErrDoubleSpend, key)
+			}
+			spent[key] = true
+		}
+	}
+	return nil
+}
+
+// CheckTransactionSanity checks the rules a transaction must follow
+// regardless of the chain: it has inputs and outputs, its outputs are not
+// negative nor above MaxMoney, nor is their total, and only a coinbase has the input of a coinbase, which is its
+// single input.
+func CheckTransactionSanity(tx *Transaction) error {
+	if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+		return ErrEmptyTx
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return ErrBadTxID
+	}
+	if _, err := sumValues(tx.Vout); err != nil {
+		return err
+	}
+	if tx.IsCoinbase() {
+		if tx.Vin[0].Txid != nil {
+			return ErrBadCoinbase
+		}
+		return nil
+	}
+	for _, vin := range tx.Vin {
+		if vin.OutIdx < 0 {
+			return ErrBadCoinbase
+		}
+	}
+	return nil
+}
+
+// CheckBlock checks all the consensus rules of the block: its sanity, see
+// CheckBlockSanity, and its place in the tree. It must extend a known
+// block, with its height in the coinbase, the difficulty of the retarget
+// schedule and a timestamp not before the median of the previous blocks
+// nor too far in the future. Its transactions must be final, see
+// Transaction.IsFinal. The transactions of a block extending the main
+// chain must spend its unspent outputs with valid scripts, see
+// checkBlockValue. The transactions of a block of a side branch are not
+// checked here, but when a reorganization connects it, see connectUTXO.
+func (bc *Blockchain) CheckBlock(block *Block) error {
+	if err := CheckBlockSanity(block); err != nil {
+		return err
+	}
+
+	if max := time.Now().Add(maxFutureBlockTime).Unix(); block.Timestamp > max {
+		return fmt.Errorf("%w: %d > %d", ErrTimeTooNew, block.Timestamp, max)
+	}
+
+	// The block must extend a known block of the tree, only the genesis
+	// block has no parent. Its difficulty must follow the retarget
+	// schedule of its branch.
+	if block.PrevBlockHash == nil {
+		if height, err := block.Height(); err != nil || height != 0 {
+			return ErrBadCoinbaseHeight
+		}
+		genesis := bc.GetGenesisBlock()
+		if genesis != nil && !bytes.Equal(genesis.Hash, block.Hash) {
+			return ErrGenesisMismatch
+		}
+		if block.Bits != InitialBits {
+			return ErrBadDifficulty
+		}
+		return nil
+	}
+	parentHeight, err := bc.blockHeight(block.PrevBlockHash)
+	if err != nil {
+		return ErrOrphanBlock
+	}
+	// The coinbase commits to the height of the block, see CoinbaseHeight
+	height, err := block.Height()
+	if err != nil {
+		return err
+	}
+	if height != parentHeight+1 {
+		return fmt.Errorf("%w: %d, expected %d", ErrBadCoinbaseHeight, height, parentHeight+1)
+	}
+	bits, err := bc.NextBits(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if block.Bits != bits {
+		return fmt.Errorf("%w: %08x, expected %08x", ErrBadDifficulty, block.Bits, bits)
+	}
+	mtp, err := bc.MedianTimePast(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp < mtp {
+		return fmt.Errorf("%w: %d < %d", ErrTimeTooOld, block.Timestamp, mtp)
+	}
+	for _, tx := range block.Transactions {
+		if !tx.IsFinal(height, block.Timestamp) {
+			return fmt.Errorf("transaction %x: %w: %d", tx.ID, ErrNonFinalTx, tx.LockTime)
+		}
+	}
+
+	tip, _ := bc.Tip()
+	if bytes.Equal(block.PrevBlockHash, tip) {
+		return bc.checkBlockValue(block, height)
+	}
+	return nil
+}
+
+// MedianTimePast returns the median timestamp of the block with the
+// given hash and of its ancestors, up to medianTimeBlocks of them
+func (bc *Blockchain) MedianTimePast(hash []byte) (int64, error) {
+	var timestamps []int64
+	for len(timestamps) < medianTimeBlocks && hash != nil {
+		header, err := bc.GetBlockHeader(hash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, header.Timestamp)
+		hash = header.PrevBlockHash
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], nil
+}
+
+// CheckTransaction checks that the transaction can be added to the next
+// block of the main chain: its sanity, see CheckTransactionSanity, its
+// lock time, and that its inputs spend unspent outputs, of mature
+// coinbases, with scripts unlocking them, without creating value
+func (bc *Blockchain) CheckTransaction(tx *Transaction) error {
+	if err := CheckTransactionSanity(tx); err != nil {
+		return err
+	}
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	utxos := bc.UTXOIndex()
+	height := bc.Height() + 1
+	if !tx.IsFinal(height, time.Now().Unix()) {
+		return fmt.Errorf("%w: %d", ErrNonFinalTx, tx.LockTime)
+	}
+	var spent []TXOutput
+	for _, vin := range tx.Vin {
+		entry, ok := utxos.findEntry(vin.Txid, vin.OutIdx)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrTxInputNotFound, outpointString(vin.Txid, vin.OutIdx))
+		}
+		if !entry.spendableAt(height) {
+			return fmt.Errorf("%w: %s", ErrImmatureCoinbase, outpointString(vin.Txid, vin.OutIdx))
+		}
+		spent = append(spent, entry.Output)
+	}
+	if _, err := tx.Fee(spent); err != nil {
+		return err
+	}
+	return checkInputs(tx, spent)
+}
+
+// checkInputs checks that each input of the transaction unlocks the
+// output it spends, given in the order of the inputs, see script.go
+func checkInputs(tx *Transaction, spent []TXOutput) error {
+	for idx, vin := range tx.Vin {
+		if len(vin.ScriptSig) == 0 && len(spent[idx].Script) == 0 && !spent[idx].IsLockedWithKey(HashPubKey(vin.PubKey)) {
+			return fmt.Errorf("%w: input %d", ErrWrongKey, idx)
+		}
+		if err := tx.VerifyInputScript(idx, spent[idx]); err != nil {
+			return fmt.Errorf("input %d: %w", idx, err)
+		}
+	}
+	return nil
+}
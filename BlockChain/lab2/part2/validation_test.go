@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/hex"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newOverflowSpend returns a signed spend of the coinbase whose two
+// outputs of math.MaxInt64 would sum to -2, so a fee of 12
+func newOverflowSpend(t *testing.T, bc *Blockchain, coinbase *Transaction) *Transaction {
+	tx := newTestSpend(t, bc, coinbase, math.MaxInt64)
+	tx.Vout = append(tx.Vout, tx.Vout[0])
+	tx.ID = tx.Hash()
+	privKey, _, _ := decodeKeyPair(testEncPrivKeyUser1, testEncPubKeyUser1)
+	if err := bc.SignTransaction(tx, *privKey); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestCheckBlock(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	tip := bc.CurrentBlock()
+	spend := newTestSpend(t, bc, coinbases[0], 9)
+	coinbase := func(data string) *Transaction {
+		tx, err := NewCoinbaseTX(testMinerAddress, data, bc.Height()+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	// at returns a parent with the hash of the tip, at the given time
+	at := func(timestamp int64) *Block {
+		return &Block{BlockHeader: BlockHeader{Timestamp: timestamp}, Transactions: tip.Transactions, Hash: tip.Hash}
+	}
+
+	badHash := mineTestBlock(t, tip, "bad hash")
+	badHash.Hash = tip.Hash
+	badPoW := mineTestBlock(t, tip, "bad pow")
+	badPoW.Nonce = 1
+	badPoW.Hash = badPoW.BlockHeader.Hash()
+	badMerkle := mineTestBlock(t, tip, "bad merkle", spend)
+	badMerkle.Transactions = badMerkle.Transactions[:1]
+	noOutputs := *spend
+	noOutputs.Vout = nil
+	greedy, _ := NewCoinbaseTXWithFees(testMinerAddress, "", bc.Height()+1, 2) // the spend pays 1
+	skipped, _ := NewCoinbaseTX(testMinerAddress, "", bc.Height()+2)
+	unknownInput := *spend
+	unknownInput.Vin = []TXInput{spend.Vin[0]}
+	unknownInput.Vin[0].Txid = spend.ID
+	unknownInput.ID = unknownInput.Hash()
+	badSignature := newTestSpend(t, bc, coinbases[1], 9)
+	badSignature.Vout[0].Value = 8
+	badSignature.ID = badSignature.Hash()
+	// a transaction spending an output of an earlier one of the block,
+	// with the signature of the latter
+	badChild := &Transaction{
+		Vin: []TXInput{{
+			Txid:      spend.ID,
+			Signature: spend.Vin[0].Signature,
+			PubKey:    testTransactions["tx2"].Vin[0].PubKey,
+		}},
+		Vout: []TXOutput{{Value: 8, PubKeyHash: spend.Vout[0].PubKeyHash}},
+	}
+	badChild.ID = badChild.Hash()
+
+	tests := []struct {
+		name  string
+		block *Block
+		err   error
+	}{
+		{"nil block", nil, ErrNoTransactions},
+		{"no transactions", &Block{}, ErrNoTransactions},
+		{"first transaction not coinbase", mineTestBlockWithCoinbase(tip, spend), ErrFirstTxNotCoinbase},
+		{"first transaction without inputs", mineTestBlockWithCoinbase(tip, &Transaction{Vout: spend.Vout}), ErrEmptyTx},
+		{"two coinbases", mineTestBlock(t, tip, "two", coinbase("coinbases")), ErrMultipleCoinbases},
+		{"too big", mineTestBlockWithCoinbase(tip, coinbase(strings.Repeat("x", MaxBlockSize))), ErrBlockTooBig},
+		{"hash of another header", badHash, ErrBadBlockHash},
+		{"invalid proof of work", badPoW, ErrInvalidHeader},
+		{"merkle root of other transactions", badMerkle, ErrBadMerkleRoot},
+		{"transaction without outputs", mineTestBlock(t, tip, "empty", &noOutputs), ErrEmptyTx},
+		{"outputs overflowing", mineTestBlock(t, tip, "overflow", newOverflowSpend(t, bc, coinbases[0])), ErrValueTooLarge},
+		{"duplicate transaction", mineTestBlock(t, tip, "duplicate", spend, spend), ErrDuplicateTx},
+		{"double spend", mineTestBlock(t, tip, "double", spend, newTestSpend(t, bc, coinbases[0], 8)), ErrDoubleSpend},
+		{"other genesis", mineTestBlock(t, &Block{}, "genesis"), ErrGenesisMismatch},
+		{"unknown parent", mineTestBlock(t, &Block{Transactions: tip.Transactions, Hash: coinbases[0].ID}, "orphan"), ErrOrphanBlock},
+		{"coinbase of another height", mineTestBlockWithCoinbase(tip, skipped), ErrBadCoinbaseHeight},
+		{"wrong difficulty", mineTestBlockWithBits(t, tip, "bits", BigToCompact(new(big.Int).Rsh(powLimit, 1))), ErrBadDifficulty},
+		{"before the median time past", mineTestBlock(t, at(tip.Timestamp-1), "old"), ErrTimeTooOld},
+		{"in the future", mineTestBlock(t, at(time.Now().Add(3*time.Hour).Unix()), "new"), ErrTimeTooNew},
+		{"coinbase value", mineTestBlockWithCoinbase(tip, greedy, spend), ErrCoinbaseValue},
+		{"unknown input", mineTestBlock(t, tip, "unknown", &unknownInput), ErrTxInputNotFound},
+		{"invalid signature", mineTestBlock(t, tip, "signature", badSignature), ErrBadSignature},
+		{"invalid signature of a child", mineTestBlock(t, tip, "child", spend, badChild), ErrBadSignature},
+		{"valid", mineTestBlock(t, tip, "valid", spend), nil},
+		{"in the future, within bounds", mineTestBlock(t, at(time.Now().Add(time.Hour).Unix()), "soon"), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bc.CheckBlock(test.block)
+			if test.err == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+			assert.Equal(t, test.err == nil, bc.ValidateBlock(test.block))
+		})
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	bc, _ := NewBlockchain(NewMemoryStorage(), testMinerAddress)
+	genesis := bc.CurrentBlock()
+	mtp, err := bc.MedianTimePast(genesis.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Timestamp, mtp)
+
+	// the median of the last 11 blocks, whatever their order
+	prev := genesis
+	for _, offset := range []int64{5, 1, 9, 3, 7, 2, 8, 4, 6, 10, 0, 11} {
+		parent := &Block{BlockHeader: BlockHeader{Timestamp: genesis.Timestamp + offset}, Transactions: prev.Transactions, Hash: prev.Hash}
+		b := mineTestBlock(t, parent, "mtp")
+		mustStoreBlock(t, bc, b)
+		prev = b
+	}
+	mtp, err = bc.MedianTimePast(prev.Hash)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Timestamp+6, mtp)
+
+	_, err = bc.MedianTimePast([]byte("unknown"))
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+}
+
+func TestCheckTransaction(t *testing.T) {
+	bc, coinbases := newMempoolTestChain(t, 3)
+	otherKey, otherPubKey := newKeyPair()
+
+	badSignature := newTestSpend(t, bc, coinbases[0], 9)
+	badSignature.Vout[0].Value = 8
+	badSignature.ID = badSignature.Hash()
+	minting := newTestSpend(t, bc, coinbases[0], 11)
+	wrongKey := newTestSpend(t, bc, coinbases[0], 9)
+	wrongKey.Vin[0].PubKey = otherPubKey
+	assert.Nil(t, wrongKey.Sign(otherKey, map[string]*Transaction{
+		hex.EncodeToString(coinbases[0].ID): coinbases[0],
+	}))
+	badCoinbase := *coinbases[1]
+	badCoinbase.Vin = append(badCoinbase.Vin, badSignature.Vin[0])
+	badCoinbase.ID = badCoinbase.Hash()
+	negative := newTestSpend(t, bc, coinbases[0], -1)
+	unknownInput := newTestSpend(t, bc, coinbases[0], 9)
+	unknownInput.Vin[0].Txid = badSignature.ID
+	unknownInput.ID = unknownInput.Hash()
+	tooLarge := newTestSpend(t, bc, coinbases[0], MaxMoney+1)
+	badID := newTestSpend(t, bc, coinbases[0], 9)
+	badID.ID = badSignature.ID
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+		err  error
+	}{
+		{"no inputs", &Transaction{Vout: coinbases[0].Vout}, ErrEmptyTx},
+		{"ID of another transaction", badID, ErrBadTxID},
+		{"negative output", negative, ErrNegativeValue},
+		{"output above MaxMoney", tooLarge, ErrValueTooLarge},
+		{"outputs overflowing", newOverflowSpend(t, bc, coinbases[0]), ErrValueTooLarge},
+		{"coinbase with two inputs", &badCoinbase, ErrBadCoinbase},
+		{"unknown input", unknownInput, ErrTxInputNotFound},
+		{"outputs exceed inputs", minting, ErrValueNotConserved},
+		{"key of another output", wrongKey, ErrWrongKey},
+		{"signature of another transaction", badSignature, ErrBadSignature},
+		{"valid", newTestSpend(t, bc, coinbases[0], 9), nil},
+		{"coinbase", coinbases[0], nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := bc.CheckTransaction(test.tx)
+			if test.err == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.ErrorIs(t, err, test.err)
+			}
+			assert.Equal(t, test.err == nil, bc.VerifyTransaction(test.tx))
+		})
+	}
+}
@@ -3,53 +3,132 @@ package main
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
-
-	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
 
 	"golang.org/x/crypto/ripemd160"
 )
 
 const (
-	version            = byte(0x00)
+	// version is the version of the addresses of P-256 keys,
+	// the other schemes have their own, see SignatureScheme
+	version = byte(0x00)
+	// scriptHashVersion is the version of the addresses
+	// of redeem scripts, see MultisigAddress
+	scriptHashVersion  = byte(0x05)
 	addressChecksumLen = 4
+	addressHashLen     = 20
+
+	// Bech32HRP is the human-readable part of the Bech32 addresses,
+	// see EncodeBech32Address
+	Bech32HRP = "lab"
 )
 
+var ErrInvalidAddress = errors.New("invalid address")
+
 // newKeyPair creates a new cryptographic key pair
+// of the DefaultSignatureScheme
 func newKeyPair() (ecdsa.PrivateKey, []byte) {
-	curve := elliptic.P256()
-	privSt, err := ecdsa.GenerateKey(curve, rand.Reader)
-	if err != nil {
-		return ecdsa.PrivateKey{}, nil
-	}
-
-	pubKey := pubKeyToByte(privSt.PublicKey)
-	return *privSt, pubKey
+	return newSchemeKeyPair(DefaultSignatureScheme)
 }
 
-// pubKeyToByte converts the ecdsa.PublicKey to a concatenation of its coordinates in bytes
+// pubKeyToByte encodes the ecdsa.PublicKey as its SignatureScheme does.
+// Keys of other curves are the concatenation of their coordinates.
 func pubKeyToByte(pubkey ecdsa.PublicKey) []byte {
-	var add []byte
-	add = append(add, pubkey.X.Bytes()...)
-	add = append(add, pubkey.Y.Bytes()...)
+	if scheme, err := SchemeOfKey(pubkey.Curve); err == nil {
+		return scheme.EncodePublicKey(pubkey)
+	}
+	size := (pubkey.Curve.Params().BitSize + 7) / 8
+	add := make([]byte, 2*size)
+	pubkey.X.FillBytes(add[:size])
+	pubkey.Y.FillBytes(add[size:])
 	return add
 }
 
-// GetAddress returns address
+// GetAddress returns address, with the version of the SignatureScheme
+// of the key
 // https://en.bitcoin.it/wiki/Technical_background_of_version_1_Bitcoin_addresses#How_to_create_Bitcoin_Address
 func GetAddress(pubKeyBytes []byte) []byte {
+	addressVersion := version
+	if scheme, err := SchemeOfPublicKey(pubKeyBytes); err == nil {
+		addressVersion = scheme.AddressVersion()
+	}
+	return encodeAddress(addressVersion, HashPubKey(pubKeyBytes))
+}
+
+// encodeAddress returns the address of a hash: the base58 encoding
+// of the version, the hash and the checksum of both
+func encodeAddress(version byte, hash []byte) []byte {
 	var versionedKey []byte
-	hashedKey := HashPubKey(pubKeyBytes)
 	versionedKey = append(versionedKey, []byte{version}...)
-	versionedKey = append(versionedKey, hashedKey...)
+	versionedKey = append(versionedKey, hash...)
 	checksum := checksum(versionedKey)
 	versionedKey = append(versionedKey, checksum...)
 	return Base58Encode(versionedKey)
 }
 
+// EncodeBech32Address returns the Bech32 address of a hash: a segwit
+// address whose witness version is the version of the base58 address
+// and whose program is the hash
+func EncodeBech32Address(version byte, hash []byte) (string, error) {
+	return EncodeSegwitAddress(Bech32HRP, version, hash)
+}
+
+// GetBech32Address returns the Bech32 address of the public key,
+// the same key as the GetAddress one
+func GetBech32Address(pubKeyBytes []byte) string {
+	version, hash, _ := DecodeAddress(string(GetAddress(pubKeyBytes)))
+	address, _ := EncodeBech32Address(version, hash)
+	return address
+}
+
+// DecodeAddress returns the version and the hash of an address, either
+// base58 encoded by encodeAddress or Bech32 encoded by
+// EncodeBech32Address. The version must be the one of a SignatureScheme
+// or the scriptHashVersion.
+func DecodeAddress(address string) (byte, []byte, error) {
+	var version byte
+	var hash []byte
+	if strings.HasPrefix(strings.ToLower(address), Bech32HRP+"1") {
+		var err error
+		if version, hash, err = DecodeSegwitAddress(Bech32HRP, address); err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+	} else {
+		decodedAddr, err := Base58Decode([]byte(address))
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: %v", ErrInvalidAddress, err)
+		}
+		if len(decodedAddr) != 1+addressHashLen+addressChecksumLen {
+			return 0, nil, fmt.Errorf("%w: %d bytes", ErrInvalidAddress, len(decodedAddr))
+		}
+		payload := decodedAddr[:len(decodedAddr)-addressChecksumLen]
+		if !bytes.Equal(checksum(payload), decodedAddr[len(payload):]) {
+			return 0, nil, fmt.Errorf("%w: wrong checksum", ErrInvalidAddress)
+		}
+		version, hash = payload[0], payload[1:]
+	}
+
+	if len(hash) != addressHashLen {
+		return 0, nil, fmt.Errorf("%w: %d bytes hash", ErrInvalidAddress, len(hash))
+	}
+	if version == scriptHashVersion {
+		return version, hash, nil
+	}
+	for _, scheme := range signatureSchemes {
+		if scheme.AddressVersion() == version {
+			return version, hash, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("%w: unknown version %#02x", ErrInvalidAddress, version)
+}
+
 // GetStringAddress returns address as string
 func GetStringAddress(pubKeyBytes []byte) string {
 	return string(pubKeyBytes[:])
@@ -65,10 +144,14 @@ func HashPubKey(pubKey []byte) []byte {
 }
 
 // GetPubKeyHashFromAddress returns the hash of the public key
-// discarding the version and the checksum
+// discarding the version and the checksum, nil for an invalid address.
+// For the address of a redeem script, it is the hash of the script.
 func GetPubKeyHashFromAddress(address string) []byte {
-	decodedAddr := Base58Decode([]byte(address))
-	return decodedAddr[1 : len(decodedAddr)-4]
+	_, hash, err := DecodeAddress(address)
+	if err != nil {
+		return nil
+	}
+	return hash
 }
 
 // ValidateAddress check if an address is valid
@@ -77,11 +160,9 @@ func ValidateAddress(address string) bool {
 	// Validate a address by decoding it, extracting the
 	// checksum, re-computing it using the "checksum" function
 	// and comparing both.
-	decodedAddr := Base58Decode([]byte(address))
-	pubKey := decodedAddr[0 : len(decodedAddr)-4]
-	oldchksum := decodedAddr[len(decodedAddr)-4:]
-	newChecksum := checksum(pubKey)
-	return bytes.Equal(oldchksum, newChecksum)
+	// (see DecodeAddress, which also checks the version and the length)
+	_, _, err := DecodeAddress(address)
+	return err == nil
 }
 
 // Checksum generates a checksum for a public key
@@ -95,11 +176,18 @@ func encodeKeyPair(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) (st
 	return encodePrivateKey(privateKey), encodePublicKey(publicKey)
 }
 
+// encodePrivateKey returns the PEM encoded private key. The keys of
+// schemes other than P256ECDSA have a Scheme header.
 func encodePrivateKey(privateKey *ecdsa.PrivateKey) string {
-	x509Encoded, _ := x509.MarshalECPrivateKey(privateKey)
-	pemEncoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded})
-
-	return string(pemEncoded)
+	der, err := marshalPrivateKey(privateKey)
+	if err != nil {
+		return ""
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	if scheme, _ := SchemeOfKey(privateKey.Curve); scheme != nil && scheme != P256ECDSA {
+		block.Headers = map[string]string{"Scheme": scheme.Name()}
+	}
+	return string(pem.EncodeToMemory(block))
 }
 
 func encodePublicKey(publicKey *ecdsa.PublicKey) string {
@@ -109,21 +197,104 @@ func encodePublicKey(publicKey *ecdsa.PublicKey) string {
 	return string(pemEncodedPub)
 }
 
-func decodeKeyPair(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey) {
-	return decodePrivateKey(pemEncoded), decodePublicKey(pemEncodedPub)
+func decodeKeyPair(pemEncoded string, pemEncodedPub string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	privateKey := decodePrivateKey(pemEncoded)
+	if privateKey == nil {
+		return nil, nil, ErrInvalidWalletKey
+	}
+	publicKey, err := decodePublicKey(pemEncodedPub)
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey, publicKey, nil
 }
 
 func decodePrivateKey(pemEncoded string) *ecdsa.PrivateKey {
 	block, _ := pem.Decode([]byte(pemEncoded))
-	privateKey, _ := x509.ParseECPrivateKey(block.Bytes)
-
+	if block == nil {
+		return nil
+	}
+	scheme := P256ECDSA
+	if name, ok := block.Headers["Scheme"]; ok {
+		var err error
+		if scheme, err = SchemeByName(name); err != nil {
+			return nil
+		}
+	}
+	privateKey, err := parsePrivateKey(block.Bytes, scheme)
+	if err != nil && block.Headers["Scheme"] == "" {
+		// a secp256k1 key from elsewhere
+		privateKey, err = parsePrivateKey(block.Bytes, Secp256k1ECDSA)
+	}
+	if err != nil {
+		return nil
+	}
 	return privateKey
 }
 
-func decodePublicKey(pemEncodedPub string) *ecdsa.PublicKey {
-	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
-	genericPubKey, _ := x509.ParsePKIXPublicKey(blockPub.Bytes)
-	publicKey := genericPubKey.(*ecdsa.PublicKey) // cast to ecdsa
+// oidSecp256k1 is the object identifier of the secp256k1 curve
+var oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+// ecPrivateKey is the SEC1 ASN.1 structure of an EC private key,
+// which x509 only supports for the NIST curves
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
 
-	return publicKey
+// marshalPrivateKey returns the SEC1 DER encoding of the private key.
+// Both secp256k1 schemes have the same encoding.
+func marshalPrivateKey(privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	if privateKey.Curve != secp256k1 && privateKey.Curve != secp256k1Schnorr {
+		return x509.MarshalECPrivateKey(privateKey)
+	}
+	uncompressed := append([]byte{4}, bytes32(privateKey.X)...)
+	uncompressed = append(uncompressed, bytes32(privateKey.Y)...)
+	return asn1.Marshal(ecPrivateKey{
+		Version:       1,
+		PrivateKey:    bytes32(privateKey.D),
+		NamedCurveOID: oidSecp256k1,
+		PublicKey:     asn1.BitString{Bytes: uncompressed, BitLength: 8 * len(uncompressed)},
+	})
+}
+
+// parsePrivateKey decodes a private key of the scheme
+// encoded by marshalPrivateKey
+func parsePrivateKey(der []byte, scheme SignatureScheme) (*ecdsa.PrivateKey, error) {
+	if scheme == P256ECDSA {
+		return x509.ParseECPrivateKey(der)
+	}
+	var key ecPrivateKey
+	if rest, err := asn1.Unmarshal(der, &key); err != nil || len(rest) != 0 {
+		return nil, ErrInvalidWalletKey
+	}
+	curve := scheme.Curve()
+	d := new(big.Int).SetBytes(key.PrivateKey)
+	if key.Version != 1 || !key.NamedCurveOID.Equal(oidSecp256k1) || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidWalletKey
+	}
+	privateKey := &ecdsa.PrivateKey{D: d}
+	privateKey.Curve = curve
+	privateKey.X, privateKey.Y = curve.ScalarBaseMult(bytes32(d))
+	return privateKey, nil
+}
+
+// decodePublicKey decodes a public key encoded by encodePublicKey,
+// malformed keys are errors
+func decodePublicKey(pemEncodedPub string) (*ecdsa.PublicKey, error) {
+	blockPub, _ := pem.Decode([]byte(pemEncodedPub))
+	if blockPub == nil {
+		return nil, fmt.Errorf("%w: not PEM encoded", ErrInvalidPubKey)
+	}
+	genericPubKey, err := x509.ParsePKIXPublicKey(blockPub.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPubKey, err)
+	}
+	publicKey, ok := genericPubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not an ECDSA key", ErrInvalidPubKey)
+	}
+	return publicKey, nil
 }
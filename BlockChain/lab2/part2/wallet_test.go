@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	_ "embed"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -131,6 +132,60 @@ func TestInvalidAddresses(t *testing.T) {
 	}
 }
 
+func TestDecodeAddress(t *testing.T) {
+	for _, test := range addressTable {
+		version, hash, err := DecodeAddress(test.address)
+		assert.Nil(t, err)
+		assert.Equal(t, test.version, version)
+		assert.Equal(t, test.pubKeyHash, hash)
+
+		// the Bech32 address of the same hash
+		bech32, err := EncodeBech32Address(version, hash)
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(bech32, Bech32HRP+"1q"))
+		assert.Equal(t, bech32, GetBech32Address(test.pubkey))
+		for _, address := range []string{bech32, strings.ToUpper(bech32)} {
+			version, hash, err = DecodeAddress(address)
+			assert.Nil(t, err)
+			assert.Equal(t, test.version, version)
+			assert.Equal(t, test.pubKeyHash, hash)
+		}
+	}
+
+	// the Bech32 witness version is the version of the address
+	ms, _ := newTestMultisig(t, 2, 3)
+	bech32, err := EncodeBech32Address(scriptHashVersion, ms.ScriptHash())
+	assert.Nil(t, err)
+	version, hash, err := DecodeAddress(bech32)
+	assert.Nil(t, err)
+	assert.Equal(t, scriptHashVersion, version)
+	assert.Equal(t, ms.ScriptHash(), hash)
+
+	// typos and garbage are errors, not panics
+	// a typo in the checksum, whatever its last character
+	typo := bech32[:len(bech32)-1] + "q"
+	if bech32[len(bech32)-1] == 'q' {
+		typo = bech32[:len(bech32)-1] + "p"
+	}
+	invalid := append([]string{"", "1", "11", "lab1", "lab1qqqqqqqqqqqq", typo, "\x00", "1111111111111111111111111"}, invalidAddresses...)
+	// a valid checksum but an unknown version or a short hash
+	invalid = append(invalid, string(encodeAddress(0x42, addressTable[0].pubKeyHash)), string(encodeAddress(version, addressTable[0].pubKeyHash[:19])))
+	unknown, _ := EncodeBech32Address(3, addressTable[0].pubKeyHash)
+	short, _ := EncodeBech32Address(0, make([]byte, 32))
+	invalid = append(invalid, unknown, short)
+	for _, address := range invalid {
+		_, _, err := DecodeAddress(address)
+		assert.ErrorIs(t, err, ErrInvalidAddress, "%q", address)
+		assert.False(t, ValidateAddress(address))
+		assert.Nil(t, GetPubKeyHashFromAddress(address))
+
+		var out TXOutput
+		assert.ErrorIs(t, out.Lock(address), ErrInvalidAddress)
+		_, err = NewCoinbaseTX(address, "", 1)
+		assert.ErrorIs(t, err, ErrInvalidAddress)
+	}
+}
+
 func TestChecksum(t *testing.T) {
 	for i := 0; i < len(addressTable); i++ {
 		t.Run(addressTable[i].address, func(t *testing.T) {
@@ -149,11 +204,36 @@ func TestNewKeyPair(t *testing.T) {
 		t.Fatal("newKeyPair returned an unexpected result")
 	}
 
-	assert.Equalf(t, append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...), pubKey, "The public key should be represented as a concatenation of it's coordinates")
+	x := make([]byte, 32)
+	privKey.PublicKey.X.FillBytes(x)
+	assert.Equal(t, DefaultSignatureScheme, Secp256k1ECDSA)
+	assert.Equalf(t, append([]byte{byte(2 + privKey.PublicKey.Y.Bit(0))}, x...), pubKey, "The public key should be compressed")
+
+	// P-256 keys are still the concatenation of their padded coordinates
+	privKey, pubKey = newSchemeKeyPair(P256ECDSA)
+	y := make([]byte, 32)
+	privKey.PublicKey.X.FillBytes(x)
+	privKey.PublicKey.Y.FillBytes(y)
+	assert.Equalf(t, append(x, y...), pubKey, "The public key should be represented as a concatenation of it's padded coordinates")
 }
 
 func TestPubKeyToByte(t *testing.T) {
-	pubkey := decodePublicKey(testEncPubKeyUser1)
+	pubkey, err := decodePublicKey(testEncPubKeyUser1)
+	assert.Nil(t, err)
 
 	assert.Equalf(t, Hex2Bytes("f86aa0caf08359ee4227d2901ab490172c69a801910f4140cdde2f5dc8f8bb3dc19da2c9fb0ed041db106a8fea0382de25edbc83df6893574e40fc2e1e493748"), pubKeyToByte(*pubkey), "The public key should be represented as a concatenation of it's coordinates")
 }
+
+func TestDecodeMalformedKeys(t *testing.T) {
+	// malformed keys are errors, not panics
+	truncated := strings.Replace(testEncPubKeyUser1, "\n-----END", "AAAA\n-----END", 1)
+	ed25519Key := "-----BEGIN PUBLIC KEY-----\nMCowBQYDK2VwAyEAGb9ECWmEzf6FQbrBZ9w7lshQhqowtrbLDFw4rXAxZuE=\n-----END PUBLIC KEY-----\n"
+	for _, pemPub := range []string{"", "not pem", truncated, testEncPrivKeyUser1, ed25519Key} {
+		_, err := decodePublicKey(pemPub)
+		assert.ErrorIs(t, err, ErrInvalidPubKey, pemPub)
+	}
+	_, _, err := decodeKeyPair(testEncPrivKeyUser1, "")
+	assert.ErrorIs(t, err, ErrInvalidPubKey)
+	_, _, err = decodeKeyPair("", testEncPubKeyUser1)
+	assert.ErrorIs(t, err, ErrInvalidWalletKey)
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// walletsVersion is the version of the format of the wallets file
+const walletsVersion = 1
+
+// walletScryptN is the scrypt cost of the new wallets files. Loaded files
+// keep the parameters they were created with.
+var walletScryptN = 1 << 15
+
+var (
+	ErrWalletLocked     = errors.New("wallets are locked")
+	ErrWrongPassphrase  = errors.New("wrong passphrase")
+	ErrWalletNotFound   = errors.New("no wallet for this address")
+	ErrWalletExists     = errors.New("wallet already exists")
+	ErrInvalidWalletKey = errors.New("invalid private key")
+	ErrWalletsFile      = errors.New("invalid wallets file")
+	ErrNoSeed           = errors.New("wallets have no seed")
+)
+
+// walletsSeedData is the additional data of the sealed seed
+var walletsSeedData = []byte("seed")
+
+// walletsCheck is the plaintext sealed in the wallets file to check
+// the passphrase, even when it holds no key
+var walletsCheck = []byte("wallets")
+
+// Wallet is a key pair of a Wallets store, with a label chosen by
+// its owner. The private key is only available while the store is
+// unlocked.
+type Wallet struct {
+	Label     string
+	PublicKey []byte
+	Path      string // the derivation path of the keys derived from the seed
+
+	scheme     SignatureScheme
+	privateKey *ecdsa.PrivateKey
+	sealed     []byte // the encrypted private key
+}
+
+// Address returns the address of the wallet
+func (w *Wallet) Address() string {
+	return GetStringAddress(GetAddress(w.PublicKey))
+}
+
+// Scheme returns the SignatureScheme of the keys of the wallet
+func (w *Wallet) Scheme() SignatureScheme {
+	return w.scheme
+}
+
+// PrivateKey returns the private key of the wallet,
+// which the Wallets must be unlocked to read
+func (w *Wallet) PrivateKey() (ecdsa.PrivateKey, error) {
+	if w.privateKey == nil {
+		return ecdsa.PrivateKey{}, ErrWalletLocked
+	}
+	return *w.privateKey, nil
+}
+
+// Wallets is a set of wallets persisted in a file. The private keys are
+// encrypted with AES-GCM under a key derived from a passphrase with
+// scrypt; the labels and public keys are not, so the addresses can be
+// listed while the wallets are locked.
+//
+// The wallets can also have a seed, encrypted as well, from which new
+// keys are derived as the receiving addresses of an HDWallet, see
+// NewHDWallet. The wallets are then restored from the seed alone, see
+// RestoreHD.
+//
+// The wallets are locked when loaded, see Unlock. Every change is saved
+// to the file right away.
+type Wallets struct {
+	file       string
+	kdf        walletsKDF
+	check      []byte
+	key        []byte // the AES key, nil while locked
+	wallets    []*Wallet
+	sealedSeed []byte
+	seed       []byte // nil while locked
+	seedScheme SignatureScheme
+	nextIndex  uint32 // the index of the next receiving address of the seed
+}
+
+// walletsKDF are the scrypt parameters of a wallets file
+type walletsKDF struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+// walletsFile is the JSON content of a wallets file
+type walletsFile struct {
+	Version   int          `json:"version"`
+	KDF       walletsKDF   `json:"kdf"`
+	Check     []byte       `json:"check"`
+	Keys      []walletFile `json:"keys"`
+	Seed      []byte       `json:"seed,omitempty"`
+	NextIndex uint32       `json:"nextIndex,omitempty"`
+	// SeedScheme is the name of the SignatureScheme of the keys of the
+	// seed, the seeds of the first files derive P-256 keys
+	SeedScheme string `json:"seedScheme,omitempty"`
+}
+
+type walletFile struct {
+	Label      string `json:"label"`
+	PublicKey  []byte `json:"publicKey"`
+	PrivateKey []byte `json:"privateKey"`
+	Path       string `json:"path,omitempty"`
+	// Scheme is the name of the SignatureScheme,
+	// the keys of the first files are P-256 keys
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// CreateWallets creates an empty wallets file encrypted with the
+// passphrase. The returned Wallets are unlocked.
+func CreateWallets(file, passphrase string) (*Wallets, error) {
+	if _, err := os.Stat(file); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrWalletExists, file)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	ws := &Wallets{file: file, kdf: walletsKDF{N: walletScryptN, R: 8, P: 1, Salt: salt}}
+	key, err := ws.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ws.key = key
+	if ws.check, err = ws.seal(walletsCheck, nil); err != nil {
+		return nil, err
+	}
+	return ws, ws.Save()
+}
+
+// LoadWallets reads a wallets file. The returned Wallets are locked.
+func LoadWallets(file string) (*Wallets, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var content walletsFile
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWalletsFile, err)
+	}
+	if content.Version != walletsVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrWalletsFile, content.Version)
+	}
+	ws := &Wallets{file: file, kdf: content.KDF, check: content.Check, sealedSeed: content.Seed, nextIndex: content.NextIndex}
+	if content.Seed != nil {
+		ws.seedScheme = P256ECDSA
+		if content.SeedScheme != "" {
+			if ws.seedScheme, err = SchemeByName(content.SeedScheme); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrWalletsFile, err)
+			}
+		}
+	}
+	for _, k := range content.Keys {
+		scheme := P256ECDSA
+		if k.Scheme != "" {
+			if scheme, err = SchemeByName(k.Scheme); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrWalletsFile, err)
+			}
+		}
+		if _, err := scheme.ParsePublicKey(k.PublicKey); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrWalletsFile, err)
+		}
+		ws.wallets = append(ws.wallets, &Wallet{Label: k.Label, PublicKey: k.PublicKey, Path: k.Path, scheme: scheme, sealed: k.PrivateKey})
+	}
+	return ws, nil
+}
+
+// Save writes the wallets to their file, replacing it
+func (ws *Wallets) Save() error {
+	content := walletsFile{Version: walletsVersion, KDF: ws.kdf, Check: ws.check, Seed: ws.sealedSeed, NextIndex: ws.nextIndex}
+	if ws.seedScheme != nil {
+		content.SeedScheme = ws.seedScheme.Name()
+	}
+	for _, w := range ws.wallets {
+		content.Keys = append(content.Keys, walletFile{Label: w.Label, PublicKey: w.PublicKey, PrivateKey: w.sealed, Path: w.Path, Scheme: w.scheme.Name()})
+	}
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	// the file is replaced at once, so a crash never leaves it half written
+	tmp := ws.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ws.file)
+}
+
+// IsLocked reports whether the private keys are unavailable
+func (ws *Wallets) IsLocked() bool {
+	return ws.key == nil
+}
+
+// Unlock decrypts the private keys with the passphrase
+func (ws *Wallets) Unlock(passphrase string) error {
+	key, err := ws.deriveKey(passphrase)
+	if err != nil {
+		return err
+	}
+	if check, err := openSealed(key, ws.check, nil); err != nil || !bytes.Equal(check, walletsCheck) {
+		return ErrWrongPassphrase
+	}
+	privateKeys := make([]*ecdsa.PrivateKey, len(ws.wallets))
+	for i, w := range ws.wallets {
+		der, err := openSealed(key, w.sealed, w.PublicKey)
+		if err != nil {
+			return fmt.Errorf("%w: key of %s: %v", ErrWalletsFile, w.Address(), err)
+		}
+		privateKey, err := parsePrivateKey(der, w.scheme)
+		if err != nil || !bytes.Equal(pubKeyToByte(privateKey.PublicKey), w.PublicKey) {
+			return fmt.Errorf("%w: key of %s", ErrWalletsFile, w.Address())
+		}
+		privateKeys[i] = privateKey
+	}
+	var seed []byte
+	if ws.sealedSeed != nil {
+		if seed, err = openSealed(key, ws.sealedSeed, walletsSeedData); err != nil {
+			return fmt.Errorf("%w: seed: %v", ErrWalletsFile, err)
+		}
+	}
+
+	ws.key = key
+	ws.seed = seed
+	for i, w := range ws.wallets {
+		w.privateKey = privateKeys[i]
+	}
+	return nil
+}
+
+// Lock forgets the private keys until the next Unlock
+func (ws *Wallets) Lock() {
+	ws.key = nil
+	ws.seed = nil
+	for _, w := range ws.wallets {
+		w.privateKey = nil
+	}
+}
+
+// Wallets returns the wallets, in the order they were added
+func (ws *Wallets) Wallets() []*Wallet {
+	return ws.wallets
+}
+
+// Get returns the wallet of the address
+func (ws *Wallets) Get(address string) (*Wallet, error) {
+	for _, w := range ws.wallets {
+		if w.Address() == address {
+			return w, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrWalletNotFound, address)
+}
+
+// NewWallet adds a new key pair of the DefaultSignatureScheme
+// with the label to the unlocked wallets
+func (ws *Wallets) NewWallet(label string) (*Wallet, error) {
+	return ws.NewSchemeWallet(DefaultSignatureScheme, label)
+}
+
+// NewSchemeWallet adds a new key pair of the scheme
+// with the label to the unlocked wallets
+func (ws *Wallets) NewSchemeWallet(scheme SignatureScheme, label string) (*Wallet, error) {
+	privateKey, _ := newSchemeKeyPair(scheme)
+	if privateKey.D == nil {
+		return nil, ErrInvalidWalletKey
+	}
+	return ws.add(&privateKey, label, "")
+}
+
+// Import adds a private key, PEM encoded as by Export,
+// with the label to the unlocked wallets
+func (ws *Wallets) Import(pemPrivateKey, label string) (*Wallet, error) {
+	privateKey := decodePrivateKey(pemPrivateKey)
+	if privateKey == nil {
+		return nil, ErrInvalidWalletKey
+	}
+	if _, err := SchemeOfKey(privateKey.Curve); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidWalletKey, err)
+	}
+	return ws.add(privateKey, label, "")
+}
+
+// Export returns the PEM encoded private key of the address
+// from the unlocked wallets
+func (ws *Wallets) Export(address string) (string, error) {
+	w, err := ws.Get(address)
+	if err != nil {
+		return "", err
+	}
+	privateKey, err := w.PrivateKey()
+	if err != nil {
+		return "", err
+	}
+	return encodePrivateKey(&privateKey), nil
+}
+
+// SetLabel changes the label of the wallet of the address
+func (ws *Wallets) SetLabel(address, label string) error {
+	w, err := ws.Get(address)
+	if err != nil {
+		return err
+	}
+	w.Label = label
+	return ws.Save()
+}
+
+// HasSeed reports whether the wallets have a seed, see SetSeed
+func (ws *Wallets) HasSeed() bool {
+	return ws.sealedSeed != nil
+}
+
+// SetSeed sets the seed of the unlocked wallets, see MnemonicToSeed.
+// The seed cannot be changed once set. Its keys are the ones of the
+// DefaultSignatureScheme.
+func (ws *Wallets) SetSeed(seed []byte) error {
+	return ws.SetSchemeSeed(DefaultSignatureScheme, seed)
+}
+
+// SetSchemeSeed sets the seed of the unlocked wallets, whose keys are
+// the ones of the scheme, see NewSchemeMasterKey
+func (ws *Wallets) SetSchemeSeed(scheme SignatureScheme, seed []byte) error {
+	if ws.IsLocked() {
+		return ErrWalletLocked
+	}
+	if ws.HasSeed() {
+		return fmt.Errorf("%w: the seed is already set", ErrWalletExists)
+	}
+	if _, err := NewSchemeMasterKey(scheme, seed); err != nil {
+		return err
+	}
+	sealed, err := ws.seal(seed, walletsSeedData)
+	if err != nil {
+		return err
+	}
+	ws.sealedSeed, ws.seed, ws.seedScheme, ws.nextIndex = sealed, seed, scheme, 0
+	if err := ws.Save(); err != nil {
+		ws.sealedSeed, ws.seed, ws.seedScheme = nil, nil, nil
+		return err
+	}
+	return nil
+}
+
+// HDWallet returns the HDWallet of the seed of the unlocked wallets
+func (ws *Wallets) HDWallet() (*HDWallet, error) {
+	if ws.IsLocked() {
+		return nil, ErrWalletLocked
+	}
+	if !ws.HasSeed() {
+		return nil, ErrNoSeed
+	}
+	return NewSchemeHDWallet(ws.seedScheme, ws.seed)
+}
+
+// NewHDWallet adds the next receiving address of the seed
+// with the label to the unlocked wallets
+func (ws *Wallets) NewHDWallet(label string) (*Wallet, error) {
+	hd, err := ws.HDWallet()
+	if err != nil {
+		return nil, err
+	}
+	w, err := ws.addHDKey(hd, false, ws.nextIndex, label)
+	if err != nil {
+		return nil, err
+	}
+	ws.nextIndex++
+	return w, ws.Save()
+}
+
+// RestoreHD adds to the unlocked wallets the addresses of the seed
+// with unspent outputs, see HDWallet.Scan, and returns them.
+// The next receiving address follows the last one found.
+func (ws *Wallets) RestoreHD(utxos UTXOFinder) ([]*Wallet, error) {
+	hd, err := ws.HDWallet()
+	if err != nil {
+		return nil, err
+	}
+	found, err := hd.Scan(utxos)
+	if err != nil {
+		return nil, err
+	}
+	var restored []*Wallet
+	for _, a := range found {
+		if !a.Change && a.Index >= ws.nextIndex {
+			ws.nextIndex = a.Index + 1
+		}
+		if _, err := ws.Get(a.Address); err == nil {
+			continue
+		}
+		w, err := ws.addHDKey(hd, a.Change, a.Index, "")
+		if err != nil {
+			return nil, err
+		}
+		restored = append(restored, w)
+	}
+	return restored, ws.Save()
+}
+
+func (ws *Wallets) addHDKey(hd *HDWallet, change bool, index uint32, label string) (*Wallet, error) {
+	key, err := hd.Key(change, index)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := key.PrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	chain := 0
+	if change {
+		chain = 1
+	}
+	return ws.add(&privateKey, label, fmt.Sprintf("%s/%d/%d", DefaultAccountPath, chain, index))
+}
+
+func (ws *Wallets) add(privateKey *ecdsa.PrivateKey, label, path string) (*Wallet, error) {
+	if ws.IsLocked() {
+		return nil, ErrWalletLocked
+	}
+	scheme, err := SchemeOfKey(privateKey.Curve)
+	if err != nil {
+		return nil, err
+	}
+	w := &Wallet{Label: label, PublicKey: scheme.EncodePublicKey(privateKey.PublicKey), Path: path, scheme: scheme, privateKey: privateKey}
+	if _, err := ws.Get(w.Address()); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrWalletExists, w.Address())
+	}
+	der, err := marshalPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	// the public key is authenticated with the private key,
+	// so the keys of two wallets cannot be swapped in the file
+	if w.sealed, err = ws.seal(der, w.PublicKey); err != nil {
+		return nil, err
+	}
+	ws.wallets = append(ws.wallets, w)
+	if err := ws.Save(); err != nil {
+		ws.wallets = ws.wallets[:len(ws.wallets)-1]
+		return nil, err
+	}
+	return w, nil
+}
+
+// deriveKey returns the AES-256 key of the passphrase
+func (ws *Wallets) deriveKey(passphrase string) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), ws.kdf.Salt, ws.kdf.N, ws.kdf.R, ws.kdf.P, 32)
+}
+
+// seal encrypts and authenticates the plaintext and the additional data
+// with the key of the unlocked wallets: nonce|ciphertext
+func (ws *Wallets) seal(plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(ws.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openSealed decrypts data sealed by Wallets.seal
+func openSealed(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrWalletsFile
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestWallets creates a wallets file in a temporary directory,
+// with a cheap key derivation
+func newTestWallets(t *testing.T, passphrase string) (*Wallets, string) {
+	old := walletScryptN
+	walletScryptN = 1 << 10
+	t.Cleanup(func() { walletScryptN = old })
+
+	file := filepath.Join(t.TempDir(), "wallets.json")
+	ws, err := CreateWallets(file, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws, file
+}
+
+func TestWalletsPersistence(t *testing.T) {
+	ws, file := newTestWallets(t, "secret")
+	assert.False(t, ws.IsLocked())
+	first, err := ws.NewWallet("savings")
+	assert.Nil(t, err)
+	second, err := ws.NewWallet("")
+	assert.Nil(t, err)
+	assert.NotEqual(t, first.Address(), second.Address())
+
+	_, err = CreateWallets(file, "other")
+	assert.ErrorIs(t, err, ErrWalletExists)
+
+	// the addresses and labels are readable while locked
+	loaded, err := LoadWallets(file)
+	assert.Nil(t, err)
+	assert.True(t, loaded.IsLocked())
+	assert.Len(t, loaded.Wallets(), 2)
+	w, err := loaded.Get(first.Address())
+	assert.Nil(t, err)
+	assert.Equal(t, "savings", w.Label)
+	assert.Equal(t, first.PublicKey, w.PublicKey)
+	_, err = w.PrivateKey()
+	assert.ErrorIs(t, err, ErrWalletLocked)
+	_, err = loaded.NewWallet("")
+	assert.ErrorIs(t, err, ErrWalletLocked)
+
+	// but the private keys are not
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	pem, err := ws.Export(first.Address())
+	assert.Nil(t, err)
+	lines := strings.Split(pem, "\n")
+	assert.NotContains(t, string(data), lines[1])
+
+	assert.ErrorIs(t, loaded.Unlock("wrong"), ErrWrongPassphrase)
+	assert.True(t, loaded.IsLocked())
+	assert.Nil(t, loaded.Unlock("secret"))
+	key, err := w.PrivateKey()
+	assert.Nil(t, err)
+	expected, _ := first.PrivateKey()
+	assert.Equal(t, expected.D, key.D)
+
+	loaded.Lock()
+	assert.True(t, loaded.IsLocked())
+	_, err = w.PrivateKey()
+	assert.ErrorIs(t, err, ErrWalletLocked)
+	_, err = loaded.Export(first.Address())
+	assert.ErrorIs(t, err, ErrWalletLocked)
+}
+
+func TestWalletsImportExport(t *testing.T) {
+	ws, file := newTestWallets(t, "secret")
+	w, err := ws.Import(testEncPrivKeyUser1, "miner")
+	assert.Nil(t, err)
+	assert.Equal(t, testMinerAddress, w.Address())
+	_, err = ws.Import(testEncPrivKeyUser1, "again")
+	assert.ErrorIs(t, err, ErrWalletExists)
+	_, err = ws.Import("not a key", "")
+	assert.ErrorIs(t, err, ErrInvalidWalletKey)
+
+	// an exported key imports back into other wallets
+	pem, err := ws.Export(testMinerAddress)
+	assert.Nil(t, err)
+	other, _ := newTestWallets(t, "other")
+	imported, err := other.Import(pem, "")
+	assert.Nil(t, err)
+	assert.Equal(t, testMinerAddress, imported.Address())
+	_, err = ws.Export("1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX")
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+
+	// labels can be changed while locked
+	ws.Lock()
+	assert.Nil(t, ws.SetLabel(testMinerAddress, "rewards"))
+	assert.ErrorIs(t, ws.SetLabel("1HrwWkjdwQuhaHSco9H7u7SVsmo4aeDZBX", ""), ErrWalletNotFound)
+	loaded, err := LoadWallets(file)
+	assert.Nil(t, err)
+	assert.Equal(t, "rewards", loaded.Wallets()[0].Label)
+}
+
+func TestWalletsFileTampering(t *testing.T) {
+	ws, file := newTestWallets(t, "secret")
+	_, err := ws.NewWallet("")
+	assert.Nil(t, err)
+	_, err = ws.Import(testEncPrivKeyUser1, "")
+	assert.Nil(t, err)
+
+	// swapping the encrypted keys of two wallets is detected
+	ws.wallets[0].sealed, ws.wallets[1].sealed = ws.wallets[1].sealed, ws.wallets[0].sealed
+	assert.Nil(t, ws.Save())
+	loaded, err := LoadWallets(file)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, loaded.Unlock("secret"), ErrWalletsFile)
+	assert.True(t, loaded.IsLocked())
+
+	assert.Nil(t, os.WriteFile(file, []byte(`{"version": 2}`), 0600))
+	_, err = LoadWallets(file)
+	assert.ErrorIs(t, err, ErrWalletsFile)
+	assert.Nil(t, os.WriteFile(file, []byte(`not json`), 0600))
+	_, err = LoadWallets(file)
+	assert.ErrorIs(t, err, ErrWalletsFile)
+}
+
+func TestWalletsSeed(t *testing.T) {
+	seed, _ := MnemonicToSeed(mnemonicTable[2].mnemonic, "")
+	hd, _ := NewHDWallet(seed)
+	ws, file := newTestWallets(t, "secret")
+	_, err := ws.NewHDWallet("")
+	assert.ErrorIs(t, err, ErrNoSeed)
+	assert.Nil(t, ws.SetSeed(seed))
+	assert.ErrorIs(t, ws.SetSeed(seed), ErrWalletExists)
+
+	// the new addresses are the receiving addresses of the seed
+	var addresses []string
+	for i := uint32(0); i < 3; i++ {
+		w, err := ws.NewHDWallet("")
+		assert.Nil(t, err)
+		expected, _ := hd.Address(false, i)
+		assert.Equal(t, expected, w.Address())
+		assert.Equal(t, fmt.Sprintf("m/44'/1'/0'/0/%d", i), w.Path)
+		assert.Equal(t, DefaultSignatureScheme, w.Scheme())
+		addresses = append(addresses, w.Address())
+	}
+
+	// the seed is encrypted with the keys
+	loaded, err := LoadWallets(file)
+	assert.Nil(t, err)
+	assert.True(t, loaded.HasSeed())
+	_, err = loaded.NewHDWallet("")
+	assert.ErrorIs(t, err, ErrWalletLocked)
+	assert.Nil(t, loaded.Unlock("secret"))
+	w, err := loaded.NewHDWallet("")
+	assert.Nil(t, err)
+	expected, _ := hd.Address(false, 3)
+	assert.Equal(t, expected, w.Address())
+	assert.Equal(t, "m/44'/1'/0'/0/3", loaded.Wallets()[3].Path)
+
+	// other wallets restore the addresses with funds from the seed alone
+	utxos := make(UTXOSet)
+	for i, address := range []string{addresses[0], addresses[2]} {
+		out := TXOutput{Value: 1}
+		out.Lock(address)
+		utxos[hex.EncodeToString([]byte{byte(i)})] = map[int]TXOutput{0: out}
+	}
+	restored, _ := newTestWallets(t, "other")
+	assert.Nil(t, restored.SetSeed(seed))
+	found, err := restored.RestoreHD(utxos)
+	assert.Nil(t, err)
+	assert.Len(t, found, 2)
+	assert.Equal(t, addresses[0], found[0].Address())
+	assert.Equal(t, addresses[2], found[1].Address())
+	w, err = restored.NewHDWallet("")
+	assert.Nil(t, err)
+	assert.Equal(t, expected, w.Address())
+	found, err = restored.RestoreHD(utxos)
+	assert.Nil(t, err)
+	assert.Empty(t, found)
+
+	// the seeds of the files without scheme derive P-256 keys
+	legacy, legacyFile := newTestWallets(t, "legacy")
+	assert.Nil(t, legacy.SetSchemeSeed(P256ECDSA, seed))
+	legacy.seedScheme = nil
+	assert.Nil(t, legacy.Save())
+	loaded, err = LoadWallets(legacyFile)
+	assert.Nil(t, err)
+	assert.Nil(t, loaded.Unlock("legacy"))
+	w, err = loaded.NewHDWallet("")
+	assert.Nil(t, err)
+	assert.Equal(t, P256ECDSA, w.Scheme())
+	p256, _ := NewSchemeHDWallet(P256ECDSA, seed)
+	expected, _ = p256.Address(false, 0)
+	assert.Equal(t, expected, w.Address())
+	assert.NotEqual(t, addresses[0], w.Address())
+}
+
+func TestWalletsSchemes(t *testing.T) {
+	ws, file := newTestWallets(t, "secret")
+	w, err := ws.NewWallet("")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultSignatureScheme, w.Scheme())
+	for _, scheme := range signatureSchemes {
+		w, err := ws.NewSchemeWallet(scheme, scheme.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, scheme, w.Scheme())
+		assert.Len(t, w.PublicKey, scheme.PublicKeySize())
+	}
+	legacy, err := ws.Import(testEncPrivKeyUser1, "")
+	assert.Nil(t, err)
+	assert.Equal(t, P256ECDSA, legacy.Scheme())
+
+	// each key is read back with its scheme, and signs with it
+	loaded, err := LoadWallets(file)
+	assert.Nil(t, err)
+	assert.Nil(t, loaded.Unlock("secret"))
+	digest := make([]byte, 32)
+	for i, w := range loaded.Wallets() {
+		assert.Equal(t, ws.Wallets()[i].Scheme(), w.Scheme())
+		key, err := w.PrivateKey()
+		assert.Nil(t, err)
+		signature, err := signDigest(&key, digest)
+		assert.Nil(t, err)
+		assert.True(t, verifyDigest(w.PublicKey, digest, signature))
+
+		// and exported with it
+		pem, err := loaded.Export(w.Address())
+		assert.Nil(t, err)
+		other, _ := newTestWallets(t, "other")
+		imported, err := other.Import(pem, "")
+		assert.Nil(t, err)
+		assert.Equal(t, w.Address(), imported.Address())
+	}
+	assert.Equal(t, testMinerAddress, loaded.Wallets()[4].Address())
+}
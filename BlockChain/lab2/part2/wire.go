@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// protocolVersion is the version of the wire protocol spoken by the node.
+// Peers announcing an older version are disconnected.
+const protocolVersion = 1
+
+// networkMagic starts every message, so messages of another
+// network or protocol are rejected right away
+var networkMagic = [4]byte{0xb1, 0x0c, 0xc4, 0x1a}
+
+const (
+	// commandLength is the size of the zero padded command name
+	commandLength = 12
+	// headerLength is the size of a message header:
+	// magic | command | payload length | checksum
+	headerLength = 4 + commandLength + 4 + 4
+	// maxPayloadLength bounds the memory a peer can make us allocate
+	maxPayloadLength = 32 << 20
+)
+
+var (
+	ErrBadMagic        = errors.New("wire: unknown network magic")
+	ErrBadChecksum     = errors.New("wire: payload checksum mismatch")
+	ErrPayloadTooLarge = errors.New("wire: payload too large")
+)
+
+// Commands of the wire protocol
+const (
+	cmdVersion    = "version"
+	cmdVerack     = "verack"
+	cmdGetBlocks  = "getblocks"
+	cmdInv        = "inv"
+	cmdGetData    = "getdata"
+	cmdBlock      = "block"
+	cmdTx         = "tx"
+	cmdGetHeaders = "getheaders"
+	cmdHeaders    = "headers"
+	cmdGetProof   = "getproof"
+	cmdProof      = "proof"
+)
+
+// Types of inventory announced in inv and getdata messages
+const (
+	invBlock = "block"
+	invTx    = "tx"
+)
+
+// versionMsg starts the handshake between two nodes
+type versionMsg struct {
+	Version  int    // protocol version of the sender
+	Height   int    // height of the sender's main chain
+	AddrFrom string // address where the sender accepts connections
+	Genesis  []byte // hash of the sender's genesis block
+}
+
+// getBlocksMsg asks for the hashes of the blocks following the locator,
+// see Blockchain.BlockLocator
+type getBlocksMsg struct {
+	Locator [][]byte
+}
+
+// invMsg announces blocks or transactions by hash.
+// It is also the payload of getdata, asking for the announced items.
+type invMsg struct {
+	Type  string
+	Items [][]byte
+}
+
+// blockMsg carries a serialized Block
+type blockMsg struct {
+	Block []byte
+}
+
+// txMsg carries a serialized Transaction
+type txMsg struct {
+	Transaction []byte
+}
+
+// headersMsg carries serialized block headers of the main chain,
+// in reply to getheaders whose payload is a getBlocksMsg
+type headersMsg struct {
+	Headers [][]byte
+}
+
+// getProofMsg asks for a transaction of a block
+// with the merkle proof of its inclusion
+type getProofMsg struct {
+	Block       []byte // hash of the block
+	Transaction []byte // ID of the transaction
+}
+
+// proofMsg carries a serialized transaction and its merkle proof,
+// see Block.MerkleProof. Transaction is empty if the transaction
+// is not in the block.
+type proofMsg struct {
+	Block       []byte
+	Transaction []byte
+	Hashes      [][]byte
+	Index       []int64
+}
+
+// message is a decoded message header with its raw payload
+type message struct {
+	Command string
+	Payload []byte
+}
+
+// writeMessage encodes payload with gob and writes it as a single
+// message of the given command. A nil payload sends an empty message.
+func writeMessage(w io.Writer, command string, payload interface{}) error {
+	var body []byte
+	if payload != nil {
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(payload); err != nil {
+			return err
+		}
+		body = buffer.Bytes()
+	}
+
+	header := make([]byte, headerLength)
+	copy(header, networkMagic[:])
+	copy(header[4:4+commandLength], command)
+	binary.BigEndian.PutUint32(header[4+commandLength:], uint32(len(body)))
+	copy(header[8+commandLength:], checksum(body))
+
+	_, err := w.Write(append(header, body...))
+	return err
+}
+
+// readMessage reads the next message, checking the network magic
+// and the checksum of the payload
+func readMessage(r io.Reader) (*message, error) {
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:4], networkMagic[:]) {
+		return nil, ErrBadMagic
+	}
+	command := string(bytes.TrimRight(header[4:4+commandLength], "\x00"))
+
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxPayloadLength {
+		return nil, fmt.Errorf("%w: %d bytes", ErrPayloadTooLarge, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[8+commandLength:], checksum(payload)) {
+		return nil, ErrBadChecksum
+	}
+	return &message{Command: command, Payload: payload}, nil
+}
+
+// decode decodes the payload of the message into v
+func (m *message) decode(v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(m.Payload)).Decode(v)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWireMessageRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	version := &versionMsg{Version: protocolVersion, Height: 3, AddrFrom: "127.0.0.1:3000", Genesis: Hex2Bytes("0102")}
+	assert.Nil(t, writeMessage(&buffer, cmdVersion, version))
+	assert.Nil(t, writeMessage(&buffer, cmdVerack, nil))
+
+	msg, err := readMessage(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, cmdVersion, msg.Command)
+	var decoded versionMsg
+	assert.Nil(t, msg.decode(&decoded))
+	assert.Equal(t, *version, decoded)
+
+	msg, err = readMessage(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, cmdVerack, msg.Command)
+	assert.Empty(t, msg.Payload)
+}
+
+func TestWireMessageRejected(t *testing.T) {
+	var buffer bytes.Buffer
+	writeMessage(&buffer, cmdInv, &invMsg{Type: invBlock, Items: [][]byte{Hex2Bytes("0102")}})
+	data := buffer.Bytes()
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	_, err := readMessage(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrBadChecksum)
+
+	otherNetwork := append([]byte{}, data...)
+	otherNetwork[0] ^= 0xff
+	_, err = readMessage(bytes.NewReader(otherNetwork))
+	assert.ErrorIs(t, err, ErrBadMagic)
+
+	tooLarge := append([]byte{}, data[:headerLength]...)
+	tooLarge[4+commandLength] = 0xff
+	_, err = readMessage(bytes.NewReader(tooLarge))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}